@@ -3,20 +3,28 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // user time zones must resolve without system zoneinfo
 
 	"github.com/fitz123/mcduck-wallet/internal/bot"
 	"github.com/fitz123/mcduck-wallet/internal/database"
 	"github.com/fitz123/mcduck-wallet/internal/handlers"
+	"github.com/fitz123/mcduck-wallet/internal/health"
 	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"github.com/fitz123/mcduck-wallet/internal/metrics"
+	"github.com/fitz123/mcduck-wallet/internal/ratelimit"
 	"github.com/fitz123/mcduck-wallet/internal/services"
+	"github.com/fitz123/mcduck-wallet/internal/tracing"
 	"github.com/fitz123/mcduck-wallet/internal/webapp"
+	"github.com/fitz123/mcduck-wallet/internal/webapp/static"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -24,35 +32,133 @@ func main() {
 	cfg := loadConfig()
 
 	// Initialize logger
-	logger.Init(cfg.LogLevel)
+	logger.Init(cfg.LogLevel, cfg.LogFormat)
+	logger.AddSecret(cfg.TelegramToken)
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.TraceExporter, cfg.TraceFile)
+	if err != nil {
+		fatal("Failed to initialize tracing", err)
+	}
+
+	// The web app loads its CSS and JavaScript from the binary only
+	if err := static.Check(); err != nil {
+		fatal("Web app assets are missing", err)
+	}
 
 	// Initialize database
 	db, err := database.New(cfg.DatabaseDSN)
 	if err != nil {
-		logger.Error("Failed to initialize database: %v", err)
+		fatal("Failed to initialize database", err)
+	}
+
+	// Initialize metrics
+	if err := db.Conn.Use(metrics.GormPlugin{}); err != nil {
+		logger.Error("Failed to register database metrics", "error", err)
+	}
+	if err := metrics.RegisterWalletCollector(db); err != nil {
+		logger.Error("Failed to register wallet metrics", "error", err)
+	}
+	if err := db.Conn.Use(tracing.GormPlugin{}); err != nil {
+		logger.Error("Failed to register database tracing", "error", err)
 	}
-	defer db.Close()
 
 	// Initialize services
 	userService := services.NewUserService(db)
-	coreService := services.NewCoreService(db, userService)
-	botService := bot.NewBotService(cfg.TelegramToken, userService, coreService)
-	webService := webapp.NewWebService(userService, coreService, cfg.TelegramToken)
+	coreService := services.NewTracedCoreService(services.NewCoreService(db, userService))
+	limiter := ratelimit.New()
+	botService, err := bot.NewBotService(cfg.TelegramToken, userService, coreService, limiter)
+	if err != nil {
+		db.Close()
+		fatal("Failed to initialize Telegram bot", err)
+	}
+	coreService.SetNotifier(botService)
+	webService := webapp.NewWebService(userService, coreService, cfg.TelegramToken, limiter)
 
 	// Start the bot
 	go botService.Start()
 
+	// Start background jobs
+	jobs, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go services.RunPeriodically(jobs, "interest", time.Hour, func(ctx context.Context) error {
+		report, err := coreService.RunInterest(ctx)
+		if err == nil && (report.Accrued > 0 || len(report.Paid) > 0) {
+			logger.InfoContext(ctx, "Interest run completed", "accrued", report.Accrued, "paid", report.Paid)
+		}
+		return err
+	})
+	go services.RunPeriodically(jobs, "loan_reminders", time.Hour, func(ctx context.Context) error {
+		sent, err := coreService.RunLoanReminders(ctx)
+		if err == nil && sent > 0 {
+			logger.InfoContext(ctx, "Loan reminders sent", "reminders", sent)
+		}
+		return err
+	})
+	go services.RunPeriodically(jobs, "escrow_timeouts", time.Minute, func(ctx context.Context) error {
+		refunded, err := coreService.RunEscrowTimeouts(ctx)
+		if err == nil && refunded > 0 {
+			logger.InfoContext(ctx, "Expired escrows refunded", "escrows", refunded)
+		}
+		return err
+	})
+	go services.RunPeriodically(jobs, "voucher_expiry", time.Hour, func(ctx context.Context) error {
+		refunded, err := coreService.RunVoucherExpiry(ctx)
+		if err == nil && refunded > 0 {
+			logger.InfoContext(ctx, "Expired vouchers refunded", "vouchers", refunded)
+		}
+		return err
+	})
+	go services.RunPeriodically(jobs, "webhooks", 10*time.Second, func(ctx context.Context) error {
+		delivered, err := coreService.RunWebhookDeliveries(ctx)
+		if err == nil && delivered > 0 {
+			logger.DebugContext(ctx, "Webhook deliveries sent", "deliveries", delivered)
+		}
+		return err
+	})
+
+	// Health checks
+	checker := health.New()
+	checker.AddLiveness("bot", botService.Alive)
+	checker.AddReadiness("database", db.Ping)
+	checker.AddReadiness("migrations", db.CheckMigrations)
+	checker.AddReadiness("bot", botService.Ready)
+
 	// Initialize and start the web server
-	server := initWebServer(cfg.ServerAddress, webService)
+	server := initWebServer(cfg.ServerAddress, webService, checker, limiter)
+	serverErr := make(chan error, 2)
 	go func() {
 		logger.Info("Starting WebApp server", "address", cfg.ServerAddress)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Error("WebApp server error", "error", err)
+			serverErr <- fmt.Errorf("webapp server: %w", err)
+		}
+	}()
+
+	// Metrics are served on their own address, away from the public WebApp
+	metricsServer := initMetricsServer(cfg.MetricsAddress)
+	go func() {
+		logger.Info("Starting metrics server", "address", cfg.MetricsAddress)
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErr <- fmt.Errorf("metrics server: %w", err)
 		}
 	}()
 
 	// Handle shutdown signals
-	handleShutdown(botService, server, db)
+	err = handleShutdown(botService, server, metricsServer, db, serverErr, stopJobs)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+	if err != nil {
+		fatal("Shut down after a fatal error", err)
+	}
+}
+
+// fatal logs an error that prevents the application from running and exits
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }
 
 func loadConfig() *Config {
@@ -60,16 +166,35 @@ func loadConfig() *Config {
 	return &Config{
 		TelegramToken: os.Getenv("TELEGRAM_BOT_TOKEN"),
 		ServerAddress: ":80",
-		DatabaseDSN:   "mcduck_wallet.db",
-		LogLevel:      "debug",
+		// Only local by default, open it to the scraper's network with e.g. :9090
+		MetricsAddress: getEnv("METRICS_ADDR", "127.0.0.1:9090"),
+		DatabaseDSN:    "mcduck_wallet.db",
+		LogLevel:       getEnv("LOG_LEVEL", "debug"),
+		LogFormat:      getEnv("LOG_FORMAT", logger.FormatText),
+		TraceExporter:  getEnv("TRACE_EXPORTER", tracing.ExporterNone),
+		TraceFile:      getEnv("TRACE_FILE", "traces.jsonl"),
 	}
 }
 
-func initWebServer(addr string, webService *webapp.WebService) *http.Server {
+func getEnv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+func initWebServer(addr string, webService *webapp.WebService, checker *health.Checker, limiter *ratelimit.Limiter) *http.Server {
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(middleware.RequestID)
+	r.Use(tracing.HTTPMiddleware)
+	r.Use(logger.HTTPMiddleware)
 	r.Use(middleware.Recoverer)
+	r.Use(metrics.HTTPMiddleware)
+	r.Use(limiter.HTTPMiddleware(ratelimit.IP, ratelimit.RemoteIP))
+	r.Use(webapp.SecurityHeaders)
 
+	r.Get("/healthz", checker.LivenessHandler)
+	r.Get("/readyz", checker.ReadinessHandler)
 	handlers.RegisterRoutes(r, webService)
 
 	return &http.Server{
@@ -78,34 +203,60 @@ func initWebServer(addr string, webService *webapp.WebService) *http.Server {
 	}
 }
 
-func handleShutdown(botService *bot.BotService, server *http.Server, db *database.DB) {
+// initMetricsServer serves the Prometheus metrics, it has no other routes
+func initMetricsServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+}
+
+// handleShutdown waits for a signal or a fatal server error and stops all
+// components. It returns the server error, if that caused the shutdown.
+func handleShutdown(botService *bot.BotService, server, metricsServer *http.Server, db *database.DB, serverErr <-chan error, stopJobs context.CancelFunc) error {
 	// Channel to listen for OS signals
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 
-	<-quit
-	logger.Info("Received shutdown signal, shutting down gracefully...")
+	var fatalErr error
+	select {
+	case <-quit:
+		logger.Info("Received shutdown signal, shutting down gracefully...")
+	case fatalErr = <-serverErr:
+		logger.Error("Server failed, shutting down", "error", fatalErr)
+	}
 
-	// Stop the bot
+	// Stop the bot and background jobs
 	botService.Stop()
 	logger.Info("Telegram bot stopped")
+	stopJobs()
 
 	// Shutdown the web server
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
-		logger.Error("Server forced to shutdown: %v", err)
+		logger.Error("Server forced to shutdown", "error", err)
 	}
 	logger.Info("WebApp server stopped")
+	if err := metricsServer.Shutdown(ctx); err != nil {
+		logger.Error("Metrics server forced to shutdown", "error", err)
+	}
 
 	// Close the database connection
 	db.Close()
 	logger.Info("Database connection closed")
+	return fatalErr
 }
 
 type Config struct {
-	TelegramToken string
-	ServerAddress string
-	DatabaseDSN   string
-	LogLevel      string
+	TelegramToken  string
+	ServerAddress  string
+	MetricsAddress string // Prometheus metrics, not exposed with the WebApp
+	DatabaseDSN    string
+	LogLevel       string
+	LogFormat      string // text or json
+	TraceExporter  string // none, otlp, stdout or file
+	TraceFile      string // output of the file exporter
 }
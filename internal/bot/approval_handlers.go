@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+var (
+	btnApprove = tele.Btn{Unique: "approve"}
+	btnReject  = tele.Btn{Unique: "reject"}
+)
+
+// NotifyApprovers implements services.Notifier by sending the operation with
+// Approve/Reject buttons to every approver
+func (bs *BotService) NotifyApprovers(ctx context.Context, op *database.PendingOperation, approvers []database.User) {
+	id := strconv.FormatUint(uint64(op.ID), 10)
+	for _, approver := range approvers {
+		// Every approver reads the request in their own language
+		ctx := userLocale(ctx, &approver)
+		markup := &tele.ReplyMarkup{}
+		markup.Inline(markup.Row(
+			markup.Data(i18n.T(ctx, "bot.btn.approve"), btnApprove.Unique, id),
+			markup.Data(i18n.T(ctx, "bot.btn.reject"), btnReject.Unique, id),
+		))
+
+		text := i18n.T(ctx, "bot.approval_requested", messages.FormatPendingOperation(ctx, op))
+		if _, err := bs.bot.Send(&tele.User{ID: approver.TelegramID}, text, markup); err != nil {
+			logger.ErrorContext(ctx, "Failed to notify approver", "approver", approver.Username, "operation", op.ID, "error", err)
+		}
+	}
+}
+
+// NotifyOperationResolved implements services.Notifier by telling the
+// initiator how their operation ended
+func (bs *BotService) NotifyOperationResolved(ctx context.Context, op *database.PendingOperation) {
+	if initiator, err := bs.userService.GetUser(ctx, op.InitiatorTelegramID); err == nil {
+		ctx = userLocale(ctx, initiator)
+	}
+	text := i18n.T(ctx, "bot.operation_resolved", op.ID, messages.FormatOperationStatus(ctx, op.Status), messages.FormatPendingOperation(ctx, op))
+	if _, err := bs.bot.Send(&tele.User{ID: op.InitiatorTelegramID}, text); err != nil {
+		logger.ErrorContext(ctx, "Failed to notify initiator", "operation", op.ID, "error", err)
+	}
+}
+
+func (bs *BotService) handlePending(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	ops, err := bs.coreService.ListPendingOperations(ctx, c.Sender().ID)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.pending", err.Error()))
+	}
+	if len(ops) == 0 {
+		return c.Send(i18n.T(ctx, "bot.no_pending"))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(i18n.T(ctx, "bot.pending_title") + "\n")
+	for i := range ops {
+		sb.WriteString("\n" + messages.FormatPendingOperation(ctx, &ops[i]) + "\n")
+	}
+	sb.WriteString("\n" + i18n.T(ctx, "bot.pending_hint"))
+	return c.Send(sb.String())
+}
+
+func (bs *BotService) handleApprove(c tele.Context) error {
+	return bs.decideFromCommand(c, true)
+}
+
+func (bs *BotService) handleReject(c tele.Context) error {
+	return bs.decideFromCommand(c, false)
+}
+
+func (bs *BotService) decideFromCommand(c tele.Context, approve bool) error {
+	ctx := bs.requestContext(c)
+	args := c.Args()
+	if len(args) != 1 {
+		return c.Send(i18n.T(ctx, messages.UsageApprove))
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(args[0], "#"), 10, 64)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.invalid_operation"))
+	}
+
+	op, err := bs.decide(c, uint(id), approve)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+	return c.Send(formatDecision(ctx, op))
+}
+
+func (bs *BotService) handleApproveCallback(c tele.Context) error {
+	return bs.decideFromCallback(c, true)
+}
+
+func (bs *BotService) handleRejectCallback(c tele.Context) error {
+	return bs.decideFromCallback(c, false)
+}
+
+func (bs *BotService) decideFromCallback(c tele.Context, approve bool) error {
+	ctx := bs.requestContext(c)
+	id, err := strconv.ParseUint(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(ctx, "bot.error.invalid_operation")})
+	}
+
+	op, err := bs.decide(c, uint(id), approve)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(ctx, "bot.error.failed", err.Error()), ShowAlert: true})
+	}
+	if err := c.Respond(); err != nil {
+		return err
+	}
+	return c.Edit(formatDecision(ctx, op))
+}
+
+func (bs *BotService) decide(c tele.Context, id uint, approve bool) (*database.PendingOperation, error) {
+	ctx := bs.requestContext(c)
+	if approve {
+		return bs.coreService.ApproveOperation(ctx, c.Sender().ID, id)
+	}
+	return bs.coreService.RejectOperation(ctx, c.Sender().ID, id)
+}
+
+func formatDecision(ctx context.Context, op *database.PendingOperation) string {
+	if op.Status == database.OperationPending {
+		return i18n.T(ctx, "bot.decision_recorded", op.ID, messages.FormatPendingOperation(ctx, op))
+	}
+	return i18n.T(ctx, "bot.operation_status", op.ID, messages.FormatOperationStatus(ctx, op.Status), messages.FormatPendingOperation(ctx, op))
+}
+
+// pendingMessage returns the reply for an operation queued for approval, or "" for other errors
+func pendingMessage(ctx context.Context, err error) string {
+	var pending *services.PendingApprovalError
+	if !errors.As(err, &pending) {
+		return ""
+	}
+	return i18n.T(ctx, messages.InfoPendingApproval, pending.Operation.ID, pending.Operation.RequiredApprovals)
+}
+
+// handleAdminConfig lists settings or changes one. Usage: /config [key=value]
+func (bs *BotService) handleAdminConfig(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
+	}
+
+	args := c.Args()
+	if len(args) == 0 {
+		settings, err := bs.coreService.ListSettings(ctx)
+		if err != nil {
+			return c.Send("Error fetching settings: " + err.Error())
+		}
+		if len(settings) == 0 {
+			return c.Send("No settings configured.\n" + i18n.T(ctx, messages.UsageConfig))
+		}
+		response := "Settings:\n"
+		for _, setting := range settings {
+			response += fmt.Sprintf("%s=%s\n", setting.Key, setting.Value)
+		}
+		return c.Send(response)
+	}
+
+	if len(args) != 1 {
+		return c.Send(i18n.T(ctx, messages.UsageConfig))
+	}
+	key, value, ok := strings.Cut(args[0], "=")
+	if !ok {
+		return c.Send(i18n.T(ctx, messages.UsageConfig))
+	}
+	if err := bs.coreService.SetSetting(ctx, key, value); err != nil {
+		return c.Send("Failed to change setting: " + err.Error())
+	}
+	if value == "" {
+		return c.Send(fmt.Sprintf("Setting %s has been reset.", key))
+	}
+	return c.Send(fmt.Sprintf("Setting %s has been set to %s.", key, value))
+}
@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+const defaultAuditLogLimit = 20
+
+// handleAdminAuditLog lists, verifies or exports the audit log.
+// Usage: /auditlog [verify] [csv] [actor=@user] [action=name] [target=text] [from=YYYY-MM-DD] [to=YYYY-MM-DD] [limit=N]
+func (bs *BotService) handleAdminAuditLog(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
+	}
+
+	var asCSV, verify bool
+	var filterArgs []string
+	for _, arg := range c.Args() {
+		switch arg {
+		case "csv":
+			asCSV = true
+		case "verify":
+			verify = true
+		default:
+			filterArgs = append(filterArgs, arg)
+		}
+	}
+
+	if verify {
+		if err := bs.coreService.VerifyAuditLog(ctx); err != nil {
+			return c.Send("Audit log verification FAILED: " + err.Error())
+		}
+		return c.Send("Audit log verified: hash chain is intact.")
+	}
+
+	filter, err := services.ParseAuditFilter(filterArgs)
+	if err != nil {
+		return c.Send(err.Error() + "\n" + i18n.T(ctx, messages.UsageAuditLog))
+	}
+	if filter.Limit == 0 && !asCSV {
+		filter.Limit = defaultAuditLogLimit
+	}
+
+	logs, err := bs.coreService.ListAuditLogs(ctx, filter)
+	if err != nil {
+		return c.Send("Error fetching audit log: " + err.Error())
+	}
+
+	if asCSV {
+		var buf bytes.Buffer
+		if err := services.WriteAuditLogsCSV(&buf, logs); err != nil {
+			return c.Send("Error exporting audit log: " + err.Error())
+		}
+		doc := &tele.Document{
+			File:     tele.FromReader(&buf),
+			FileName: "auditlog.csv",
+			MIME:     "text/csv",
+		}
+		return c.Send(doc)
+	}
+
+	if len(logs) == 0 {
+		return c.Send("No audit log entries found.")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Audit log:\n\n")
+	for _, e := range logs {
+		sb.WriteString(fmt.Sprintf("#%d %s [%s] @%s %s %s: %s -> %s\n",
+			e.ID, e.CreatedAt.In(i18n.Location(ctx)).Format("2006-01-02 15:04"), e.Source,
+			e.ActorUsername, e.Action, e.Target, e.Before, e.After))
+	}
+
+	for _, chunk := range splitMessage(sb.String(), 4096) {
+		if err := c.Send(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
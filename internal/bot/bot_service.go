@@ -9,34 +9,46 @@ import (
 	"time"
 
 	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/logger"
 	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/metrics"
+	"github.com/fitz123/mcduck-wallet/internal/ratelimit"
 	"github.com/fitz123/mcduck-wallet/internal/services"
+	"github.com/fitz123/mcduck-wallet/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	tele "gopkg.in/telebot.v3"
 )
 
 type BotService struct {
 	bot         *tele.Bot
+	poller      *trackingPoller
+	limiter     *ratelimit.Limiter
 	userService services.UserService
 	coreService services.CoreService // Added core service for business logic
 }
 
-func NewBotService(token string, userService services.UserService, coreService services.CoreService) *BotService {
+func NewBotService(token string, userService services.UserService, coreService services.CoreService, limiter *ratelimit.Limiter) (*BotService, error) {
+	poller := &trackingPoller{Timeout: 10 * time.Second}
 	pref := tele.Settings{
-		Token:  token,
-		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
+		Token:   token,
+		Poller:  poller,
+		OnError: handleError,
 	}
 	bot, err := tele.NewBot(pref)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	bs := &BotService{
 		bot:         bot,
+		poller:      poller,
+		limiter:     limiter,
 		userService: userService,
 		coreService: coreService,
 	}
 	bs.registerHandlers()
-	return bs
+	return bs, nil
 }
 
 func (bs *BotService) Start() {
@@ -47,43 +59,150 @@ func (bs *BotService) Stop() {
 	bs.bot.Stop()
 }
 
+// Alive reports whether the update poller is running
+func (bs *BotService) Alive(ctx context.Context) error {
+	return bs.poller.Alive(ctx)
+}
+
+// Ready reports whether the update poller reached Telegram recently
+func (bs *BotService) Ready(ctx context.Context) error {
+	return bs.poller.Ready(ctx)
+}
+
 func (bs *BotService) registerHandlers() {
+	bs.bot.Use(metrics.BotMiddleware)
+	bs.bot.Use(bs.traceUpdates)
+	// Throttled updates are dropped before they reach the database
+	bs.bot.Use(bs.rateLimit)
+	bs.bot.Use(bs.syncProfile)
+	bs.bot.Use(bs.selectWallet)
+	bs.bot.Use(bs.localize)
+
 	bs.bot.Handle("/start", bs.handleStart)
 	bs.bot.Handle("/balance", bs.handleBalance)
 	bs.bot.Handle("/transfer", bs.handleTransfer)
 	bs.bot.Handle("/history", bs.handleHistory)
+	bs.bot.Handle("/limits", bs.handleLimits)
+	bs.bot.Handle("/pots", bs.handlePots)
+	bs.bot.Handle("/pot", bs.handlePot)
+	bs.bot.Handle("/loans", bs.handleLoans)
+	bs.bot.Handle("/lend", bs.handleLend)
+	bs.bot.Handle("/repay", bs.handleRepay)
+	bs.bot.Handle("/escrow", bs.handleEscrow)
+	bs.bot.Handle("/voucher", bs.handleVoucher)
+	bs.bot.Handle("/redeem", bs.handleRedeem)
+	bs.bot.Handle("/refund", bs.handleRefund)
+	bs.bot.Handle("/invite", bs.handleInvite)
+	bs.bot.Handle("/wallet", bs.handleWallet)
+	bs.bot.Handle("/webhook", bs.handleWebhook)
+	bs.bot.Handle(tele.OnContact, bs.handleContact)
+	bs.bot.Handle("/export", bs.handleExport)
+	bs.bot.Handle("/language", bs.handleLanguage)
+	bs.bot.Handle("/timezone", bs.handleTimezone)
 	bs.bot.Handle("/set", bs.handleAdminSet)
 	bs.bot.Handle("/listusers", bs.handleAdminListUsers)
 	bs.bot.Handle("/removeuser", bs.handleAdminRemoveUser)
+	bs.bot.Handle("/restoreuser", bs.handleAdminRestoreUser)
+	bs.bot.Handle("/purgeuser", bs.handleAdminPurgeUser)
 	bs.bot.Handle("/adduser", bs.handleAdminAddUser)
 	bs.bot.Handle("/addcurrency", bs.handleAdminAddCurrency)
 	bs.bot.Handle("/setdefaultcurrency", bs.handleAdminSetDefaultCurrency)
+	bs.bot.Handle("/auditlog", bs.handleAdminAuditLog)
+	bs.bot.Handle("/config", bs.handleAdminConfig)
+	bs.bot.Handle("/throttled", bs.handleAdminThrottled)
+	bs.bot.Handle("/import", bs.handleImportCommand)
+	bs.bot.Handle(tele.OnDocument, bs.handleDocument)
+	bs.bot.Handle("/pending", bs.handlePending)
+	bs.bot.Handle("/approve", bs.handleApprove)
+	bs.bot.Handle("/reject", bs.handleReject)
+	bs.bot.Handle(&btnApprove, bs.handleApproveCallback)
+	bs.bot.Handle(&btnReject, bs.handleRejectCallback)
+	bs.bot.Handle(&btnEscrowRelease, bs.handleEscrowReleaseCallback)
+	bs.bot.Handle(&btnEscrowCancel, bs.handleEscrowCancelCallback)
+	bs.bot.Handle(&btnEscrowDispute, bs.handleEscrowDisputeCallback)
+	bs.bot.Handle(&btnRefund, bs.handleRefundCallback)
 }
 
-func (bs *BotService) handleStart(c tele.Context) error {
+// updateContextKey stores the context of an update in the telebot context
+const updateContextKey = "context"
+
+// requestContext returns the context for handling an update, carrying the
+// sender as actor, the update and user IDs for logging and the update's span
+func (bs *BotService) requestContext(c tele.Context) context.Context {
+	return contextOf(c)
+}
+
+func contextOf(c tele.Context) context.Context {
+	if ctx, ok := c.Get(updateContextKey).(context.Context); ok {
+		return ctx
+	}
+	return updateContext(c)
+}
+
+func updateContext(c tele.Context) context.Context {
 	ctx := context.Background()
-	user, err := bs.userService.GetUser(ctx, c.Sender().ID)
-	if err != nil {
-		// User not found, create a new one
-		user = &database.User{
-			TelegramID: c.Sender().ID,
-			Username:   c.Sender().Username,
-		}
-		err = bs.userService.CreateUser(ctx, user)
-		if err != nil {
-			return c.Send("Error creating user: " + err.Error())
+	if c.Sender() == nil {
+		return logger.With(ctx, "update_id", c.Update().ID)
+	}
+	ctx = logger.With(ctx, "update_id", c.Update().ID, "user_id", c.Sender().ID)
+	return services.WithActor(ctx, c.Sender().ID, services.SourceBot)
+}
+
+// traceUpdates starts a span for every update and makes its context
+// available to the handler. Successfully handled updates are logged here,
+// failures reach handleError through telebot.
+func (bs *BotService) traceUpdates(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		start := time.Now()
+		ctx, span := tracing.Start(updateContext(c), "bot "+metrics.UpdateCommand(c),
+			attribute.Int("telegram.update_id", c.Update().ID),
+		)
+		c.Set(updateContextKey, ctx)
+
+		err := next(c)
+		tracing.End(span, err)
+		if err == nil {
+			logger.DebugContext(ctx, "Handled update", "text", c.Text(), "duration", time.Since(start))
 		}
-	} else {
-		// Update username if changed
-		if user.Username != c.Sender().Username {
-			bs.userService.UpdateUsername(ctx, c.Sender().ID, c.Sender().Username)
+		return err
+	}
+}
+
+// handleError replaces telebot's default error handler, which logs through the standard logger
+func handleError(err error, c tele.Context) {
+	if c == nil {
+		logger.Error("Telegram bot error", "error", err)
+		return
+	}
+	logger.ErrorContext(contextOf(c), "Telegram bot error", "error", err)
+}
+
+func (bs *BotService) handleStart(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	// Profiles of existing users are kept up to date by syncProfile
+	var inviteCode string
+	if code, ok := strings.CutPrefix(c.Message().Payload, invitePayload); ok {
+		inviteCode = code
+	}
+	registration, err := bs.coreService.Register(ctx, services.Profile{
+		TelegramID:   c.Sender().ID,
+		Username:     c.Sender().Username,
+		FirstName:    c.Sender().FirstName,
+		LastName:     c.Sender().LastName,
+		LanguageCode: c.Sender().LanguageCode,
+	}, inviteCode)
+	if err != nil {
+		if key := registrationError(err); key != "" {
+			return c.Send(i18n.T(ctx, key))
 		}
+		return c.Send(i18n.T(ctx, "bot.error.create_user", err.Error()))
 	}
+	user := registration.User
 
 	// Create a keyboard with a WebApp button
 	webAppURL := "https://mcduck.120912.xyz"
 	webAppButton := tele.InlineButton{
-		Text: "Open McDuck Wallet",
+		Text: i18n.T(ctx, "bot.open_wallet"),
 		WebApp: &tele.WebApp{
 			URL: webAppURL,
 		},
@@ -95,147 +214,248 @@ func (bs *BotService) handleStart(c tele.Context) error {
 		},
 	}
 
-	return c.Send(fmt.Sprintf(messages.InfoWelcome, user.Username), keyboard)
+	if err := c.Send(i18n.T(ctx, messages.InfoWelcome, user.Username), keyboard); err != nil {
+		return err
+	}
+	if registration.Bonus > 0 {
+		if err := c.Send(i18n.T(ctx, messages.InfoWelcomeBonus, registration.Currency.Sign, registration.Bonus)); err != nil {
+			return err
+		}
+	}
+
+	// Voucher deep links start the bot with the code as payload
+	if code, ok := strings.CutPrefix(c.Message().Payload, redeemPayload); ok {
+		return bs.redeemVoucher(c, code)
+	}
+	return nil
 }
 
 func (bs *BotService) handleBalance(c tele.Context) error {
-	ctx := context.Background()
+	ctx := bs.requestContext(c)
 	balances, err := bs.coreService.GetBalances(ctx, c.Sender().ID)
 	if err != nil {
-		return c.Send("Error fetching balances: " + err.Error())
+		return c.Send(i18n.T(ctx, "bot.error.balances", err.Error()))
 	}
 
 	var formattedBalances []string
 	for _, balance := range balances {
 		formattedBalance := fmt.Sprintf("%s%.0f %s", balance.Currency.Sign, balance.Amount, balance.Currency.Name)
+		if balance.IsPot() {
+			formattedBalance = i18n.T(ctx, "bot.balance_pot", balance.Currency.Sign, balance.Amount, balance.Currency.Name, balance.Pot)
+		}
+		if balance.Held > 0 {
+			formattedBalance += i18n.T(ctx, "bot.balance_held", balance.Currency.Sign, balance.Held)
+		}
 		formattedBalances = append(formattedBalances, formattedBalance)
 	}
 
-	response := "Your current balances:\n" + strings.Join(formattedBalances, "\n")
+	response := i18n.T(ctx, "bot.balances", strings.Join(formattedBalances, "\n"))
 	return c.Send(response)
 }
 
+// handleTransfer sends money to a user named in the arguments, or to the
+// user behind the message the command replies to.
+// Usage: /transfer <recipient> <amount> [currency], or as a reply /transfer <amount> [currency]
 func (bs *BotService) handleTransfer(c tele.Context) error {
-	ctx := context.Background()
+	ctx := bs.requestContext(c)
 	args := c.Args()
-	if len(args) < 2 || len(args) > 3 {
-		return c.Send(messages.UsageTransfer)
+
+	recipient, errKey := replyRecipient(c.Message())
+	if errKey != "" {
+		return c.Send(i18n.T(ctx, errKey))
+	}
+	if recipient == "" && len(args) > 0 {
+		recipient, args = args[0], args[1:]
+	}
+	if recipient == "" || len(args) < 1 || len(args) > 2 {
+		return c.Send(i18n.T(ctx, messages.UsageTransfer))
 	}
 
 	currencyCode := ""
-	if len(args) == 3 {
-		currencyCode = strings.ToUpper(args[2])
+	if len(args) == 2 {
+		currencyCode = strings.ToUpper(args[1])
 	} else {
 		defaultCurrency, err := bs.coreService.GetDefaultCurrency(ctx)
 		if err != nil {
-			return c.Send("Error fetching default currency: " + err.Error())
+			return c.Send(i18n.T(ctx, "bot.error.default_currency", err.Error()))
 		}
 		currencyCode = defaultCurrency.Code
 	}
 
-	toUsername := strings.TrimPrefix(args[0], "@")
-	amount, err := strconv.ParseFloat(args[1], 64)
+	amount, err := services.ParseAmount(args[0])
+	if err != nil {
+		return c.Send(i18n.T(ctx, messages.ErrInvalidAmount))
+	}
+
+	to, err := bs.coreService.ResolveRecipient(ctx, recipient)
 	if err != nil {
-		return c.Send(messages.ErrInvalidAmount)
+		return c.Send(i18n.T(ctx, "bot.error.transfer", err.Error()))
 	}
 
-	err = bs.coreService.TransferMoney(ctx, c.Sender().ID, toUsername, amount, currencyCode)
+	fee, err := bs.coreService.QuoteTransferFee(ctx, c.Sender().ID, amount, currencyCode)
 	if err != nil {
-		return c.Send("Transfer failed: " + err.Error())
+		return c.Send(i18n.T(ctx, "bot.error.transfer", err.Error()))
 	}
 
-	return c.Send(fmt.Sprintf(messages.InfoTransferSuccessful, amount, currencyCode, toUsername))
+	err = bs.coreService.TransferMoney(ctx, c.Sender().ID, strconv.FormatInt(to.TelegramID, 10), amount, currencyCode)
+	if msg := pendingMessage(ctx, err); msg != "" {
+		return c.Send(msg)
+	}
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.transfer", err.Error()))
+	}
+
+	response := messages.RenameNotice(ctx, recipient, to) +
+		i18n.T(ctx, messages.InfoTransferSuccessful, amount, currencyCode, messages.DisplayName(to))
+	if fee > 0 {
+		response += "\n" + i18n.T(ctx, messages.InfoTransferFee, fee, currencyCode)
+	}
+	return c.Send(response)
 }
 
 func (bs *BotService) handleHistory(c tele.Context) error {
-	ctx := context.Background()
+	ctx := bs.requestContext(c)
 	transactions, err := bs.coreService.GetTransactionHistory(ctx, c.Sender().ID)
 	if err != nil {
-		return c.Send("Error fetching transaction history: " + err.Error())
+		return c.Send(i18n.T(ctx, "bot.error.history", err.Error()))
 	}
 
-	formattedTransactions := messages.FormatTransactionHistory(transactions)
-	response := fmt.Sprintf("*Transaction History*\n\n%s", strings.Join(formattedTransactions, "\n\n"))
+	formattedTransactions := messages.FormatTransactionHistory(ctx, transactions)
+	response := fmt.Sprintf("%s\n\n%s", i18n.T(ctx, "bot.history_title"), strings.Join(formattedTransactions, "\n\n"))
 	return c.Send(response, &tele.SendOptions{ParseMode: tele.ModeMarkdown})
 }
 
+func (bs *BotService) handleLimits(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	limits, err := bs.coreService.GetTransferLimits(ctx, c.Sender().ID)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.limits", err.Error()))
+	}
+
+	return c.Send(messages.FormatTransferLimits(ctx, limits))
+}
+
 // Admin handlers
 
 func (bs *BotService) handleAdminSet(c tele.Context) error {
-	ctx := context.Background()
+	ctx := bs.requestContext(c)
 	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
-		return c.Send(messages.ErrUnauthorized)
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
 	}
 
 	args := c.Args()
 	if len(args) < 2 || len(args) > 3 {
-		return c.Send("Usage:\n/set <@username> admin=<true|false>\n/set <@username> balance=<amount> <currency>")
+		return c.Send(i18n.T(ctx, messages.UsageAdminSet))
 	}
 
 	targetUsername := strings.TrimPrefix(args[0], "@")
 
 	keyValue := strings.Split(args[1], "=")
 	if len(keyValue) != 2 {
-		return c.Send("Invalid key=value format.")
+		return c.Send(i18n.T(ctx, "bot.admin.invalid_key_value"))
 	}
 
 	key := keyValue[0]
 	value := keyValue[1]
 
+	if kind, ok := strings.CutPrefix(key, "limit."); ok {
+		limit, err := services.ParseAmount(value)
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.admin.invalid_limit"))
+		}
+		if len(args) < 3 {
+			return c.Send(i18n.T(ctx, "bot.admin.currency_required"))
+		}
+		currencyCode := strings.ToUpper(args[2])
+
+		err = bs.coreService.SetTransferLimit(ctx, targetUsername, kind, limit, currencyCode)
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.admin.set_limit_failed", err.Error()))
+		}
+		if limit == 0 {
+			return c.Send(i18n.T(ctx, "bot.admin.limit_removed", kind, targetUsername, currencyCode))
+		}
+		return c.Send(i18n.T(ctx, "bot.admin.limit_set", kind, targetUsername, limit, currencyCode))
+	}
+
 	switch key {
+	case "approver":
+		isApprover, err := strconv.ParseBool(value)
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.admin.invalid_bool", "approver"))
+		}
+		err = bs.coreService.SetApproverStatus(ctx, targetUsername, isApprover)
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.admin.set_approver_failed", err.Error()))
+		}
+		return c.Send(i18n.T(ctx, "bot.admin.approver_set", targetUsername, isApprover))
+
+	case "status":
+		err := bs.coreService.SetUserStatus(ctx, targetUsername, strings.ToLower(value))
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.admin.set_status_failed", err.Error()))
+		}
+		return c.Send(i18n.T(ctx, "bot.admin.status_set", targetUsername, strings.ToLower(value)))
+
 	case "admin":
 		isAdmin, err := strconv.ParseBool(value)
 		if err != nil {
-			return c.Send("Invalid boolean value for admin. Please use 'true' or 'false'.")
+			return c.Send(i18n.T(ctx, "bot.admin.invalid_bool", "admin"))
 		}
 		err = bs.coreService.SetAdminStatus(ctx, targetUsername, isAdmin)
 		if err != nil {
-			return c.Send("Failed to set admin status: " + err.Error())
+			return c.Send(i18n.T(ctx, "bot.admin.set_admin_failed", err.Error()))
 		}
-		return c.Send(fmt.Sprintf("Successfully set admin status of %s to %v", targetUsername, isAdmin))
+		return c.Send(i18n.T(ctx, "bot.admin.admin_set", targetUsername, isAdmin))
 
 	case "balance":
-		amount, err := strconv.ParseFloat(value, 64)
+		amount, err := services.ParseAmount(value)
 		if err != nil {
-			return c.Send("Invalid amount. Please enter a number.")
+			return c.Send(i18n.T(ctx, messages.ErrInvalidAmount))
 		}
 
 		if len(args) < 3 {
-			return c.Send("Please specify the currency code.")
+			return c.Send(i18n.T(ctx, "bot.admin.currency_required"))
 		}
 		currencyCode := strings.ToUpper(args[2])
 
 		err = bs.coreService.AdminSetBalance(ctx, c.Sender().ID, targetUsername, amount, currencyCode)
+		if msg := pendingMessage(ctx, err); msg != "" {
+			return c.Send(msg)
+		}
 		if err != nil {
-			return c.Send("Failed to set balance: " + err.Error())
+			return c.Send(i18n.T(ctx, "bot.admin.set_balance_failed", err.Error()))
 		}
 
 		currency, err := bs.coreService.GetCurrencyByCode(ctx, currencyCode)
 		if err != nil {
-			return c.Send("Failed to get currency information: " + err.Error())
+			return c.Send(i18n.T(ctx, "bot.admin.currency_failed", err.Error()))
 		}
 
-		return c.Send(fmt.Sprintf("Successfully set balance of %s to %s%.2f %s", targetUsername, currency.Sign, amount, currency.Name))
+		return c.Send(i18n.T(ctx, "bot.admin.balance_set", targetUsername, currency.Sign, amount, currency.Name))
 
 	default:
-		return c.Send("Unknown key. Available keys: admin, balance")
+		return c.Send(i18n.T(ctx, "bot.admin.unknown_key"))
 	}
 }
 
 func (bs *BotService) handleAdminListUsers(c tele.Context) error {
-	ctx := context.Background()
+	ctx := bs.requestContext(c)
 	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
-		return c.Send(messages.ErrUnauthorized)
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
 	}
 
 	users, err := bs.coreService.ListUsersWithBalances(ctx)
 	if err != nil {
-		return c.Send("An error occurred while fetching user data.")
+		return c.Send(i18n.T(ctx, "bot.admin.users_failed"))
 	}
 
-	response := "Users and their balances:\n\n"
+	response := i18n.T(ctx, "bot.admin.users_title") + "\n\n"
 	for _, user := range users {
 		userLine := fmt.Sprintf("%d - @%s:\n", user.TelegramID, user.Username)
+		if user.Status != "" && user.Status != database.UserStatusActive {
+			userLine = fmt.Sprintf("%d - @%s (%s):\n", user.TelegramID, user.Username, user.Status)
+		}
 		for currencyCode, amount := range user.Balances {
 			currency, _ := bs.coreService.GetCurrencyByCode(ctx, currencyCode)
 			balanceLine := fmt.Sprintf("  %s%.2f %s\n", currency.Sign, amount, currencyCode)
@@ -260,59 +480,109 @@ func (bs *BotService) handleAdminListUsers(c tele.Context) error {
 }
 
 func (bs *BotService) handleAdminRemoveUser(c tele.Context) error {
-	ctx := context.Background()
+	ctx := bs.requestContext(c)
 	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
-		return c.Send(messages.ErrUnauthorized)
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
 	}
 
 	args := c.Args()
-	if len(args) != 1 {
-		return c.Send("Usage: /removeuser <username>")
+	if len(args) < 1 || len(args) > 2 {
+		return c.Send(i18n.T(ctx, messages.UsageRemoveUser))
 	}
 
 	username := strings.TrimPrefix(args[0], "@")
-	err := bs.coreService.RemoveUser(ctx, username)
+	sweepTo := ""
+	if len(args) == 2 {
+		value, ok := strings.CutPrefix(args[1], "sweep=")
+		if !ok {
+			return c.Send(i18n.T(ctx, messages.UsageRemoveUser))
+		}
+		sweepTo = strings.TrimPrefix(value, "@")
+	}
+
+	err := bs.coreService.RemoveUser(ctx, username, sweepTo)
 	if err != nil {
-		return c.Send(fmt.Sprintf("Failed to remove user: %v", err))
+		return c.Send(i18n.T(ctx, "bot.admin.remove_failed", err.Error()))
 	}
 
-	return c.Send(fmt.Sprintf("User @%s has been successfully removed.", username))
+	if sweepTo != "" {
+		return c.Send(i18n.T(ctx, "bot.admin.user_swept", username, sweepTo))
+	}
+	return c.Send(i18n.T(ctx, "bot.admin.user_frozen", username))
+}
+
+func (bs *BotService) handleAdminRestoreUser(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
+	}
+
+	args := c.Args()
+	if len(args) != 1 {
+		return c.Send(i18n.T(ctx, messages.UsageRestoreUser))
+	}
+
+	username := strings.TrimPrefix(args[0], "@")
+	if err := bs.coreService.RestoreUser(ctx, username); err != nil {
+		return c.Send(i18n.T(ctx, "bot.admin.restore_failed", err.Error()))
+	}
+
+	return c.Send(i18n.T(ctx, "bot.admin.user_restored", username))
+}
+
+func (bs *BotService) handleAdminPurgeUser(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
+	}
+
+	args := c.Args()
+	if len(args) != 1 {
+		return c.Send(i18n.T(ctx, messages.UsagePurgeUser))
+	}
+
+	username := strings.TrimPrefix(args[0], "@")
+	if err := bs.coreService.PurgeUser(ctx, username); err != nil {
+		return c.Send(i18n.T(ctx, "bot.admin.purge_failed", err.Error()))
+	}
+
+	return c.Send(i18n.T(ctx, "bot.admin.user_purged", username))
 }
 
 func (bs *BotService) handleAdminAddUser(c tele.Context) error {
-	ctx := context.Background()
+	ctx := bs.requestContext(c)
 	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
-		return c.Send(messages.ErrUnauthorized)
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
 	}
 
 	args := c.Args()
 	if len(args) != 2 {
-		return c.Send("Usage: /adduser <telegram_id> <username>")
+		return c.Send(i18n.T(ctx, messages.UsageAddUser))
 	}
 
 	telegramID, err := strconv.ParseInt(args[0], 10, 64)
 	if err != nil {
-		return c.Send("Invalid Telegram ID. Please provide a valid numeric ID.")
+		return c.Send(i18n.T(ctx, "bot.admin.invalid_telegram_id"))
 	}
 
 	username := args[1]
 	err = bs.coreService.AddUser(ctx, telegramID, username)
 	if err != nil {
-		return c.Send(fmt.Sprintf("Failed to add user: %v", err))
+		return c.Send(i18n.T(ctx, "bot.admin.add_user_failed", err.Error()))
 	}
 
-	return c.Send(fmt.Sprintf("User @%s with Telegram ID %d has been successfully added.", username, telegramID))
+	return c.Send(i18n.T(ctx, "bot.admin.user_added", username, telegramID))
 }
 
 func (bs *BotService) handleAdminAddCurrency(c tele.Context) error {
-	ctx := context.Background()
+	ctx := bs.requestContext(c)
 	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
-		return c.Send(messages.ErrUnauthorized)
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
 	}
 
 	args := c.Args()
 	if len(args) != 3 {
-		return c.Send("Usage: /addcurrency <code> <name> <sign>")
+		return c.Send(i18n.T(ctx, messages.UsageAddCurrency))
 	}
 
 	code := strings.ToUpper(args[0])
@@ -321,31 +591,31 @@ func (bs *BotService) handleAdminAddCurrency(c tele.Context) error {
 
 	err := bs.coreService.AddCurrency(ctx, code, name, sign)
 	if err != nil {
-		return c.Send(fmt.Sprintf("Failed to add currency: %v", err))
+		return c.Send(i18n.T(ctx, "bot.admin.add_currency_failed", err.Error()))
 	}
 
-	return c.Send(fmt.Sprintf("Currency %s (%s) with sign %s has been successfully added.", code, name, sign))
+	return c.Send(i18n.T(ctx, "bot.admin.currency_added", code, name, sign))
 }
 
 func (bs *BotService) handleAdminSetDefaultCurrency(c tele.Context) error {
-	ctx := context.Background()
+	ctx := bs.requestContext(c)
 	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
-		return c.Send(messages.ErrUnauthorized)
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
 	}
 
 	args := c.Args()
 	if len(args) != 1 {
-		return c.Send("Usage: /setdefaultcurrency <code>")
+		return c.Send(i18n.T(ctx, messages.UsageSetDefaultCurrency))
 	}
 
 	code := strings.ToUpper(args[0])
 
 	err := bs.coreService.SetDefaultCurrency(ctx, code)
 	if err != nil {
-		return c.Send(fmt.Sprintf("Failed to set default currency: %v", err))
+		return c.Send(i18n.T(ctx, "bot.admin.default_currency_failed", err.Error()))
 	}
 
-	return c.Send(fmt.Sprintf("Default currency has been set to %s.", code))
+	return c.Send(i18n.T(ctx, "bot.admin.default_currency_set", code))
 }
 
 // Helper function to split long messages
@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+var (
+	btnEscrowRelease = tele.Btn{Unique: "escrow_release"}
+	btnEscrowCancel  = tele.Btn{Unique: "escrow_cancel"}
+	btnEscrowDispute = tele.Btn{Unique: "escrow_dispute"}
+)
+
+// NotifyEscrow implements services.Notifier by telling both sides of an
+// escrow about its new state, except the user who changed it
+func (bs *BotService) NotifyEscrow(ctx context.Context, escrow *database.Escrow) {
+	actor := services.ActorFromContext(ctx).TelegramID
+	for _, telegramID := range []int64{escrow.SenderTelegramID, escrow.RecipientTelegramID} {
+		if telegramID == actor {
+			continue
+		}
+		ctx := ctx
+		if user, err := bs.userService.GetUser(ctx, telegramID); err == nil {
+			ctx = userLocale(ctx, user)
+		}
+		text := i18n.T(ctx, "bot.escrow_update", messages.FormatEscrow(ctx, escrow))
+		markup := escrowMarkup(ctx, escrow, telegramID == escrow.SenderTelegramID)
+		if _, err := bs.bot.Send(&tele.User{ID: telegramID}, text, markup); err != nil {
+			logger.ErrorContext(ctx, "Failed to notify about escrow", "escrow", escrow.ID, "error", err)
+		}
+	}
+}
+
+// escrowMarkup returns the buttons a side of an open escrow can use
+func escrowMarkup(ctx context.Context, escrow *database.Escrow, sender bool) *tele.ReplyMarkup {
+	markup := &tele.ReplyMarkup{}
+	if !escrow.IsOpen() {
+		return markup
+	}
+	id := strconv.FormatUint(uint64(escrow.ID), 10)
+	var buttons []tele.Btn
+	if sender {
+		buttons = append(buttons, markup.Data(i18n.T(ctx, "bot.btn.release"), btnEscrowRelease.Unique, id))
+	}
+	if escrow.Status == database.EscrowHeld {
+		buttons = append(buttons,
+			markup.Data(i18n.T(ctx, "bot.btn.cancel"), btnEscrowCancel.Unique, id),
+			markup.Data(i18n.T(ctx, "bot.btn.dispute"), btnEscrowDispute.Unique, id),
+		)
+	}
+	markup.Inline(markup.Row(buttons...))
+	return markup
+}
+
+// handleEscrow lists, creates and settles escrows.
+// Usage: /escrow [<@username> <amount> [currency]] or /escrow release|cancel|dispute <id>
+func (bs *BotService) handleEscrow(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	args := c.Args()
+	if len(args) == 0 {
+		escrows, err := bs.coreService.ListEscrows(ctx, c.Sender().ID)
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.error.escrows", err.Error()))
+		}
+		return c.Send(messages.FormatEscrows(ctx, escrows))
+	}
+
+	switch action := strings.ToLower(args[0]); action {
+	case "release", "cancel", "dispute":
+		if len(args) != 2 {
+			return c.Send(i18n.T(ctx, messages.UsageEscrow))
+		}
+		id, err := strconv.ParseUint(strings.TrimPrefix(args[1], "#"), 10, 64)
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.error.invalid_escrow"))
+		}
+		escrow, err := bs.updateEscrow(c, action, uint(id))
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+		}
+		return c.Send(i18n.T(ctx, "bot.escrow_update", messages.FormatEscrow(ctx, escrow)), escrowMarkup(ctx, escrow, escrow.SenderTelegramID == c.Sender().ID))
+	case "resolve":
+		return bs.handleEscrowResolve(c, args[1:])
+	}
+
+	if len(args) < 2 || len(args) > 3 {
+		return c.Send(i18n.T(ctx, messages.UsageEscrow))
+	}
+	recipient := strings.TrimPrefix(args[0], "@")
+	amount, err := services.ParseAmount(args[1])
+	if err != nil {
+		return c.Send(i18n.T(ctx, messages.ErrInvalidAmount))
+	}
+	var currencyCode string
+	if len(args) == 3 {
+		currencyCode = strings.ToUpper(args[2])
+	} else {
+		defaultCurrency, err := bs.coreService.GetDefaultCurrency(ctx)
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.error.default_currency", err.Error()))
+		}
+		currencyCode = defaultCurrency.Code
+	}
+
+	escrow, err := bs.coreService.CreateEscrow(ctx, c.Sender().ID, recipient, amount, currencyCode)
+	if msg := pendingMessage(ctx, err); msg != "" {
+		return c.Send(msg)
+	}
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.transfer", err.Error()))
+	}
+	text := i18n.T(ctx, messages.InfoEscrowCreated, escrow.Currency.Sign, escrow.Amount, escrow.RecipientUsername, escrow.ID)
+	return c.Send(text, escrowMarkup(ctx, escrow, true))
+}
+
+// handleEscrowResolve lets admins settle a disputed escrow.
+// Usage: /escrow resolve <id> release|refund
+func (bs *BotService) handleEscrowResolve(c tele.Context, args []string) error {
+	ctx := bs.requestContext(c)
+	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
+	}
+	if len(args) != 2 || (args[1] != "release" && args[1] != "refund") {
+		return c.Send(i18n.T(ctx, messages.UsageEscrow))
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(args[0], "#"), 10, 64)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.invalid_escrow"))
+	}
+
+	escrow, err := bs.coreService.ResolveEscrow(ctx, c.Sender().ID, uint(id), args[1] == "release")
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+	return c.Send(i18n.T(ctx, "bot.escrow_update", messages.FormatEscrow(ctx, escrow)))
+}
+
+func (bs *BotService) handleEscrowReleaseCallback(c tele.Context) error {
+	return bs.escrowCallback(c, "release")
+}
+
+func (bs *BotService) handleEscrowCancelCallback(c tele.Context) error {
+	return bs.escrowCallback(c, "cancel")
+}
+
+func (bs *BotService) handleEscrowDisputeCallback(c tele.Context) error {
+	return bs.escrowCallback(c, "dispute")
+}
+
+func (bs *BotService) escrowCallback(c tele.Context, action string) error {
+	ctx := bs.requestContext(c)
+	id, err := strconv.ParseUint(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(ctx, "bot.error.invalid_escrow")})
+	}
+
+	escrow, err := bs.updateEscrow(c, action, uint(id))
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(ctx, "bot.error.failed", err.Error()), ShowAlert: true})
+	}
+	if err := c.Respond(); err != nil {
+		return err
+	}
+	return c.Edit(i18n.T(ctx, "bot.escrow_update", messages.FormatEscrow(ctx, escrow)), escrowMarkup(ctx, escrow, escrow.SenderTelegramID == c.Sender().ID))
+}
+
+func (bs *BotService) updateEscrow(c tele.Context, action string, id uint) (*database.Escrow, error) {
+	ctx := bs.requestContext(c)
+	switch action {
+	case "release":
+		return bs.coreService.ReleaseEscrow(ctx, c.Sender().ID, id)
+	case "cancel":
+		return bs.coreService.CancelEscrow(ctx, c.Sender().ID, id)
+	default:
+		return bs.coreService.DisputeEscrow(ctx, c.Sender().ID, id)
+	}
+}
@@ -0,0 +1,53 @@
+package bot
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/export"
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	tele "gopkg.in/telebot.v3"
+)
+
+// handleExport sends the user's statement as a document.
+// Usage: /export [from] [to] [csv|ofx|pdf]
+func (bs *BotService) handleExport(c tele.Context) error {
+	ctx := bs.requestContext(c)
+
+	format := export.FormatCSV
+	var dates []string
+	for _, arg := range c.Args() {
+		if export.IsFormat(arg) {
+			format = arg
+			continue
+		}
+		dates = append(dates, arg)
+	}
+	if len(dates) > 2 {
+		return c.Send(i18n.T(ctx, messages.UsageExport))
+	}
+	dates = append(dates, "", "")
+
+	from, to, err := export.ParsePeriod(dates[0], dates[1], time.Now().In(i18n.Location(ctx)))
+	if err != nil {
+		return c.Send(err.Error() + "\n" + i18n.T(ctx, messages.UsageExport))
+	}
+
+	statement, err := bs.coreService.GenerateStatement(ctx, c.Sender().ID, from, to)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.statement", err.Error()))
+	}
+
+	var buf bytes.Buffer
+	if err := export.Write(&buf, format, statement); err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.statement", err.Error()))
+	}
+
+	doc := &tele.Document{
+		File:     tele.FromReader(&buf),
+		FileName: export.FileName(format, statement),
+		MIME:     export.MIMEType(format),
+	}
+	return c.Send(doc)
+}
@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"io"
+	"path"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxImportSize limits the size of import files
+const maxImportSize = 5 << 20
+
+// handleImportCommand explains how to import, the file itself arrives as a document
+func (bs *BotService) handleImportCommand(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
+	}
+	return c.Send(i18n.T(ctx, messages.UsageImport))
+}
+
+// handleDocument imports a CSV or JSON file sent with an "/import [dry]" caption
+func (bs *BotService) handleDocument(c tele.Context) error {
+	fields := strings.Fields(c.Message().Caption)
+	if len(fields) == 0 || fields[0] != "/import" {
+		return nil
+	}
+
+	ctx := bs.requestContext(c)
+	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
+	}
+	dryRun := len(fields) > 1 && fields[1] == "dry"
+
+	doc := c.Message().Document
+	format := strings.TrimPrefix(strings.ToLower(path.Ext(doc.FileName)), ".")
+	if format != services.ImportFormatCSV && format != services.ImportFormatJSON {
+		return c.Send("Unsupported file type, send a .csv or .json file.")
+	}
+	if doc.FileSize > maxImportSize {
+		return c.Send("File is too large.")
+	}
+
+	file, err := bs.bot.File(&doc.File)
+	if err != nil {
+		return c.Send("Failed to download file: " + err.Error())
+	}
+	defer file.Close()
+
+	report, err := bs.coreService.Import(ctx, format, io.LimitReader(file, maxImportSize), dryRun)
+	if err != nil {
+		return c.Send("Import failed: " + err.Error())
+	}
+
+	for _, chunk := range splitMessage(messages.FormatImportReport(report), 4096) {
+		if err := c.Send(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
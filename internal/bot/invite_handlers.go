@@ -0,0 +1,145 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// invitePayload prefixes the /start payload of invite links
+const invitePayload = "inv_"
+
+// registrationError explains why /start could not register the sender
+func registrationError(err error) string {
+	switch {
+	case errors.Is(err, services.ErrRegistrationClosed):
+		return "bot.error.registration_closed"
+	case errors.Is(err, services.ErrInviteInvalid):
+		return "bot.error.invite_invalid"
+	case errors.Is(err, services.ErrAccountRemoved):
+		return "bot.error.account_removed"
+	}
+	return ""
+}
+
+// handleInvite lets admins manage invite links.
+// Usage: /invite create [x<uses>] [expires <ttl>], /invite list or /invite revoke <code>
+func (bs *BotService) handleInvite(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
+	}
+	args := c.Args()
+	if len(args) == 0 {
+		return c.Send(i18n.T(ctx, messages.UsageInvite))
+	}
+	switch strings.ToLower(args[0]) {
+	case "create":
+		return bs.handleInviteCreate(c, args[1:])
+	case "list":
+		return bs.handleInviteList(c)
+	case "revoke":
+		if len(args) != 2 {
+			break
+		}
+		if err := bs.coreService.RevokeInvite(ctx, c.Sender().ID, args[1]); err != nil {
+			return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+		}
+		return c.Send(fmt.Sprintf("Invite %s revoked.", services.NormalizeVoucherCode(args[1])))
+	}
+	return c.Send(i18n.T(ctx, messages.UsageInvite))
+}
+
+func (bs *BotService) handleInviteCreate(c tele.Context, args []string) error {
+	ctx := bs.requestContext(c)
+	maxUses := 0
+	var ttl time.Duration
+	var err error
+	for rest := args; len(rest) > 0; rest = rest[1:] {
+		switch arg := strings.ToLower(rest[0]); {
+		case arg == "expires" && len(rest) > 1:
+			ttl, err = services.ParseDuration(rest[1])
+			if err != nil || ttl <= 0 {
+				return c.Send(i18n.T(ctx, messages.UsageInvite))
+			}
+			rest = rest[1:]
+		case strings.HasPrefix(arg, "x"):
+			maxUses, err = strconv.Atoi(arg[1:])
+			if err != nil || maxUses <= 0 {
+				return c.Send(i18n.T(ctx, messages.UsageInvite))
+			}
+		default:
+			return c.Send(i18n.T(ctx, messages.UsageInvite))
+		}
+	}
+
+	invite, err := bs.coreService.CreateInvite(ctx, c.Sender().ID, maxUses, ttl)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+	return c.Send(fmt.Sprintf("Invite %s (%s):\n%s", invite.Code, inviteLimits(ctx, invite), bs.inviteLink(invite)), tele.NoPreview)
+}
+
+func (bs *BotService) handleInviteList(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	reports, err := bs.coreService.ListInvites(ctx)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+	if len(reports) == 0 {
+		return c.Send("No invites yet.")
+	}
+
+	now := time.Now()
+	lines := []string{"Invites:"}
+	for _, r := range reports {
+		status := "active"
+		if !r.Invite.IsUsable(now) {
+			status = "closed"
+		}
+		line := fmt.Sprintf("%s by @%s, %s, %s", r.Invite.Code, r.Invite.CreatedByUsername, inviteLimits(ctx, &r.Invite), status)
+		if len(r.Invitees) > 0 {
+			names := make([]string, len(r.Invitees))
+			for i := range r.Invitees {
+				names[i] = messages.DisplayName(&r.Invitees[i])
+			}
+			line += "\n  joined: " + strings.Join(names, ", ")
+		}
+		lines = append(lines, line)
+	}
+	// Long lists do not fit into a single message
+	for len(lines) > 0 {
+		n := min(len(lines), vouchersPerMessage)
+		if err := c.Send(strings.Join(lines[:n], "\n")); err != nil {
+			return err
+		}
+		lines = lines[n:]
+	}
+	return nil
+}
+
+// inviteLink is the deep link that starts the bot with an invite
+func (bs *BotService) inviteLink(invite *database.Invite) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s%s", bs.bot.Me.Username, invitePayload, invite.Code)
+}
+
+// inviteLimits describes how often and how long an invite can be used
+func inviteLimits(ctx context.Context, invite *database.Invite) string {
+	uses := fmt.Sprintf("used %d times", invite.Uses)
+	if invite.MaxUses > 0 {
+		uses = fmt.Sprintf("used %d of %d", invite.Uses, invite.MaxUses)
+	}
+	if invite.ExpiresAt != nil {
+		return fmt.Sprintf("%s, valid until %s", uses, i18n.FormatDateTime(ctx, *invite.ExpiresAt))
+	}
+	return uses
+}
@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	tele "gopkg.in/telebot.v3"
+)
+
+// localize sets the locale of the update's context from the sender's stored
+// language, falling back to the language of their Telegram client, and the
+// time zone from the one stored for the sender
+func (bs *BotService) localize(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if c.Sender() == nil {
+			return next(c)
+		}
+
+		ctx := contextOf(c)
+		user, err := bs.userService.GetUser(ctx, c.Sender().ID)
+		if err != nil {
+			user = &database.User{}
+		}
+		ctx = i18n.WithLocale(ctx, i18n.Resolve(user.Language, c.Sender().LanguageCode))
+		c.Set(updateContextKey, i18n.WithLocation(ctx, user.Location()))
+		return next(c)
+	}
+}
+
+// userLocale returns ctx translated to the stored language and time zone of
+// user, used for messages the user didn't trigger themselves
+func userLocale(ctx context.Context, user *database.User) context.Context {
+	ctx = i18n.WithLocale(ctx, i18n.Resolve(user.Language, user.AppLanguage))
+	return i18n.WithLocation(ctx, user.Location())
+}
+
+// handleLanguage shows or changes the language of the sender. Usage: /language [code|auto]
+func (bs *BotService) handleLanguage(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	args := c.Args()
+	if len(args) == 0 {
+		current := i18n.T(ctx, messages.InfoLanguageCurrent, i18n.T(ctx, "language.name"))
+		return c.Send(current + "\n" + i18n.T(ctx, messages.UsageLanguage, strings.Join(i18n.Supported(), ", ")))
+	}
+	if len(args) != 1 {
+		return c.Send(i18n.T(ctx, messages.UsageLanguage, strings.Join(i18n.Supported(), ", ")))
+	}
+
+	if strings.EqualFold(args[0], "auto") {
+		if err := bs.userService.SetLanguage(ctx, c.Sender().ID, ""); err != nil {
+			return c.Send(i18n.T(ctx, "bot.error.language", err.Error()))
+		}
+		ctx = i18n.WithLocale(ctx, i18n.Resolve("", c.Sender().LanguageCode))
+		return c.Send(i18n.T(ctx, messages.InfoLanguageAuto))
+	}
+
+	locale := i18n.Normalize(args[0])
+	if locale == "" {
+		return c.Send(i18n.T(ctx, messages.ErrUnsupportedLanguage, args[0]) + "\n" +
+			i18n.T(ctx, messages.UsageLanguage, strings.Join(i18n.Supported(), ", ")))
+	}
+	if err := bs.userService.SetLanguage(ctx, c.Sender().ID, locale); err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.language", err.Error()))
+	}
+	// Confirm in the new language
+	return c.Send(i18n.T(i18n.WithLocale(ctx, locale), messages.InfoLanguageSet))
+}
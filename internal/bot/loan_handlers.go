@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// NotifyLoanReminder implements services.Notifier by reminding the borrower
+// of a loan, overdue loans are reported to the lender as well
+func (bs *BotService) NotifyLoanReminder(ctx context.Context, loan *database.Loan, overdue bool) {
+	bctx := ctx
+	if borrower, err := bs.userService.GetUser(ctx, loan.BorrowerTelegramID); err == nil {
+		bctx = userLocale(ctx, borrower)
+	}
+	due := i18n.FormatDate(bctx, *loan.DueDate)
+	text := i18n.T(bctx, "bot.loan_due_soon", loan.LenderUsername, loan.Currency.Sign, loan.Outstanding(), due)
+	if overdue {
+		text = i18n.T(bctx, "bot.loan_overdue", loan.LenderUsername, due, loan.Currency.Sign, loan.Outstanding(), loan.LenderUsername)
+	}
+	if _, err := bs.bot.Send(&tele.User{ID: loan.BorrowerTelegramID}, text); err != nil {
+		logger.ErrorContext(ctx, "Failed to remind borrower", "loan", loan.ID, "error", err)
+	}
+	if !overdue {
+		return
+	}
+
+	lctx := ctx
+	if lender, err := bs.userService.GetUser(ctx, loan.LenderTelegramID); err == nil {
+		lctx = userLocale(ctx, lender)
+	}
+	text = i18n.T(lctx, "bot.loan_overdue_lender", loan.BorrowerUsername, i18n.FormatDate(lctx, *loan.DueDate), loan.Currency.Sign, loan.Outstanding())
+	if _, err := bs.bot.Send(&tele.User{ID: loan.LenderTelegramID}, text); err != nil {
+		logger.ErrorContext(ctx, "Failed to notify lender", "loan", loan.ID, "error", err)
+	}
+}
+
+// handleLoans lists the open loans the sender gave or owes
+func (bs *BotService) handleLoans(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	user, err := bs.userService.GetUser(ctx, c.Sender().ID)
+	if err != nil {
+		return c.Send(i18n.T(ctx, messages.ErrUserNotFound))
+	}
+	loans, err := bs.coreService.ListLoans(ctx, c.Sender().ID)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.loans", err.Error()))
+	}
+	return c.Send(messages.FormatLoans(ctx, loans, user, bs.coreService.Now()))
+}
+
+// handleLend lends money to another user.
+// Usage: /lend <@username> <amount> [currency] [due YYYY-MM-DD]
+func (bs *BotService) handleLend(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	args := c.Args()
+	if len(args) < 2 {
+		return c.Send(i18n.T(ctx, messages.UsageLend))
+	}
+	borrower := strings.TrimPrefix(args[0], "@")
+	amount, err := services.ParseAmount(args[1])
+	if err != nil {
+		return c.Send(i18n.T(ctx, messages.ErrInvalidAmount))
+	}
+
+	var currencyCode string
+	var due *time.Time
+	rest := args[2:]
+	if len(rest) > 0 && !strings.EqualFold(rest[0], "due") {
+		currencyCode = strings.ToUpper(rest[0])
+		rest = rest[1:]
+	}
+	switch {
+	case len(rest) == 2 && strings.EqualFold(rest[0], "due"):
+		t, err := time.ParseInLocation("2006-01-02", rest[1], i18n.Location(ctx))
+		if err != nil {
+			return c.Send(i18n.T(ctx, messages.ErrInvalidDate, rest[1]))
+		}
+		t = t.UTC()
+		due = &t
+	case len(rest) != 0:
+		return c.Send(i18n.T(ctx, messages.UsageLend))
+	}
+	if currencyCode == "" {
+		defaultCurrency, err := bs.coreService.GetDefaultCurrency(ctx)
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.error.default_currency", err.Error()))
+		}
+		currencyCode = defaultCurrency.Code
+	}
+
+	fee, err := bs.coreService.QuoteTransferFee(ctx, c.Sender().ID, amount, currencyCode)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.transfer", err.Error()))
+	}
+
+	loan, err := bs.coreService.Lend(ctx, c.Sender().ID, borrower, amount, currencyCode, due)
+	if msg := pendingMessage(ctx, err); msg != "" {
+		return c.Send(msg)
+	}
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.transfer", err.Error()))
+	}
+
+	response := i18n.T(ctx, messages.InfoLoanCreated, loan.Amount, currencyCode, loan.BorrowerUsername)
+	if loan.DueDate != nil {
+		response += " " + i18n.T(ctx, messages.InfoLoanDue, i18n.FormatDate(ctx, *loan.DueDate))
+	}
+	if fee > 0 {
+		response += "\n" + i18n.T(ctx, messages.InfoTransferFee, fee, currencyCode)
+	}
+	return c.Send(response)
+}
+
+// handleRepay pays back a loan. Usage: /repay <@username> <amount> [currency]
+func (bs *BotService) handleRepay(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	args := c.Args()
+	if len(args) < 2 || len(args) > 3 {
+		return c.Send(i18n.T(ctx, messages.UsageRepay))
+	}
+	lender := strings.TrimPrefix(args[0], "@")
+	amount, err := services.ParseAmount(args[1])
+	if err != nil {
+		return c.Send(i18n.T(ctx, messages.ErrInvalidAmount))
+	}
+	var currencyCode string
+	if len(args) == 3 {
+		currencyCode = strings.ToUpper(args[2])
+	}
+
+	repayment, err := bs.coreService.Repay(ctx, c.Sender().ID, lender, amount, currencyCode)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+	if repayment.Outstanding > 0 {
+		return c.Send(i18n.T(ctx, messages.InfoLoanRepaid, amount, repayment.CurrencyCode, lender, repayment.Outstanding, repayment.CurrencyCode))
+	}
+	return c.Send(i18n.T(ctx, messages.InfoLoanSettled, amount, repayment.CurrencyCode, lender))
+}
@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	tele "gopkg.in/telebot.v3"
+)
+
+// pollErrorBackoff is the pause after a failed getUpdates call
+const pollErrorBackoff = 2 * time.Second
+
+// trackingPoller is a long poller that remembers when it last reached
+// Telegram, so that health checks can tell whether updates still arrive
+type trackingPoller struct {
+	Timeout time.Duration
+
+	mu           sync.Mutex
+	running      bool
+	lastSuccess  time.Time
+	lastError    error
+	lastUpdateID int
+}
+
+func (p *trackingPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	p.setRunning(true)
+	defer p.setRunning(false)
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		updates, err := p.getUpdates(b)
+		p.record(err)
+		if err != nil {
+			logger.Warn("Failed to poll Telegram updates", "error", err)
+			select {
+			case <-stop:
+				return
+			case <-time.After(pollErrorBackoff):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			p.lastUpdateID = update.ID
+			dest <- update
+		}
+	}
+}
+
+func (p *trackingPoller) getUpdates(b *tele.Bot) ([]tele.Update, error) {
+	params := map[string]string{
+		"offset":  strconv.Itoa(p.lastUpdateID + 1),
+		"timeout": strconv.Itoa(int(p.Timeout / time.Second)),
+	}
+	data, err := b.Raw("getUpdates", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Result []tele.Update
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+func (p *trackingPoller) setRunning(running bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running = running
+}
+
+func (p *trackingPoller) record(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastError = err
+	if err == nil {
+		p.lastSuccess = time.Now()
+	}
+}
+
+// Alive reports an error unless the polling loop is running
+func (p *trackingPoller) Alive(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running {
+		return errors.New("poller is not running")
+	}
+	return nil
+}
+
+// Ready reports an error unless the last successful poll is recent. A poll
+// lasts up to Timeout, so anything older than a few timeouts is stale.
+func (p *trackingPoller) Ready(ctx context.Context) error {
+	if err := p.Alive(ctx); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastSuccess.IsZero() {
+		return errors.New("no successful poll yet")
+	}
+	if since := time.Since(p.lastSuccess); since > 3*p.Timeout {
+		if p.lastError != nil {
+			return fmt.Errorf("last successful poll %s ago: %w", since.Round(time.Second), p.lastError)
+		}
+		return fmt.Errorf("last successful poll %s ago", since.Round(time.Second))
+	}
+	return nil
+}
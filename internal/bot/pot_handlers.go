@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// handlePots lists the savings pots of the sender
+func (bs *BotService) handlePots(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	pots, err := bs.coreService.ListPots(ctx, c.Sender().ID)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.pots", err.Error()))
+	}
+	return c.Send(messages.FormatPots(ctx, pots))
+}
+
+// handlePot creates pots and moves money between them.
+// Usage: /pot new <name> <currency> [goal] [deadline] or /pot move <amount> <currency> <from> <to>
+func (bs *BotService) handlePot(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	args := c.Args()
+	if len(args) == 0 {
+		return c.Send(i18n.T(ctx, messages.UsagePot))
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "new":
+		return bs.handlePotNew(c, args[1:])
+	case "move":
+		return bs.handlePotMove(c, args[1:])
+	default:
+		return c.Send(i18n.T(ctx, messages.UsagePot))
+	}
+}
+
+func (bs *BotService) handlePotNew(c tele.Context, args []string) error {
+	ctx := bs.requestContext(c)
+	if len(args) < 2 || len(args) > 4 {
+		return c.Send(i18n.T(ctx, messages.UsagePot))
+	}
+	name, currencyCode := args[0], strings.ToUpper(args[1])
+
+	// The goal and the deadline are both optional, dates tell them apart
+	var goal float64
+	var deadline *time.Time
+	for _, arg := range args[2:] {
+		if strings.Count(arg, "-") == 2 {
+			t, err := time.ParseInLocation("2006-01-02", arg, i18n.Location(ctx))
+			if err != nil {
+				return c.Send(i18n.T(ctx, messages.ErrInvalidDate, arg))
+			}
+			t = t.UTC()
+			deadline = &t
+			continue
+		}
+		value, err := services.ParseAmount(arg)
+		if err != nil {
+			return c.Send(i18n.T(ctx, messages.ErrInvalidAmount))
+		}
+		goal = value
+	}
+
+	pot, err := bs.coreService.CreatePot(ctx, c.Sender().ID, name, currencyCode, goal, deadline)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+	return c.Send(i18n.T(ctx, messages.InfoPotCreated, pot.Pot))
+}
+
+func (bs *BotService) handlePotMove(c tele.Context, args []string) error {
+	ctx := bs.requestContext(c)
+	if len(args) != 4 {
+		return c.Send(i18n.T(ctx, messages.UsagePot))
+	}
+	amount, err := services.ParseAmount(args[0])
+	if err != nil {
+		return c.Send(i18n.T(ctx, messages.ErrInvalidAmount))
+	}
+	currencyCode := strings.ToUpper(args[1])
+	from, to := strings.ToLower(args[2]), strings.ToLower(args[3])
+
+	if err := bs.coreService.MoveBetweenPots(ctx, c.Sender().ID, amount, currencyCode, from, to); err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+	return c.Send(i18n.T(ctx, messages.InfoPotMoved, amount, currencyCode, from, to))
+}
@@ -0,0 +1,34 @@
+package bot
+
+import (
+	"errors"
+
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// syncProfile stores the sender's Telegram profile with every update, so
+// that username changes are picked up without waiting for /start. Users are
+// only registered by /start.
+func (bs *BotService) syncProfile(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		sender := c.Sender()
+		if sender == nil || sender.IsBot {
+			return next(c)
+		}
+
+		ctx := contextOf(c)
+		err := bs.userService.SyncProfile(ctx, services.Profile{
+			TelegramID:   sender.ID,
+			Username:     sender.Username,
+			FirstName:    sender.FirstName,
+			LastName:     sender.LastName,
+			LanguageCode: sender.LanguageCode,
+		})
+		if err != nil && !errors.Is(err, services.ErrUserNotFound) {
+			logger.ErrorContext(ctx, "Failed to sync profile", "error", err)
+		}
+		return next(c)
+	}
+}
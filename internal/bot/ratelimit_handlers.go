@@ -0,0 +1,79 @@
+package bot
+
+import (
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/metrics"
+	"github.com/fitz123/mcduck-wallet/internal/ratelimit"
+	tele "gopkg.in/telebot.v3"
+)
+
+// moneyCommands move money and are additionally limited by ratelimit.Money
+var moneyCommands = map[string]bool{
+	"/transfer": true,
+	"/pot":      true,
+	"/lend":     true,
+	"/repay":    true,
+	"/escrow":   true,
+	"/redeem":   true,
+	"/refund":   true,
+}
+
+// rateLimit throttles every sender. A throttled sender is told to slow down
+// once, further updates are dropped until the bucket refills. It runs before
+// any middleware that touches the database, so the reply is in the language
+// Telegram reports.
+func (bs *BotService) rateLimit(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if c.Sender() == nil {
+			return next(c)
+		}
+
+		subject := ratelimit.UserSubject(c.Sender().ID)
+		decision := bs.limiter.Allow(ratelimit.General, subject)
+		if decision.Allowed && moneyCommands[metrics.UpdateCommand(c)] {
+			decision = bs.limiter.Allow(ratelimit.Money, subject)
+		}
+		if decision.Allowed {
+			return next(c)
+		}
+
+		ctx := i18n.WithLocale(contextOf(c), i18n.Resolve("", c.Sender().LanguageCode))
+		msg := i18n.T(ctx, messages.ErrSlowDown, ratelimit.RetrySeconds(decision.RetryAfter))
+		if c.Callback() != nil {
+			// Callbacks must always be answered, or the button keeps spinning
+			return c.Respond(&tele.CallbackResponse{Text: msg})
+		}
+		if !decision.FirstRejection {
+			return nil
+		}
+		return c.Send(msg)
+	}
+}
+
+// handleAdminThrottled lists the users and addresses rejected by rate limiting
+func (bs *BotService) handleAdminThrottled(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
+	}
+
+	stats := bs.limiter.Throttled()
+	if len(stats) == 0 {
+		return c.Send(i18n.T(ctx, messages.InfoNoThrottled))
+	}
+
+	lines := []string{i18n.T(ctx, messages.InfoThrottled)}
+	for _, stat := range stats {
+		name := stat.Subject
+		if id, ok := ratelimit.ParseUserSubject(stat.Subject); ok {
+			if user, err := bs.userService.GetUser(ctx, id); err == nil {
+				name = "@" + user.Username
+			}
+		}
+		lines = append(lines, i18n.T(ctx, messages.InfoThrottledEntry, name, stat.Class, stat.Count, stat.Last.Format("2006-01-02 15:04:05")))
+	}
+	return c.Send(strings.Join(lines, "\n"))
+}
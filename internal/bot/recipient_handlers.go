@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"strconv"
+
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	tele "gopkg.in/telebot.v3"
+)
+
+// replyRecipient returns the Telegram ID of the user a command names by
+// replying to a shared contact, to a forwarded message or, in groups, to a
+// message of that user. It returns a locale key instead when the replied
+// message cannot name a user, and nothing when there is no reply.
+func replyRecipient(m *tele.Message) (recipient, errKey string) {
+	reply := m.ReplyTo
+	if reply == nil {
+		return "", ""
+	}
+	switch {
+	case reply.Contact != nil:
+		if reply.Contact.UserID == 0 {
+			return "", "bot.error.contact_not_on_telegram"
+		}
+		return strconv.FormatInt(reply.Contact.UserID, 10), ""
+	case reply.OriginalSender != nil:
+		return strconv.FormatInt(reply.OriginalSender.ID, 10), ""
+	case reply.OriginalSenderName != "":
+		return "", "bot.error.forward_hidden"
+	case reply.Sender != nil && !reply.Sender.IsBot && reply.Sender.ID != m.Sender.ID:
+		return strconv.FormatInt(reply.Sender.ID, 10), ""
+	}
+	return "", ""
+}
+
+// handleContact explains how to pay a shared contact
+func (bs *BotService) handleContact(c tele.Context) error {
+	if c.Chat().Type != tele.ChatPrivate {
+		return nil
+	}
+	ctx := bs.requestContext(c)
+	contact := c.Message().Contact
+	if contact.UserID == 0 {
+		return c.Send(i18n.T(ctx, "bot.error.contact_not_on_telegram"))
+	}
+	user, err := bs.coreService.ResolveRecipient(ctx, strconv.FormatInt(contact.UserID, 10))
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.contact_not_registered", contact.FirstName))
+	}
+	return c.Send(i18n.T(ctx, "bot.contact_hint", messages.DisplayName(user)))
+}
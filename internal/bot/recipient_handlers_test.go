@@ -0,0 +1,33 @@
+package bot
+
+import (
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestReplyRecipient(t *testing.T) {
+	sender := &tele.User{ID: 1}
+	for _, tc := range []struct {
+		name      string
+		reply     *tele.Message
+		recipient string
+		errKey    string
+	}{
+		{"no reply", nil, "", ""},
+		{"shared contact", &tele.Message{Contact: &tele.Contact{UserID: 42, PhoneNumber: "+100"}}, "42", ""},
+		{"contact not on Telegram", &tele.Message{Contact: &tele.Contact{PhoneNumber: "+100"}}, "", "bot.error.contact_not_on_telegram"},
+		{"forwarded message", &tele.Message{Sender: sender, OriginalSender: &tele.User{ID: 43}}, "43", ""},
+		{"forward from a hidden account", &tele.Message{Sender: sender, OriginalSenderName: "Dave"}, "", "bot.error.forward_hidden"},
+		{"message of another user", &tele.Message{Sender: &tele.User{ID: 44}}, "44", ""},
+		{"own message", &tele.Message{Sender: sender}, "", ""},
+		{"message of a bot", &tele.Message{Sender: &tele.User{ID: 45, IsBot: true}}, "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recipient, errKey := replyRecipient(&tele.Message{Sender: sender, ReplyTo: tc.reply})
+			if recipient != tc.recipient || errKey != tc.errKey {
+				t.Errorf("replyRecipient = %q, %q, want %q, %q", recipient, errKey, tc.recipient, tc.errKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+var btnRefund = tele.Btn{Unique: "refund"}
+
+// NotifyReversal implements services.Notifier by telling the sender about
+// the money they got back, and the recipient when an admin took it
+func (bs *BotService) NotifyReversal(ctx context.Context, reversal *services.Reversal) {
+	actor := services.ActorFromContext(ctx).TelegramID
+	if reversal.Sender.TelegramID != actor {
+		sctx := userLocale(ctx, &reversal.Sender)
+		text := i18n.T(sctx, "bot.refund_received", reversal.Recipient.Username, reversal.Currency.Sign, reversal.Amount)
+		if _, err := bs.bot.Send(&tele.User{ID: reversal.Sender.TelegramID}, text); err != nil {
+			logger.ErrorContext(ctx, "Failed to notify about refund", "transaction", reversal.Original.ID, "error", err)
+		}
+	}
+	if reversal.ByAdmin && reversal.Recipient.TelegramID != actor {
+		rctx := userLocale(ctx, &reversal.Recipient)
+		text := i18n.T(rctx, "bot.refund_by_admin", reversal.Currency.Sign, reversal.Amount, reversal.Sender.Username)
+		if _, err := bs.bot.Send(&tele.User{ID: reversal.Recipient.TelegramID}, text); err != nil {
+			logger.ErrorContext(ctx, "Failed to notify about reversal", "transaction", reversal.Original.ID, "error", err)
+		}
+	}
+}
+
+// handleRefund lists transfers the sender can refund, or refunds one.
+// Usage: /refund [<transaction id> [amount]]
+func (bs *BotService) handleRefund(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	args := c.Args()
+	if len(args) == 0 {
+		return bs.listRefundable(c)
+	}
+	if len(args) > 2 {
+		return c.Send(i18n.T(ctx, messages.UsageRefund))
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(args[0], "#"), 10, 64)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.invalid_transaction"))
+	}
+	var amount float64
+	if len(args) == 2 {
+		if amount, err = services.ParseAmount(args[1]); err != nil || amount <= 0 {
+			return c.Send(i18n.T(ctx, messages.ErrInvalidAmount))
+		}
+	}
+
+	reversal, err := bs.coreService.ReverseTransaction(ctx, c.Sender().ID, uint(id), amount)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.refund", err.Error()))
+	}
+	return c.Send(refundedText(ctx, reversal))
+}
+
+func (bs *BotService) listRefundable(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	transactions, err := bs.coreService.ListRefundable(ctx, c.Sender().ID)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.history", err.Error()))
+	}
+	if len(transactions) == 0 {
+		return c.Send(i18n.T(ctx, messages.InfoNoRefundable))
+	}
+
+	lines := []string{i18n.T(ctx, "bot.refundable_title")}
+	markup := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for _, t := range transactions {
+		line := i18n.T(ctx, "bot.refundable_item", t.ID, i18n.FormatDateTime(ctx, t.Timestamp), t.FromUsername, t.Balance.Currency.Sign, t.Amount)
+		if t.Reversed > 0 {
+			line += i18n.T(ctx, "bot.refundable_partly", t.Reversed)
+		}
+		lines = append(lines, line)
+		id := strconv.FormatUint(uint64(t.ID), 10)
+		rows = append(rows, markup.Row(markup.Data(i18n.T(ctx, "bot.btn.refund", t.ID), btnRefund.Unique, id)))
+	}
+	markup.Inline(rows...)
+	return c.Send(strings.Join(lines, "\n"), markup)
+}
+
+// handleRefundCallback refunds what is left of a transfer
+func (bs *BotService) handleRefundCallback(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	id, err := strconv.ParseUint(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(ctx, "bot.error.invalid_transaction")})
+	}
+
+	reversal, err := bs.coreService.ReverseTransaction(ctx, c.Sender().ID, uint(id), 0)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(ctx, "bot.error.refund", err.Error()), ShowAlert: true})
+	}
+	if err := c.Respond(); err != nil {
+		return err
+	}
+	return c.Send(refundedText(ctx, reversal))
+}
+
+// refundedText confirms a reversal, mentioning the refunded fee and the
+// recipient's overdraft
+func refundedText(ctx context.Context, reversal *services.Reversal) string {
+	text := i18n.T(ctx, messages.InfoRefunded, reversal.Currency.Sign, reversal.Amount, reversal.Sender.Username)
+	if reversal.Fee > 0 {
+		text += i18n.T(ctx, "bot.refund_fee", reversal.Currency.Sign, reversal.Fee)
+	}
+	if reversal.Overdraft > 0 {
+		text += i18n.T(ctx, "bot.refund_overdraft", reversal.Recipient.Username, reversal.Currency.Sign, reversal.Overdraft)
+	}
+	return text
+}
@@ -0,0 +1,43 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// handleTimezone shows or changes the time zone of the sender. Usage: /timezone [zone|auto]
+func (bs *BotService) handleTimezone(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	args := c.Args()
+	if len(args) == 0 {
+		loc := i18n.Location(ctx)
+		current := i18n.T(ctx, messages.InfoTimezoneCurrent, loc.String(), i18n.FormatDateTime(ctx, time.Now()))
+		return c.Send(current + "\n" + i18n.T(ctx, messages.UsageTimezone))
+	}
+	if len(args) != 1 {
+		return c.Send(i18n.T(ctx, messages.UsageTimezone))
+	}
+
+	// An empty zone lets the WebApp detect it again
+	timezone := args[0]
+	if strings.EqualFold(timezone, "auto") {
+		timezone = ""
+	}
+	err := bs.userService.SetTimezone(ctx, c.Sender().ID, timezone)
+	if errors.Is(err, services.ErrInvalidTimezone) {
+		return c.Send(i18n.T(ctx, messages.ErrInvalidTimezone, args[0]) + "\n" + i18n.T(ctx, messages.UsageTimezone))
+	}
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.timezone", err.Error()))
+	}
+	if timezone == "" {
+		return c.Send(i18n.T(ctx, messages.InfoTimezoneAuto))
+	}
+	return c.Send(i18n.T(ctx, messages.InfoTimezoneSet, timezone))
+}
@@ -0,0 +1,134 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// redeemPayload prefixes the /start payload of voucher deep links
+	redeemPayload = "redeem_"
+	// vouchersPerMessage keeps voucher lists below the message size limit
+	vouchersPerMessage = 40
+)
+
+// handleVoucher lets admins issue vouchers and see what became of them.
+// Usage: /voucher create <amount> <currency> [x<count>] [expires <ttl>] [system] or /voucher report
+func (bs *BotService) handleVoucher(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
+	}
+	args := c.Args()
+	if len(args) == 0 {
+		return c.Send(i18n.T(ctx, messages.UsageVoucher))
+	}
+	switch strings.ToLower(args[0]) {
+	case "create":
+		return bs.handleVoucherCreate(c, args[1:])
+	case "report":
+		return bs.handleVoucherReport(c)
+	}
+	return c.Send(i18n.T(ctx, messages.UsageVoucher))
+}
+
+func (bs *BotService) handleVoucherCreate(c tele.Context, args []string) error {
+	ctx := bs.requestContext(c)
+	if len(args) < 2 {
+		return c.Send(i18n.T(ctx, messages.UsageVoucher))
+	}
+	amount, err := services.ParseAmount(args[0])
+	if err != nil {
+		return c.Send(i18n.T(ctx, messages.ErrInvalidAmount))
+	}
+	currencyCode := strings.ToUpper(args[1])
+
+	count := 1
+	var ttl time.Duration
+	fromSystem := false
+	for rest := args[2:]; len(rest) > 0; rest = rest[1:] {
+		switch arg := strings.ToLower(rest[0]); {
+		case arg == "system":
+			fromSystem = true
+		case arg == "expires" && len(rest) > 1:
+			ttl, err = services.ParseDuration(rest[1])
+			if err != nil || ttl <= 0 {
+				return c.Send(i18n.T(ctx, messages.UsageVoucher))
+			}
+			rest = rest[1:]
+		case strings.HasPrefix(arg, "x"):
+			count, err = strconv.Atoi(arg[1:])
+			if err != nil {
+				return c.Send(i18n.T(ctx, messages.UsageVoucher))
+			}
+		default:
+			return c.Send(i18n.T(ctx, messages.UsageVoucher))
+		}
+	}
+
+	vouchers, err := bs.coreService.CreateVouchers(ctx, c.Sender().ID, amount, currencyCode, count, ttl, fromSystem)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+
+	lines := []string{fmt.Sprintf("Created %d vouchers of %s%.2f in batch %s:", len(vouchers), vouchers[0].Currency.Sign, amount, vouchers[0].Batch)}
+	if expiresAt := vouchers[0].ExpiresAt; expiresAt != nil {
+		lines[0] = fmt.Sprintf("Created %d vouchers of %s%.2f in batch %s, valid until %s:", len(vouchers), vouchers[0].Currency.Sign, amount, vouchers[0].Batch, i18n.FormatDateTime(ctx, *expiresAt))
+	}
+	for _, voucher := range vouchers {
+		lines = append(lines, fmt.Sprintf("%s https://t.me/%s?start=%s%s", voucher.Code, bs.bot.Me.Username, redeemPayload, voucher.Code))
+	}
+	// Large batches do not fit into a single message
+	for len(lines) > 0 {
+		n := min(len(lines), vouchersPerMessage)
+		if err := c.Send(strings.Join(lines[:n], "\n"), tele.NoPreview); err != nil {
+			return err
+		}
+		lines = lines[n:]
+	}
+	return nil
+}
+
+func (bs *BotService) handleVoucherReport(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	batches, err := bs.coreService.VoucherReport(ctx)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+	if len(batches) == 0 {
+		return c.Send(i18n.T(ctx, messages.InfoNoVouchers))
+	}
+
+	lines := []string{"Voucher batches:"}
+	for _, b := range batches {
+		lines = append(lines, fmt.Sprintf("%s %s by @%s: %.2f %s each, issued %d, redeemed %d, expired %d",
+			b.Batch, i18n.FormatDate(ctx, b.CreatedAt), b.CreatedByUsername, b.Amount, b.CurrencyCode,
+			b.Issued, b.Redeemed, b.Expired))
+	}
+	return c.Send(strings.Join(lines, "\n"))
+}
+
+// handleRedeem pays a voucher to the sender. Usage: /redeem <code>
+func (bs *BotService) handleRedeem(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	if len(c.Args()) == 0 {
+		return c.Send(i18n.T(ctx, messages.UsageRedeem))
+	}
+	return bs.redeemVoucher(c, strings.Join(c.Args(), ""))
+}
+
+func (bs *BotService) redeemVoucher(c tele.Context, code string) error {
+	ctx := bs.requestContext(c)
+	voucher, err := bs.coreService.RedeemVoucher(ctx, c.Sender().ID, code)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.voucher", err.Error()))
+	}
+	return c.Send(i18n.T(ctx, messages.InfoVoucherRedeemed, voucher.Currency.Sign, voucher.Amount))
+}
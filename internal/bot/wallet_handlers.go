@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// selectWallet limits the update's context to the sender's active wallet, so
+// that handlers only see the members, currencies and money of that wallet
+func (bs *BotService) selectWallet(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if c.Sender() == nil {
+			return next(c)
+		}
+
+		ctx := contextOf(c)
+		walletID, err := bs.userService.ActiveWallet(ctx, c.Sender().ID)
+		if err != nil {
+			return fmt.Errorf("select wallet: %w", err)
+		}
+		c.Set(updateContextKey, database.WithWallet(ctx, walletID))
+		return next(c)
+	}
+}
+
+// handleWallet lists the sender's wallets or switches to another one, admins
+// of the default wallet also create wallets.
+// Usage: /wallet, /wallet <name> or /wallet new <name>
+func (bs *BotService) handleWallet(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	args := c.Args()
+	if len(args) > 1 && strings.EqualFold(args[0], "new") {
+		name := strings.Join(args[1:], " ")
+		wallet, err := bs.coreService.CreateWallet(ctx, c.Sender().ID, name)
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+		}
+		return c.Send(i18n.T(ctx, messages.InfoWalletCreated, wallet.Name, wallet.Name))
+	}
+
+	wallets, err := bs.userService.ListWallets(ctx, c.Sender().ID)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+	if len(args) == 0 {
+		if len(wallets) == 0 {
+			return c.Send(i18n.T(ctx, messages.UsageWallet))
+		}
+		active := database.WalletFromContext(ctx)
+		lines := make([]string, len(wallets))
+		for i, wallet := range wallets {
+			mark := "▫️"
+			if wallet.ID == active {
+				mark = "✅"
+			}
+			lines[i] = fmt.Sprintf("%s %s", mark, wallet.Name)
+		}
+		return c.Send(i18n.T(ctx, messages.InfoWallets, strings.Join(lines, "\n")))
+	}
+
+	name := strings.Join(args, " ")
+	wallet := findWallet(wallets, name)
+	if wallet == nil {
+		return c.Send(i18n.T(ctx, "bot.error.not_wallet_member", name))
+	}
+	if err := bs.userService.SwitchWallet(ctx, c.Sender().ID, wallet.ID); err != nil {
+		if errors.Is(err, services.ErrNotWalletMember) {
+			return c.Send(i18n.T(ctx, "bot.error.not_wallet_member", name))
+		}
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+	return c.Send(i18n.T(ctx, messages.InfoWalletSwitched, wallet.Name))
+}
+
+// findWallet returns the wallet with the given name or ID, or nil
+func findWallet(wallets []database.Wallet, nameOrID string) *database.Wallet {
+	id, _ := strconv.ParseUint(nameOrID, 10, 64)
+	for i := range wallets {
+		if strings.EqualFold(wallets[i].Name, nameOrID) || uint64(wallets[i].ID) == id {
+			return &wallets[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// webhookLogSize is how many deliveries /webhook log shows
+const webhookLogSize = 20
+
+// handleWebhook lets admins manage the webhooks of their wallet.
+// Usage: /webhook add <url> [<event>,...], /webhook list, /webhook log <id> or /webhook remove <id>
+func (bs *BotService) handleWebhook(c tele.Context) error {
+	ctx := bs.requestContext(c)
+	if !bs.userService.IsAdmin(ctx, c.Sender().ID) {
+		return c.Send(i18n.T(ctx, messages.ErrUnauthorized))
+	}
+	usage := i18n.T(ctx, messages.UsageWebhook, strings.Join(services.EventTypes, ", "))
+	args := c.Args()
+	if len(args) == 0 {
+		return c.Send(usage)
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "add":
+		if len(args) < 2 || len(args) > 3 {
+			break
+		}
+		var events []string
+		if len(args) == 3 {
+			events = strings.Split(args[2], ",")
+		}
+		webhook, err := bs.coreService.CreateWebhook(ctx, c.Sender().ID, args[1], events)
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+		}
+		return c.Send(fmt.Sprintf("Webhook #%d added for %s.\nSecret: %s\nDeliveries are signed with HMAC-SHA256 of the body keyed with the secret, sent as %s: sha256=<hex>.",
+			webhook.ID, webhookEvents(webhook), webhook.Secret, services.WebhookSignatureHeader), tele.NoPreview)
+	case "list":
+		webhooks, err := bs.coreService.ListWebhooks(ctx)
+		if err != nil {
+			return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+		}
+		if len(webhooks) == 0 {
+			return c.Send("No webhooks yet.")
+		}
+		lines := []string{"Webhooks:"}
+		for _, webhook := range webhooks {
+			lines = append(lines, fmt.Sprintf("#%d %s, %s, by @%s", webhook.ID, webhook.URL, webhookEvents(&webhook), webhook.CreatedByUsername))
+		}
+		return c.Send(strings.Join(lines, "\n"), tele.NoPreview)
+	case "log", "remove":
+		if len(args) != 2 {
+			break
+		}
+		id, err := strconv.ParseUint(strings.TrimPrefix(args[1], "#"), 10, 64)
+		if err != nil {
+			break
+		}
+		if strings.EqualFold(args[0], "remove") {
+			if err := bs.coreService.DeleteWebhook(ctx, c.Sender().ID, uint(id)); err != nil {
+				return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+			}
+			return c.Send(fmt.Sprintf("Webhook #%d removed.", id))
+		}
+		return bs.sendWebhookLog(c, uint(id))
+	}
+	return c.Send(usage)
+}
+
+func (bs *BotService) sendWebhookLog(c tele.Context, webhookID uint) error {
+	ctx := bs.requestContext(c)
+	deliveries, err := bs.coreService.ListWebhookDeliveries(ctx, webhookID, webhookLogSize)
+	if err != nil {
+		return c.Send(i18n.T(ctx, "bot.error.failed", err.Error()))
+	}
+	if len(deliveries) == 0 {
+		return c.Send(fmt.Sprintf("Webhook #%d has no deliveries yet.", webhookID))
+	}
+
+	lines := []string{fmt.Sprintf("Latest deliveries of webhook #%d:", webhookID)}
+	for _, d := range deliveries {
+		line := fmt.Sprintf("#%d %s %s, %s, %d attempt(s)", d.ID, i18n.FormatDateTime(ctx, d.CreatedAt), d.EventType, d.Status, d.Attempts)
+		if d.StatusCode != 0 {
+			line += fmt.Sprintf(", HTTP %d", d.StatusCode)
+		}
+		if d.Error != "" {
+			line += ": " + d.Error
+		}
+		if d.Status == database.DeliveryPending && d.Attempts > 0 {
+			line += ", next try " + i18n.FormatDateTime(ctx, d.NextAttemptAt)
+		}
+		lines = append(lines, line)
+	}
+	return c.Send(strings.Join(lines, "\n"), tele.NoPreview)
+}
+
+// webhookEvents describes the events a webhook subscribed to
+func webhookEvents(webhook *database.Webhook) string {
+	if webhook.Events == "" {
+		return "all events"
+	}
+	return strings.ReplaceAll(webhook.Events, ",", ", ")
+}
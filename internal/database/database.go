@@ -2,6 +2,10 @@
 package database
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -11,20 +15,170 @@ type DB struct {
 }
 
 func New(dsn string) (*DB, error) {
-	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		// Store every timestamp in UTC, users see them in their own time zone
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Use(walletPlugin{}); err != nil {
+		return nil, err
+	}
+
+	sharedSettings, err := renameSharedSettings(db)
 	if err != nil {
 		return nil, err
 	}
 
 	// Auto-migrate your models here
-	err = db.AutoMigrate(&User{}, &Balance{}, &Transaction{}, &Currency{})
+	err = db.AutoMigrate(models...)
 	if err != nil {
 		return nil, err
 	}
+	if err := migrateWallets(db); err != nil {
+		return nil, err
+	}
+	if sharedSettings {
+		if err := migrateSharedSettings(db); err != nil {
+			return nil, err
+		}
+	}
+	if err := migratePendingTargets(db); err != nil {
+		return nil, err
+	}
+	if err := migrateTransferCounterparts(db); err != nil {
+		return nil, err
+	}
+	if err := migrateTransferRefunds(db); err != nil {
+		return nil, err
+	}
+	if err := migrateHeldMoney(db); err != nil {
+		return nil, err
+	}
+
+	// The audit log is append-only, enforce it at the database level as well
+	for _, stmt := range auditLogTriggers {
+		if err := db.Exec(stmt).Error; err != nil {
+			return nil, err
+		}
+	}
 
 	return &DB{Conn: db}, nil
 }
 
+// models lists every table managed by AutoMigrate
+var models = []any{&User{}, &Balance{}, &Transaction{}, &Currency{}, &AuditLog{}, &TransferLimit{},
+	&Setting{}, &PendingOperation{}, &Approval{}, &InterestAccrual{}, &Loan{}, &Escrow{}, &Voucher{}, &UsernameChange{}, &Invite{}, &Wallet{}, &ActiveWallet{}, &Webhook{}, &WebhookDelivery{}}
+
+// migrateWallets creates the default wallet, which existing data belongs to
+// through the column defaults, and drops the indexes that made Telegram IDs
+// and currency codes unique across all wallets
+func migrateWallets(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&Wallet{}).Where("id = ?", DefaultWalletID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		wallet := Wallet{Model: gorm.Model{ID: DefaultWalletID}, Name: "default"}
+		if err := db.Create(&wallet).Error; err != nil {
+			return err
+		}
+	}
+
+	migrator := db.Migrator()
+	for _, index := range []struct {
+		model any
+		name  string
+	}{
+		{&User{}, "idx_users_telegram_id"},
+		{&Currency{}, "idx_currencies_code"},
+	} {
+		if migrator.HasIndex(index.model, index.name) {
+			if err := migrator.DropIndex(index.model, index.name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sharedSettingsTable keeps the settings of older versions, which applied to
+// all wallets, while they are copied to each wallet
+const sharedSettingsTable = "settings_shared"
+
+// renameSharedSettings moves a settings table without wallets out of the way
+// of AutoMigrate and reports whether there was one
+func renameSharedSettings(db *gorm.DB) (bool, error) {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&Setting{}) || migrator.HasColumn(&Setting{}, "WalletID") {
+		return false, nil
+	}
+	return true, migrator.RenameTable(&Setting{}, sharedSettingsTable)
+}
+
+// migrateSharedSettings gives every wallet a copy of the settings that used
+// to apply to all of them
+func migrateSharedSettings(db *gorm.DB) error {
+	err := db.Exec(`INSERT INTO settings (wallet_id, key, value, updated_at)
+		SELECT wallets.id, shared.key, shared.value, shared.updated_at
+		FROM ` + sharedSettingsTable + ` shared CROSS JOIN wallets
+		WHERE wallets.deleted_at IS NULL`).Error
+	if err != nil {
+		return err
+	}
+	return db.Migrator().DropTable(sharedSettingsTable)
+}
+
+// migratePendingTargets fills in the target user of operations queued by
+// older versions, which stored the target's username only
+func migratePendingTargets(db *gorm.DB) error {
+	return db.Exec(`UPDATE pending_operations SET target_user_id = COALESCE((
+		SELECT users.id FROM users
+		WHERE users.username = pending_operations.target_username
+			AND users.wallet_id = pending_operations.wallet_id
+			AND users.deleted_at IS NULL
+		ORDER BY users.id DESC LIMIT 1), 0)
+	WHERE target_user_id IS NULL`).Error
+}
+
+// migrateTransferCounterparts links both sides of transfers written by older
+// versions, which paired them by users, amount and time only. The outgoing
+// side was always written right before the incoming one.
+func migrateTransferCounterparts(db *gorm.DB) error {
+	return db.Exec(`UPDATE transactions SET counterpart_id = COALESCE((
+		SELECT CASE transactions.type WHEN 'transfer_in' THEN MAX(other.id) ELSE MIN(other.id) END
+		FROM transactions other
+		WHERE (transactions.type = 'transfer_in' AND other.type = 'transfer_out' AND other.id < transactions.id
+				OR transactions.type = 'transfer_out' AND other.type = 'transfer_in' AND other.id > transactions.id)
+			AND other.from_user_id = transactions.from_user_id
+			AND other.to_user_id = transactions.to_user_id
+			AND other.amount = -transactions.amount
+			AND other.timestamp = transactions.timestamp), 0)
+	WHERE counterpart_id IS NULL`).Error
+}
+
+// migrateTransferRefunds zeroes the refund columns of transactions written
+// before refunds existed, which the columns were added to as NULL
+func migrateTransferRefunds(db *gorm.DB) error {
+	return db.Exec(`UPDATE transactions SET reversed = COALESCE(reversed, 0), fee = COALESCE(fee, 0)
+	WHERE reversed IS NULL OR fee IS NULL`).Error
+}
+
+// migrateHeldMoney zeroes the money on hold of balances opened before holds
+// existed, which the column was added to as NULL
+func migrateHeldMoney(db *gorm.DB) error {
+	return db.Exec(`UPDATE balances SET held = 0 WHERE held IS NULL`).Error
+}
+
+var auditLogTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS audit_logs_no_update BEFORE UPDATE ON audit_logs
+	BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+	`CREATE TRIGGER IF NOT EXISTS audit_logs_no_delete BEFORE DELETE ON audit_logs
+	BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+}
+
 func (db *DB) Close() error {
 	sqlDB, err := db.Conn.DB()
 	if err != nil {
@@ -32,3 +186,33 @@ func (db *DB) Close() error {
 	}
 	return sqlDB.Close()
 }
+
+// Ping checks that the database answers
+func (db *DB) Ping(ctx context.Context) error {
+	sqlDB, err := db.Conn.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// CheckMigrations returns an error when a table or column of the models is
+// missing, e.g. because the schema was changed behind the application's back
+func (db *DB) CheckMigrations(ctx context.Context) error {
+	migrator := db.Conn.WithContext(ctx).Migrator()
+	for _, model := range models {
+		if !migrator.HasTable(model) {
+			return fmt.Errorf("table for %T is missing", model)
+		}
+		stmt := &gorm.Statement{DB: db.Conn}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !migrator.HasColumn(model, field.DBName) {
+				return fmt.Errorf("column %s.%s is missing", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+	return nil
+}
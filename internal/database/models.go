@@ -2,47 +2,395 @@
 package database
 
 import (
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// User account statuses
+const (
+	UserStatusActive = "active"
+	UserStatusFrozen = "frozen"
+	UserStatusClosed = "closed"
+)
+
+// User is a Telegram user's membership in a wallet. A Telegram user who
+// belongs to several wallets has a User in each of them.
 type User struct {
 	gorm.Model
-	TelegramID   int64 `gorm:"uniqueIndex"`
+	WalletID     uint  `gorm:"uniqueIndex:idx_user_wallet_telegram;default:1"`
+	TelegramID   int64 `gorm:"uniqueIndex:idx_user_wallet_telegram"`
 	Username     string
 	Accounts     []Balance
-	IsAdmin      bool `gorm:"default:false"`
+	IsAdmin      bool   `gorm:"default:false"`
+	IsApprover   bool   `gorm:"default:false"`
+	Status       string `gorm:"default:active"`
+	Language     string // locale chosen with /language, empty follows Telegram
+	Timezone     string // IANA time zone such as Europe/Berlin, empty means UTC
+	FirstName    string
+	LastName     string
+	AppLanguage  string // language of the user's Telegram app
+	InvitedByID  uint   `gorm:"index"` // user whose invite this user joined with, zero for none
+	InviteID     uint   // invite this user joined with, zero for none
 	Transactions []Transaction
 }
 
+// IsActive reports whether the user may move money
+func (u *User) IsActive() bool {
+	return u.Status == "" || u.Status == UserStatusActive
+}
+
+// Location returns the user's time zone, UTC when unset or unknown
+func (u *User) Location() *time.Location {
+	if u.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Balance holds a user's money in one currency. Every user has one main
+// balance per currency, savings pots are additional balances with a name.
 type Balance struct {
 	gorm.Model
 	UserID     uint
 	Amount     float64
+	Held       float64 `gorm:"default:0"` // money on hold for escrows and vouchers, not part of Amount
 	CurrencyID uint
 	Currency   Currency
+	Pot        string     `gorm:"index"` // empty for the main balance
+	Goal       float64    // savings goal of a pot, zero means none
+	Deadline   *time.Time // date the goal should be reached by
+}
+
+// IsPot reports whether the balance is a savings pot
+func (b *Balance) IsPot() bool {
+	return b.Pot != ""
+}
+
+// GoalProgress returns how much of the goal is saved in percent, capped at 100
+func (b *Balance) GoalProgress() float64 {
+	if b.Goal <= 0 {
+		return 0
+	}
+	return min(max(b.Amount/b.Goal*100, 0), 100)
 }
 
 type Transaction struct {
 	gorm.Model
-	UserID       uint
-	BalanceID    uint
-	Balance      Balance
-	Amount       float64
-	Type         string
-	FromUserID   uint
-	FromUsername string
-	ToUserID     uint
-	ToUsername   string
-	Timestamp    time.Time
-	BalanceAfter float64
+	UserID        uint
+	BalanceID     uint
+	Balance       Balance
+	Amount        float64
+	Type          string
+	FromUserID    uint
+	FromUsername  string
+	ToUserID      uint
+	ToUsername    string
+	Timestamp     time.Time
+	BalanceAfter  float64
+	Pot           string  // other pot of a move between own pots, empty for the main balance
+	LoanID        uint    `gorm:"index"`     // loan the transaction lends or repays, zero for none
+	EscrowID      uint    `gorm:"index"`     // escrow the transaction holds or settles, zero for none
+	ReversalOf    uint    `gorm:"index"`     // transaction this one refunds, zero for none
+	Reversed      float64 `gorm:"default:0"` // part of the transaction refunded so far
+	CounterpartID uint    `gorm:"index"`     // other side of a move between two balances, zero for none
+	Fee           float64 `gorm:"default:0"` // fee the sender paid on top of an outgoing transfer
 }
 
 type Currency struct {
 	gorm.Model
-	Code      string `gorm:"uniqueIndex"`
+	WalletID  uint   `gorm:"uniqueIndex:idx_currency_wallet_code;default:1"`
+	Code      string `gorm:"uniqueIndex:idx_currency_wallet_code"`
 	Name      string
 	Sign      string
 	IsDefault bool `gorm:"default:false"`
 }
+
+// AuditLog is an append-only record of an administrative action. Entries are
+// hash-chained: Hash covers the entry fields together with PrevHash, so editing
+// or deleting any row breaks the chain from that point on.
+type AuditLog struct {
+	ID              uint      `gorm:"primarykey"`
+	WalletID        uint      `gorm:"index;default:1"` // covered by the hash, the chain spans all wallets
+	CreatedAt       time.Time `gorm:"index"`
+	ActorTelegramID int64     `gorm:"index"`
+	ActorUsername   string
+	Action          string `gorm:"index"`
+	Target          string `gorm:"index"`
+	Before          string
+	After           string
+	Source          string
+	PrevHash        string
+	Hash            string `gorm:"uniqueIndex"`
+}
+
+// TransferLimit caps a user's outgoing transfers in one currency. Zero values
+// mean no limit.
+type TransferLimit struct {
+	gorm.Model
+	UserID     uint `gorm:"uniqueIndex:idx_transfer_limit_user_currency"`
+	CurrencyID uint `gorm:"uniqueIndex:idx_transfer_limit_user_currency"`
+	Currency   Currency
+	MaxSingle  float64
+	Daily      float64
+	Monthly    float64
+	MaxPerHour int
+}
+
+// Setting is a runtime configuration value changed by admins with /config,
+// every wallet has its own
+type Setting struct {
+	WalletID  uint   `gorm:"primaryKey;autoIncrement:false;default:1"`
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	UpdatedAt time.Time
+}
+
+// Pending operation types
+const (
+	OperationTransfer   = "transfer"
+	OperationSetBalance = "set_balance"
+	OperationLoan       = "loan"
+	OperationEscrow     = "escrow"
+)
+
+// Pending operation statuses
+const (
+	OperationPending  = "pending"
+	OperationExecuted = "executed"
+	OperationRejected = "rejected"
+	OperationExpired  = "expired"
+	OperationFailed   = "failed"
+)
+
+// PendingOperation is a transfer or balance adjustment waiting for approvals
+type PendingOperation struct {
+	gorm.Model
+	WalletID            uint `gorm:"index;default:1"`
+	Type                string
+	InitiatorID         uint
+	InitiatorTelegramID int64
+	InitiatorUsername   string
+	TargetUserID        uint
+	TargetUsername      string // for display, the target is found by TargetUserID
+	Amount              float64
+	CurrencyCode        string
+	Source              string
+	Status              string `gorm:"index"`
+	RequiredApprovals   int
+	ExpiresAt           time.Time
+	Error               string
+	DueDate             *time.Time // due date of a loan
+	Approvals           []Approval
+}
+
+// Approval is an approver's decision on a pending operation
+type Approval struct {
+	gorm.Model
+	PendingOperationID uint `gorm:"uniqueIndex:idx_approval_operation_approver"`
+	ApproverID         uint `gorm:"uniqueIndex:idx_approval_operation_approver"`
+	ApproverUsername   string
+	Approved           bool
+}
+
+// InterestAccrual is the interest a balance earned on one day. Accruals are
+// paid out monthly, PaidAt is set once they have been posted to the ledger.
+type InterestAccrual struct {
+	ID        uint   `gorm:"primarykey"`
+	BalanceID uint   `gorm:"uniqueIndex:idx_interest_accrual_balance_day"`
+	Day       string `gorm:"uniqueIndex:idx_interest_accrual_balance_day"` // YYYY-MM-DD in UTC
+	Balance   float64
+	Rate      float64 // yearly rate in percent
+	Amount    float64
+	PaidAt    *time.Time `gorm:"index"`
+}
+
+// Loan statuses
+const (
+	LoanOpen   = "open"
+	LoanRepaid = "repaid"
+)
+
+// Loan is money lent from one user to another. Lending and every repayment
+// are ledger transactions linked to the loan by LoanID.
+type Loan struct {
+	gorm.Model
+	WalletID           uint `gorm:"index;default:1"`
+	LenderID           uint `gorm:"index"`
+	LenderTelegramID   int64
+	LenderUsername     string
+	BorrowerID         uint `gorm:"index"`
+	BorrowerTelegramID int64
+	BorrowerUsername   string
+	CurrencyID         uint
+	Currency           Currency
+	Amount             float64
+	Repaid             float64
+	DueDate            *time.Time // start of the day the loan should be repaid by
+	Status             string     `gorm:"index"`
+	DueReminderAt      *time.Time // when the borrower was reminded of the coming due date
+	OverdueReminderAt  *time.Time // when the last reminder about the overdue loan was sent
+}
+
+// Outstanding returns the part of the loan that is not repaid yet
+func (l *Loan) Outstanding() float64 {
+	return max(l.Amount-l.Repaid, 0)
+}
+
+// IsOverdue reports whether the loan is still open after its due day
+func (l *Loan) IsOverdue(now time.Time) bool {
+	return l.Status == LoanOpen && l.DueDate != nil && now.After(l.DueDate.Add(24*time.Hour))
+}
+
+// Escrow statuses
+const (
+	EscrowHeld     = "held"
+	EscrowDisputed = "disputed"
+	EscrowReleased = "released"
+	EscrowRefunded = "refunded"
+)
+
+// Escrow is money the sender holds back for a recipient until they release
+// it. Meanwhile the amount sits in the Held part of the sender's balance.
+type Escrow struct {
+	gorm.Model
+	WalletID            uint `gorm:"index;default:1"`
+	SenderID            uint `gorm:"index"`
+	SenderTelegramID    int64
+	SenderUsername      string
+	RecipientID         uint `gorm:"index"`
+	RecipientTelegramID int64
+	RecipientUsername   string
+	BalanceID           uint // sender's balance holding the money
+	CurrencyID          uint
+	Currency            Currency
+	Amount              float64
+	Status              string    `gorm:"index"`
+	ExpiresAt           time.Time `gorm:"index"` // held escrows are refunded after this time
+	ResolvedAt          *time.Time
+	ResolvedBy          string // username of who settled the escrow, empty after a timeout
+}
+
+// IsOpen reports whether the escrow still holds its money
+func (e *Escrow) IsOpen() bool {
+	return e.Status == EscrowHeld || e.Status == EscrowDisputed
+}
+
+// Voucher is a code worth an amount of money that can be redeemed once.
+// Vouchers created together share a batch, a user redeems one per batch.
+type Voucher struct {
+	gorm.Model
+	WalletID           uint   `gorm:"index;default:1"`
+	Code               string `gorm:"uniqueIndex"`
+	Batch              string `gorm:"index"`
+	CurrencyID         uint
+	Currency           Currency
+	Amount             float64
+	CreatedByID        uint
+	CreatedByUsername  string
+	BalanceID          uint       // creator's balance holding the money, zero when the system account pays
+	ExpiresAt          *time.Time `gorm:"index"`
+	RedeemedByID       uint       `gorm:"index"`
+	RedeemedByUsername string
+	RedeemedAt         *time.Time
+	RefundedAt         *time.Time // when the money of an expired voucher went back to the creator
+}
+
+// IsExpired reports whether the voucher can no longer be redeemed because it expired
+func (v *Voucher) IsExpired(now time.Time) bool {
+	return v.RedeemedAt == nil && v.ExpiresAt != nil && !now.Before(*v.ExpiresAt)
+}
+
+// UsernameChange records that a user's Telegram username changed, so that
+// the old username can still be resolved
+type UsernameChange struct {
+	gorm.Model
+	UserID      uint   `gorm:"index"`
+	OldUsername string `gorm:"index"`
+	NewUsername string // empty when the user dropped the username
+}
+
+// Invite is a deep link that lets users join a wallet, new users also when
+// registration is closed
+type Invite struct {
+	gorm.Model
+	WalletID          uint   `gorm:"index;default:1"`
+	Code              string `gorm:"uniqueIndex"`
+	CreatedByID       uint
+	CreatedByUsername string
+	MaxUses           int // zero for unlimited
+	Uses              int
+	ExpiresAt         *time.Time // nil for never
+}
+
+// IsUsable reports whether the invite can still be used at now
+func (i *Invite) IsUsable(now time.Time) bool {
+	return (i.MaxUses == 0 || i.Uses < i.MaxUses) && (i.ExpiresAt == nil || now.Before(*i.ExpiresAt))
+}
+
+// Wallet is a group of users, such as a family, with its own currencies and
+// admins. Users, currencies and everything they own belong to one wallet,
+// queries only see the wallet in their context, see WithWallet.
+type Wallet struct {
+	gorm.Model
+	Name string `gorm:"uniqueIndex"`
+}
+
+// ActiveWallet is the wallet a Telegram user currently works with in the bot
+// and the WebApp
+type ActiveWallet struct {
+	TelegramID int64 `gorm:"primaryKey;autoIncrement:false"`
+	WalletID   uint
+	UpdatedAt  time.Time
+}
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL that receives the events of a wallet, signed with Secret
+type Webhook struct {
+	gorm.Model
+	WalletID          uint `gorm:"index;default:1"`
+	URL               string
+	Secret            string
+	Events            string // comma-separated event types, empty for all
+	CreatedByUsername string
+}
+
+// Accepts reports whether the webhook subscribed to events of eventType
+func (w *Webhook) Accepts(eventType string) bool {
+	if w.Events == "" {
+		return true
+	}
+	for _, accepted := range strings.Split(w.Events, ",") {
+		if accepted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is an event on its way to a webhook. Deliveries form the
+// outbox the delivery job works through and stay as the delivery log.
+type WebhookDelivery struct {
+	gorm.Model
+	WalletID      uint `gorm:"index;default:1"`
+	WebhookID     uint `gorm:"index"`
+	EventType     string
+	Payload       string // the JSON body, signed as is
+	Status        string `gorm:"index"`
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index"`
+	LastAttemptAt *time.Time
+	StatusCode    int    // HTTP status of the last attempt, zero when it got no response
+	Error         string // why the last attempt failed
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// DefaultWalletID is the wallet that holds everything created before wallets
+// existed, and the wallet of contexts that name none
+const DefaultWalletID uint = 1
+
+type walletKey struct{}
+
+// WithWallet returns a copy of ctx whose queries only see the data of walletID
+func WithWallet(ctx context.Context, walletID uint) context.Context {
+	return context.WithValue(ctx, walletKey{}, walletID)
+}
+
+// AnyWallet returns a copy of ctx whose queries see the data of all wallets,
+// for jobs and lookups that are not limited to one wallet
+func AnyWallet(ctx context.Context) context.Context {
+	return context.WithValue(ctx, walletKey{}, uint(0))
+}
+
+// WalletFromContext returns the wallet queries in ctx are limited to, zero
+// when they see all wallets
+func WalletFromContext(ctx context.Context) uint {
+	if ctx == nil {
+		return DefaultWalletID
+	}
+	walletID, ok := ctx.Value(walletKey{}).(uint)
+	if !ok {
+		return DefaultWalletID
+	}
+	return walletID
+}
+
+// walletPlugin keeps wallets apart: queries, updates and deletes of models
+// with a WalletID only see rows of the wallet in the statement context set
+// with db.WithContext, and new rows are created in that wallet
+type walletPlugin struct{}
+
+func (walletPlugin) Name() string {
+	return "wallets"
+}
+
+func (walletPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("wallets:create", assignWallet); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("wallets:query", scopeToWallet); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("wallets:update", scopeToWallet); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("wallets:delete", scopeToWallet); err != nil {
+		return err
+	}
+	return cb.Row().Before("gorm:row").Register("wallets:row", scopeToWallet)
+}
+
+// walletField returns the WalletID field of the statement's model, or nil
+func walletField(db *gorm.DB) *schema.Field {
+	if db.Statement.Schema == nil {
+		return nil
+	}
+	return db.Statement.Schema.LookUpField("WalletID")
+}
+
+func scopeToWallet(db *gorm.DB) {
+	walletID := WalletFromContext(db.Statement.Context)
+	field := walletField(db)
+	if walletID == 0 || field == nil {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: walletID},
+	}})
+}
+
+func assignWallet(db *gorm.DB) {
+	field := walletField(db)
+	if field == nil {
+		return
+	}
+	ctx := db.Statement.Context
+	walletID := WalletFromContext(ctx)
+	assign := func(row reflect.Value) {
+		if _, zero := field.ValueOf(ctx, row); !zero {
+			return
+		}
+		if walletID == 0 {
+			db.AddError(fmt.Errorf("%s: no wallet to create the row in", db.Statement.Schema.Name))
+			return
+		}
+		db.AddError(field.Set(ctx, row, walletID))
+	}
+
+	switch rows := db.Statement.ReflectValue; rows.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rows.Len(); i++ {
+			assign(reflect.Indirect(rows.Index(i)))
+		}
+	case reflect.Struct:
+		assign(rows)
+	}
+}
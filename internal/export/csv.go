@@ -0,0 +1,57 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/services"
+)
+
+// WriteCSV renders the statement as one CSV table, with opening and closing
+// balance rows around the transactions of every currency
+func WriteCSV(w io.Writer, statement *services.Statement) error {
+	cw := csv.NewWriter(w)
+	header := []string{"currency", "date", "id", "type", "counterparty", "amount", "running_balance", "pot"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	amount := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	date := func(t time.Time) string { return t.In(statement.User.Location()).Format(time.RFC3339) }
+	for _, account := range statement.Accounts {
+		code := account.Balance.Currency.Code
+		pot := account.Balance.Pot
+		opening := ""
+		if !statement.From.IsZero() {
+			opening = date(statement.From)
+		}
+		if err := cw.Write([]string{code, opening, "", "opening_balance", "", "", amount(account.OpeningBalance), pot}); err != nil {
+			return err
+		}
+		for _, row := range account.Rows {
+			t := row.Transaction
+			record := []string{
+				code,
+				date(t.Timestamp),
+				strconv.FormatUint(uint64(t.ID), 10),
+				t.Type,
+				counterparty(&t),
+				amount(t.Amount),
+				amount(row.RunningBalance),
+				pot,
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		closing := []string{code, date(statement.To), "", "closing_balance", "", "", amount(account.ClosingBalance), pot}
+		if err := cw.Write(closing); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
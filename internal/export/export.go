@@ -0,0 +1,124 @@
+// Package export renders account statements as downloadable documents
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+)
+
+// Supported statement formats
+const (
+	FormatCSV = "csv"
+	FormatOFX = "ofx"
+	FormatPDF = "pdf"
+)
+
+// clock returns the time documents are generated at
+var clock = time.Now
+
+// Write renders the statement in the given format
+func Write(w io.Writer, format string, statement *services.Statement) error {
+	switch format {
+	case FormatCSV:
+		return WriteCSV(w, statement)
+	case FormatOFX:
+		return WriteOFX(w, statement)
+	case FormatPDF:
+		return WritePDF(w, statement)
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// IsFormat reports whether s names a supported format
+func IsFormat(s string) bool {
+	return s == FormatCSV || s == FormatOFX || s == FormatPDF
+}
+
+// FileName returns the download file name for a statement
+func FileName(format string, statement *services.Statement) string {
+	loc := statement.User.Location()
+	from := "start"
+	if !statement.From.IsZero() {
+		from = statement.From.In(loc).Format("20060102")
+	}
+	return fmt.Sprintf("statement-%s-%s.%s", from, statement.To.In(loc).AddDate(0, 0, -1).Format("20060102"), format)
+}
+
+// MIMEType returns the content type of a format
+func MIMEType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatOFX:
+		return "application/x-ofx"
+	case FormatPDF:
+		return "application/pdf"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// counterparty returns the other side of a transaction from the owner's view
+func counterparty(t *database.Transaction) string {
+	if t.Amount < 0 {
+		return t.ToUsername
+	}
+	return t.FromUsername
+}
+
+// description returns a human readable description of a transaction
+func description(t *database.Transaction) string {
+	switch t.Type {
+	case "pot_out":
+		return "moved to " + potName(t.Pot)
+	case "pot_in":
+		return "moved from " + potName(t.Pot)
+	}
+	other := counterparty(t)
+	kind := strings.ReplaceAll(t.Type, "_", " ")
+	if other == "" {
+		return kind
+	}
+	return fmt.Sprintf("%s @%s", kind, other)
+}
+
+// potName names a pot in statements, the main balance has no pot name
+func potName(pot string) string {
+	if pot == "" {
+		return services.MainPot
+	}
+	return "pot " + pot
+}
+
+// ParsePeriod parses an optional inclusive date range in YYYY-MM-DD format.
+// Empty values mean from the first transaction and up to today. Days start
+// at midnight in the location of now, which should be the user's time zone.
+func ParsePeriod(fromStr, toStr string, now time.Time) (time.Time, time.Time, error) {
+	var from time.Time
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+
+	if fromStr != "" {
+		t, err := time.ParseInLocation("2006-01-02", fromStr, now.Location())
+		if err != nil {
+			return from, to, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", fromStr)
+		}
+		from = t
+	}
+	if toStr != "" {
+		t, err := time.ParseInLocation("2006-01-02", toStr, now.Location())
+		if err != nil {
+			return from, to, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", toStr)
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	if !from.IsZero() && !from.Before(to) {
+		return from, to, fmt.Errorf("start date must not be after end date")
+	}
+	return from, to, nil
+}
@@ -0,0 +1,165 @@
+package export
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var (
+	usd = database.Currency{Code: "USD", Name: "US Dollar", Sign: "$"}
+	eur = database.Currency{Code: "EUR", Name: "Euro", Sign: "€"}
+)
+
+func init() {
+	clock = func() time.Time { return time.Date(2025, 6, 5, 12, 0, 0, 0, time.UTC) }
+}
+
+// testStatement returns a statement of June 2 and 3 in Berlin with transfers,
+// a pot whose name needs escaping and a currency without movements
+func testStatement() *services.Statement {
+	user := database.User{TelegramID: 1, Username: "alice", Timezone: "Europe/Berlin"}
+	loc := user.Location()
+	at := func(day, hour, min int) time.Time { return time.Date(2025, 6, day, hour, min, 0, 0, loc).UTC() }
+	transaction := func(id uint, typ string, amount float64, at time.Time, from, to, pot string) database.Transaction {
+		t := database.Transaction{Type: typ, Amount: amount, Timestamp: at, FromUsername: from, ToUsername: to, Pot: pot}
+		t.ID = id
+		return t
+	}
+	pot := `trip, "fun" <&>`
+
+	return &services.Statement{
+		User: user,
+		From: at(2, 0, 0),
+		To:   at(4, 0, 0),
+		Accounts: []services.StatementAccount{
+			{
+				Balance:        database.Balance{Currency: eur},
+				OpeningBalance: 7,
+				ClosingBalance: 7,
+			},
+			{
+				Balance:        database.Balance{Currency: usd},
+				OpeningBalance: 100,
+				ClosingBalance: 55.5,
+				Rows: []services.StatementRow{
+					{Transaction: transaction(11, "transfer_out", -30, at(2, 0, 30), "alice", "bob", ""), RunningBalance: 70},
+					{Transaction: transaction(14, "transfer_in", 5.5, at(3, 9, 15), "carol", "alice", ""), RunningBalance: 75.5},
+					{Transaction: transaction(15, "pot_out", -20, at(3, 23, 59), "", "", pot), RunningBalance: 55.5},
+				},
+			},
+			{
+				Balance:        database.Balance{Currency: usd, Pot: pot},
+				ClosingBalance: 20,
+				Rows: []services.StatementRow{
+					{Transaction: transaction(16, "pot_in", 20, at(3, 23, 59), "", "", ""), RunningBalance: 20},
+				},
+			},
+		},
+	}
+}
+
+// emptyStatement returns a statement since the first transaction of a user
+// who has none
+func emptyStatement() *services.Statement {
+	return &services.Statement{
+		User: database.User{TelegramID: 2, Username: "bob"},
+		To:   time.Date(2025, 6, 5, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// assertGolden compares got with testdata/name, or rewrites it with -update
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n%s", name, got)
+	}
+}
+
+func TestGoldenStatements(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		statement *services.Statement
+	}{
+		{"statement", testStatement()},
+		{"empty", emptyStatement()},
+	} {
+		for _, format := range []string{FormatCSV, FormatOFX} {
+			t.Run(tc.name+"."+format, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := Write(&buf, format, tc.statement); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+				assertGolden(t, tc.name+"."+format, buf.Bytes())
+			})
+		}
+	}
+}
+
+func TestPDFIsAWellFormedDocument(t *testing.T) {
+	statement := testStatement()
+	// Enough rows for a second page
+	rows := statement.Accounts[1].Rows
+	for len(statement.Accounts[1].Rows) < pdfLinesPerPage {
+		statement.Accounts[1].Rows = append(statement.Accounts[1].Rows, rows...)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatPDF, statement); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	pdf := buf.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatal("document lacks the PDF header or trailer")
+	}
+	if !strings.Contains(pdf, "/Count 2 >>") {
+		t.Error("rows did not continue on a second page")
+	}
+	for _, text := range []string{
+		"Account: @alice",
+		"Period: 2025-06-02 - 2025-06-03",
+		"Generated: 2025-06-05 14:00 CEST",
+		`US Dollar (USD), pot trip, "fun" <&>`,
+		"Euro (EUR)",
+	} {
+		if !strings.Contains(pdf, "("+pdfEscape(text)+") '") {
+			t.Errorf("document does not show %q", text)
+		}
+	}
+
+	// Cross-reference offsets point at the objects they list
+	xref := pdf[strings.LastIndex(pdf, "xref\n"):]
+	for i, line := range strings.Split(xref, "\n")[3:] {
+		if !strings.HasSuffix(line, " n ") {
+			break
+		}
+		var offset int
+		if _, err := fmt.Sscan(line, &offset); err != nil {
+			t.Fatal(err)
+		}
+		if want := strings.TrimSpace(strings.SplitN(pdf[offset:], "\n", 2)[0]); want != strconv.Itoa(i+1)+" 0 obj" {
+			t.Errorf("xref entry %d points at %q", i+1, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/services"
+)
+
+const ofxTimeFormat = "20060102150405"
+
+// WriteOFX renders the statement as an OFX 2.2 document with one bank
+// statement per currency, which bookkeeping tools can import. Dates without
+// an offset are GMT in OFX, so they are written in UTC.
+func WriteOFX(w io.Writer, statement *services.Statement) error {
+	now := clock().UTC()
+	if _, err := io.WriteString(w, xml.Header+`<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`+"\n"); err != nil {
+		return err
+	}
+
+	e := &ofxWriter{w: w}
+	e.open("OFX")
+	e.open("SIGNONMSGSRSV1")
+	e.open("SONRS")
+	e.open("STATUS")
+	e.elem("CODE", "0")
+	e.elem("SEVERITY", "INFO")
+	e.close("STATUS")
+	e.elem("DTSERVER", now.Format(ofxTimeFormat))
+	e.elem("LANGUAGE", "ENG")
+	e.close("SONRS")
+	e.close("SIGNONMSGSRSV1")
+
+	e.open("BANKMSGSRSV1")
+	for i, account := range statement.Accounts {
+		e.open("STMTTRNRS")
+		e.elem("TRNUID", fmt.Sprint(i+1))
+		e.open("STATUS")
+		e.elem("CODE", "0")
+		e.elem("SEVERITY", "INFO")
+		e.close("STATUS")
+		e.open("STMTRS")
+		e.elem("CURDEF", account.Balance.Currency.Code)
+		e.open("BANKACCTFROM")
+		e.elem("BANKID", "MCDUCK")
+		acctID := fmt.Sprintf("%d-%s", statement.User.TelegramID, account.Balance.Currency.Code)
+		if account.Balance.IsPot() {
+			acctID += "-" + account.Balance.Pot
+		}
+		e.elem("ACCTID", acctID)
+		e.elem("ACCTTYPE", "CHECKING")
+		e.close("BANKACCTFROM")
+
+		e.open("BANKTRANLIST")
+		start := statement.From
+		if start.IsZero() && len(account.Rows) > 0 {
+			start = account.Rows[0].Transaction.Timestamp
+		}
+		e.elem("DTSTART", start.UTC().Format(ofxTimeFormat))
+		e.elem("DTEND", statement.To.UTC().Format(ofxTimeFormat))
+		for _, row := range account.Rows {
+			t := row.Transaction
+			trnType := "CREDIT"
+			if t.Amount < 0 {
+				trnType = "DEBIT"
+			}
+			e.open("STMTTRN")
+			e.elem("TRNTYPE", trnType)
+			e.elem("DTPOSTED", t.Timestamp.UTC().Format(ofxTimeFormat))
+			e.elem("TRNAMT", fmt.Sprintf("%.2f", t.Amount))
+			e.elem("FITID", fmt.Sprint(t.ID))
+			if other := counterparty(&t); other != "" {
+				e.elem("NAME", other)
+			}
+			e.elem("MEMO", description(&t))
+			e.close("STMTTRN")
+		}
+		e.close("BANKTRANLIST")
+
+		e.open("LEDGERBAL")
+		e.elem("BALAMT", fmt.Sprintf("%.2f", account.ClosingBalance))
+		e.elem("DTASOF", statement.To.UTC().Format(ofxTimeFormat))
+		e.close("LEDGERBAL")
+		e.close("STMTRS")
+		e.close("STMTTRNRS")
+	}
+	e.close("BANKMSGSRSV1")
+	e.close("OFX")
+
+	return e.err
+}
+
+// ofxWriter writes indented OFX elements and remembers the first error
+type ofxWriter struct {
+	w     io.Writer
+	depth int
+	err   error
+}
+
+func (e *ofxWriter) write(s string) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, "%*s%s\n", e.depth*2, "", s)
+}
+
+func (e *ofxWriter) open(tag string) {
+	e.write("<" + tag + ">")
+	e.depth++
+}
+
+func (e *ofxWriter) close(tag string) {
+	e.depth--
+	e.write("</" + tag + ">")
+}
+
+func (e *ofxWriter) elem(tag, value string) {
+	var escaped strings.Builder
+	_ = xml.EscapeText(&escaped, []byte(value))
+	e.write("<" + tag + ">" + escaped.String() + "</" + tag + ">")
+}
@@ -0,0 +1,141 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/services"
+)
+
+// Page layout in PDF points (A4)
+const (
+	pdfPageWidth    = 595
+	pdfPageHeight   = 842
+	pdfMargin       = 50
+	pdfFontSize     = 10
+	pdfLineHeight   = 14
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLineHeight
+)
+
+// WritePDF renders the statement as a plain text PDF document using the
+// built-in Courier font, so no font files need to be embedded
+func WritePDF(w io.Writer, statement *services.Statement) error {
+	return writePDFLines(w, statementLines(statement))
+}
+
+// statementLines lays the statement out as fixed-width text lines
+func statementLines(statement *services.Statement) []string {
+	loc := statement.User.Location()
+	from := "first transaction"
+	if !statement.From.IsZero() {
+		from = statement.From.In(loc).Format("2006-01-02")
+	}
+	lines := []string{
+		"McDuck Wallet - Account Statement",
+		fmt.Sprintf("Account: @%s", statement.User.Username),
+		fmt.Sprintf("Period: %s - %s", from, statement.To.In(loc).AddDate(0, 0, -1).Format("2006-01-02")),
+		fmt.Sprintf("Generated: %s", clock().In(loc).Format("2006-01-02 15:04 MST")),
+	}
+
+	for _, account := range statement.Accounts {
+		lines = append(lines,
+			"",
+			accountTitle(&account.Balance),
+			fmt.Sprintf("%-16s  %-38s %12s %12s", "Date", "Description", "Amount", "Balance"),
+			strings.Repeat("-", 81),
+			fmt.Sprintf("%-16s  %-38s %12s %12.2f", "", "Opening balance", "", account.OpeningBalance),
+		)
+		for _, row := range account.Rows {
+			t := row.Transaction
+			lines = append(lines, fmt.Sprintf("%-16s  %-38.38s %12.2f %12.2f",
+				t.Timestamp.In(loc).Format("2006-01-02 15:04"), description(&t), t.Amount, row.RunningBalance))
+		}
+		lines = append(lines, fmt.Sprintf("%-16s  %-38s %12s %12.2f", "", "Closing balance", "", account.ClosingBalance))
+	}
+	return lines
+}
+
+// writePDFLines writes a minimal PDF 1.4 document with the lines split into pages
+func writePDFLines(w io.Writer, lines []string) error {
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// Object layout: 1 catalog, 2 pages, 3 font, then a page and a content
+	// stream object for every page
+	var objects []string
+	objects = append(objects, "<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	objects = append(objects, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	objects = append(objects, "<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, page := range pages {
+		objects = append(objects, fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 5+2*i))
+
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLineHeight, pdfMargin, pdfPageHeight-pdfMargin)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) '\n", pdfEscape(line))
+		}
+		content.WriteString("ET")
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pdfEscape escapes a string for a PDF literal, replacing characters outside
+// Latin-1 which the standard fonts cannot show
+func pdfEscape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < 0x20:
+			sb.WriteByte(' ')
+		case r > 0xFF:
+			sb.WriteByte('?')
+		case r >= 0x80:
+			fmt.Fprintf(&sb, "\\%03o", r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// accountTitle names the balance of a statement section
+func accountTitle(balance *database.Balance) string {
+	currency := balance.Currency
+	if balance.IsPot() {
+		return fmt.Sprintf("%s (%s), pot %s", currency.Name, currency.Code, balance.Pot)
+	}
+	return fmt.Sprintf("%s (%s)", currency.Name, currency.Code)
+}
@@ -2,17 +2,31 @@
 package handlers
 
 import (
+	"github.com/fitz123/mcduck-wallet/internal/ratelimit"
 	"github.com/fitz123/mcduck-wallet/internal/webapp"
+	"github.com/fitz123/mcduck-wallet/internal/webapp/static"
 	"github.com/go-chi/chi/v5"
 )
 
 func RegisterRoutes(r chi.Router, webService *webapp.WebService) {
 	r.Get("/", webService.ServeHome)
+	r.Handle(static.Prefix+"*", static.Handler())
 	r.Route("/", func(r chi.Router) {
 		r.Use(webService.AuthMiddleware)
+		r.Use(webService.RateLimitMiddleware(ratelimit.General))
+		r.Use(webService.CSRFMiddleware)
 		r.Get("/dashboard", webService.GetDashboard)
+		r.Post("/wallet", webService.SwitchWallet)
 		r.Get("/transfer-form", webService.GetTransferForm)
-		r.Post("/transfer", webService.TransferMoney)
+		r.Post("/transfer/confirm", webService.ConfirmTransfer)
+		r.With(webService.RateLimitMiddleware(ratelimit.Money)).Post("/transfer", webService.TransferMoney)
 		r.Get("/history", webService.GetTransactionHistory)
+		r.Get("/loans", webService.GetLoans)
+		r.Get("/export", webService.ExportStatement)
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(webService.AdminMiddleware)
+			r.Get("/", webService.GetAdminPage)
+			r.Post("/import", webService.ImportData)
+		})
 	})
 }
@@ -0,0 +1,131 @@
+// Package health serves liveness and readiness endpoints reporting the
+// status of every component in JSON
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds the time a single check may take
+const checkTimeout = 3 * time.Second
+
+// Check reports a component as healthy by returning nil
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker holds the liveness and readiness checks of the application
+type Checker struct {
+	mu        sync.RWMutex
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+func New() *Checker {
+	return &Checker{}
+}
+
+// AddLiveness registers a check that must pass for the process to be considered alive
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveness = append(c.liveness, namedCheck{name, check})
+}
+
+// AddReadiness registers a check that must pass for the process to serve traffic
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness = append(c.readiness, namedCheck{name, check})
+}
+
+// Report is the JSON body of the health endpoints
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// ComponentStatus is the result of a single check
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+// LivenessHandler serves /healthz
+func (c *Checker) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	checks := c.liveness
+	c.mu.RUnlock()
+	serve(w, r, checks)
+}
+
+// ReadinessHandler serves /readyz. It includes the liveness checks, unless a
+// readiness check of the same component replaces them.
+func (c *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	checks := append([]namedCheck{}, c.readiness...)
+	for _, live := range c.liveness {
+		replaced := false
+		for _, ready := range c.readiness {
+			replaced = replaced || ready.name == live.name
+		}
+		if !replaced {
+			checks = append(checks, live)
+		}
+	}
+	c.mu.RUnlock()
+	serve(w, r, checks)
+}
+
+// run executes the checks concurrently and builds a report
+func run(ctx context.Context, checks []namedCheck) Report {
+	report := Report{Status: statusOK, Components: make(map[string]ComponentStatus)}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			status := ComponentStatus{Status: statusOK}
+			if err := nc.check(ctx); err != nil {
+				status = ComponentStatus{Status: statusFail, Error: err.Error()}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[nc.name] = status
+			if status.Status != statusOK {
+				report.Status = statusFail
+			}
+		}(nc)
+	}
+	wg.Wait()
+	return report
+}
+
+func serve(w http.ResponseWriter, r *http.Request, checks []namedCheck) {
+	report := run(r.Context(), checks)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != statusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
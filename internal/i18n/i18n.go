@@ -0,0 +1,150 @@
+// Package i18n translates user-facing text. Catalogs are JSON files in the
+// locales directory mapping message keys to fmt format strings.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default is the locale used when the user's language is not supported, its
+// catalog must contain every key
+const Default = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+var catalogs = make(map[string]map[string]string)
+
+func init() {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		catalog := make(map[string]string)
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("locale %s: %v", entry.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(entry.Name(), ".json")] = catalog
+	}
+	if _, ok := catalogs[Default]; !ok {
+		panic("default locale " + Default + " is missing")
+	}
+}
+
+// Supported returns the available locales
+func Supported() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Normalize maps a language code such as "ru" or "pt-BR" to a supported
+// locale, or returns "" when there is none
+func Normalize(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if _, ok := catalogs[code]; ok {
+		return code
+	}
+	base, _, _ := strings.Cut(code, "-")
+	if _, ok := catalogs[base]; ok {
+		return base
+	}
+	return ""
+}
+
+// Resolve picks the locale of a user: the stored override when set, then the
+// language reported by Telegram, then the default
+func Resolve(override, languageCode string) string {
+	if locale := Normalize(override); locale != "" {
+		return locale
+	}
+	if locale := Normalize(languageCode); locale != "" {
+		return locale
+	}
+	return Default
+}
+
+type contextKey struct{}
+
+type locationKey struct{}
+
+// WithLocale returns a context whose text is translated to locale
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, contextKey{}, locale)
+}
+
+// Locale returns the locale of ctx, the default when none is set
+func Locale(ctx context.Context) string {
+	if locale, ok := ctx.Value(contextKey{}).(string); ok && locale != "" {
+		return locale
+	}
+	return Default
+}
+
+// WithLocation returns a context whose dates are shown in loc
+func WithLocation(ctx context.Context, loc *time.Location) context.Context {
+	return context.WithValue(ctx, locationKey{}, loc)
+}
+
+// Location returns the time zone of ctx, UTC when none is set
+func Location(ctx context.Context) *time.Location {
+	if loc, ok := ctx.Value(locationKey{}).(*time.Location); ok && loc != nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// T translates key to the locale of ctx and formats it with args
+func T(ctx context.Context, key string, args ...any) string {
+	return Translate(Locale(ctx), key, args...)
+}
+
+// Translate translates key to locale and formats it with args. Missing
+// translations fall back to the default locale, then to the key itself.
+func Translate(locale, key string, args ...any) string {
+	format, ok := catalogs[locale][key]
+	if !ok {
+		format, ok = catalogs[Default][key]
+	}
+	if !ok {
+		format = key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// FormatDateTime formats t as a short date and time in the time zone of ctx,
+// e.g. "2 Jan 2024, 3:04 PM"
+func FormatDateTime(ctx context.Context, t time.Time) string {
+	t = t.In(Location(ctx))
+	return T(ctx, "date.datetime", FormatDate(ctx, t), t.Format(T(ctx, "date.time_layout")))
+}
+
+// FormatDate formats t as a short date with a localized month name in the
+// time zone of ctx
+func FormatDate(ctx context.Context, t time.Time) string {
+	t = t.In(Location(ctx))
+	months := strings.Split(T(ctx, "date.months"), ",")
+	month := t.Month().String()[:3]
+	if len(months) == 12 {
+		month = months[t.Month()-1]
+	}
+	return T(ctx, "date.date", t.Day(), month, t.Year())
+}
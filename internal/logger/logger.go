@@ -3,14 +3,22 @@
 package logger
 
 import (
+	"context"
+	"io"
 	"log/slog"
 	"os"
 )
 
-var defaultLogger *slog.Logger
+// Output formats accepted by Init
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var defaultLogger = newLogger(os.Stdout, slog.LevelInfo, FormatText)
 
-// accept loglevel as string and set corresponding log level
-func Init(logLevel string) {
+// accept loglevel and format as string and set corresponding log level and handler
+func Init(logLevel, format string) {
 	var level slog.Level
 	switch logLevel {
 	case "debug":
@@ -25,9 +33,23 @@ func Init(logLevel string) {
 		level = slog.LevelDebug
 	}
 	// Initialize the default logger
-	defaultLogger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: level,
-	}))
+	defaultLogger = newLogger(os.Stdout, level, format)
+}
+
+// newLogger builds the handler pipeline: context attributes, then redaction,
+// then the text or JSON output
+func newLogger(w io.Writer, level slog.Level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+	var handler slog.Handler
+	if format == FormatJSON {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(&contextHandler{redactHandler{handler}})
 }
 
 // Info logs an informational message
@@ -50,6 +72,26 @@ func Warn(msg string, args ...any) {
 	defaultLogger.Warn(msg, args...)
 }
 
+// InfoContext logs an informational message with the attributes carried by ctx
+func InfoContext(ctx context.Context, msg string, args ...any) {
+	defaultLogger.InfoContext(ctx, msg, args...)
+}
+
+// ErrorContext logs an error message with the attributes carried by ctx
+func ErrorContext(ctx context.Context, msg string, args ...any) {
+	defaultLogger.ErrorContext(ctx, msg, args...)
+}
+
+// DebugContext logs a debug message with the attributes carried by ctx
+func DebugContext(ctx context.Context, msg string, args ...any) {
+	defaultLogger.DebugContext(ctx, msg, args...)
+}
+
+// WarnContext logs a warning message with the attributes carried by ctx
+func WarnContext(ctx context.Context, msg string, args ...any) {
+	defaultLogger.WarnContext(ctx, msg, args...)
+}
+
 // WithContext returns a new logger with the given context
 func WithContext(ctx map[string]any) *slog.Logger {
 	return defaultLogger.With(slog.Group("context", slog.Any("data", ctx)))
@@ -0,0 +1,98 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// sensitiveKeys are attribute keys whose values are never logged
+var sensitiveKeys = map[string]bool{
+	"token":         true,
+	"hash":          true,
+	"signature":     true,
+	"password":      true,
+	"authorization": true,
+}
+
+var secretPatterns = []*regexp.Regexp{
+	// Telegram bot tokens, e.g. inside API URLs
+	regexp.MustCompile(`\d{5,}:[A-Za-z0-9_-]{30,}`),
+	// Signatures inside WebApp initData
+	regexp.MustCompile(`((?:^|[&?])(?:hash|signature)=)[^&\s]+`),
+}
+
+// AddSecret registers a value, such as the bot token, that is replaced
+// wherever it appears in log messages or attributes
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// Redact removes secrets from s
+func Redact(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	secretsMu.RUnlock()
+
+	s = secretPatterns[0].ReplaceAllString(s, redacted)
+	return secretPatterns[1].ReplaceAllString(s, "${1}"+redacted)
+}
+
+// redactAttr is the ReplaceAttr hook of the output handlers
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		// Errors and other values are rendered as strings, so redact that form
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return a
+}
+
+// redactHandler removes secrets from log messages, attributes are handled by redactAttr
+type redactHandler struct {
+	slog.Handler
+}
+
+func (h redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	msg := Redact(r.Message)
+	if msg == r.Message {
+		return h.Handler.Handle(ctx, r)
+	}
+	clean := slog.NewRecord(r.Time, r.Level, msg, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		clean.AddAttrs(a)
+		return true
+	})
+	return h.Handler.Handle(ctx, clean)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return redactHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{h.Handler.WithGroup(name)}
+}
@@ -3,15 +3,20 @@
 package messages
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/services"
 )
 
 // FormatTransactionHistory formats the transaction history for bot
-func FormatTransactionHistory(transactions []database.Transaction) []string {
+func FormatTransactionHistory(ctx context.Context, transactions []database.Transaction) []string {
 	if len(transactions) == 0 {
-		return []string{InfoNoTransactions}
+		return []string{i18n.T(ctx, InfoNoTransactions)}
 	}
 
 	formattedTransactions := make([]string, len(transactions))
@@ -21,16 +26,68 @@ func FormatTransactionHistory(transactions []database.Transaction) []string {
 
 		switch t.Type {
 		case "transfer_out":
-			description = "Sent to"
+			description = i18n.T(ctx, "history.sent_to")
 			otherParty = truncateUsername(t.ToUsername)
 		case "transfer_in":
-			description = "Received from"
+			description = i18n.T(ctx, "history.received_from")
+			otherParty = truncateUsername(t.FromUsername)
+		case "sweep_out":
+			description = i18n.T(ctx, "history.swept_to")
+			otherParty = truncateUsername(t.ToUsername)
+		case "sweep_in":
+			description = i18n.T(ctx, "history.swept_from")
+			otherParty = truncateUsername(t.FromUsername)
+		case "opening_balance":
+			description = i18n.T(ctx, "history.opening_balance")
+		case "import":
+			description = i18n.T(ctx, "history.imported")
+			if t.Amount < 0 {
+				otherParty = truncateUsername(t.ToUsername)
+			} else {
+				otherParty = truncateUsername(t.FromUsername)
+			}
+		case "pot_out":
+			description = i18n.T(ctx, "history.pot_out")
+			otherParty = potName(t.Pot)
+		case "pot_in":
+			description = i18n.T(ctx, "history.pot_in")
+			otherParty = potName(t.Pot)
+		case "interest_in":
+			description = i18n.T(ctx, "history.interest")
+		case "fee_out":
+			description = i18n.T(ctx, "history.fee")
+		case "fee_refund_in":
+			description = i18n.T(ctx, "history.fee_refund")
+		case "loan_out", "repay_out":
+			description = i18n.T(ctx, "history."+t.Type)
+			otherParty = truncateUsername(t.ToUsername)
+		case "loan_in", "repay_in":
+			description = i18n.T(ctx, "history."+t.Type)
+			otherParty = truncateUsername(t.FromUsername)
+		case "escrow_hold", "escrow_refund":
+			description = i18n.T(ctx, "history."+t.Type)
+			otherParty = truncateUsername(t.ToUsername)
+		case "escrow_release":
+			description = i18n.T(ctx, "history.escrow_release")
+			otherParty = truncateUsername(t.FromUsername)
+		case "voucher_hold", "voucher_refund":
+			description = i18n.T(ctx, "history."+t.Type)
+		case "voucher_redeem":
+			description = i18n.T(ctx, "history.voucher_redeem")
+			otherParty = truncateUsername(t.FromUsername)
+		case "welcome_bonus":
+			description = i18n.T(ctx, "history.welcome_bonus")
+		case "reversal_out":
+			description = i18n.T(ctx, "history.reversal_out")
+			otherParty = truncateUsername(t.ToUsername)
+		case "reversal_in":
+			description = i18n.T(ctx, "history.reversal_in")
 			otherParty = truncateUsername(t.FromUsername)
 		case "admin_set_balance":
-			description = "Set by admin"
+			description = i18n.T(ctx, "history.set_by_admin")
 			otherParty = truncateUsername(t.FromUsername)
 		default:
-			description = "System Transaction"
+			description = i18n.T(ctx, "history.system")
 			otherParty = ""
 		}
 
@@ -38,18 +95,220 @@ func FormatTransactionHistory(transactions []database.Transaction) []string {
 			description = fmt.Sprintf("%s *%s*", description, otherParty)
 		}
 
-		formattedTransactions[i] = fmt.Sprintf("%s - %s %s%.0f (Balance: %.0f)",
-			t.Timestamp.Format("2006-01-02 15:04"),
+		formattedTransactions[i] = i18n.T(ctx, "history.line",
+			t.ID,
+			i18n.FormatDateTime(ctx, t.Timestamp),
 			description,
 			t.Balance.Currency.Sign, abs(t.Amount),
 			t.BalanceAfter,
 		)
+		// Refunds and the transfers they refund point at each other
+		if t.ReversalOf != 0 {
+			formattedTransactions[i] += i18n.T(ctx, "history.refund_of", t.ReversalOf)
+		}
+		if t.Reversed > 0 {
+			formattedTransactions[i] += i18n.T(ctx, "history.refunded", t.Reversed)
+		}
 	}
 
 	return formattedTransactions
 }
 
+// FormatTransferLimits formats the transfer limits of a user for bot
+func FormatTransferLimits(ctx context.Context, limits []database.TransferLimit) string {
+	if len(limits) == 0 {
+		return i18n.T(ctx, InfoNoLimits)
+	}
+
+	response := i18n.T(ctx, "limits.title") + "\n"
+	for _, l := range limits {
+		response += fmt.Sprintf("\n%s:\n", l.Currency.Code)
+		if l.MaxSingle > 0 {
+			response += i18n.T(ctx, "limits.single", l.Currency.Sign, l.MaxSingle) + "\n"
+		}
+		if l.Daily > 0 {
+			response += i18n.T(ctx, "limits.daily", l.Currency.Sign, l.Daily) + "\n"
+		}
+		if l.Monthly > 0 {
+			response += i18n.T(ctx, "limits.monthly", l.Currency.Sign, l.Monthly) + "\n"
+		}
+		if l.MaxPerHour > 0 {
+			response += i18n.T(ctx, "limits.hourly", l.MaxPerHour) + "\n"
+		}
+	}
+	return response
+}
+
+// FormatPots formats the savings pots of a user with their goals for bot
+func FormatPots(ctx context.Context, pots []database.Balance) string {
+	if len(pots) == 0 {
+		return i18n.T(ctx, InfoNoPots)
+	}
+
+	lines := []string{i18n.T(ctx, "pots.title")}
+	for _, pot := range pots {
+		line := i18n.T(ctx, "pots.line", pot.Pot, pot.Currency.Sign, pot.Amount)
+		if pot.Goal > 0 {
+			line += i18n.T(ctx, "pots.goal", pot.Currency.Sign, pot.Goal, pot.GoalProgress())
+		}
+		if pot.Deadline != nil {
+			line += i18n.T(ctx, "pots.deadline", i18n.FormatDate(ctx, *pot.Deadline))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatLoans formats the open loans of user for bot, the loans they gave
+// and the loans they owe
+func FormatLoans(ctx context.Context, loans []database.Loan, user *database.User, now time.Time) string {
+	if len(loans) == 0 {
+		return i18n.T(ctx, InfoNoLoans)
+	}
+
+	var lent, borrowed []string
+	for _, loan := range loans {
+		other := loan.LenderUsername
+		if loan.LenderID == user.ID {
+			other = loan.BorrowerUsername
+		}
+		line := i18n.T(ctx, "loans.line", other, loan.Currency.Sign, loan.Outstanding(), loan.Currency.Sign, loan.Amount)
+		if loan.DueDate != nil {
+			line += i18n.T(ctx, "loans.due", i18n.FormatDate(ctx, *loan.DueDate))
+		}
+		if loan.IsOverdue(now) {
+			line += i18n.T(ctx, "loans.overdue")
+		}
+		if loan.LenderID == user.ID {
+			lent = append(lent, line)
+		} else {
+			borrowed = append(borrowed, line)
+		}
+	}
+
+	var sections []string
+	if len(lent) > 0 {
+		sections = append(sections, i18n.T(ctx, "loans.lent")+"\n"+strings.Join(lent, "\n"))
+	}
+	if len(borrowed) > 0 {
+		sections = append(sections, i18n.T(ctx, "loans.borrowed")+"\n"+strings.Join(borrowed, "\n"))
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// FormatEscrows formats a list of open escrows for bot
+func FormatEscrows(ctx context.Context, escrows []database.Escrow) string {
+	if len(escrows) == 0 {
+		return i18n.T(ctx, InfoNoEscrows)
+	}
+
+	lines := []string{i18n.T(ctx, "escrow.title")}
+	for i := range escrows {
+		lines = append(lines, FormatEscrow(ctx, &escrows[i]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatEscrow formats a single escrow with its state for bot
+func FormatEscrow(ctx context.Context, escrow *database.Escrow) string {
+	text := i18n.T(ctx, "escrow.summary", escrow.ID, escrow.SenderUsername, escrow.RecipientUsername,
+		escrow.Currency.Sign, escrow.Amount, i18n.T(ctx, "escrow.status."+escrow.Status))
+	switch {
+	case escrow.Status == database.EscrowHeld:
+		text += i18n.T(ctx, "escrow.expires", i18n.FormatDateTime(ctx, escrow.ExpiresAt))
+	case escrow.ResolvedBy != "":
+		text += i18n.T(ctx, "escrow.resolved_by", escrow.ResolvedBy)
+	}
+	return text
+}
+
+// FormatPendingOperation formats an operation waiting for approval for bot
+func FormatPendingOperation(ctx context.Context, op *database.PendingOperation) string {
+	var description string
+	switch op.Type {
+	case database.OperationTransfer:
+		description = i18n.T(ctx, "operation.transfer", op.InitiatorUsername, op.Amount, op.CurrencyCode, op.TargetUsername)
+	case database.OperationSetBalance:
+		description = i18n.T(ctx, "operation.set_balance", op.InitiatorUsername, op.TargetUsername, op.Amount, op.CurrencyCode)
+	case database.OperationEscrow:
+		description = i18n.T(ctx, "operation.escrow", op.InitiatorUsername, op.Amount, op.CurrencyCode, op.TargetUsername)
+	case database.OperationLoan:
+		description = i18n.T(ctx, "operation.loan", op.InitiatorUsername, op.Amount, op.CurrencyCode, op.TargetUsername)
+		if op.DueDate != nil {
+			description += i18n.T(ctx, "loans.due", i18n.FormatDate(ctx, *op.DueDate))
+		}
+	default:
+		description = op.Type
+	}
+
+	approvals := 0
+	for _, a := range op.Approvals {
+		if a.Approved {
+			approvals++
+		}
+	}
+
+	text := i18n.T(ctx, "operation.summary", op.ID, description, approvals, op.RequiredApprovals, i18n.FormatDateTime(ctx, op.ExpiresAt))
+	if op.Error != "" {
+		text += "\n" + i18n.T(ctx, "operation.error", op.Error)
+	}
+	return text
+}
+
+// FormatOperationStatus translates the status of a pending operation
+func FormatOperationStatus(ctx context.Context, status string) string {
+	return i18n.T(ctx, "operation.status."+status)
+}
+
+// FormatImportReport formats the result of an import for bot
+func FormatImportReport(report *services.ImportReport) string {
+	var sb strings.Builder
+	switch {
+	case report.Committed:
+		sb.WriteString("Import completed.\n")
+	case len(report.Errors) > 0:
+		sb.WriteString("Import rejected, nothing was changed.\n")
+	default:
+		sb.WriteString("Dry run passed, nothing was changed.\n")
+	}
+
+	for _, kind := range []string{services.ImportCurrency, services.ImportUser, services.ImportBalance, services.ImportTransaction} {
+		if n := report.Created[kind]; n > 0 {
+			sb.WriteString(fmt.Sprintf("%s records: %d\n", kind, n))
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		sb.WriteString("\nErrors:\n")
+		for _, e := range report.Errors {
+			sb.WriteString(fmt.Sprintf("line %d: %s\n", e.Line, e.Message))
+		}
+	}
+	return sb.String()
+}
+
 // abs returns the absolute value of x
+// DisplayName names a user by @username, by name or by Telegram ID for
+// users without a username
+func DisplayName(user *database.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	if name := strings.TrimSpace(user.FirstName + " " + user.LastName); name != "" {
+		return name
+	}
+	return fmt.Sprintf("ID %d", user.TelegramID)
+}
+
+// RenameNotice warns that recipient named user by an old username, it is
+// empty otherwise
+func RenameNotice(ctx context.Context, recipient string, user *database.User) string {
+	if !services.IsRenamed(recipient, user) {
+		return ""
+	}
+	return i18n.T(ctx, InfoRecipientRenamed, strings.TrimPrefix(recipient, "@"), DisplayName(user)) + "\n"
+}
+
 func abs(x float64) float64 {
 	if x < 0 {
 		return -x
@@ -57,6 +316,14 @@ func abs(x float64) float64 {
 	return x
 }
 
+// potName names the other side of a move between own pots
+func potName(pot string) string {
+	if pot == "" {
+		return services.MainPot
+	}
+	return pot
+}
+
 // truncateUsername shortens long usernames and adds an ellipsis
 func truncateUsername(username string) string {
 	maxLength := 15
@@ -1,13 +1,73 @@
 // File: ./internal/messages/messages.go
 package messages
 
+// Message keys, translated with i18n.T. The texts live in internal/i18n/locales.
 const (
-	InfoWelcome            = "Welcome to McDuck Wallet, @%s! Your personal finance assistant.\nUse the button below to open the WebApp."
-	InfoTransferSuccessful = "Successfully transferred %.0f %s to @%s"
-	InfoNoTransactions     = "No transactions found"
-	ErrUserNotFound        = "User not found."
-	ErrInvalidAmount       = "Invalid amount. Please enter a number."
-	ErrUnauthorized        = "Unauthorized: This command is only available for admin accounts."
-	UsageTransfer          = "Usage: /transfer <@username> <amount> [<currency_code>]"
+	InfoWelcome             = "info.welcome"
+	InfoWelcomeBonus        = "info.welcome_bonus"
+	InfoTransferSuccessful  = "info.transfer_successful"
+	InfoTransferFee         = "info.transfer_fee"
+	InfoRecipientRenamed    = "info.recipient_renamed"
+	InfoNoTransactions      = "info.no_transactions"
+	InfoNoLimits            = "info.no_limits"
+	InfoPendingApproval     = "info.pending_approval"
+	InfoNoThrottled         = "info.no_throttled"
+	InfoThrottled           = "info.throttled"
+	InfoThrottledEntry      = "info.throttled_entry"
+	InfoLanguageCurrent     = "info.language_current"
+	InfoLanguageSet         = "info.language_set"
+	InfoLanguageAuto        = "info.language_auto"
+	InfoTimezoneCurrent     = "info.timezone_current"
+	InfoTimezoneSet         = "info.timezone_set"
+	InfoTimezoneAuto        = "info.timezone_auto"
+	InfoNoPots              = "info.no_pots"
+	InfoPotCreated          = "info.pot_created"
+	InfoPotMoved            = "info.pot_moved"
+	InfoNoLoans             = "info.no_loans"
+	InfoLoanCreated         = "info.loan_created"
+	InfoLoanDue             = "info.loan_due"
+	InfoLoanRepaid          = "info.loan_repaid"
+	InfoLoanSettled         = "info.loan_settled"
+	InfoNoEscrows           = "info.no_escrows"
+	InfoEscrowCreated       = "info.escrow_created"
+	InfoNoVouchers          = "info.no_vouchers"
+	InfoVoucherRedeemed     = "info.voucher_redeemed"
+	InfoNoRefundable        = "info.no_refundable"
+	InfoRefunded            = "info.refunded"
+	InfoWallets             = "info.wallets"
+	InfoWalletSwitched      = "info.wallet_switched"
+	InfoWalletCreated       = "info.wallet_created"
+	ErrUserNotFound         = "error.user_not_found"
+	ErrInvalidAmount        = "error.invalid_amount"
+	ErrUnauthorized         = "error.unauthorized"
+	ErrSlowDown             = "error.slow_down"
+	ErrUnsupportedLanguage  = "error.unsupported_language"
+	ErrInvalidTimezone      = "error.invalid_timezone"
+	ErrInvalidDate          = "error.invalid_date"
+	UsageTransfer           = "usage.transfer"
+	UsageAdminSet           = "usage.admin_set"
+	UsageConfig             = "usage.config"
+	UsageRemoveUser         = "usage.remove_user"
+	UsageRestoreUser        = "usage.restore_user"
+	UsagePurgeUser          = "usage.purge_user"
+	UsageAddUser            = "usage.add_user"
+	UsageAddCurrency        = "usage.add_currency"
+	UsageSetDefaultCurrency = "usage.set_default_currency"
+	UsageExport             = "usage.export"
+	UsageImport             = "usage.import"
+	UsageAuditLog           = "usage.audit_log"
+	UsageApprove            = "usage.approve"
+	UsageLanguage           = "usage.language"
+	UsageTimezone           = "usage.timezone"
+	UsagePot                = "usage.pot"
+	UsageLend               = "usage.lend"
+	UsageRepay              = "usage.repay"
+	UsageEscrow             = "usage.escrow"
+	UsageVoucher            = "usage.voucher"
+	UsageRedeem             = "usage.redeem"
+	UsageRefund             = "usage.refund"
+	UsageInvite             = "usage.invite"
+	UsageWallet             = "usage.wallet"
+	UsageWebhook            = "usage.webhook"
 	// Add other messages as needed
 )
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// BotMiddleware records handled bot updates by command and outcome
+func BotMiddleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		command := UpdateCommand(c)
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		BotUpdates.WithLabelValues(command, outcome).Inc()
+		BotUpdateDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// UpdateCommand returns a low-cardinality name for the kind of update
+func UpdateCommand(c tele.Context) string {
+	if cb := c.Callback(); cb != nil {
+		unique, _, _ := strings.Cut(strings.TrimPrefix(cb.Data, "\f"), "|")
+		return "callback:" + unique
+	}
+	msg := c.Message()
+	if msg == nil {
+		return "other"
+	}
+	if msg.Document != nil {
+		return "document"
+	}
+	if strings.HasPrefix(msg.Text, "/") {
+		command, _, _ := strings.Cut(strings.Fields(msg.Text)[0], "@")
+		return command
+	}
+	return "text"
+}
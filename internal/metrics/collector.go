@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// walletCollector reads gauges from the database on every scrape, so they
+// are always consistent with the ledger
+type walletCollector struct {
+	db       *database.DB
+	supply   *prometheus.Desc
+	users    *prometheus.Desc
+	balances *prometheus.Desc
+}
+
+// RegisterWalletCollector registers gauges for the total supply per currency
+// and the number of users
+func RegisterWalletCollector(db *database.DB) error {
+	return prometheus.Register(&walletCollector{
+		db: db,
+		supply: prometheus.NewDesc(namespace+"_total_supply",
+			"Sum of all user balances, by currency.", []string{"currency"}, nil),
+		users: prometheus.NewDesc(namespace+"_users",
+			"Number of users, by status.", []string{"status"}, nil),
+		balances: prometheus.NewDesc(namespace+"_balances",
+			"Number of balances, by currency.", []string{"currency"}, nil),
+	})
+}
+
+func (c *walletCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.supply
+	ch <- c.users
+	ch <- c.balances
+}
+
+func (c *walletCollector) Collect(ch chan<- prometheus.Metric) {
+	var supply []struct {
+		Code  string
+		Total float64
+		Count int64
+	}
+	err := c.db.Conn.Model(&database.Balance{}).
+		Select("currencies.code AS code, COALESCE(SUM(balances.amount + COALESCE(balances.held, 0)), 0) AS total, COUNT(balances.id) AS count").
+		Joins("JOIN currencies ON currencies.id = balances.currency_id").
+		Group("currencies.code").
+		Scan(&supply).Error
+	if err != nil {
+		logger.Error("Failed to collect supply metrics", "error", err)
+	}
+	for _, s := range supply {
+		ch <- prometheus.MustNewConstMetric(c.supply, prometheus.GaugeValue, s.Total, s.Code)
+		ch <- prometheus.MustNewConstMetric(c.balances, prometheus.GaugeValue, float64(s.Count), s.Code)
+	}
+
+	var users []struct {
+		Status string
+		Count  int64
+	}
+	// Users count across all wallets, like the balances above
+	err = c.db.Conn.WithContext(database.AnyWallet(context.Background())).Model(&database.User{}).
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Scan(&users).Error
+	if err != nil {
+		logger.Error("Failed to collect user metrics", "error", err)
+	}
+	for _, u := range users {
+		ch <- prometheus.MustNewConstMetric(c.users, prometheus.GaugeValue, float64(u.Count), u.Status)
+	}
+}
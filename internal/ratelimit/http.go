@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
+	"github.com/fitz123/mcduck-wallet/internal/messages"
+)
+
+// HTTPMiddleware limits requests by subject, as returned by subject for the
+// request. Throttled requests get 429 with a Retry-After header.
+func (l *Limiter) HTTPMiddleware(class Class, subject func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if decision := l.Allow(class, subject(r)); !decision.Allowed {
+				seconds := RetrySeconds(decision.RetryAfter)
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				http.Error(w, i18n.T(r.Context(), messages.ErrSlowDown, seconds), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RemoteIP is the subject of the request's remote address
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return IPSubject(host)
+}
+
+// RetrySeconds rounds a wait up to whole seconds, at least one
+func RetrySeconds(wait time.Duration) int {
+	return max(1, int(math.Ceil(wait.Seconds())))
+}
@@ -0,0 +1,176 @@
+// Package ratelimit throttles users and IP addresses with token buckets
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/metrics"
+)
+
+// Class is a kind of request sharing a rate: a bucket holds Burst tokens and
+// refills at Rate tokens per second
+type Class struct {
+	Name  string
+	Rate  float64
+	Burst int
+}
+
+var (
+	// General applies to every bot update and web request of a user
+	General = Class{Name: "general", Rate: 1, Burst: 20}
+	// Money applies on top of General to operations moving money
+	Money = Class{Name: "money", Rate: 0.1, Burst: 3}
+	// IP applies to every web request of an address, it is generous because
+	// users behind the same proxy share it
+	IP = Class{Name: "ip", Rate: 20, Burst: 100}
+)
+
+// sweepInterval is how often buckets that have refilled are dropped
+const sweepInterval = time.Minute
+
+// ThrottleRetention is how long the throttle counters of a subject are kept
+// after its last rejected request
+const ThrottleRetention = 24 * time.Hour
+
+type bucket struct {
+	class  Class
+	tokens float64
+	last   time.Time
+	warned bool // the subject was told to slow down since the last allowed request
+}
+
+// Decision is the outcome of Allow
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	// FirstRejection is set for the first rejection after an allowed request,
+	// so that callers can warn once instead of answering every request
+	FirstRejection bool
+}
+
+// ThrottleStat counts the rejected requests of a subject in a class
+type ThrottleStat struct {
+	Subject string
+	Class   string
+	Count   int
+	Last    time.Time
+}
+
+// Limiter keeps a token bucket per class and subject, e.g. "tg:12345" for a
+// Telegram user or "ip:192.0.2.1" for an address
+type Limiter struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	buckets   map[string]*bucket
+	throttled map[string]*ThrottleStat
+	lastSweep time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{
+		now:       time.Now,
+		buckets:   make(map[string]*bucket),
+		throttled: make(map[string]*ThrottleStat),
+	}
+}
+
+// UserSubject is the subject of a Telegram user, shared by the bot and the web app
+func UserSubject(telegramID int64) string {
+	return fmt.Sprintf("tg:%d", telegramID)
+}
+
+// ParseUserSubject returns the Telegram ID of a subject created by UserSubject
+func ParseUserSubject(subject string) (int64, bool) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(subject, "tg:"), 10, 64)
+	return id, err == nil && strings.HasPrefix(subject, "tg:")
+}
+
+// IPSubject is the subject of a remote address
+func IPSubject(ip string) string {
+	return "ip:" + ip
+}
+
+// Allow takes a token from the subject's bucket of the class. When the bucket
+// is empty the request is rejected and the decision tells when to retry.
+func (l *Limiter) Allow(class Class, subject string) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	key := class.Name + "|" + subject
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{class: class, tokens: float64(class.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(float64(class.Burst), b.tokens+now.Sub(b.last).Seconds()*class.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.warned = false
+		return Decision{Allowed: true}
+	}
+
+	stat, ok := l.throttled[key]
+	if !ok {
+		stat = &ThrottleStat{Subject: subject, Class: class.Name}
+		l.throttled[key] = stat
+	}
+	stat.Count++
+	stat.Last = now
+	metrics.RateLimited.WithLabelValues(class.Name).Inc()
+
+	decision := Decision{
+		RetryAfter:     time.Duration((1 - b.tokens) / class.Rate * float64(time.Second)),
+		FirstRejection: !b.warned,
+	}
+	b.warned = true
+	return decision
+}
+
+// sweep drops buckets that are full again, they behave like new ones, and
+// the counters of subjects not throttled within ThrottleRetention
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.class.Rate >= float64(b.class.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	for key, stat := range l.throttled {
+		if now.Sub(stat.Last) >= ThrottleRetention {
+			delete(l.throttled, key)
+		}
+	}
+}
+
+// Throttled returns the throttle counters of the last ThrottleRetention, most
+// throttled first
+func (l *Limiter) Throttled() []ThrottleStat {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(l.now())
+
+	stats := make([]ThrottleStat, 0, len(l.throttled))
+	for _, stat := range l.throttled {
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Last.After(stats[j].Last)
+	})
+	return stats
+}
@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2025, 5, 12, 8, 0, 0, 0, time.UTC)
+
+// newTestLimiter returns a limiter and a pointer to the time it reads
+func newTestLimiter() (*Limiter, *time.Time) {
+	now := testStart
+	l := New()
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestBucketRefillsAtTheClassRate(t *testing.T) {
+	l, now := newTestLimiter()
+	class := Class{Name: "test", Rate: 2, Burst: 3}
+
+	for i := range class.Burst {
+		if !l.Allow(class, "tg:1").Allowed {
+			t.Fatalf("request %d of the burst rejected", i+1)
+		}
+	}
+	first := l.Allow(class, "tg:1")
+	if first.Allowed || !first.FirstRejection || first.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("request after the burst = %+v, want the first rejection for 500ms", first)
+	}
+	if again := l.Allow(class, "tg:1"); again.Allowed || again.FirstRejection {
+		t.Errorf("second rejection = %+v, want no new warning", again)
+	}
+	if !l.Allow(class, "tg:2").Allowed {
+		t.Error("another subject shares the bucket")
+	}
+
+	*now = now.Add(time.Second)
+	for i := range 2 {
+		if !l.Allow(class, "tg:1").Allowed {
+			t.Fatalf("request %d after a second rejected, two tokens refilled", i+1)
+		}
+	}
+	if l.Allow(class, "tg:1").Allowed {
+		t.Error("more tokens than refilled")
+	}
+
+	*now = now.Add(time.Hour)
+	for i := range class.Burst {
+		if !l.Allow(class, "tg:1").Allowed {
+			t.Fatalf("request %d after an hour rejected, the bucket refills up to the burst only", i+1)
+		}
+	}
+	if l.Allow(class, "tg:1").Allowed {
+		t.Error("bucket refilled above the burst")
+	}
+}
+
+func TestMoneyIsTighterThanGeneral(t *testing.T) {
+	l, now := newTestLimiter()
+	subject := UserSubject(42)
+
+	// Callers check Money after General for operations moving money
+	allow := func() bool {
+		return l.Allow(General, subject).Allowed && l.Allow(Money, subject).Allowed
+	}
+	for i := range Money.Burst {
+		if !allow() {
+			t.Fatalf("money request %d rejected", i+1)
+		}
+	}
+	if allow() {
+		t.Fatal("money request above the money burst allowed")
+	}
+	if !l.Allow(General, subject).Allowed {
+		t.Error("other requests are throttled with money requests")
+	}
+
+	*now = now.Add(time.Duration(float64(time.Second) / Money.Rate))
+	if !allow() {
+		t.Error("money request rejected after a token refilled")
+	}
+
+	stats := l.Throttled()
+	if len(stats) != 1 || stats[0].Class != Money.Name || stats[0].Subject != subject || stats[0].Count != 1 {
+		t.Errorf("throttled = %+v, want one money rejection of %s", stats, subject)
+	}
+}
+
+func TestIdleBucketsAndCountersArePruned(t *testing.T) {
+	l, now := newTestLimiter()
+	class := Class{Name: "test", Rate: 1, Burst: 1}
+
+	l.Allow(class, "ip:192.0.2.1")
+	l.Allow(class, "ip:192.0.2.1")
+	*now = now.Add(time.Hour)
+	l.Allow(class, "ip:192.0.2.2")
+	l.Allow(class, "ip:192.0.2.2")
+
+	*now = now.Add(sweepInterval)
+	l.Allow(class, "ip:192.0.2.3")
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets kept, want only the one just used", len(l.buckets))
+	}
+	if stats := l.Throttled(); len(stats) != 2 {
+		t.Fatalf("throttled = %+v, want both addresses within the retention", stats)
+	}
+
+	*now = testStart.Add(ThrottleRetention)
+	stats := l.Throttled()
+	if len(stats) != 1 || stats[0].Subject != "ip:192.0.2.2" {
+		t.Errorf("throttled = %+v, want only the address throttled within the retention", stats)
+	}
+	if len(l.throttled) != 1 {
+		t.Errorf("%d counters kept, want 1", len(l.throttled))
+	}
+}
@@ -0,0 +1,33 @@
+package services
+
+import "context"
+
+// Sources an action can originate from
+const (
+	SourceBot    = "bot"
+	SourceWeb    = "web"
+	SourceAPI    = "api"
+	SourceSystem = "system"
+)
+
+// Actor identifies who performs an action and through which interface
+type Actor struct {
+	TelegramID int64
+	Source     string
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the acting user and source
+func WithActor(ctx context.Context, telegramID int64, source string) context.Context {
+	return context.WithValue(ctx, actorKey{}, Actor{TelegramID: telegramID, Source: source})
+}
+
+// ActorFromContext returns the actor stored in ctx, defaulting to the system
+func ActorFromContext(ctx context.Context) Actor {
+	actor, ok := ctx.Value(actorKey{}).(Actor)
+	if !ok {
+		return Actor{Source: SourceSystem}
+	}
+	return actor
+}
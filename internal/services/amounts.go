@@ -0,0 +1,43 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ParseAmount parses an amount of money typed by a user. Unlike
+// strconv.ParseFloat it rejects NaN and infinities, which pass every
+// comparison with a balance unnoticed. Callers check the sign themselves.
+func ParseAmount(value string) (float64, error) {
+	amount, err := parseFiniteFloat(value)
+	if err != nil {
+		return 0, ErrInvalidAmount
+	}
+	return amount, nil
+}
+
+// validateAmount returns ErrInvalidAmount unless amount is positive and finite
+func validateAmount(amount float64) error {
+	if !(amount > 0) || math.IsInf(amount, 1) {
+		return ErrInvalidAmount
+	}
+	return nil
+}
+
+// isFinite reports whether f is neither NaN nor infinite
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// parseFiniteFloat parses a number, rejecting NaN and infinities
+func parseFiniteFloat(value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if !isFinite(f) {
+		return 0, fmt.Errorf("%q is not a finite number", value)
+	}
+	return f, nil
+}
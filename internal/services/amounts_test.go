@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+var amountTestStart = time.Date(2025, 8, 4, 12, 0, 0, 0, time.UTC)
+
+func TestParseAmountRejectsNonFiniteNumbers(t *testing.T) {
+	for _, value := range []string{"NaN", "nan", "Inf", "+Inf", "-inf", "infinity", "1e400", "", "ten"} {
+		if amount, err := ParseAmount(value); err == nil {
+			t.Errorf("ParseAmount(%q) = %v, want an error", value, amount)
+		}
+	}
+	amount, err := ParseAmount("12.5")
+	if err != nil || amount != 12.5 {
+		t.Errorf("ParseAmount(12.5) = %v, %v", amount, err)
+	}
+}
+
+func TestServicesRejectNonFiniteAmounts(t *testing.T) {
+	s, _ := newTestService(t, amountTestStart)
+	usd := createCurrency(t, s, "USD")
+	admin := createUser(t, s, 1, "alice", usd, 10, amountTestStart)
+	createUser(t, s, 2, "bob", usd, 10, amountTestStart)
+	s.db.Conn.Model(admin).Update("is_admin", true)
+
+	ctx := WithActor(context.Background(), 1, SourceBot)
+	if _, err := s.CreatePot(ctx, 1, "trip", "USD", 0, nil); err != nil {
+		t.Fatalf("CreatePot: %v", err)
+	}
+
+	for _, amount := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		checks := map[string]error{
+			"TransferMoney":    s.TransferMoney(ctx, 1, "bob", amount, "USD"),
+			"MoveBetweenPots":  s.MoveBetweenPots(ctx, 1, amount, "USD", MainPot, "trip"),
+			"SetTransferLimit": s.SetTransferLimit(ctx, "bob", LimitDaily, amount, "USD"),
+			"AdminSetBalance":  s.AdminSetBalance(ctx, 1, "bob", amount, "USD"),
+		}
+		_, checks["Lend"] = s.Lend(ctx, 1, "bob", amount, "USD", nil)
+		_, checks["Repay"] = s.Repay(ctx, 2, "alice", amount, "USD")
+		_, checks["CreateEscrow"] = s.CreateEscrow(ctx, 1, "bob", amount, "USD")
+		_, checks["CreateVouchers"] = s.CreateVouchers(ctx, 1, amount, "USD", 1, 0, false)
+		_, checks["CreatePot"] = s.CreatePot(ctx, 1, "car", "USD", amount, nil)
+		_, checks["ReverseTransaction"] = s.ReverseTransaction(ctx, 1, 1, amount)
+		for name, err := range checks {
+			if err == nil {
+				t.Errorf("%s accepted %v", name, amount)
+			}
+		}
+	}
+
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 10)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 10)
+	assertAmount(t, "total", totalMoney(t, s, usd), 20)
+}
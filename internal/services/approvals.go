@@ -0,0 +1,292 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultRequiredApprovals = 1
+	defaultApprovalTTL       = 24 * time.Hour
+)
+
+// Notifier delivers out-of-band messages to users, e.g. through the bot
+type Notifier interface {
+	NotifyApprovers(ctx context.Context, op *database.PendingOperation, approvers []database.User)
+	NotifyOperationResolved(ctx context.Context, op *database.PendingOperation)
+	NotifyLoanReminder(ctx context.Context, loan *database.Loan, overdue bool)
+	NotifyEscrow(ctx context.Context, escrow *database.Escrow)
+	NotifyReversal(ctx context.Context, reversal *Reversal)
+}
+
+// PendingApprovalError is returned when an operation was queued for approval instead of executed
+type PendingApprovalError struct {
+	Operation *database.PendingOperation
+}
+
+func (e *PendingApprovalError) Error() string {
+	return fmt.Sprintf("operation #%d requires %d approval(s) and is pending", e.Operation.ID, e.Operation.RequiredApprovals)
+}
+
+func (s *coreService) SetNotifier(notifier Notifier) {
+	s.notifier = notifier
+}
+
+// approvalThreshold returns the amount above which operations in a currency
+// need approval, zero when approvals are disabled
+func approvalThreshold(tx *gorm.DB, currencyCode string) float64 {
+	return getCurrencyFloatSetting(tx, SettingApprovalThreshold, currencyCode, 0)
+}
+
+// requestApproval creates a pending operation when magnitude exceeds the
+// approval threshold. It returns nil when the operation may run right away.
+func (s *coreService) requestApproval(ctx context.Context, tx *gorm.DB, opType string, initiator, target *database.User, amount float64, currencyCode string, magnitude float64) (*database.PendingOperation, error) {
+	threshold := approvalThreshold(tx, currencyCode)
+	if threshold <= 0 || magnitude <= threshold {
+		return nil, nil
+	}
+
+	op := &database.PendingOperation{
+		Type:                opType,
+		InitiatorID:         initiator.ID,
+		InitiatorTelegramID: initiator.TelegramID,
+		InitiatorUsername:   initiator.Username,
+		TargetUserID:        target.ID,
+		TargetUsername:      target.Username,
+		Amount:              amount,
+		CurrencyCode:        currencyCode,
+		Source:              ActorFromContext(ctx).Source,
+		Status:              database.OperationPending,
+		RequiredApprovals:   getIntSetting(tx, SettingApprovalRequired, defaultRequiredApprovals),
+		ExpiresAt:           s.now().Add(getDurationSetting(tx, SettingApprovalTTL, defaultApprovalTTL)),
+	}
+	if err := tx.Create(op).Error; err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// approvers returns the users allowed to approve operations of initiatorID.
+// Admins act as approvers as long as nobody is designated explicitly.
+func approvers(tx *gorm.DB, initiatorID uint) ([]database.User, error) {
+	var users []database.User
+	err := tx.Where("is_approver = ? AND status = ? AND id <> ?", true, database.UserStatusActive, initiatorID).
+		Find(&users).Error
+	if err != nil || len(users) > 0 {
+		return users, err
+	}
+
+	var designated int64
+	if err := tx.Model(&database.User{}).Where("is_approver = ?", true).Count(&designated).Error; err != nil {
+		return nil, err
+	}
+	if designated > 0 {
+		return users, nil
+	}
+	err = tx.Where("is_admin = ? AND status = ? AND id <> ?", true, database.UserStatusActive, initiatorID).
+		Find(&users).Error
+	return users, err
+}
+
+func (s *coreService) notifyApprovers(ctx context.Context, op *database.PendingOperation) {
+	if s.notifier == nil {
+		return
+	}
+	users, err := approvers(s.db.Conn.WithContext(ctx), op.InitiatorID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to load approvers", "operation", op.ID, "error", err)
+		return
+	}
+	if len(users) == 0 {
+		logger.WarnContext(ctx, "No approvers available for pending operation", "operation", op.ID)
+		return
+	}
+	s.notifier.NotifyApprovers(ctx, op, users)
+}
+
+// ListPendingOperations returns the pending operations visible to a user:
+// all of them for approvers and admins, only their own for everybody else.
+func (s *coreService) ListPendingOperations(ctx context.Context, telegramID int64) ([]database.PendingOperation, error) {
+	user, err := s.userService.GetUser(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	db := s.db.Conn.WithContext(ctx)
+	if err := expirePendingOperations(db, s.now()); err != nil {
+		return nil, err
+	}
+
+	query := db.Preload("Approvals").
+		Where("status = ?", database.OperationPending).
+		Order("id")
+	if !user.IsApprover && !user.IsAdmin {
+		query = query.Where("initiator_id = ?", user.ID)
+	}
+
+	var ops []database.PendingOperation
+	if err := query.Find(&ops).Error; err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+func (s *coreService) ApproveOperation(ctx context.Context, approverTelegramID int64, operationID uint) (*database.PendingOperation, error) {
+	return s.decideOperation(ctx, approverTelegramID, operationID, true)
+}
+
+func (s *coreService) RejectOperation(ctx context.Context, approverTelegramID int64, operationID uint) (*database.PendingOperation, error) {
+	return s.decideOperation(ctx, approverTelegramID, operationID, false)
+}
+
+// decideOperation records an approver's decision and executes the operation
+// once it has collected enough approvals, all in one database transaction
+func (s *coreService) decideOperation(ctx context.Context, approverTelegramID int64, operationID uint, approve bool) (*database.PendingOperation, error) {
+	var op database.PendingOperation
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := s.now()
+		if err := expirePendingOperations(tx, now); err != nil {
+			return err
+		}
+
+		approver, err := findUserByTelegramID(tx, approverTelegramID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Preload("Approvals").First(&op, operationID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("operation not found")
+			}
+			return err
+		}
+		if op.Status != database.OperationPending {
+			return fmt.Errorf("operation is already %s", op.Status)
+		}
+
+		allowed, err := approvers(tx, op.InitiatorID)
+		if err != nil {
+			return err
+		}
+		if !containsUser(allowed, approver.ID) {
+			return errors.New("you are not allowed to decide on this operation")
+		}
+		for _, a := range op.Approvals {
+			if a.ApproverID == approver.ID {
+				return errors.New("you have already decided on this operation")
+			}
+		}
+
+		approval := database.Approval{
+			PendingOperationID: op.ID,
+			ApproverID:         approver.ID,
+			ApproverUsername:   approver.Username,
+			Approved:           approve,
+		}
+		if err := tx.Create(&approval).Error; err != nil {
+			return err
+		}
+		op.Approvals = append(op.Approvals, approval)
+
+		action := AuditApproveOperation
+		if !approve {
+			action = AuditRejectOperation
+		}
+		if err := s.recordAudit(ctx, tx, action, fmt.Sprintf("operation:%d", op.ID), op.Status, nil); err != nil {
+			return err
+		}
+
+		if !approve {
+			op.Status = database.OperationRejected
+			return tx.Save(&op).Error
+		}
+
+		approvals := 0
+		for _, a := range op.Approvals {
+			if a.Approved {
+				approvals++
+			}
+		}
+		if approvals < op.RequiredApprovals {
+			return nil
+		}
+
+		// Run the operation in a savepoint so that a failure is recorded
+		// on the operation instead of discarding the approval
+		execErr := tx.Transaction(func(tx *gorm.DB) error {
+			return s.executeOperation(ctx, tx, &op, now)
+		})
+		if execErr != nil {
+			op.Status = database.OperationFailed
+			op.Error = execErr.Error()
+		} else {
+			op.Status = database.OperationExecuted
+		}
+		return tx.Save(&op).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if op.Status != database.OperationPending && s.notifier != nil {
+		s.notifier.NotifyOperationResolved(ctx, &op)
+	}
+	return &op, nil
+}
+
+// executeOperation performs an approved operation on behalf of its initiator
+func (s *coreService) executeOperation(ctx context.Context, tx *gorm.DB, op *database.PendingOperation, now time.Time) error {
+	var initiator database.User
+	if err := tx.Preload("Accounts.Currency").First(&initiator, op.InitiatorID).Error; err != nil {
+		return err
+	}
+	// The target is loaded by ID, its username may have changed or passed
+	// to somebody else since the operation was requested
+	var target database.User
+	if err := tx.Preload("Accounts.Currency").Limit(1).Find(&target, op.TargetUserID).Error; err != nil {
+		return err
+	}
+	if target.ID == 0 {
+		return ErrUserNotFound
+	}
+
+	switch op.Type {
+	case database.OperationTransfer:
+		return s.transferTx(ctx, tx, &initiator, &target, op.Amount, op.CurrencyCode, now)
+	case database.OperationSetBalance:
+		if !initiator.IsAdmin {
+			return errors.New("initiator is no longer an admin")
+		}
+		ctx = WithActor(ctx, op.InitiatorTelegramID, op.Source)
+		return s.setBalanceTx(ctx, tx, &target, op.Amount, op.CurrencyCode)
+	case database.OperationLoan:
+		_, err := lendTx(tx, &initiator, &target, op.Amount, op.CurrencyCode, op.DueDate, now)
+		return err
+	case database.OperationEscrow:
+		_, err := holdTx(tx, &initiator, &target, op.Amount, op.CurrencyCode, now)
+		return err
+	default:
+		return fmt.Errorf("unknown operation type %q", op.Type)
+	}
+}
+
+// expirePendingOperations marks operations past their deadline as expired
+func expirePendingOperations(tx *gorm.DB, now time.Time) error {
+	return tx.Model(&database.PendingOperation{}).
+		Where("status = ? AND expires_at < ?", database.OperationPending, now).
+		Update("status", database.OperationExpired).Error
+}
+
+func containsUser(users []database.User, id uint) bool {
+	for _, u := range users {
+		if u.ID == id {
+			return true
+		}
+	}
+	return false
+}
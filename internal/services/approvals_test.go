@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var approvalTestStart = time.Date(2025, 6, 16, 10, 0, 0, 0, time.UTC)
+
+// newTestApprovals returns a service where transfers above 100 USD need two
+// approvals. Alice (1) holds 200 USD, Bob (2) nothing, Carol (3) and Dave
+// (4) are approvers.
+func newTestApprovals(t *testing.T) (*coreService, *testClock) {
+	t.Helper()
+	s, clock := newTestService(t, approvalTestStart)
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 200, approvalTestStart)
+	createUser(t, s, 2, "bob", usd, 0, approvalTestStart)
+	for _, approver := range []*database.User{
+		createUser(t, s, 3, "carol", usd, 0, approvalTestStart),
+		createUser(t, s, 4, "dave", usd, 0, approvalTestStart),
+	} {
+		s.db.Conn.Model(approver).Update("is_approver", true)
+	}
+	setSetting(t, s, SettingApprovalThreshold, "100")
+	setSetting(t, s, SettingApprovalRequired, "2")
+	return s, clock
+}
+
+// requestTransfer sends a transfer that has to wait for approval and returns
+// the pending operation
+func requestTransfer(t *testing.T, s *coreService, ctx context.Context, from int64, to string, amount float64) *database.PendingOperation {
+	t.Helper()
+	var pending *PendingApprovalError
+	if err := s.TransferMoney(ctx, from, to, amount, "USD"); !errors.As(err, &pending) {
+		t.Fatalf("TransferMoney: err = %v, want a pending approval", err)
+	}
+	return pending.Operation
+}
+
+func TestApprovalNeedsEnoughApprovals(t *testing.T) {
+	s, _ := newTestApprovals(t)
+	ctx := context.Background()
+
+	if err := s.TransferMoney(ctx, 1, "bob", 100, "USD"); err != nil {
+		t.Fatalf("transfer at the threshold: %v", err)
+	}
+	if err := s.TransferMoney(ctx, 2, "alice", 50, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	op := requestTransfer(t, s, ctx, 1, "bob", 120.5)
+	assertAmount(t, "alice before approval", balanceOf(t, s, 1, "USD"), 150)
+	if op.RequiredApprovals != 2 || op.Status != database.OperationPending {
+		t.Fatalf("operation = %+v, want pending with 2 required approvals", op)
+	}
+
+	if _, err := s.ApproveOperation(ctx, 1, op.ID); err == nil {
+		t.Error("initiator approved their own operation")
+	}
+	if _, err := s.ApproveOperation(ctx, 2, op.ID); err == nil {
+		t.Error("a user who is not an approver approved the operation")
+	}
+
+	decided, err := s.ApproveOperation(ctx, 3, op.ID)
+	if err != nil {
+		t.Fatalf("first approval: %v", err)
+	}
+	if decided.Status != database.OperationPending {
+		t.Fatalf("status after one approval = %q, want pending", decided.Status)
+	}
+	if _, err := s.ApproveOperation(ctx, 3, op.ID); err == nil {
+		t.Error("an approver decided twice")
+	}
+	assertAmount(t, "bob after one approval", balanceOf(t, s, 2, "USD"), 50)
+
+	decided, err = s.ApproveOperation(ctx, 4, op.ID)
+	if err != nil {
+		t.Fatalf("second approval: %v", err)
+	}
+	if decided.Status != database.OperationExecuted {
+		t.Fatalf("status after two approvals = %q (%s), want executed", decided.Status, decided.Error)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 29.5)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 170.5)
+
+	if _, err := s.ApproveOperation(ctx, 4, op.ID); err == nil {
+		t.Error("an executed operation was decided again")
+	}
+}
+
+func TestRejectedOperationDoesNotExecute(t *testing.T) {
+	s, _ := newTestApprovals(t)
+	ctx := context.Background()
+	op := requestTransfer(t, s, ctx, 1, "bob", 150)
+
+	if _, err := s.ApproveOperation(ctx, 3, op.ID); err != nil {
+		t.Fatalf("ApproveOperation: %v", err)
+	}
+	decided, err := s.RejectOperation(ctx, 4, op.ID)
+	if err != nil {
+		t.Fatalf("RejectOperation: %v", err)
+	}
+	if decided.Status != database.OperationRejected {
+		t.Fatalf("status = %q, want rejected", decided.Status)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 200)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 0)
+}
+
+func TestPendingOperationExpires(t *testing.T) {
+	s, clock := newTestApprovals(t)
+	setSetting(t, s, SettingApprovalTTL, "1h")
+	ctx := context.Background()
+	op := requestTransfer(t, s, ctx, 1, "bob", 150)
+
+	clock.Set(approvalTestStart.Add(61 * time.Minute))
+	ops, err := s.ListPendingOperations(ctx, 3)
+	if err != nil {
+		t.Fatalf("ListPendingOperations: %v", err)
+	}
+	if len(ops) != 0 {
+		t.Errorf("pending operations after expiry = %d, want 0", len(ops))
+	}
+	if _, err := s.ApproveOperation(ctx, 3, op.ID); err == nil {
+		t.Fatal("an expired operation was approved")
+	}
+
+	var stored database.PendingOperation
+	if err := s.db.Conn.First(&stored, op.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != database.OperationExpired {
+		t.Errorf("status = %q, want expired", stored.Status)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 200)
+}
+
+func TestFailedExecutionLeavesBalancesAlone(t *testing.T) {
+	s, _ := newTestApprovals(t)
+	setSetting(t, s, SettingApprovalRequired, "1")
+	ctx := context.Background()
+	op := requestTransfer(t, s, ctx, 1, "bob", 150)
+
+	// Alice spends the money while the transfer waits
+	if err := s.TransferMoney(ctx, 1, "carol", 100, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	decided, err := s.ApproveOperation(ctx, 4, op.ID)
+	if err != nil {
+		t.Fatalf("ApproveOperation: %v", err)
+	}
+	if decided.Status != database.OperationFailed || decided.Error == "" {
+		t.Fatalf("operation = %q (%q), want failed with an error", decided.Status, decided.Error)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 100)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 0)
+
+	var approvals int64
+	s.db.Conn.Model(&database.Approval{}).Where("pending_operation_id = ?", op.ID).Count(&approvals)
+	if approvals != 1 {
+		t.Errorf("approvals = %d, want the approval kept", approvals)
+	}
+}
+
+func TestApprovedTransferReachesTheRequestedUser(t *testing.T) {
+	s, _ := newTestApprovals(t)
+	setSetting(t, s, SettingApprovalThreshold, "10")
+	setSetting(t, s, SettingApprovalRequired, "1")
+	usd, err := s.GetCurrencyByCode(context.Background(), "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	createUser(t, s, 5, "", *usd, 0, approvalTestStart)
+	ctx := context.Background()
+
+	// A recipient without a username is named by Telegram ID
+	op := requestTransfer(t, s, ctx, 1, "5", 20)
+	if decided, err := s.ApproveOperation(ctx, 3, op.ID); err != nil || decided.Status != database.OperationExecuted {
+		t.Fatalf("ApproveOperation = %+v, %v, want executed", decided, err)
+	}
+	assertAmount(t, "user without a username", balanceOf(t, s, 5, "USD"), 20)
+
+	// Bob gives up his username and somebody else takes it before approval
+	op = requestTransfer(t, s, ctx, 1, "bob", 30)
+	if err := s.db.Conn.Model(&database.User{}).Where("telegram_id = ?", 2).Update("username", "robert").Error; err != nil {
+		t.Fatal(err)
+	}
+	createUser(t, s, 6, "bob", *usd, 0, approvalTestStart)
+	if decided, err := s.ApproveOperation(ctx, 3, op.ID); err != nil || decided.Status != database.OperationExecuted {
+		t.Fatalf("ApproveOperation = %+v, %v, want executed", decided, err)
+	}
+	assertAmount(t, "requested recipient", balanceOf(t, s, 2, "USD"), 30)
+	assertAmount(t, "new owner of the username", balanceOf(t, s, 6, "USD"), 0)
+}
+
+func TestApprovalsStayInTheirWallet(t *testing.T) {
+	a, ctxA, b, ctxB := newTestWallets(t)
+	setSetting(t, a, SettingApprovalThreshold, "50")
+	bob, err := findUserByTelegramID(a.db.Conn, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.db.Conn.Model(bob).Update("is_approver", true)
+
+	op := requestTransfer(t, a, ctxA, 1, "bob", 60)
+
+	// Alice administers both wallets, Carol is a member of the second only
+	for _, approver := range []int64{1, 3} {
+		if _, err := b.ApproveOperation(ctxB, approver, op.ID); err == nil {
+			t.Errorf("user %d approved an operation of another wallet", approver)
+		}
+		ops, err := b.ListPendingOperations(ctxB, approver)
+		if err != nil {
+			t.Fatalf("ListPendingOperations: %v", err)
+		}
+		if len(ops) != 0 {
+			t.Errorf("user %d sees %d operations of another wallet", approver, len(ops))
+		}
+	}
+
+	decided, err := a.ApproveOperation(ctxA, 2, op.ID)
+	if err != nil || decided.Status != database.OperationExecuted {
+		t.Fatalf("ApproveOperation = %+v, %v, want executed", decided, err)
+	}
+	assertAmount(t, "bob", balanceOf(t, a, 2, "USD"), 60)
+	assertAmount(t, "carol", balanceOf(t, b, 3, "USD"), 50)
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// Audited admin actions
+const (
+	AuditSetAdminStatus     = "set_admin_status"
+	AuditSetApproverStatus  = "set_approver_status"
+	AuditSetBalance         = "set_balance"
+	AuditSetStatus          = "set_status"
+	AuditSetLimit           = "set_limit"
+	AuditRemoveUser         = "remove_user"
+	AuditRestoreUser        = "restore_user"
+	AuditPurgeUser          = "purge_user"
+	AuditAddUser            = "add_user"
+	AuditAddCurrency        = "add_currency"
+	AuditSetDefaultCurrency = "set_default_currency"
+	AuditSetSetting         = "set_setting"
+	AuditImport             = "import"
+	AuditApproveOperation   = "approve_operation"
+	AuditRejectOperation    = "reject_operation"
+	AuditResolveEscrow      = "resolve_escrow"
+	AuditCreateVouchers     = "create_vouchers"
+	AuditReverseTransaction = "reverse_transaction"
+	AuditCreateInvite       = "create_invite"
+	AuditRevokeInvite       = "revoke_invite"
+	AuditCreateWallet       = "create_wallet"
+	AuditCreateWebhook      = "create_webhook"
+	AuditDeleteWebhook      = "delete_webhook"
+)
+
+// AuditFilter narrows down the audit log listing. Zero values match everything.
+type AuditFilter struct {
+	Actor  string // actor username
+	Action string
+	Target string // substring of the target
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// recordAudit appends an entry to the audit log using tx, so that the entry is
+// committed or rolled back together with the change it describes.
+func (s *coreService) recordAudit(ctx context.Context, tx *gorm.DB, action, target string, before, after any) error {
+	actor := ActorFromContext(ctx)
+
+	var actorUsername string
+	if actor.TelegramID != 0 {
+		var user database.User
+		if err := tx.Unscoped().Where("telegram_id = ?", actor.TelegramID).First(&user).Error; err == nil {
+			actorUsername = user.Username
+		}
+	}
+
+	// One chain spans the entries of all wallets
+	var last database.AuditLog
+	prevHash := ""
+	err := tx.WithContext(database.AnyWallet(ctx)).Order("id desc").Limit(1).Find(&last).Error
+	if err != nil {
+		return err
+	}
+	if last.ID != 0 {
+		prevHash = last.Hash
+	}
+
+	entry := database.AuditLog{
+		// Set here rather than on create, the hash covers it
+		WalletID:        database.WalletFromContext(tx.Statement.Context),
+		CreatedAt:       s.now().Truncate(time.Microsecond),
+		ActorTelegramID: actor.TelegramID,
+		ActorUsername:   actorUsername,
+		Action:          action,
+		Target:          target,
+		Before:          auditValue(before),
+		After:           auditValue(after),
+		Source:          actor.Source,
+		PrevHash:        prevHash,
+	}
+	entry.Hash = auditHash(&entry)
+
+	return tx.Create(&entry).Error
+}
+
+// auditValue serializes a before/after value for storage
+func auditValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
+
+// auditHash computes the chained hash of an entry. The wallet of entries in
+// the default wallet is left out, so that entries written before wallets
+// existed still match their hash.
+func auditHash(e *database.AuditLog) string {
+	fields := []string{
+		e.PrevHash,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		strconv.FormatInt(e.ActorTelegramID, 10),
+		e.ActorUsername,
+		e.Action,
+		e.Target,
+		e.Before,
+		e.After,
+		e.Source,
+	}
+	if e.WalletID != database.DefaultWalletID {
+		fields = append(fields, strconv.FormatUint(uint64(e.WalletID), 10))
+	}
+
+	h := sha256.New()
+	for _, field := range fields {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *coreService) ListAuditLogs(ctx context.Context, filter AuditFilter) ([]database.AuditLog, error) {
+	query := s.db.Conn.WithContext(ctx).Order("id desc")
+	if filter.Actor != "" {
+		query = query.Where("actor_username = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Target != "" {
+		query = query.Where("target LIKE ?", "%"+filter.Target+"%")
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To.UTC())
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var logs []database.AuditLog
+	if err := query.Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// VerifyAuditLog walks the whole audit log of all wallets and checks the hash
+// chain. It returns an error describing the first entry that does not match.
+func (s *coreService) VerifyAuditLog(ctx context.Context) error {
+	var logs []database.AuditLog
+	if err := s.db.Conn.WithContext(database.AnyWallet(ctx)).Order("id asc").Find(&logs).Error; err != nil {
+		return err
+	}
+
+	prevHash := ""
+	for i := range logs {
+		entry := &logs[i]
+		if entry.PrevHash != prevHash {
+			return fmt.Errorf("audit log entry #%d: chain broken, previous entry missing or altered", entry.ID)
+		}
+		if auditHash(entry) != entry.Hash {
+			return fmt.Errorf("audit log entry #%d: content does not match its hash", entry.ID)
+		}
+		prevHash = entry.Hash
+	}
+	return nil
+}
+
+// WriteAuditLogsCSV writes the given entries to w as CSV with a header row
+func WriteAuditLogsCSV(w io.Writer, logs []database.AuditLog) error {
+	cw := csv.NewWriter(w)
+	header := []string{"id", "timestamp", "actor_telegram_id", "actor_username", "action", "target", "before", "after", "source", "prev_hash", "hash"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, e := range logs {
+		record := []string{
+			strconv.FormatUint(uint64(e.ID), 10),
+			e.CreatedAt.UTC().Format(time.RFC3339Nano),
+			strconv.FormatInt(e.ActorTelegramID, 10),
+			e.ActorUsername,
+			e.Action,
+			e.Target,
+			e.Before,
+			e.After,
+			e.Source,
+			e.PrevHash,
+			e.Hash,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ParseAuditFilter parses "key=value" arguments such as
+// actor=@alice action=set_balance target=bob from=2024-01-01 to=2024-02-01 limit=50
+func ParseAuditFilter(args []string) (AuditFilter, error) {
+	filter := AuditFilter{}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return filter, fmt.Errorf("invalid filter %q, expected key=value", arg)
+		}
+		switch key {
+		case "actor":
+			filter.Actor = strings.TrimPrefix(value, "@")
+		case "action":
+			filter.Action = value
+		case "target":
+			filter.Target = strings.TrimPrefix(value, "@")
+		case "from", "to":
+			t, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+			}
+			if key == "from" {
+				filter.From = t
+			} else {
+				filter.To = t.AddDate(0, 0, 1)
+			}
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				return filter, fmt.Errorf("invalid limit %q", value)
+			}
+			filter.Limit = limit
+		default:
+			return filter, fmt.Errorf("unknown filter %q", key)
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+func TestAuditEntriesUseTheServiceClock(t *testing.T) {
+	start := time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC)
+	s, clock := newTestService(t, start)
+	createCurrency(t, s, "USD")
+	clock.Set(start.Add(90 * time.Minute))
+	if err := s.SetDefaultCurrency(context.Background(), "USD"); err != nil {
+		t.Fatalf("SetDefaultCurrency: %v", err)
+	}
+
+	var entry database.AuditLog
+	if err := s.db.Conn.Where("action = ?", AuditSetDefaultCurrency).First(&entry).Error; err != nil {
+		t.Fatalf("audit entry: %v", err)
+	}
+	if !entry.CreatedAt.Equal(start.Add(90 * time.Minute)) {
+		t.Errorf("entry created at %v, want the time of the service clock", entry.CreatedAt)
+	}
+}
+
+func TestVerifyAuditLogFindsTamperedEntries(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tamper string
+	}{
+		{"content", `UPDATE audit_logs SET target = '@mallory' WHERE action = 'set_admin_status'`},
+		{"wallet moved away", `UPDATE audit_logs SET wallet_id = 1 WHERE wallet_id = 2`},
+		{"wallet moved in", `UPDATE audit_logs SET wallet_id = 2 WHERE action = 'set_admin_status'`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, ctxA, _, _ := newTestWallets(t)
+			if err := a.VerifyAuditLog(ctxA); err != nil {
+				t.Fatalf("VerifyAuditLog before tampering: %v", err)
+			}
+
+			// Bypass the trigger that keeps the log append-only
+			if err := a.db.Conn.Exec(`DROP TRIGGER audit_logs_no_update`).Error; err != nil {
+				t.Fatal(err)
+			}
+			result := a.db.Conn.Exec(tc.tamper)
+			if result.Error != nil || result.RowsAffected == 0 {
+				t.Fatalf("tamper: %v, %d rows", result.Error, result.RowsAffected)
+			}
+			if err := a.VerifyAuditLog(ctxA); err == nil {
+				t.Error("VerifyAuditLog accepted a tampered entry")
+			}
+		})
+	}
+}
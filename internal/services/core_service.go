@@ -4,37 +4,118 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"time"
 
 	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/metrics"
 	"gorm.io/gorm"
 )
 
 type CoreService interface {
 	GetBalances(ctx context.Context, telegramID int64) ([]database.Balance, error)
+	ListPots(ctx context.Context, telegramID int64) ([]database.Balance, error)
+	CreatePot(ctx context.Context, telegramID int64, name, currencyCode string, goal float64, deadline *time.Time) (*database.Balance, error)
+	MoveBetweenPots(ctx context.Context, telegramID int64, amount float64, currencyCode, fromPot, toPot string) error
 	GetDefaultCurrency(ctx context.Context) (*database.Currency, error)
+	ResolveRecipient(ctx context.Context, recipient string) (*database.User, error)
 	TransferMoney(ctx context.Context, fromTelegramID int64, toUsername string, amount float64, currencyCode string) error
+	QuoteTransferFee(ctx context.Context, telegramID int64, amount float64, currencyCode string) (float64, error)
+	Lend(ctx context.Context, lenderTelegramID int64, borrowerUsername string, amount float64, currencyCode string, due *time.Time) (*database.Loan, error)
+	Repay(ctx context.Context, borrowerTelegramID int64, lenderUsername string, amount float64, currencyCode string) (*Repayment, error)
+	ListLoans(ctx context.Context, telegramID int64) ([]database.Loan, error)
+	CreateEscrow(ctx context.Context, senderTelegramID int64, recipientUsername string, amount float64, currencyCode string) (*database.Escrow, error)
+	ReleaseEscrow(ctx context.Context, telegramID int64, escrowID uint) (*database.Escrow, error)
+	CancelEscrow(ctx context.Context, telegramID int64, escrowID uint) (*database.Escrow, error)
+	DisputeEscrow(ctx context.Context, telegramID int64, escrowID uint) (*database.Escrow, error)
+	ResolveEscrow(ctx context.Context, adminTelegramID int64, escrowID uint, release bool) (*database.Escrow, error)
+	ListEscrows(ctx context.Context, telegramID int64) ([]database.Escrow, error)
+	CreateVouchers(ctx context.Context, adminTelegramID int64, amount float64, currencyCode string, count int, ttl time.Duration, fromSystem bool) ([]database.Voucher, error)
+	RedeemVoucher(ctx context.Context, telegramID int64, code string) (*database.Voucher, error)
+	VoucherReport(ctx context.Context) ([]VoucherBatch, error)
+	Register(ctx context.Context, profile Profile, inviteCode string) (*Registration, error)
+	CreateInvite(ctx context.Context, adminTelegramID int64, maxUses int, ttl time.Duration) (*database.Invite, error)
+	RevokeInvite(ctx context.Context, adminTelegramID int64, code string) error
+	ListInvites(ctx context.Context) ([]InviteReport, error)
+	CreateWallet(ctx context.Context, adminTelegramID int64, name string) (*database.Wallet, error)
+	CreateWebhook(ctx context.Context, adminTelegramID int64, rawURL string, events []string) (*database.Webhook, error)
+	DeleteWebhook(ctx context.Context, adminTelegramID int64, webhookID uint) error
+	ListWebhooks(ctx context.Context) ([]database.Webhook, error)
+	ListWebhookDeliveries(ctx context.Context, webhookID uint, limit int) ([]database.WebhookDelivery, error)
 	GetTransactionHistory(ctx context.Context, telegramID int64) ([]database.Transaction, error)
+	ReverseTransaction(ctx context.Context, telegramID int64, transactionID uint, amount float64) (*Reversal, error)
+	ListRefundable(ctx context.Context, telegramID int64) ([]database.Transaction, error)
 	SetAdminStatus(ctx context.Context, targetUsername string, isAdmin bool) error
+	SetApproverStatus(ctx context.Context, targetUsername string, isApprover bool) error
+	SetUserStatus(ctx context.Context, username, status string) error
+	SetTransferLimit(ctx context.Context, username, kind string, value float64, currencyCode string) error
+	GetTransferLimits(ctx context.Context, telegramID int64) ([]database.TransferLimit, error)
 	AdminSetBalance(ctx context.Context, adminTelegramID int64, targetUsername string, amount float64, currencyCode string) error
 	GetCurrencyByCode(ctx context.Context, code string) (*database.Currency, error)
 	ListUsersWithBalances(ctx context.Context) ([]UserWithBalance, error)
-	RemoveUser(ctx context.Context, username string) error
+	RemoveUser(ctx context.Context, username, sweepToUsername string) error
+	RestoreUser(ctx context.Context, username string) error
+	PurgeUser(ctx context.Context, username string) error
 	AddUser(ctx context.Context, telegramID int64, username string) error
 	AddCurrency(ctx context.Context, code, name, sign string) error
 	SetDefaultCurrency(ctx context.Context, code string) error
+	Import(ctx context.Context, format string, r io.Reader, dryRun bool) (*ImportReport, error)
+	GenerateStatement(ctx context.Context, telegramID int64, from, to time.Time) (*Statement, error)
+	ListAuditLogs(ctx context.Context, filter AuditFilter) ([]database.AuditLog, error)
+	VerifyAuditLog(ctx context.Context) error
+	ListSettings(ctx context.Context) ([]database.Setting, error)
+	SetSetting(ctx context.Context, key, value string) error
+	SetNotifier(notifier Notifier)
+	Now() time.Time
+	ListPendingOperations(ctx context.Context, telegramID int64) ([]database.PendingOperation, error)
+	ApproveOperation(ctx context.Context, approverTelegramID int64, operationID uint) (*database.PendingOperation, error)
+	RejectOperation(ctx context.Context, approverTelegramID int64, operationID uint) (*database.PendingOperation, error)
+	RunInterest(ctx context.Context) (*InterestReport, error)
+	RunLoanReminders(ctx context.Context) (int, error)
+	RunEscrowTimeouts(ctx context.Context) (int, error)
+	RunVoucherExpiry(ctx context.Context) (int, error)
+	RunWebhookDeliveries(ctx context.Context) (int, error)
 }
 
 type coreService struct {
 	db          *database.DB
 	userService UserService
+	notifier    Notifier
+	events      *EventBus
+	httpClient  *http.Client     // sends webhook deliveries
+	clock       func() time.Time // replaced in tests to control time
+
+	// webhookAddressAllowed checks the addresses of new webhooks, replaced
+	// in tests to reach local receivers
+	webhookAddressAllowed func(net.IP) bool
 }
 
 func NewCoreService(db *database.DB, userService UserService) CoreService {
-	return &coreService{
+	s := &coreService{
 		db:          db,
 		userService: userService,
+		events:      &EventBus{},
+		httpClient:  newWebhookClient(publicAddress),
+		clock:       time.Now,
+
+		webhookAddressAllowed: publicAddress,
 	}
+	s.events.Subscribe(s.enqueueWebhooks)
+	return s
+}
+
+// Now returns the service's current time, which due dates and deadlines are
+// compared with
+func (s *coreService) Now() time.Time {
+	return s.now()
+}
+
+// now returns the current time in UTC
+func (s *coreService) now() time.Time {
+	return s.clock().UTC()
 }
 
 func (s *coreService) GetBalances(ctx context.Context, telegramID int64) ([]database.Balance, error) {
@@ -56,91 +137,132 @@ func (s *coreService) GetDefaultCurrency(ctx context.Context) (*database.Currenc
 	return &currency, nil
 }
 
+// TransferMoney sends money to a recipient, named by username or Telegram ID
 func (s *coreService) TransferMoney(ctx context.Context, fromTelegramID int64, toUsername string, amount float64, currencyCode string) error {
-	fromUser, err := s.userService.GetUser(ctx, fromTelegramID)
-	if err != nil {
-		return err
-	}
+	var pending *database.PendingOperation
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		fromUser, err := findUserByTelegramID(tx, fromTelegramID)
+		if err != nil {
+			return err
+		}
+		toUser, err := findUserForUpdate(tx, toUsername)
+		if err != nil {
+			return err
+		}
+
+		now := s.now()
+		if _, _, err := prepareTransfer(tx, fromUser, toUser, amount, currencyCode, now); err != nil {
+			return err
+		}
 
-	toUser, err := s.userService.GetUserByUsername(toUsername)
+		pending, err = s.requestApproval(ctx, tx, database.OperationTransfer, fromUser, toUser, amount, currencyCode, amount)
+		if err != nil || pending != nil {
+			return err
+		}
+		return s.transferTx(ctx, tx, fromUser, toUser, amount, currencyCode, now)
+	})
 	if err != nil {
+		metrics.TransfersFailed.WithLabelValues(transferFailureReason(err)).Inc()
 		return err
 	}
+	if pending != nil {
+		s.notifyApprovers(ctx, pending)
+		return &PendingApprovalError{Operation: pending}
+	}
+	return nil
+}
 
+// prepareTransfer validates a transfer and returns the balances it moves money between
+func prepareTransfer(tx *gorm.DB, fromUser, toUser *database.User, amount float64, currencyCode string, now time.Time) (*database.Balance, *database.Balance, error) {
 	if fromUser.ID == toUser.ID {
-		return errors.New("cannot transfer to self")
+		return nil, nil, ErrTransferToSelf
 	}
-	if amount <= 0 {
-		return errors.New("transfer amount must be positive")
+	if !fromUser.IsActive() {
+		return nil, nil, ErrAccountFrozen
 	}
-
-	var fromBalance, toBalance *database.Balance
-	for i := range fromUser.Accounts {
-		if fromUser.Accounts[i].Currency.Code == currencyCode {
-			fromBalance = &fromUser.Accounts[i]
-			break
-		}
+	if !toUser.IsActive() {
+		return nil, nil, ErrRecipientNotActive
 	}
-	for i := range toUser.Accounts {
-		if toUser.Accounts[i].Currency.Code == currencyCode {
-			toBalance = &toUser.Accounts[i]
-			break
-		}
+	if err := validateAmount(amount); err != nil {
+		return nil, nil, err
 	}
 
+	fromBalance := findBalance(fromUser, currencyCode)
+	toBalance := findBalance(toUser, currencyCode)
 	if fromBalance == nil || toBalance == nil {
-		return errors.New("currency not supported")
-	}
-	if fromBalance.Amount < amount {
-		return errors.New("insufficient balance")
-	}
-
-	fromBalance.Amount -= amount
-	toBalance.Amount += amount
-
-	// Create transactions
-	now := time.Now()
-	fromTransaction := database.Transaction{
-		UserID:       fromUser.ID,
-		BalanceID:    fromBalance.ID,
-		Amount:       -amount,
-		Type:         "transfer_out",
-		FromUserID:   fromUser.ID,
-		FromUsername: fromUser.Username,
-		ToUserID:     toUser.ID,
-		ToUsername:   toUser.Username,
-		Timestamp:    now,
-		BalanceAfter: fromBalance.Amount,
-	}
-	toTransaction := database.Transaction{
-		UserID:       toUser.ID,
-		BalanceID:    toBalance.ID,
-		Amount:       amount,
-		Type:         "transfer_in",
-		FromUserID:   fromUser.ID,
-		FromUsername: fromUser.Username,
-		ToUserID:     toUser.ID,
-		ToUsername:   toUser.Username,
-		Timestamp:    now,
-		BalanceAfter: toBalance.Amount,
-	}
-
-	// Save to database
-	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := tx.Save(fromBalance).Error; err != nil {
-			return err
-		}
-		if err := tx.Save(toBalance).Error; err != nil {
-			return err
+		return nil, nil, ErrCurrencyNotSupported
+	}
+	if fromBalance.Amount < amount+transferFee(tx, fromUser, currencyCode, amount) {
+		return nil, nil, ErrInsufficientBalance
+	}
+	if err := checkTransferLimits(tx, fromUser, fromBalance, amount, now); err != nil {
+		return nil, nil, err
+	}
+	return fromBalance, toBalance, nil
+}
+
+// transferTx moves money between two users and records the transactions using tx
+func (s *coreService) transferTx(ctx context.Context, tx *gorm.DB, fromUser, toUser *database.User, amount float64, currencyCode string, now time.Time) error {
+	fromBalance, toBalance, err := prepareTransfer(tx, fromUser, toUser, amount, currencyCode, now)
+	if err != nil {
+		return err
+	}
+
+	fee := transferFee(tx, fromUser, currencyCode, amount)
+	// The sender's side keeps the fee, so that refunds can give it back
+	withFee := func(t *database.Transaction) {
+		if t.Type == "transfer_out" {
+			t.Fee = fee
 		}
-		if err := tx.Create(&fromTransaction).Error; err != nil {
-			return err
+	}
+	if err := moveMoney(tx, fromUser, fromBalance, toUser, toBalance, amount, "transfer_out", "transfer_in", now, withFee); err != nil {
+		return err
+	}
+	if err := chargeTransferFee(tx, fromUser, fromBalance, amount, now); err != nil {
+		return err
+	}
+
+	metrics.Transfers.WithLabelValues(currencyCode).Inc()
+	metrics.TransferVolume.WithLabelValues(currencyCode).Add(amount)
+	return s.publish(ctx, tx, TransferCompleted{
+		FromTelegramID: fromUser.TelegramID,
+		FromUsername:   fromUser.Username,
+		ToTelegramID:   toUser.TelegramID,
+		ToUsername:     toUser.Username,
+		Amount:         amount,
+		Fee:            fee,
+		Currency:       currencyCode,
+	})
+}
+
+// findUserForUpdate loads a non-deleted user with balances inside tx. The
+// user may be named as anything resolveRecipient understands.
+func findUserForUpdate(tx *gorm.DB, username string) (*database.User, error) {
+	return resolveRecipient(tx, username)
+}
+
+// findUserByTelegramID loads a non-deleted user with balances inside tx
+func findUserByTelegramID(tx *gorm.DB, telegramID int64) (*database.User, error) {
+	var user database.User
+	if err := tx.Preload("Accounts.Currency").
+		Where("telegram_id = ?", telegramID).
+		First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
 		}
-		if err := tx.Create(&toTransaction).Error; err != nil {
-			return err
+		return nil, err
+	}
+	return &user, nil
+}
+
+// findBalance returns the user's main balance in the given currency, or nil
+func findBalance(user *database.User, currencyCode string) *database.Balance {
+	for i := range user.Accounts {
+		if user.Accounts[i].Currency.Code == currencyCode && !user.Accounts[i].IsPot() {
+			return &user.Accounts[i]
 		}
-		return nil
-	})
+	}
+	return nil
 }
 
 func (s *coreService) GetTransactionHistory(ctx context.Context, telegramID int64) ([]database.Transaction, error) {
@@ -159,49 +281,94 @@ func (s *coreService) GetTransactionHistory(ctx context.Context, telegramID int6
 	if err != nil {
 		return nil, err
 	}
+	if err := withCurrentNames(s.db.Conn.WithContext(ctx), transactions); err != nil {
+		return nil, err
+	}
 	return transactions, nil
 }
 
 // Admin functions
 
 func (s *coreService) SetAdminStatus(ctx context.Context, targetUsername string, isAdmin bool) error {
-	var user database.User
-	if err := s.db.Conn.WithContext(ctx).
-		Where("username = ?", targetUsername).
-		First(&user).Error; err != nil {
-		return err
-	}
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := findUserForUpdate(tx, targetUsername)
+		if err != nil {
+			return err
+		}
+
+		before := user.IsAdmin
+		if err := tx.Model(user).Update("is_admin", isAdmin).Error; err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, tx, AuditSetAdminStatus, "@"+user.Username, before, isAdmin)
+	})
+}
 
-	return s.db.Conn.WithContext(ctx).
-		Model(&user).
-		Update("is_admin", isAdmin).Error
+func (s *coreService) SetApproverStatus(ctx context.Context, targetUsername string, isApprover bool) error {
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := findUserForUpdate(tx, targetUsername)
+		if err != nil {
+			return err
+		}
+
+		before := user.IsApprover
+		if err := tx.Model(user).Update("is_approver", isApprover).Error; err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, tx, AuditSetApproverStatus, "@"+user.Username, before, isApprover)
+	})
 }
 
 func (s *coreService) AdminSetBalance(ctx context.Context, adminTelegramID int64, targetUsername string, amount float64, currencyCode string) error {
 	if !s.userService.IsAdmin(ctx, adminTelegramID) {
 		return errors.New("unauthorized")
 	}
-
-	var targetUser database.User
-	if err := s.db.Conn.WithContext(ctx).
-		Preload("Accounts.Currency").
-		Where("username = ?", targetUsername).
-		First(&targetUser).Error; err != nil {
-		return err
+	if !isFinite(amount) {
+		return ErrInvalidAmount
 	}
 
-	var targetBalance *database.Balance
-	for i := range targetUser.Accounts {
-		if targetUser.Accounts[i].Currency.Code == currencyCode {
-			targetBalance = &targetUser.Accounts[i]
-			break
+	var pending *database.PendingOperation
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		admin, err := findUserByTelegramID(tx, adminTelegramID)
+		if err != nil {
+			return err
+		}
+		targetUser, err := findUserForUpdate(tx, targetUsername)
+		if err != nil {
+			return err
+		}
+
+		adjustment := amount
+		if balance := findBalance(targetUser, currencyCode); balance != nil {
+			adjustment = amount - balance.Amount
 		}
+		pending, err = s.requestApproval(ctx, tx, database.OperationSetBalance, admin, targetUser, amount, currencyCode, abs(adjustment))
+		if err != nil || pending != nil {
+			return err
+		}
+		return s.setBalanceTx(ctx, tx, targetUser, amount, currencyCode)
+	})
+	if err != nil {
+		return err
+	}
+	if pending != nil {
+		s.notifyApprovers(ctx, pending)
+		return &PendingApprovalError{Operation: pending}
 	}
+	return nil
+}
+
+// setBalanceTx overwrites a user's balance in a currency using tx and records
+// the adjustment in the ledger
+func (s *coreService) setBalanceTx(ctx context.Context, tx *gorm.DB, targetUser *database.User, amount float64, currencyCode string) error {
+	targetBalance := findBalance(targetUser, currencyCode)
 
+	var before any
+	previous := 0.0
 	if targetBalance == nil {
 		// Create new balance
-		currency, err := s.GetCurrencyByCode(ctx, currencyCode)
-		if err != nil {
+		var currency database.Currency
+		if err := tx.Where("code = ?", currencyCode).First(&currency).Error; err != nil {
 			return err
 		}
 		targetBalance = &database.Balance{
@@ -209,17 +376,52 @@ func (s *coreService) AdminSetBalance(ctx context.Context, adminTelegramID int64
 			Amount:     amount,
 			CurrencyID: currency.ID,
 		}
-		if err := s.db.Conn.WithContext(ctx).Create(targetBalance).Error; err != nil {
+		if err := tx.Create(targetBalance).Error; err != nil {
 			return err
 		}
 	} else {
+		before = targetBalance.Amount
+		previous = targetBalance.Amount
 		targetBalance.Amount = amount
-		if err := s.db.Conn.WithContext(ctx).Save(targetBalance).Error; err != nil {
+		if err := tx.Save(targetBalance).Error; err != nil {
 			return err
 		}
 	}
 
-	return nil
+	var admin database.User
+	if actor := ActorFromContext(ctx); actor.TelegramID != 0 {
+		if err := tx.Where("telegram_id = ?", actor.TelegramID).Limit(1).Find(&admin).Error; err != nil {
+			return err
+		}
+	}
+	adjustment := database.Transaction{
+		UserID:       targetUser.ID,
+		BalanceID:    targetBalance.ID,
+		Amount:       amount - previous,
+		Type:         "admin_set_balance",
+		FromUserID:   admin.ID,
+		FromUsername: admin.Username,
+		ToUserID:     targetUser.ID,
+		ToUsername:   targetUser.Username,
+		Timestamp:    s.now(),
+		BalanceAfter: amount,
+	}
+	if err := tx.Create(&adjustment).Error; err != nil {
+		return err
+	}
+
+	target := fmt.Sprintf("@%s %s", targetUser.Username, currencyCode)
+	if err := s.recordAudit(ctx, tx, AuditSetBalance, target, before, amount); err != nil {
+		return err
+	}
+	return s.publish(ctx, tx, BalanceAdjusted{
+		TelegramID:    targetUser.TelegramID,
+		Username:      targetUser.Username,
+		Currency:      currencyCode,
+		Before:        previous,
+		After:         amount,
+		AdminUsername: admin.Username,
+	})
 }
 
 func (s *coreService) GetCurrencyByCode(ctx context.Context, code string) (*database.Currency, error) {
@@ -235,6 +437,7 @@ func (s *coreService) GetCurrencyByCode(ctx context.Context, code string) (*data
 type UserWithBalance struct {
 	TelegramID int64
 	Username   string
+	Status     string
 	Balances   map[string]float64
 }
 
@@ -242,6 +445,7 @@ func (s *coreService) ListUsersWithBalances(ctx context.Context) ([]UserWithBala
 	var users []database.User
 	err := s.db.Conn.WithContext(ctx).
 		Preload("Accounts.Currency").
+		Where("status <> ?", database.UserStatusClosed).
 		Find(&users).Error
 	if err != nil {
 		return nil, err
@@ -252,48 +456,69 @@ func (s *coreService) ListUsersWithBalances(ctx context.Context) ([]UserWithBala
 		ub := UserWithBalance{
 			TelegramID: user.TelegramID,
 			Username:   user.Username,
+			Status:     user.Status,
 			Balances:   make(map[string]float64),
 		}
 		for _, acc := range user.Accounts {
-			ub.Balances[acc.Currency.Code] = acc.Amount
+			ub.Balances[acc.Currency.Code] += acc.Amount
 		}
 		result = append(result, ub)
 	}
 	return result, nil
 }
 
-func (s *coreService) RemoveUser(ctx context.Context, username string) error {
-	result := s.db.Conn.WithContext(ctx).
-		Where("username = ?", username).
-		Delete(&database.User{})
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return errors.New("user not found")
-	}
-	return nil
-}
-
 func (s *coreService) AddUser(ctx context.Context, telegramID int64, username string) error {
-	user := &database.User{
-		TelegramID: telegramID,
-		Username:   username,
-	}
-	return s.userService.CreateUser(ctx, user)
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing database.User
+		if err := tx.Unscoped().Where("telegram_id = ?", telegramID).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		if existing.ID != 0 {
+			if existing.DeletedAt.Valid || existing.Status == database.UserStatusFrozen {
+				return fmt.Errorf("user with Telegram ID %d was removed, use /restoreuser @%s", telegramID, existing.Username)
+			}
+			return fmt.Errorf("user with Telegram ID %d already exists", telegramID)
+		}
+
+		user := &database.User{
+			TelegramID: telegramID,
+			Username:   username,
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if err := s.publish(ctx, tx, UserCreated{TelegramID: telegramID, Username: username, Source: "admin"}); err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, tx, AuditAddUser, "@"+user.Username, nil, userSnapshot(user))
+	})
 }
 
 func (s *coreService) AddCurrency(ctx context.Context, code, name, sign string) error {
-	currency := &database.Currency{
-		Code: code,
-		Name: name,
-		Sign: sign,
-	}
-	return s.db.Conn.WithContext(ctx).Create(currency).Error
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		currency := &database.Currency{
+			Code: code,
+			Name: name,
+			Sign: sign,
+		}
+		if err := tx.Create(currency).Error; err != nil {
+			return err
+		}
+		if err := s.publish(ctx, tx, CurrencyAdded{Code: code, Name: name, Sign: sign}); err != nil {
+			return err
+		}
+		after := map[string]string{"code": code, "name": name, "sign": sign}
+		return s.recordAudit(ctx, tx, AuditAddCurrency, "currency:"+code, nil, after)
+	})
 }
 
 func (s *coreService) SetDefaultCurrency(ctx context.Context, code string) error {
 	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var previous database.Currency
+		if err := tx.Where("is_default = ?", true).Limit(1).Find(&previous).Error; err != nil {
+			return err
+		}
+
 		// Unset previous default
 		if err := tx.Model(&database.Currency{}).
 			Where("is_default = ?", true).
@@ -301,11 +526,32 @@ func (s *coreService) SetDefaultCurrency(ctx context.Context, code string) error
 			return err
 		}
 		// Set new default
-		if err := tx.Model(&database.Currency{}).
+		result := tx.Model(&database.Currency{}).
 			Where("code = ?", code).
-			Update("is_default", true).Error; err != nil {
-			return err
+			Update("is_default", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("currency not found")
 		}
-		return nil
+		return s.recordAudit(ctx, tx, AuditSetDefaultCurrency, "currency:"+code, previous.Code, code)
 	})
 }
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// userSnapshot returns the audited view of a user
+func userSnapshot(user *database.User) map[string]any {
+	return map[string]any{
+		"telegram_id": user.TelegramID,
+		"username":    user.Username,
+		"is_admin":    user.IsAdmin,
+		"status":      user.Status,
+	}
+}
@@ -0,0 +1,64 @@
+package services
+
+import "errors"
+
+var (
+	ErrUserNotFound         = errors.New("user not found")
+	ErrAccountFrozen        = errors.New("your account is frozen, please contact an admin")
+	ErrRecipientNotActive   = errors.New("recipient account is not active")
+	ErrLimitExceeded        = errors.New("transfer limit exceeded")
+	ErrInsufficientBalance  = errors.New("insufficient balance")
+	ErrCurrencyNotSupported = errors.New("currency not supported")
+	ErrTransferToSelf       = errors.New("cannot transfer to self")
+	ErrInvalidAmount        = errors.New("transfer amount must be positive")
+	ErrInvalidTimezone      = errors.New("unknown time zone")
+	ErrPotNotFound          = errors.New("pot not found")
+	ErrPotExists            = errors.New("a pot with this name already exists")
+	ErrInvalidPotName       = errors.New("pot names are 1-32 letters, digits, - or _")
+	ErrLoanNotFound         = errors.New("no outstanding loan from this user")
+	ErrRepaymentTooLarge    = errors.New("repayment exceeds the outstanding debt")
+	ErrInvalidDueDate       = errors.New("due date must be in the future")
+	ErrEscrowNotFound       = errors.New("escrow not found")
+	ErrEscrowClosed         = errors.New("escrow is already settled")
+	ErrVoucherNotFound      = errors.New("voucher not found")
+	ErrVoucherRedeemed      = errors.New("voucher has already been redeemed")
+	ErrVoucherExpired       = errors.New("voucher has expired")
+	ErrVoucherBatchUsed     = errors.New("you have already redeemed a voucher of this batch")
+	ErrTransactionNotFound  = errors.New("transaction not found")
+	ErrNotReversible        = errors.New("only transfers can be refunded")
+	ErrAlreadyReversed      = errors.New("transaction has already been refunded")
+	ErrReversalTooLarge     = errors.New("refund is larger than what is left of the transfer")
+	ErrAmbiguousRecipient   = errors.New("several users have this username, use their Telegram ID instead")
+	ErrRegistrationClosed   = errors.New("registration is closed, ask an admin for an invite")
+	ErrInviteInvalid        = errors.New("invite is invalid, used up or expired")
+	ErrAccountRemoved       = errors.New("your account was removed, ask an admin to restore it")
+	ErrNotWalletMember      = errors.New("you are not a member of this wallet")
+	ErrWebhookNotFound      = errors.New("webhook not found")
+	ErrWebhookAddress       = errors.New("webhooks cannot be sent to local or private addresses")
+)
+
+// transferFailureReason classifies a transfer error for metrics
+func transferFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		return "user_not_found"
+	case errors.Is(err, ErrAmbiguousRecipient):
+		return "ambiguous_recipient"
+	case errors.Is(err, ErrAccountFrozen):
+		return "account_frozen"
+	case errors.Is(err, ErrRecipientNotActive):
+		return "recipient_not_active"
+	case errors.Is(err, ErrLimitExceeded):
+		return "limit_exceeded"
+	case errors.Is(err, ErrInsufficientBalance):
+		return "insufficient_balance"
+	case errors.Is(err, ErrCurrencyNotSupported):
+		return "currency_not_supported"
+	case errors.Is(err, ErrTransferToSelf):
+		return "transfer_to_self"
+	case errors.Is(err, ErrInvalidAmount):
+		return "invalid_amount"
+	default:
+		return "other"
+	}
+}
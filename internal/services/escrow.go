@@ -0,0 +1,336 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"github.com/fitz123/mcduck-wallet/internal/metrics"
+	"gorm.io/gorm"
+)
+
+const defaultEscrowTTL = 7 * 24 * time.Hour
+
+// CreateEscrow holds money of the sender for a recipient. The recipient gets
+// it once the sender releases it, the sender gets it back on cancellation or
+// when the escrow times out. Escrows go through the same checks, fees and
+// approvals as transfers.
+func (s *coreService) CreateEscrow(ctx context.Context, senderTelegramID int64, recipientUsername string, amount float64, currencyCode string) (*database.Escrow, error) {
+	var escrow *database.Escrow
+	var pending *database.PendingOperation
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sender, err := findUserByTelegramID(tx, senderTelegramID)
+		if err != nil {
+			return err
+		}
+		recipient, err := findUserForUpdate(tx, recipientUsername)
+		if err != nil {
+			return err
+		}
+		now := s.now()
+		if _, _, err := prepareTransfer(tx, sender, recipient, amount, currencyCode, now); err != nil {
+			return err
+		}
+
+		pending, err = s.requestApproval(ctx, tx, database.OperationEscrow, sender, recipient, amount, currencyCode, amount)
+		if err != nil || pending != nil {
+			return err
+		}
+		escrow, err = holdTx(tx, sender, recipient, amount, currencyCode, now)
+		return err
+	})
+	if err != nil {
+		metrics.TransfersFailed.WithLabelValues(transferFailureReason(err)).Inc()
+		return nil, err
+	}
+	if pending != nil {
+		s.notifyApprovers(ctx, pending)
+		return nil, &PendingApprovalError{Operation: pending}
+	}
+	s.notifyEscrow(ctx, escrow)
+	return escrow, nil
+}
+
+// holdTx opens an escrow and moves its amount into the held part of the
+// sender's balance using tx
+func holdTx(tx *gorm.DB, sender, recipient *database.User, amount float64, currencyCode string, now time.Time) (*database.Escrow, error) {
+	balance, _, err := prepareTransfer(tx, sender, recipient, amount, currencyCode, now)
+	if err != nil {
+		return nil, err
+	}
+
+	escrow := &database.Escrow{
+		SenderID:            sender.ID,
+		SenderTelegramID:    sender.TelegramID,
+		SenderUsername:      sender.Username,
+		RecipientID:         recipient.ID,
+		RecipientTelegramID: recipient.TelegramID,
+		RecipientUsername:   recipient.Username,
+		BalanceID:           balance.ID,
+		CurrencyID:          balance.CurrencyID,
+		Currency:            balance.Currency,
+		Amount:              amount,
+		Status:              database.EscrowHeld,
+		ExpiresAt:           now.Add(getDurationSetting(tx, SettingEscrowTTL, defaultEscrowTTL)),
+	}
+	if err := tx.Create(escrow).Error; err != nil {
+		return nil, err
+	}
+
+	balance.Amount -= amount
+	balance.Held += amount
+	if err := tx.Save(balance).Error; err != nil {
+		return nil, err
+	}
+	if err := recordEscrowEntry(tx, escrow, sender.ID, balance, -amount, "escrow_hold", now); err != nil {
+		return nil, err
+	}
+	if err := chargeTransferFee(tx, sender, balance, amount, now); err != nil {
+		return nil, err
+	}
+
+	metrics.Transfers.WithLabelValues(currencyCode).Inc()
+	metrics.TransferVolume.WithLabelValues(currencyCode).Add(amount)
+	return escrow, nil
+}
+
+// ReleaseEscrow pays a held or disputed escrow out to the recipient. Only the
+// sender may release it.
+func (s *coreService) ReleaseEscrow(ctx context.Context, telegramID int64, escrowID uint) (*database.Escrow, error) {
+	return s.updateEscrow(ctx, escrowID, func(tx *gorm.DB, escrow *database.Escrow) error {
+		user, err := findUserByTelegramID(tx, telegramID)
+		if err != nil {
+			return err
+		}
+		if user.ID != escrow.SenderID {
+			return errors.New("only the sender can release an escrow")
+		}
+		return settleEscrowTx(tx, escrow, true, user.Username, s.now())
+	})
+}
+
+// CancelEscrow refunds a held escrow to the sender. Both sides may cancel it
+// as long as it is not disputed.
+func (s *coreService) CancelEscrow(ctx context.Context, telegramID int64, escrowID uint) (*database.Escrow, error) {
+	return s.updateEscrow(ctx, escrowID, func(tx *gorm.DB, escrow *database.Escrow) error {
+		user, err := findUserByTelegramID(tx, telegramID)
+		if err != nil {
+			return err
+		}
+		if user.ID != escrow.SenderID && user.ID != escrow.RecipientID {
+			return ErrEscrowNotFound
+		}
+		if escrow.Status == database.EscrowDisputed {
+			return errors.New("the escrow is disputed, an admin will resolve it")
+		}
+		return settleEscrowTx(tx, escrow, false, user.Username, s.now())
+	})
+}
+
+// DisputeEscrow stops a held escrow from being cancelled or timing out until
+// an admin resolves it. Both sides may dispute it.
+func (s *coreService) DisputeEscrow(ctx context.Context, telegramID int64, escrowID uint) (*database.Escrow, error) {
+	return s.updateEscrow(ctx, escrowID, func(tx *gorm.DB, escrow *database.Escrow) error {
+		user, err := findUserByTelegramID(tx, telegramID)
+		if err != nil {
+			return err
+		}
+		if user.ID != escrow.SenderID && user.ID != escrow.RecipientID {
+			return ErrEscrowNotFound
+		}
+		if escrow.Status == database.EscrowDisputed {
+			return errors.New("the escrow is already disputed")
+		}
+		escrow.Status = database.EscrowDisputed
+		return tx.Model(escrow).Update("status", escrow.Status).Error
+	})
+}
+
+// ResolveEscrow lets an admin release an open escrow to the recipient or
+// refund it to the sender, usually to settle a dispute
+func (s *coreService) ResolveEscrow(ctx context.Context, adminTelegramID int64, escrowID uint, release bool) (*database.Escrow, error) {
+	if !s.userService.IsAdmin(ctx, adminTelegramID) {
+		return nil, errors.New("unauthorized")
+	}
+	return s.updateEscrow(ctx, escrowID, func(tx *gorm.DB, escrow *database.Escrow) error {
+		admin, err := findUserByTelegramID(tx, adminTelegramID)
+		if err != nil {
+			return err
+		}
+		before := escrow.Status
+		if err := settleEscrowTx(tx, escrow, release, admin.Username, s.now()); err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, tx, AuditResolveEscrow, fmt.Sprintf("escrow:%d", escrow.ID), before, escrow.Status)
+	})
+}
+
+// updateEscrow loads an open escrow and applies change to it in one
+// transaction, then tells both sides about the outcome
+func (s *coreService) updateEscrow(ctx context.Context, escrowID uint, change func(tx *gorm.DB, escrow *database.Escrow) error) (*database.Escrow, error) {
+	var escrow database.Escrow
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Currency").First(&escrow, escrowID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrEscrowNotFound
+			}
+			return err
+		}
+		if !escrow.IsOpen() {
+			return ErrEscrowClosed
+		}
+		return change(tx, &escrow)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.notifyEscrow(ctx, &escrow)
+	return &escrow, nil
+}
+
+// settleEscrowTx pays the held money of an escrow to the recipient when
+// release is true, back to the sender otherwise, using tx
+func settleEscrowTx(tx *gorm.DB, escrow *database.Escrow, release bool, by string, now time.Time) error {
+	var held database.Balance
+	if err := tx.Preload("Currency").First(&held, escrow.BalanceID).Error; err != nil {
+		return err
+	}
+	if held.Held < escrow.Amount-0.005 {
+		return fmt.Errorf("escrow #%d: only %.2f of %.2f is on hold", escrow.ID, held.Held, escrow.Amount)
+	}
+
+	target := &held
+	userID, entryType, status := escrow.SenderID, "escrow_refund", database.EscrowRefunded
+	if release {
+		var recipient database.User
+		if err := tx.Preload("Accounts.Currency").First(&recipient, escrow.RecipientID).Error; err != nil {
+			return fmt.Errorf("escrow #%d: recipient: %w", escrow.ID, err)
+		}
+		if !recipient.IsActive() {
+			return ErrRecipientNotActive
+		}
+		if target = findBalance(&recipient, escrow.Currency.Code); target == nil {
+			return ErrCurrencyNotSupported
+		}
+		userID, entryType, status = recipient.ID, "escrow_release", database.EscrowReleased
+	}
+
+	held.Held = roundCents(held.Held - escrow.Amount)
+	target.Amount += escrow.Amount
+	if err := tx.Save(&held).Error; err != nil {
+		return err
+	}
+	if release {
+		if err := tx.Save(target).Error; err != nil {
+			return err
+		}
+	}
+	if err := recordEscrowEntry(tx, escrow, userID, target, escrow.Amount, entryType, now); err != nil {
+		return err
+	}
+
+	escrow.Status = status
+	escrow.ResolvedAt = &now
+	escrow.ResolvedBy = by
+	return tx.Model(escrow).Updates(map[string]any{
+		"status":      escrow.Status,
+		"resolved_at": escrow.ResolvedAt,
+		"resolved_by": escrow.ResolvedBy,
+	}).Error
+}
+
+// recordEscrowEntry records a change of balance caused by an escrow. Escrow
+// entries always name the sender and the recipient of the escrow.
+func recordEscrowEntry(tx *gorm.DB, escrow *database.Escrow, userID uint, balance *database.Balance, amount float64, entryType string, now time.Time) error {
+	entry := database.Transaction{
+		UserID:       userID,
+		BalanceID:    balance.ID,
+		Amount:       amount,
+		Type:         entryType,
+		FromUserID:   escrow.SenderID,
+		FromUsername: escrow.SenderUsername,
+		ToUserID:     escrow.RecipientID,
+		ToUsername:   escrow.RecipientUsername,
+		Timestamp:    now,
+		BalanceAfter: balance.Amount,
+		EscrowID:     escrow.ID,
+	}
+	return tx.Create(&entry).Error
+}
+
+// ListEscrows returns the open escrows of a user, admins also see every
+// disputed escrow
+func (s *coreService) ListEscrows(ctx context.Context, telegramID int64) ([]database.Escrow, error) {
+	user, err := s.userService.GetUser(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.db.Conn.WithContext(ctx).
+		Preload("Currency").
+		Where("status IN ?", []string{database.EscrowHeld, database.EscrowDisputed})
+	if user.IsAdmin {
+		query = query.Where("sender_id = ? OR recipient_id = ? OR status = ?", user.ID, user.ID, database.EscrowDisputed)
+	} else {
+		query = query.Where("sender_id = ? OR recipient_id = ?", user.ID, user.ID)
+	}
+
+	var escrows []database.Escrow
+	if err := query.Order("id").Find(&escrows).Error; err != nil {
+		return nil, err
+	}
+	return escrows, nil
+}
+
+// RunEscrowTimeouts refunds held escrows of all wallets past their expiry
+// time. Disputed escrows wait for an admin. Every escrow is refunded in its
+// own transaction, one that fails is logged and retried on the next run. It
+// returns the number of refunded escrows.
+func (s *coreService) RunEscrowTimeouts(ctx context.Context) (int, error) {
+	now := s.now()
+	db := s.db.Conn.WithContext(database.AnyWallet(ctx))
+	var expired []database.Escrow
+	if err := db.Select("id").
+		Where("status = ? AND expires_at < ?", database.EscrowHeld, now).
+		Order("id").
+		Find(&expired).Error; err != nil {
+		return 0, err
+	}
+
+	refunded := 0
+	for _, candidate := range expired {
+		var escrow database.Escrow
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// The escrow may have been settled since it was listed
+			if err := tx.Preload("Currency").
+				Where("status = ? AND expires_at < ?", database.EscrowHeld, now).
+				Limit(1).
+				Find(&escrow, candidate.ID).Error; err != nil {
+				return err
+			}
+			if escrow.ID == 0 {
+				return nil
+			}
+			return settleEscrowTx(tx, &escrow, false, "", now)
+		})
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to refund expired escrow", "escrow", candidate.ID, "error", err)
+			continue
+		}
+		if escrow.ID != 0 {
+			refunded++
+			s.notifyEscrow(ctx, &escrow)
+		}
+	}
+	return refunded, nil
+}
+
+func (s *coreService) notifyEscrow(ctx context.Context, escrow *database.Escrow) {
+	if s.notifier == nil {
+		return
+	}
+	s.notifier.NotifyEscrow(ctx, escrow)
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var escrowTestStart = time.Date(2025, 9, 1, 15, 0, 0, 0, time.UTC)
+
+// newTestEscrows returns a service where Alice (1) and Carol (3) hold 100 USD
+// each, Bob (2) nothing and Dave (4) is an admin
+func newTestEscrows(t *testing.T) (*coreService, *testClock, database.Currency) {
+	t.Helper()
+	s, clock := newTestService(t, escrowTestStart)
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 100, escrowTestStart)
+	createUser(t, s, 2, "bob", usd, 0, escrowTestStart)
+	createUser(t, s, 3, "carol", usd, 100, escrowTestStart)
+	admin := createUser(t, s, 4, "dave", usd, 0, escrowTestStart)
+	s.db.Conn.Model(admin).Update("is_admin", true)
+	return s, clock, usd
+}
+
+// heldOf returns the money on hold in the main balance of a user
+func heldOf(t *testing.T, s *coreService, telegramID int64, currencyCode string) float64 {
+	t.Helper()
+	user, err := findUserByTelegramID(s.db.Conn, telegramID)
+	if err != nil {
+		t.Fatalf("find user %d: %v", telegramID, err)
+	}
+	balance := findBalance(user, currencyCode)
+	if balance == nil {
+		return 0
+	}
+	return balance.Held
+}
+
+func TestEscrowReleasePaysTheRecipient(t *testing.T) {
+	s, _, usd := newTestEscrows(t)
+	ctx := context.Background()
+
+	escrow, err := s.CreateEscrow(ctx, 1, "bob", 40, "USD")
+	if err != nil {
+		t.Fatalf("CreateEscrow: %v", err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 60)
+	assertAmount(t, "alice held", heldOf(t, s, 1, "USD"), 40)
+	if _, err := s.CreateEscrow(ctx, 1, "bob", 61, "USD"); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("escrow above the free balance: err = %v, want ErrInsufficientBalance", err)
+	}
+
+	if _, err := s.ReleaseEscrow(ctx, 2, escrow.ID); err == nil {
+		t.Error("the recipient released an escrow")
+	}
+	if _, err := s.CancelEscrow(ctx, 3, escrow.ID); !errors.Is(err, ErrEscrowNotFound) {
+		t.Errorf("a third user cancelled an escrow: err = %v, want ErrEscrowNotFound", err)
+	}
+
+	released, err := s.ReleaseEscrow(ctx, 1, escrow.ID)
+	if err != nil {
+		t.Fatalf("ReleaseEscrow: %v", err)
+	}
+	if released.Status != database.EscrowReleased {
+		t.Errorf("status = %q, want released", released.Status)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 60)
+	assertAmount(t, "alice held", heldOf(t, s, 1, "USD"), 0)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 40)
+	assertAmount(t, "total", totalMoney(t, s, usd), 200)
+
+	if _, err := s.CancelEscrow(ctx, 1, escrow.ID); !errors.Is(err, ErrEscrowClosed) {
+		t.Errorf("cancel after release: err = %v, want ErrEscrowClosed", err)
+	}
+}
+
+func TestDisputedEscrowWaitsForAnAdmin(t *testing.T) {
+	s, clock, _ := newTestEscrows(t)
+	ctx := context.Background()
+
+	escrow, err := s.CreateEscrow(ctx, 1, "bob", 25, "USD")
+	if err != nil {
+		t.Fatalf("CreateEscrow: %v", err)
+	}
+	if _, err := s.DisputeEscrow(ctx, 2, escrow.ID); err != nil {
+		t.Fatalf("DisputeEscrow: %v", err)
+	}
+	if _, err := s.CancelEscrow(ctx, 1, escrow.ID); err == nil {
+		t.Error("a disputed escrow was cancelled")
+	}
+
+	clock.Set(escrowTestStart.Add(defaultEscrowTTL + time.Hour))
+	refunded, err := s.RunEscrowTimeouts(ctx)
+	if err != nil {
+		t.Fatalf("RunEscrowTimeouts: %v", err)
+	}
+	if refunded != 0 {
+		t.Errorf("refunded %d disputed escrows", refunded)
+	}
+
+	if _, err := s.ResolveEscrow(ctx, 1, escrow.ID, false); err == nil {
+		t.Error("a non-admin resolved an escrow")
+	}
+	resolved, err := s.ResolveEscrow(ctx, 4, escrow.ID, false)
+	if err != nil {
+		t.Fatalf("ResolveEscrow: %v", err)
+	}
+	if resolved.Status != database.EscrowRefunded || resolved.ResolvedBy != "dave" {
+		t.Errorf("escrow = %q by %q, want refunded by dave", resolved.Status, resolved.ResolvedBy)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 100)
+	assertAmount(t, "alice held", heldOf(t, s, 1, "USD"), 0)
+}
+
+func TestEscrowTimeoutsRefundEachEscrowOnItsOwn(t *testing.T) {
+	s, clock, usd := newTestEscrows(t)
+	ctx := context.Background()
+
+	broken, err := s.CreateEscrow(ctx, 1, "bob", 30, "USD")
+	if err != nil {
+		t.Fatalf("CreateEscrow: %v", err)
+	}
+	if _, err := s.CreateEscrow(ctx, 3, "bob", 20, "USD"); err != nil {
+		t.Fatalf("CreateEscrow: %v", err)
+	}
+	clock.Set(escrowTestStart.Add(time.Hour))
+	if _, err := s.CreateEscrow(ctx, 3, "bob", 10, "USD"); err != nil {
+		t.Fatalf("CreateEscrow: %v", err)
+	}
+
+	// Alice's hold went missing, refunding her escrow fails
+	if err := s.db.Conn.Model(&database.Balance{}).Where("id = ?", broken.BalanceID).Update("held", 0).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Set(escrowTestStart.Add(defaultEscrowTTL + time.Minute))
+	refunded, err := s.RunEscrowTimeouts(ctx)
+	if err != nil {
+		t.Fatalf("RunEscrowTimeouts: %v", err)
+	}
+	if refunded != 1 {
+		t.Fatalf("refunded %d escrows, want Carol's expired one", refunded)
+	}
+	assertAmount(t, "carol", balanceOf(t, s, 3, "USD"), 90)
+	assertAmount(t, "carol held", heldOf(t, s, 3, "USD"), 10)
+
+	// The failed escrow is retried once its hold is back
+	if err := s.db.Conn.Model(&database.Balance{}).Where("id = ?", broken.BalanceID).Update("held", 30).Error; err != nil {
+		t.Fatal(err)
+	}
+	if refunded, err = s.RunEscrowTimeouts(ctx); err != nil || refunded != 1 {
+		t.Fatalf("RunEscrowTimeouts = %d, %v, want Alice's escrow refunded", refunded, err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 100)
+	assertAmount(t, "total with held money", totalMoney(t, s, usd)+heldOf(t, s, 3, "USD"), 200)
+}
+
+func TestBalancesOfOlderVersionsHoldNothing(t *testing.T) {
+	statements := append(baselineSchema,
+		`INSERT INTO currencies (id, code, name, sign, is_default) VALUES (1, 'USD', 'USD', '$', true)`,
+		`INSERT INTO users (id, telegram_id, username) VALUES (1, 1, 'alice'), (2, 2, 'bob')`,
+		`INSERT INTO balances (id, user_id, amount, currency_id) VALUES (1, 1, 70, 1), (2, 2, 30, 1)`,
+		// Column added by a release that left it NULL on existing rows
+		`ALTER TABLE balances ADD COLUMN held real`,
+	)
+	s, _ := newLegacyTestService(t, escrowTestStart, statements...)
+
+	var supply float64
+	if err := s.db.Conn.Raw("SELECT SUM(amount + held) FROM balances").Scan(&supply).Error; err != nil {
+		t.Fatal(err)
+	}
+	assertAmount(t, "supply", supply, 100)
+
+	if _, err := s.CreateEscrow(context.Background(), 1, "bob", 20, "USD"); err != nil {
+		t.Fatalf("CreateEscrow: %v", err)
+	}
+	assertAmount(t, "alice held", heldOf(t, s, 1, "USD"), 20)
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// Event types
+const (
+	EventTransferCompleted = "transfer.completed"
+	EventBalanceAdjusted   = "balance.adjusted"
+	EventUserCreated       = "user.created"
+	EventCurrencyAdded     = "currency.added"
+)
+
+// EventTypes lists every event type in the order they are documented
+var EventTypes = []string{EventTransferCompleted, EventBalanceAdjusted, EventUserCreated, EventCurrencyAdded}
+
+// EventData is the payload of an event
+type EventData interface {
+	EventType() string
+}
+
+// TransferCompleted is published when money moved from one user to another
+type TransferCompleted struct {
+	FromTelegramID int64   `json:"from_telegram_id"`
+	FromUsername   string  `json:"from_username"`
+	ToTelegramID   int64   `json:"to_telegram_id"`
+	ToUsername     string  `json:"to_username"`
+	Amount         float64 `json:"amount"`
+	Fee            float64 `json:"fee"`
+	Currency       string  `json:"currency"`
+}
+
+func (TransferCompleted) EventType() string { return EventTransferCompleted }
+
+// BalanceAdjusted is published when an admin set the balance of a user
+type BalanceAdjusted struct {
+	TelegramID    int64   `json:"telegram_id"`
+	Username      string  `json:"username"`
+	Currency      string  `json:"currency"`
+	Before        float64 `json:"before"`
+	After         float64 `json:"after"`
+	AdminUsername string  `json:"admin_username,omitempty"`
+}
+
+func (BalanceAdjusted) EventType() string { return EventBalanceAdjusted }
+
+// UserCreated is published when a user joined the wallet
+type UserCreated struct {
+	TelegramID int64  `json:"telegram_id"`
+	Username   string `json:"username"`
+	Source     string `json:"source"` // registration, admin or import
+}
+
+func (UserCreated) EventType() string { return EventUserCreated }
+
+// CurrencyAdded is published when a currency was added to the wallet
+type CurrencyAdded struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	Sign string `json:"sign"`
+}
+
+func (CurrencyAdded) EventType() string { return EventCurrencyAdded }
+
+// Event is something that happened in a wallet
+type Event struct {
+	Type     string    `json:"type"`
+	WalletID uint      `json:"wallet_id"`
+	Time     time.Time `json:"time"`
+	Data     EventData `json:"data"`
+}
+
+// EventHandler handles an event inside the transaction of the change that
+// caused it, so that it only takes effect when the change is committed. An
+// error rolls the change back.
+type EventHandler func(ctx context.Context, tx *gorm.DB, event *Event) error
+
+// EventBus passes the events of the core service to its subscribers
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers []EventHandler
+}
+
+// Subscribe adds a handler that receives every event published from now on
+func (b *EventBus) Subscribe(handler EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// Publish passes event to all subscribers using tx
+func (b *EventBus) Publish(ctx context.Context, tx *gorm.DB, event *Event) error {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+	for _, handler := range handlers {
+		if err := handler(ctx, tx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publish publishes data as an event of the wallet tx is limited to
+func (s *coreService) publish(ctx context.Context, tx *gorm.DB, data EventData) error {
+	return s.events.Publish(ctx, tx, &Event{
+		Type:     data.EventType(),
+		WalletID: database.WalletFromContext(tx.Statement.Context),
+		Time:     s.now(),
+		Data:     data,
+	})
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// Roles fees can be waived for with the fee.waive setting
+const (
+	RoleAdmin    = "admin"
+	RoleApprover = "approver"
+	RoleUser     = "user"
+)
+
+// userRoles returns the roles of a user, every user has RoleUser
+func userRoles(user *database.User) []string {
+	roles := []string{RoleUser}
+	if user.IsAdmin {
+		roles = append(roles, RoleAdmin)
+	}
+	if user.IsApprover {
+		roles = append(roles, RoleApprover)
+	}
+	return roles
+}
+
+// feeWaived reports whether one of the user's roles pays no fees
+func feeWaived(tx *gorm.DB, user *database.User) bool {
+	waived := strings.Split(getSetting(tx, SettingFeeWaive), ",")
+	for _, role := range userRoles(user) {
+		if slices.ContainsFunc(waived, func(w string) bool { return strings.TrimSpace(w) == role }) {
+			return true
+		}
+	}
+	return false
+}
+
+// transferFee returns the fee user pays for sending amount, a flat part plus
+// a percentage of the amount, rounded to cents
+func transferFee(tx *gorm.DB, user *database.User, currencyCode string, amount float64) float64 {
+	if user.TelegramID == SystemTelegramID || feeWaived(tx, user) {
+		return 0
+	}
+	flat := getCurrencyFloatSetting(tx, SettingFeeFlat, currencyCode, 0)
+	percent := getCurrencyFloatSetting(tx, SettingFeePercent, currencyCode, 0)
+	return roundCents(flat + amount*percent/100)
+}
+
+// chargeTransferFee moves the fee for a transfer of amount from balance to
+// the system account
+func chargeTransferFee(tx *gorm.DB, user *database.User, balance *database.Balance, amount float64, now time.Time) error {
+	fee := transferFee(tx, user, balance.Currency.Code, amount)
+	if fee <= 0 {
+		return nil
+	}
+	system, err := systemAccount(tx)
+	if err != nil {
+		return err
+	}
+	systemBalance, err := systemBalance(tx, system, balance.Currency)
+	if err != nil {
+		return err
+	}
+	return moveMoney(tx, user, balance, system, systemBalance, fee, "fee_out", "fee_in", now)
+}
+
+// QuoteTransferFee returns the fee the user would pay for a transfer
+func (s *coreService) QuoteTransferFee(ctx context.Context, telegramID int64, amount float64, currencyCode string) (float64, error) {
+	tx := s.db.Conn.WithContext(ctx)
+	user, err := findUserByTelegramID(tx, telegramID)
+	if err != nil {
+		return 0, err
+	}
+	return transferFee(tx, user, currencyCode, amount), nil
+}
+
+// roundCents rounds an amount to two decimals
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var feeTestStart = time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func TestTransferFeeFlatAndPercent(t *testing.T) {
+	s, _ := newTestService(t, feeTestStart)
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 200, feeTestStart)
+	createUser(t, s, 2, "bob", usd, 0, feeTestStart)
+	setSetting(t, s, SettingFeeFlat, "0.5")
+	setSetting(t, s, SettingFeePercent+".USD", "1")
+
+	ctx := context.Background()
+	fee, err := s.QuoteTransferFee(ctx, 1, 100, "USD")
+	if err != nil {
+		t.Fatalf("QuoteTransferFee: %v", err)
+	}
+	assertAmount(t, "quoted fee", fee, 1.5)
+
+	if err := s.TransferMoney(ctx, 1, "bob", 100, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 98.5)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 100)
+	assertAmount(t, "system", balanceOf(t, s, SystemTelegramID, "USD"), 1.5)
+	assertAmount(t, "total", totalMoney(t, s, usd), 200)
+
+	var fees []database.Transaction
+	if err := s.db.Conn.Where("type IN ?", []string{"fee_out", "fee_in"}).Order("id").Find(&fees).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(fees) != 2 || fees[0].Amount != -1.5 || fees[1].Amount != 1.5 || !fees[0].Timestamp.Equal(feeTestStart) {
+		t.Errorf("fee transactions = %+v, want -1.5 and +1.5 at %v", fees, feeTestStart)
+	}
+}
+
+func TestTransferFeeOtherCurrencyUsesDefaults(t *testing.T) {
+	s, _ := newTestService(t, feeTestStart)
+	eur := createCurrency(t, s, "EUR")
+	createUser(t, s, 1, "alice", eur, 100, feeTestStart)
+	setSetting(t, s, SettingFeeFlat, "0.5")
+	setSetting(t, s, SettingFeePercent+".USD", "1")
+
+	fee, err := s.QuoteTransferFee(context.Background(), 1, 100, "EUR")
+	if err != nil {
+		t.Fatalf("QuoteTransferFee: %v", err)
+	}
+	assertAmount(t, "fee", fee, 0.5)
+}
+
+func TestTransferFeeWaivedPerRole(t *testing.T) {
+	s, _ := newTestService(t, feeTestStart)
+	usd := createCurrency(t, s, "USD")
+	admin := createUser(t, s, 1, "alice", usd, 100, feeTestStart)
+	createUser(t, s, 2, "bob", usd, 100, feeTestStart)
+	s.db.Conn.Model(admin).Update("is_admin", true)
+	setSetting(t, s, SettingFeeFlat, "1")
+	setSetting(t, s, SettingFeeWaive, "admin, approver")
+
+	ctx := context.Background()
+	if err := s.TransferMoney(ctx, 1, "bob", 10, "USD"); err != nil {
+		t.Fatalf("admin transfer: %v", err)
+	}
+	if err := s.TransferMoney(ctx, 2, "alice", 10, "USD"); err != nil {
+		t.Fatalf("user transfer: %v", err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 100)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 99)
+	assertAmount(t, "system", balanceOf(t, s, SystemTelegramID, "USD"), 1)
+}
+
+func TestTransferFeeNeedsCoverage(t *testing.T) {
+	s, _ := newTestService(t, feeTestStart)
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 100, feeTestStart)
+	createUser(t, s, 2, "bob", usd, 0, feeTestStart)
+	setSetting(t, s, SettingFeeFlat, "1")
+
+	err := s.TransferMoney(context.Background(), 1, "bob", 100, "USD")
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("TransferMoney = %v, want ErrInsufficientBalance", err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 100)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 0)
+}
+
+func TestFeeSettingsValidation(t *testing.T) {
+	for key, value := range map[string]string{
+		SettingFeePercent:          "101",
+		SettingFeeFlat + ".USD":    "-1",
+		SettingInterestRate:        "abc",
+		SettingFeeWaive:            "admin,owner",
+		SettingInterestRate + ".X": "-5",
+		SettingFeePercent + ".USD": "NaN",
+		SettingFeeFlat:             "Inf",
+		SettingApprovalThreshold:   "NaN",
+		SettingWelcomeBonus:        "+Inf",
+		SettingInterestRate + ".Y": "-inf",
+	} {
+		validate, err := settingValidator(key)
+		if err != nil {
+			t.Fatalf("settingValidator(%s): %v", key, err)
+		}
+		if validate(value) == nil {
+			t.Errorf("%s=%s was accepted", key, value)
+		}
+	}
+}
+
+func TestNonFiniteFeeSettingsCannotCreateMoney(t *testing.T) {
+	s, _ := newTestService(t, feeTestStart)
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 10, feeTestStart)
+	createUser(t, s, 2, "bob", usd, 0, feeTestStart)
+
+	ctx := context.Background()
+	if err := s.SetSetting(ctx, SettingFeePercent, "NaN"); err == nil {
+		t.Error("SetSetting accepted NaN")
+	}
+
+	// Values stored before validation existed are ignored as well
+	setSetting(t, s, SettingFeePercent, "NaN")
+	setSetting(t, s, SettingFeeFlat+".USD", "Inf")
+	fee, err := s.QuoteTransferFee(ctx, 1, 5, "USD")
+	if err != nil {
+		t.Fatalf("QuoteTransferFee: %v", err)
+	}
+	assertAmount(t, "fee", fee, 0)
+
+	if err := s.TransferMoney(ctx, 1, "bob", 1000, "USD"); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("TransferMoney = %v, want ErrInsufficientBalance", err)
+	}
+	if err := s.TransferMoney(ctx, 1, "bob", 5, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 5)
+	assertAmount(t, "total", totalMoney(t, s, usd), 10)
+}
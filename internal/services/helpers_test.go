@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// testClock is a clock tests move forward by hand
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Set(t time.Time) {
+	c.now = t
+}
+
+// newTestService returns a core service on a fresh in-memory database whose
+// clock starts at start
+func newTestService(t *testing.T, start time.Time) (*coreService, *testClock) {
+	t.Helper()
+	db, err := database.New("file:" + t.Name() + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	clock := &testClock{now: start}
+	s := NewCoreService(db, NewUserService(db)).(*coreService)
+	s.clock = clock.Now
+	return s, clock
+}
+
+// baselineSchema creates the tables of the first release, before wallets,
+// pots, refunds and holds existed
+var baselineSchema = []string{
+	`CREATE TABLE users (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime, deleted_at datetime,
+		telegram_id integer UNIQUE, username text, is_admin numeric DEFAULT false)`,
+	`CREATE TABLE currencies (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime, deleted_at datetime,
+		code text UNIQUE, name text, sign text, is_default numeric DEFAULT false)`,
+	`CREATE TABLE balances (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime, deleted_at datetime,
+		user_id integer, amount real, currency_id integer)`,
+	`CREATE TABLE transactions (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime, deleted_at datetime,
+		user_id integer, balance_id integer, amount real, type text, from_user_id integer, from_username text,
+		to_user_id integer, to_username text, timestamp datetime, balance_after real)`,
+}
+
+// newLegacyTestService writes statements to a fresh database file, then
+// opens it with the current migrations like an upgraded deployment
+func newLegacyTestService(t *testing.T, start time.Time, statements ...string) (*coreService, *testClock) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open legacy database: %v", err)
+	}
+	for _, stmt := range statements {
+		if err := legacy.Exec(stmt).Error; err != nil {
+			t.Fatalf("legacy database: %v", err)
+		}
+	}
+	if sqlDB, err := legacy.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	db, err := database.New(path)
+	if err != nil {
+		t.Fatalf("migrate legacy database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	clock := &testClock{now: start}
+	s := NewCoreService(db, NewUserService(db)).(*coreService)
+	s.clock = clock.Now
+	return s, clock
+}
+
+// createCurrency adds a currency to the test database
+func createCurrency(t *testing.T, s *coreService, code string) database.Currency {
+	t.Helper()
+	currency := database.Currency{Code: code, Name: code, Sign: code + " "}
+	if err := s.db.Conn.Create(&currency).Error; err != nil {
+		t.Fatalf("create currency: %v", err)
+	}
+	return currency
+}
+
+// createUser adds a user with a main balance of amount in currency, opened at createdAt
+func createUser(t *testing.T, s *coreService, telegramID int64, username string, currency database.Currency, amount float64, createdAt time.Time) *database.User {
+	t.Helper()
+	user := database.User{TelegramID: telegramID, Username: username}
+	if err := s.db.Conn.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	balance := database.Balance{UserID: user.ID, CurrencyID: currency.ID, Amount: amount}
+	balance.CreatedAt = createdAt
+	if err := s.db.Conn.Create(&balance).Error; err != nil {
+		t.Fatalf("create balance: %v", err)
+	}
+	return &user
+}
+
+// setSetting stores a setting of the wallet s works in without going through
+// validation and auditing
+func setSetting(t *testing.T, s *coreService, key, value string) {
+	t.Helper()
+	walletID := database.WalletFromContext(s.db.Conn.Statement.Context)
+	if err := s.db.Conn.Save(&database.Setting{WalletID: walletID, Key: key, Value: value}).Error; err != nil {
+		t.Fatalf("set %s: %v", key, err)
+	}
+}
+
+// balanceOf returns the main balance of a user in a currency
+func balanceOf(t *testing.T, s *coreService, telegramID int64, currencyCode string) float64 {
+	t.Helper()
+	user, err := findUserByTelegramID(s.db.Conn, telegramID)
+	if err != nil {
+		t.Fatalf("find user %d: %v", telegramID, err)
+	}
+	balance := findBalance(user, currencyCode)
+	if balance == nil {
+		return 0
+	}
+	return balance.Amount
+}
+
+// totalMoney sums all balances in a currency, including the system account
+func totalMoney(t *testing.T, s *coreService, currency database.Currency) float64 {
+	t.Helper()
+	var total float64
+	err := s.db.Conn.Model(&database.Balance{}).
+		Select("COALESCE(SUM(amount), 0)").
+		Where("currency_id = ?", currency.ID).
+		Scan(&total).Error
+	if err != nil {
+		t.Fatalf("sum balances: %v", err)
+	}
+	return total
+}
+
+// assertAmount fails when got differs from want by a cent or more
+func assertAmount(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if diff := got - want; diff > 0.005 || diff < -0.005 {
+		t.Errorf("%s = %.4f, want %.4f", name, got, want)
+	}
+}
+
+// inWallet returns a view of s whose helpers above work in walletID, together
+// with a context for calling s in that wallet
+func inWallet(t *testing.T, s *coreService, walletID uint) (*coreService, context.Context) {
+	t.Helper()
+	ctx := database.WithWallet(context.Background(), walletID)
+	view := *s
+	view.db = &database.DB{Conn: s.db.Conn.WithContext(ctx)}
+	return &view, ctx
+}
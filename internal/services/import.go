@@ -0,0 +1,355 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// Import file formats
+const (
+	ImportFormatCSV  = "csv"
+	ImportFormatJSON = "json"
+)
+
+// Import record types
+const (
+	ImportCurrency    = "currency"
+	ImportUser        = "user"
+	ImportBalance     = "balance"
+	ImportTransaction = "transaction"
+)
+
+// importColumns is the CSV header, JSON records use the same keys
+var importColumns = []string{"type", "telegram_id", "username", "currency", "name", "sign", "amount", "counterparty", "timestamp"}
+
+// ImportRecord is one line of an import file. Records are applied in file
+// order, so currencies and users must come before the balances using them,
+// and opening balances must be dated before the historical transactions.
+type ImportRecord struct {
+	Line         int     `json:"-"`
+	Type         string  `json:"type"`
+	TelegramID   int64   `json:"telegram_id"`
+	Username     string  `json:"username"`
+	Currency     string  `json:"currency"`
+	Name         string  `json:"name"`
+	Sign         string  `json:"sign"`
+	Amount       float64 `json:"amount"`
+	Counterparty string  `json:"counterparty"`
+	Timestamp    string  `json:"timestamp"`
+}
+
+// ImportError describes why a line of the import file was rejected
+type ImportError struct {
+	Line    int
+	Message string
+}
+
+// ImportReport summarizes an import or a dry run
+type ImportReport struct {
+	DryRun    bool
+	Committed bool
+	Created   map[string]int
+	Errors    []ImportError
+}
+
+var errImportRollback = errors.New("import rolled back")
+
+// Import parses a CSV or JSON file and applies all of its records in one
+// database transaction. Nothing is committed when any line fails or when
+// dryRun is set; the report lists every failing line either way.
+func (s *coreService) Import(ctx context.Context, format string, r io.Reader, dryRun bool) (*ImportReport, error) {
+	report := &ImportReport{DryRun: dryRun, Created: make(map[string]int)}
+
+	var records []ImportRecord
+	switch format {
+	case ImportFormatCSV:
+		records, report.Errors = parseImportCSV(r)
+	case ImportFormatJSON:
+		records, report.Errors = parseImportJSON(r)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q, use csv or json", format)
+	}
+	if len(records) == 0 && len(report.Errors) == 0 {
+		return nil, errors.New("import file contains no records")
+	}
+
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, record := range records {
+			// Every record runs in a savepoint, so one failing line does not
+			// hide the errors of the following ones
+			err := tx.Transaction(func(tx *gorm.DB) error {
+				return s.applyImportRecord(ctx, tx, &record)
+			})
+			if err != nil {
+				report.Errors = append(report.Errors, ImportError{Line: record.Line, Message: err.Error()})
+				continue
+			}
+			report.Created[record.Type]++
+		}
+
+		if len(report.Errors) > 0 || dryRun {
+			return errImportRollback
+		}
+		return s.recordAudit(ctx, tx, AuditImport, "import", nil, report.Created)
+	})
+	if err != nil && !errors.Is(err, errImportRollback) {
+		return nil, err
+	}
+
+	sort.SliceStable(report.Errors, func(i, j int) bool {
+		return report.Errors[i].Line < report.Errors[j].Line
+	})
+	report.Committed = err == nil
+	return report, nil
+}
+
+func (s *coreService) applyImportRecord(ctx context.Context, tx *gorm.DB, record *ImportRecord) error {
+	switch record.Type {
+	case ImportCurrency:
+		if record.Currency == "" || record.Name == "" {
+			return errors.New("currency and name are required")
+		}
+		currency := database.Currency{
+			Code: strings.ToUpper(record.Currency),
+			Name: record.Name,
+			Sign: record.Sign,
+		}
+		var count int64
+		if err := tx.Model(&database.Currency{}).Where("code = ?", currency.Code).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("currency %s already exists", currency.Code)
+		}
+		if err := tx.Create(&currency).Error; err != nil {
+			return err
+		}
+		return s.publish(ctx, tx, CurrencyAdded{Code: currency.Code, Name: currency.Name, Sign: currency.Sign})
+
+	case ImportUser:
+		if record.TelegramID <= 0 {
+			return errors.New("a positive telegram_id is required")
+		}
+		if record.Username == "" {
+			return errors.New("username is required")
+		}
+		var count int64
+		if err := tx.Unscoped().Model(&database.User{}).
+			Where("telegram_id = ? OR username = ?", record.TelegramID, record.Username).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("user %d or @%s already exists", record.TelegramID, record.Username)
+		}
+		if err := tx.Create(&database.User{TelegramID: record.TelegramID, Username: record.Username}).Error; err != nil {
+			return err
+		}
+		return s.publish(ctx, tx, UserCreated{TelegramID: record.TelegramID, Username: record.Username, Source: "import"})
+
+	case ImportBalance, ImportTransaction:
+		user, err := findUserForUpdate(tx, record.Username)
+		if err != nil {
+			return fmt.Errorf("@%s: %w", record.Username, err)
+		}
+		var currency database.Currency
+		if err := tx.Where("code = ?", strings.ToUpper(record.Currency)).First(&currency).Error; err != nil {
+			return fmt.Errorf("currency %q not found", record.Currency)
+		}
+
+		balance := findBalance(user, currency.Code)
+		if balance == nil {
+			balance = &database.Balance{UserID: user.ID, CurrencyID: currency.ID}
+			if err := tx.Create(balance).Error; err != nil {
+				return err
+			}
+		}
+
+		timestamp := s.now()
+		if record.Timestamp != "" {
+			timestamp, err = parseImportTimestamp(record.Timestamp)
+			if err != nil {
+				return err
+			}
+		}
+
+		if record.Type == ImportBalance {
+			if record.Counterparty != "" {
+				return errors.New("balance records take no counterparty")
+			}
+			var history int64
+			if err := tx.Model(&database.Transaction{}).Where("balance_id = ?", balance.ID).Count(&history).Error; err != nil {
+				return err
+			}
+			if history > 0 || balance.Amount != 0 {
+				return fmt.Errorf("@%s already has a %s balance", user.Username, currency.Code)
+			}
+			return postImportTransaction(tx, user, balance, record.Amount, "opening_balance", "", timestamp)
+		}
+
+		if record.Amount == 0 {
+			return errors.New("amount must not be zero")
+		}
+
+		// Running balances follow the ledger order, so history must be chronological
+		var latest database.Transaction
+		if err := tx.Where("balance_id = ?", balance.ID).Order("timestamp desc").Limit(1).Find(&latest).Error; err != nil {
+			return err
+		}
+		if latest.ID != 0 && timestamp.Before(latest.Timestamp) {
+			return fmt.Errorf("timestamp is before the latest %s transaction of @%s, records must be in chronological order", currency.Code, user.Username)
+		}
+		return postImportTransaction(tx, user, balance, record.Amount, "import", strings.TrimPrefix(record.Counterparty, "@"), timestamp)
+
+	default:
+		return fmt.Errorf("unknown record type %q", record.Type)
+	}
+}
+
+// postImportTransaction adds amount to a balance and records it in the ledger
+func postImportTransaction(tx *gorm.DB, user *database.User, balance *database.Balance, amount float64, txType, counterparty string, timestamp time.Time) error {
+	balance.Amount += amount
+	if err := tx.Save(balance).Error; err != nil {
+		return err
+	}
+
+	t := database.Transaction{
+		UserID:       user.ID,
+		BalanceID:    balance.ID,
+		Amount:       amount,
+		Type:         txType,
+		Timestamp:    timestamp,
+		BalanceAfter: balance.Amount,
+	}
+	if amount < 0 {
+		t.FromUserID, t.FromUsername, t.ToUsername = user.ID, user.Username, counterparty
+	} else {
+		t.ToUserID, t.ToUsername, t.FromUsername = user.ID, user.Username, counterparty
+	}
+	return tx.Create(&t).Error
+}
+
+// parseImportTimestamp parses a timestamp as UTC unless it carries an offset
+func parseImportTimestamp(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q, expected YYYY-MM-DD, YYYY-MM-DD HH:MM or RFC 3339", value)
+}
+
+// parseImportCSV reads records from a CSV file with a header row. Columns
+// may come in any order, unknown columns are rejected.
+func parseImportCSV(r io.Reader) ([]ImportRecord, []ImportError) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, []ImportError{{Line: 1, Message: "cannot read header: " + err.Error()}}
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !containsString(importColumns, name) {
+			return nil, []ImportError{{Line: 1, Message: fmt.Sprintf("unknown column %q, expected %s", name, strings.Join(importColumns, ","))}}
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["type"]; !ok {
+		return nil, []ImportError{{Line: 1, Message: "missing type column"}}
+	}
+
+	var records []ImportRecord
+	var errs []ImportError
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := cr.FieldPos(0)
+		if err != nil {
+			errs = append(errs, ImportError{Line: line, Message: err.Error()})
+			continue
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		record := ImportRecord{
+			Line:         line,
+			Type:         strings.ToLower(field("type")),
+			Username:     strings.TrimPrefix(field("username"), "@"),
+			Currency:     field("currency"),
+			Name:         field("name"),
+			Sign:         field("sign"),
+			Counterparty: field("counterparty"),
+			Timestamp:    field("timestamp"),
+		}
+		if v := field("telegram_id"); v != "" {
+			if record.TelegramID, err = strconv.ParseInt(v, 10, 64); err != nil {
+				errs = append(errs, ImportError{Line: line, Message: fmt.Sprintf("invalid telegram_id %q", v)})
+				continue
+			}
+		}
+		if v := field("amount"); v != "" {
+			if record.Amount, err = parseFiniteFloat(v); err != nil {
+				errs = append(errs, ImportError{Line: line, Message: fmt.Sprintf("invalid amount %q", v)})
+				continue
+			}
+		}
+		records = append(records, record)
+	}
+	return records, errs
+}
+
+// parseImportJSON reads records from a JSON array; the record index is
+// reported as the line number
+func parseImportJSON(r io.Reader) ([]ImportRecord, []ImportError) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, []ImportError{{Line: 0, Message: "expected a JSON array of records: " + err.Error()}}
+	}
+
+	var records []ImportRecord
+	var errs []ImportError
+	for i, data := range raw {
+		var record ImportRecord
+		dec := json.NewDecoder(strings.NewReader(string(data)))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&record); err != nil {
+			errs = append(errs, ImportError{Line: i + 1, Message: err.Error()})
+			continue
+		}
+		record.Line = i + 1
+		record.Type = strings.ToLower(record.Type)
+		record.Username = strings.TrimPrefix(record.Username, "@")
+		records = append(records, record)
+	}
+	return records, errs
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
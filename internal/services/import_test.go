@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var importTestStart = time.Date(2025, 7, 1, 8, 0, 0, 0, time.UTC)
+
+const importTestCSV = `type,telegram_id,username,currency,name,sign,amount,counterparty,timestamp
+currency,,,eur,Euro,€,,,
+user,11,alice,,,,,,
+user,12,@bob,,,,,,
+balance,,alice,EUR,,,100,,2025-01-01
+balance,,bob,EUR,,,5,,2025-01-01
+transaction,,alice,EUR,,,-30,@bob,2025-02-01 10:00
+transaction,,bob,EUR,,,30,alice,2025-02-01T10:00:00Z
+`
+
+func TestImportCSVCreatesUsersAndHistory(t *testing.T) {
+	s, _ := newTestService(t, importTestStart)
+	ctx := context.Background()
+
+	report, err := s.Import(ctx, ImportFormatCSV, strings.NewReader(importTestCSV), false)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if !report.Committed || len(report.Errors) != 0 {
+		t.Fatalf("report = %+v, want committed without errors", report)
+	}
+	want := map[string]int{ImportCurrency: 1, ImportUser: 2, ImportBalance: 2, ImportTransaction: 2}
+	for recordType, n := range want {
+		if report.Created[recordType] != n {
+			t.Errorf("created %d %s records, want %d", report.Created[recordType], recordType, n)
+		}
+	}
+
+	assertAmount(t, "alice", balanceOf(t, s, 11, "EUR"), 70)
+	assertAmount(t, "bob", balanceOf(t, s, 12, "EUR"), 35)
+
+	var history []database.Transaction
+	if err := s.db.Conn.Where("type = ?", "import").Order("id").Find(&history).Error; err != nil {
+		t.Fatal(err)
+	}
+	wantTime := time.Date(2025, 2, 1, 10, 0, 0, 0, time.UTC)
+	if len(history) != 2 || !history[0].Timestamp.Equal(wantTime) || history[0].ToUsername != "bob" || history[0].BalanceAfter != 70 {
+		t.Errorf("imported history = %+v, want alice's -30 to bob at %v", history, wantTime)
+	}
+}
+
+func TestImportDryRunCommitsNothing(t *testing.T) {
+	s, _ := newTestService(t, importTestStart)
+
+	report, err := s.Import(context.Background(), ImportFormatCSV, strings.NewReader(importTestCSV), true)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if report.Committed || len(report.Errors) != 0 || report.Created[ImportUser] != 2 {
+		t.Fatalf("report = %+v, want an uncommitted run that would create 2 users", report)
+	}
+	var users int64
+	s.db.Conn.Model(&database.User{}).Count(&users)
+	if users != 0 {
+		t.Errorf("dry run created %d users", users)
+	}
+}
+
+func TestImportRollsBackWhenAnyLineFails(t *testing.T) {
+	s, _ := newTestService(t, importTestStart)
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "carol", usd, 0, importTestStart)
+
+	input := `[
+		{"type": "user", "telegram_id": 11, "username": "alice"},
+		{"type": "user", "telegram_id": 1, "username": "someone"},
+		{"type": "balance", "username": "alice", "currency": "USD", "amount": 10, "timestamp": "2025-01-01"},
+		{"type": "transaction", "username": "alice", "currency": "USD", "amount": 5, "timestamp": "2025-03-01"},
+		{"type": "transaction", "username": "alice", "currency": "USD", "amount": 5, "timestamp": "2025-02-01"},
+		{"type": "balance", "username": "nobody", "currency": "USD", "amount": 1},
+		{"type": "refund"}
+	]`
+	report, err := s.Import(context.Background(), ImportFormatJSON, strings.NewReader(input), false)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if report.Committed {
+		t.Fatal("an import with failing lines was committed")
+	}
+	var lines []int
+	for _, e := range report.Errors {
+		lines = append(lines, e.Line)
+	}
+	// The existing Telegram ID, the transaction out of order, the unknown
+	// user and the unknown record type
+	if want := []int{2, 5, 6, 7}; !slices.Equal(lines, want) {
+		t.Errorf("failing lines = %v (%+v), want %v", lines, report.Errors, want)
+	}
+
+	if _, err := findUserByTelegramID(s.db.Conn, 11); err == nil {
+		t.Error("user of a rolled back import exists")
+	}
+	assertAmount(t, "total", totalMoney(t, s, usd), 0)
+}
+
+func TestImportRejectsMalformedCSV(t *testing.T) {
+	s, _ := newTestService(t, importTestStart)
+	ctx := context.Background()
+
+	report, err := s.Import(ctx, ImportFormatCSV, strings.NewReader("type,balance\nuser,1\n"), false)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if report.Committed || len(report.Errors) != 1 || report.Errors[0].Line != 1 {
+		t.Errorf("report = %+v, want the unknown column reported on line 1", report)
+	}
+
+	report, err = s.Import(ctx, ImportFormatCSV, strings.NewReader("type,telegram_id,username\nuser,x,alice\nuser,12,bob\n"), false)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if report.Committed || len(report.Errors) != 1 || report.Errors[0].Line != 2 {
+		t.Errorf("report = %+v, want the invalid telegram_id reported on line 2", report)
+	}
+	if _, err := findUserByTelegramID(s.db.Conn, 12); err == nil {
+		t.Error("a valid line was committed next to an invalid one")
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// dayLayout formats the days of interest accruals
+const dayLayout = "2006-01-02"
+
+// maxAccrualCatchUp bounds how many missed days are accrued after downtime
+const maxAccrualCatchUp = 31
+
+// InterestReport summarizes a run of the interest engine
+type InterestReport struct {
+	Accrued int                // days accrued over all balances
+	Paid    map[string]float64 // interest paid out per currency
+}
+
+// RunInterest accrues interest in all wallets for every complete day (UTC) up
+// to now and pays out the accruals of complete months through the system
+// account of each wallet.
+// Accruals are derived from the ledger, so runs are deterministic and
+// repeating a run changes nothing.
+func (s *coreService) RunInterest(ctx context.Context) (*InterestReport, error) {
+	now := s.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	report := &InterestReport{Paid: make(map[string]float64)}
+
+	// Interest is paid in every wallet, each from its own system account
+	err := s.db.Conn.WithContext(database.AnyWallet(ctx)).Transaction(func(tx *gorm.DB) error {
+		var balances []database.Balance
+		err := tx.Preload("Currency").
+			Joins("JOIN users ON users.id = balances.user_id AND users.deleted_at IS NULL").
+			Where("users.telegram_id <> ? AND users.status <> ?", SystemTelegramID, database.UserStatusClosed).
+			Find(&balances).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range balances {
+			n, err := accrueInterest(tx, &balances[i], today)
+			if err != nil {
+				return err
+			}
+			report.Accrued += n
+		}
+		return payInterest(tx, today, now, report)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// accrueInterest records the interest of balance for every day since its
+// last accrual up to, but not including, today. Every day earns the end of
+// day balance times the yearly rate / 365.
+func accrueInterest(tx *gorm.DB, balance *database.Balance, today time.Time) (int, error) {
+	rate := getCurrencyFloatSetting(walletSettings(tx, balance.Currency.WalletID), SettingInterestRate, balance.Currency.Code, 0)
+	if rate <= 0 {
+		return 0, nil
+	}
+
+	var last database.InterestAccrual
+	if err := tx.Where("balance_id = ?", balance.ID).Order("day desc").Limit(1).Find(&last).Error; err != nil {
+		return 0, err
+	}
+	start := today.AddDate(0, 0, -1)
+	if last.ID != 0 {
+		day, err := time.Parse(dayLayout, last.Day)
+		if err != nil {
+			return 0, err
+		}
+		start = day.AddDate(0, 0, 1)
+	}
+	if earliest := today.AddDate(0, 0, -maxAccrualCatchUp); start.Before(earliest) {
+		start = earliest
+	}
+	if created := balance.CreatedAt.UTC(); start.Before(created) {
+		start = time.Date(created.Year(), created.Month(), created.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	n := 0
+	for day := start; day.Before(today); day = day.AddDate(0, 0, 1) {
+		amount, err := balanceAt(tx, balance, day.AddDate(0, 0, 1))
+		if err != nil {
+			return n, err
+		}
+		accrual := database.InterestAccrual{
+			BalanceID: balance.ID,
+			Day:       day.Format(dayLayout),
+			Balance:   amount,
+			Rate:      rate,
+		}
+		if amount > 0 {
+			accrual.Amount = amount * rate / 100 / 365
+		}
+		if err := tx.Create(&accrual).Error; err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// payInterest posts the unpaid accruals of the months before today. Sums
+// that round to less than a cent are carried over to the next month.
+func payInterest(tx *gorm.DB, today, now time.Time, report *InterestReport) error {
+	monthStart := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC).Format(dayLayout)
+
+	var due []struct {
+		BalanceID uint
+		Total     float64
+	}
+	err := tx.Model(&database.InterestAccrual{}).
+		Select("balance_id, SUM(amount) AS total").
+		Where("paid_at IS NULL AND day < ?", monthStart).
+		Group("balance_id").
+		Order("balance_id").
+		Scan(&due).Error
+	if err != nil {
+		return err
+	}
+
+	systems := make(map[uint]*database.User) // by wallet
+	for _, d := range due {
+		amount := roundCents(d.Total)
+		if amount < 0.01 && d.Total > 0 {
+			continue
+		}
+
+		if amount > 0 {
+			var balance database.Balance
+			if err := tx.Preload("Currency").First(&balance, d.BalanceID).Error; err != nil {
+				return err
+			}
+			var user database.User
+			if err := tx.First(&user, balance.UserID).Error; err != nil {
+				return err
+			}
+			system := systems[user.WalletID]
+			if system == nil {
+				walletTx := tx.WithContext(database.WithWallet(tx.Statement.Context, user.WalletID))
+				if system, err = systemAccount(walletTx); err != nil {
+					return err
+				}
+				systems[user.WalletID] = system
+			}
+			systemBalance, err := systemBalance(tx, system, balance.Currency)
+			if err != nil {
+				return err
+			}
+			if err := moveMoney(tx, system, systemBalance, &user, &balance, amount, "interest_out", "interest_in", now); err != nil {
+				return err
+			}
+			report.Paid[balance.Currency.Code] += amount
+		}
+
+		err := tx.Model(&database.InterestAccrual{}).
+			Where("balance_id = ? AND paid_at IS NULL AND day < ?", d.BalanceID, monthStart).
+			Update("paid_at", now).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// balanceAt derives the amount of a balance at t from the ledger, falling
+// back to the current amount for balances without history
+func balanceAt(tx *gorm.DB, balance *database.Balance, t time.Time) (float64, error) {
+	var previous database.Transaction
+	err := tx.Where("balance_id = ? AND timestamp < ?", balance.ID, t.UTC()).
+		Order("timestamp desc, id desc").
+		Limit(1).
+		Find(&previous).Error
+	if err != nil {
+		return 0, err
+	}
+	if previous.ID != 0 {
+		return previous.BalanceAfter, nil
+	}
+
+	var next database.Transaction
+	err = tx.Where("balance_id = ? AND timestamp >= ?", balance.ID, t.UTC()).
+		Order("timestamp, id").
+		Limit(1).
+		Find(&next).Error
+	if err != nil {
+		return 0, err
+	}
+	if next.ID != 0 {
+		return next.BalanceAfter - next.Amount, nil
+	}
+	return balance.Amount, nil
+}
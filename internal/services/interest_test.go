@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+// A yearly rate of 36.5% earns exactly 0.1% per day
+const testInterestRate = "36.5"
+
+func day(month time.Month, d, hour int) time.Time {
+	return time.Date(2025, month, d, hour, 0, 0, 0, time.UTC)
+}
+
+func runInterest(t *testing.T, s *coreService) *InterestReport {
+	t.Helper()
+	report, err := s.RunInterest(context.Background())
+	if err != nil {
+		t.Fatalf("RunInterest: %v", err)
+	}
+	return report
+}
+
+func TestInterestAccruesDailyAndPaysMonthly(t *testing.T) {
+	s, clock := newTestService(t, day(time.January, 10, 1))
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 1000, day(time.January, 1, 0))
+	setSetting(t, s, SettingInterestRate+".USD", testInterestRate)
+
+	// The first run accrues yesterday only
+	if report := runInterest(t, s); report.Accrued != 1 || len(report.Paid) != 0 {
+		t.Fatalf("first run = %+v, want 1 accrual and no payout", report)
+	}
+
+	// Daily runs through the end of January accrue without paying
+	for d := 11; d <= 31; d++ {
+		clock.Set(day(time.January, d, 1))
+		runInterest(t, s)
+	}
+	assertAmount(t, "balance before payout", balanceOf(t, s, 1, "USD"), 1000)
+
+	// The first run in February pays January: 23 days (9th to 31st) at 1.00
+	clock.Set(day(time.February, 1, 1))
+	report := runInterest(t, s)
+	assertAmount(t, "paid", report.Paid["USD"], 23)
+	assertAmount(t, "balance", balanceOf(t, s, 1, "USD"), 1023)
+	assertAmount(t, "system", balanceOf(t, s, SystemTelegramID, "USD"), -23)
+	assertAmount(t, "total", totalMoney(t, s, usd), 1000)
+
+	// Running again the same day changes nothing
+	if report := runInterest(t, s); report.Accrued != 0 || len(report.Paid) != 0 {
+		t.Errorf("repeated run = %+v, want nothing", report)
+	}
+	assertAmount(t, "balance after repeated run", balanceOf(t, s, 1, "USD"), 1023)
+
+	var interest []database.Transaction
+	s.db.Conn.Where("type = ?", "interest_in").Find(&interest)
+	if len(interest) != 1 || !interest[0].Timestamp.Equal(day(time.February, 1, 1)) {
+		t.Errorf("interest transactions = %+v, want one posted at the payout time", interest)
+	}
+}
+
+func TestInterestUsesEndOfDayBalance(t *testing.T) {
+	s, clock := newTestService(t, day(time.March, 10, 12))
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 1000, day(time.March, 1, 0))
+	createUser(t, s, 2, "bob", usd, 0, day(time.March, 1, 0))
+	setSetting(t, s, SettingInterestRate, testInterestRate)
+
+	// Alice sends half of her money at noon, only the end of day counts
+	if err := s.TransferMoney(context.Background(), 1, "bob", 500, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+
+	clock.Set(day(time.March, 11, 0))
+	runInterest(t, s)
+
+	var accruals []database.InterestAccrual
+	s.db.Conn.Order("balance_id").Find(&accruals)
+	if len(accruals) != 2 {
+		t.Fatalf("accruals = %+v, want one per balance", accruals)
+	}
+	for _, a := range accruals {
+		if a.Day != "2025-03-10" || a.Balance != 500 {
+			t.Errorf("accrual = %+v, want 500 on 2025-03-10", a)
+		}
+		assertAmount(t, "accrued", a.Amount, 0.5)
+	}
+}
+
+func TestInterestCatchesUpAfterDowntime(t *testing.T) {
+	s, clock := newTestService(t, day(time.April, 2, 6))
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 1000, day(time.March, 1, 0))
+	setSetting(t, s, SettingInterestRate, testInterestRate)
+	runInterest(t, s)
+
+	// Five days without runs are accrued by the next one
+	clock.Set(day(time.April, 7, 6))
+	if report := runInterest(t, s); report.Accrued != 5 {
+		t.Errorf("accrued %d days, want 5", report.Accrued)
+	}
+
+	// Downtime longer than the catch-up window is cut off
+	clock.Set(day(time.June, 30, 6))
+	if report := runInterest(t, s); report.Accrued != maxAccrualCatchUp {
+		t.Errorf("accrued %d days, want %d", report.Accrued, maxAccrualCatchUp)
+	}
+}
+
+func TestInterestSkipsUnratedAndNegativeBalances(t *testing.T) {
+	s, clock := newTestService(t, day(time.May, 31, 6))
+	usd := createCurrency(t, s, "USD")
+	eur := createCurrency(t, s, "EUR")
+	createUser(t, s, 1, "alice", usd, -50, day(time.May, 1, 0))
+	createUser(t, s, 2, "bob", eur, 1000, day(time.May, 1, 0))
+	setSetting(t, s, SettingInterestRate+".USD", testInterestRate)
+
+	runInterest(t, s)
+	clock.Set(day(time.June, 1, 6))
+	report := runInterest(t, s)
+
+	if len(report.Paid) != 0 {
+		t.Errorf("paid %v, want nothing", report.Paid)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), -50)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "EUR"), 1000)
+
+	var eurAccruals int64
+	s.db.Conn.Model(&database.InterestAccrual{}).Where("rate = 0 OR balance_id IN (SELECT id FROM balances WHERE currency_id = ?)", eur.ID).Count(&eurAccruals)
+	if eurAccruals != 0 {
+		t.Errorf("%d accruals without a rate", eurAccruals)
+	}
+}
+
+func TestInterestCarriesSubCentAmounts(t *testing.T) {
+	s, clock := newTestService(t, day(time.July, 30, 6))
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 1, day(time.July, 1, 0))
+	setSetting(t, s, SettingInterestRate, testInterestRate)
+
+	// 0.001 a day is less than a cent at the end of July
+	runInterest(t, s)
+	clock.Set(day(time.July, 31, 6))
+	runInterest(t, s)
+	clock.Set(day(time.August, 1, 6))
+	if report := runInterest(t, s); len(report.Paid) != 0 {
+		t.Fatalf("paid %v, want the amount carried over", report.Paid)
+	}
+
+	// Ten more days add up to a cent, paid at the start of September
+	for d := 2; d <= 31; d++ {
+		clock.Set(day(time.August, d, 6))
+		runInterest(t, s)
+	}
+	clock.Set(day(time.September, 1, 6))
+	report := runInterest(t, s)
+	assertAmount(t, "paid", report.Paid["USD"], 0.03)
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 1.03)
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// Registration is the result of a user starting the bot
+type Registration struct {
+	User     *database.User
+	Created  bool    // the user joined just now
+	Bonus    float64 // welcome bonus paid to a new user
+	Currency *database.Currency
+}
+
+// InviteReport is an invite with the users who joined with it
+type InviteReport struct {
+	Invite   database.Invite
+	Invitees []database.User
+}
+
+// Register returns the account of a user starting the bot, creating it on
+// first start. An invite adds the user to the wallet it belongs to, which
+// becomes their active wallet, and records who invited them. Without an
+// invite users join the wallet of ctx unless registration is closed. New
+// users get the welcome bonus from the system account.
+func (s *coreService) Register(ctx context.Context, profile Profile, inviteCode string) (*Registration, error) {
+	var invite database.Invite
+	if inviteCode != "" {
+		// Invites lead into their own wallet, whichever wallet the user works with
+		if err := s.db.Conn.WithContext(database.AnyWallet(ctx)).
+			Where("code = ?", NormalizeVoucherCode(inviteCode)).
+			Limit(1).
+			Find(&invite).Error; err != nil {
+			return nil, err
+		}
+		if invite.ID != 0 {
+			ctx = database.WithWallet(ctx, invite.WalletID)
+		}
+	}
+
+	registration := &Registration{}
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing database.User
+		if err := tx.Unscoped().Preload("Accounts.Currency").
+			Where("telegram_id = ?", profile.TelegramID).
+			Limit(1).
+			Find(&existing).Error; err != nil {
+			return err
+		}
+		if existing.ID != 0 {
+			if existing.DeletedAt.Valid {
+				return ErrAccountRemoved
+			}
+			registration.User = &existing
+			return nil
+		}
+
+		now := s.now()
+		if inviteCode != "" {
+			if invite.ID == 0 || !invite.IsUsable(now) {
+				return ErrInviteInvalid
+			}
+			// Count the use with a conditional update, so that concurrent
+			// joins never exceed the limit
+			result := tx.Model(&database.Invite{}).
+				Where("id = ? AND (max_uses = 0 OR uses < max_uses)", invite.ID).
+				Update("uses", gorm.Expr("uses + 1"))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrInviteInvalid
+			}
+		} else if getBoolSetting(tx, SettingRegistrationClosed, false) {
+			return ErrRegistrationClosed
+		}
+
+		user := &database.User{
+			TelegramID:  profile.TelegramID,
+			Username:    profile.Username,
+			FirstName:   profile.FirstName,
+			LastName:    profile.LastName,
+			AppLanguage: profile.LanguageCode,
+			InvitedByID: invite.CreatedByID,
+			InviteID:    invite.ID,
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if err := s.publish(ctx, tx, UserCreated{TelegramID: user.TelegramID, Username: user.Username, Source: "registration"}); err != nil {
+			return err
+		}
+		registration.User = user
+		registration.Created = true
+		return s.payWelcomeBonus(tx, registration, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if invite.ID != 0 {
+		if err := s.userService.SwitchWallet(ctx, profile.TelegramID, invite.WalletID); err != nil {
+			return nil, err
+		}
+	}
+	return registration, nil
+}
+
+// payWelcomeBonus credits the welcome bonus in the default currency to a
+// new user using tx
+func (s *coreService) payWelcomeBonus(tx *gorm.DB, registration *Registration, now time.Time) error {
+	var currency database.Currency
+	if err := tx.Where("is_default = ?", true).Limit(1).Find(&currency).Error; err != nil {
+		return err
+	}
+	if currency.ID == 0 {
+		return nil
+	}
+	bonus := roundCents(getCurrencyFloatSetting(tx, SettingWelcomeBonus, currency.Code, 0))
+	if bonus <= 0 {
+		return nil
+	}
+
+	system, err := systemAccount(tx)
+	if err != nil {
+		return err
+	}
+	systemBalance, err := systemBalance(tx, system, currency)
+	if err != nil {
+		return err
+	}
+	user := registration.User
+	balance := database.Balance{UserID: user.ID, CurrencyID: currency.ID, Currency: currency}
+	if err := tx.Create(&balance).Error; err != nil {
+		return err
+	}
+	if err := moveMoney(tx, system, systemBalance, user, &balance, bonus, "bonus_out", "welcome_bonus", now); err != nil {
+		return err
+	}
+	user.Accounts = append(user.Accounts, balance)
+	registration.Bonus = bonus
+	registration.Currency = &currency
+	return nil
+}
+
+// CreateInvite creates an invite that can be used maxUses times, zero for
+// unlimited, and expires after ttl, zero for never
+func (s *coreService) CreateInvite(ctx context.Context, adminTelegramID int64, maxUses int, ttl time.Duration) (*database.Invite, error) {
+	if !s.userService.IsAdmin(ctx, adminTelegramID) {
+		return nil, errors.New("unauthorized")
+	}
+	if maxUses < 0 || ttl < 0 {
+		return nil, errors.New("limits of invites cannot be negative")
+	}
+
+	var invite *database.Invite
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		admin, err := findUserByTelegramID(tx, adminTelegramID)
+		if err != nil {
+			return err
+		}
+		code, err := newVoucherCode()
+		if err != nil {
+			return err
+		}
+		invite = &database.Invite{
+			Code:              code,
+			CreatedByID:       admin.ID,
+			CreatedByUsername: admin.Username,
+			MaxUses:           maxUses,
+		}
+		if ttl > 0 {
+			expiresAt := s.now().Add(ttl)
+			invite.ExpiresAt = &expiresAt
+		}
+		if err := tx.Create(invite).Error; err != nil {
+			return err
+		}
+
+		after := map[string]any{"max_uses": maxUses}
+		if invite.ExpiresAt != nil {
+			after["expires_at"] = invite.ExpiresAt.Format(time.RFC3339)
+		}
+		return s.recordAudit(ctx, tx, AuditCreateInvite, inviteTarget(invite), nil, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// RevokeInvite makes an invite expire right away
+func (s *coreService) RevokeInvite(ctx context.Context, adminTelegramID int64, code string) error {
+	if !s.userService.IsAdmin(ctx, adminTelegramID) {
+		return errors.New("unauthorized")
+	}
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var invite database.Invite
+		if err := tx.Where("code = ?", NormalizeVoucherCode(code)).First(&invite).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInviteInvalid
+			}
+			return err
+		}
+		now := s.now()
+		if err := tx.Model(&invite).Update("expires_at", now).Error; err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, tx, AuditRevokeInvite, inviteTarget(&invite), nil, map[string]any{"uses": invite.Uses})
+	})
+}
+
+// ListInvites returns all invites with the users who joined with them, the
+// newest first
+func (s *coreService) ListInvites(ctx context.Context) ([]InviteReport, error) {
+	db := s.db.Conn.WithContext(ctx)
+	var invites []database.Invite
+	if err := db.Order("id desc").Find(&invites).Error; err != nil {
+		return nil, err
+	}
+	var invitees []database.User
+	if err := db.Where("invite_id <> 0").Order("id").Find(&invitees).Error; err != nil {
+		return nil, err
+	}
+
+	byInvite := make(map[uint][]database.User)
+	for _, user := range invitees {
+		byInvite[user.InviteID] = append(byInvite[user.InviteID], user)
+	}
+	reports := make([]InviteReport, len(invites))
+	for i, invite := range invites {
+		reports[i] = InviteReport{Invite: invite, Invitees: byInvite[invite.ID]}
+	}
+	return reports, nil
+}
+
+// inviteTarget names an invite in the audit log by ID, the code lets anyone
+// holding it join
+func inviteTarget(invite *database.Invite) string {
+	return fmt.Sprintf("invite:#%d", invite.ID)
+}
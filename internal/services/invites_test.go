@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var inviteTestStart = time.Date(2025, 10, 27, 11, 0, 0, 0, time.UTC)
+
+// newTestInvites returns a service with USD as the default currency, a
+// welcome bonus of 5 USD and Alice (1) as the admin
+func newTestInvites(t *testing.T) (*coreService, *testClock, database.Currency) {
+	t.Helper()
+	s, clock := newTestService(t, inviteTestStart)
+	usd := createCurrency(t, s, "USD")
+	if err := s.SetDefaultCurrency(context.Background(), "USD"); err != nil {
+		t.Fatalf("SetDefaultCurrency: %v", err)
+	}
+	setSetting(t, s, SettingWelcomeBonus, "5")
+	admin := createUser(t, s, 1, "alice", usd, 0, inviteTestStart)
+	s.db.Conn.Model(admin).Update("is_admin", true)
+	return s, clock, usd
+}
+
+func TestClosedRegistrationNeedsAnInvite(t *testing.T) {
+	s, _, _ := newTestInvites(t)
+	ctx := context.Background()
+	setSetting(t, s, SettingRegistrationClosed, "true")
+
+	if _, err := s.Register(ctx, Profile{TelegramID: 2, Username: "bob"}, ""); !errors.Is(err, ErrRegistrationClosed) {
+		t.Fatalf("registration without an invite: err = %v, want ErrRegistrationClosed", err)
+	}
+	if _, err := s.Register(ctx, Profile{TelegramID: 2, Username: "bob"}, "NOSUCHCODE"); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("registration with an unknown invite: err = %v, want ErrInviteInvalid", err)
+	}
+	if _, err := s.CreateInvite(ctx, 2, 0, 0); err == nil {
+		t.Error("a user who is not an admin created an invite")
+	}
+
+	invite, err := s.CreateInvite(ctx, 1, 0, 0)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	code := strings.ToLower(invite.Code[:4]) + "-" + invite.Code[4:]
+	registration, err := s.Register(ctx, Profile{TelegramID: 2, Username: "bob"}, code)
+	if err != nil {
+		t.Fatalf("Register with an invite: %v", err)
+	}
+	if !registration.Created || registration.User.InviteID != invite.ID || registration.User.InvitedByID == 0 {
+		t.Errorf("registration = %+v, want a new user who joined with invite #%d", registration.User, invite.ID)
+	}
+
+	// Members start the bot again without the invite
+	if registration, err = s.Register(ctx, Profile{TelegramID: 2, Username: "bob"}, ""); err != nil || registration.Created {
+		t.Errorf("second start = %+v, %v, want the existing account", registration, err)
+	}
+
+	reports, err := s.ListInvites(ctx)
+	if err != nil {
+		t.Fatalf("ListInvites: %v", err)
+	}
+	if len(reports) != 1 || len(reports[0].Invitees) != 1 || reports[0].Invitees[0].TelegramID != 2 {
+		t.Errorf("invite reports = %+v, want bob as the only invitee", reports)
+	}
+}
+
+func TestInvitesExpireAndRunOut(t *testing.T) {
+	s, clock, _ := newTestInvites(t)
+	ctx := context.Background()
+
+	if _, err := s.CreateInvite(ctx, 1, -1, 0); err == nil {
+		t.Error("created an invite with negative uses")
+	}
+	limited, err := s.CreateInvite(ctx, 1, 2, 0)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	expiring, err := s.CreateInvite(ctx, 1, 0, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	revoked, err := s.CreateInvite(ctx, 1, 0, 0)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+
+	for id := int64(2); id <= 3; id++ {
+		if _, err := s.Register(ctx, Profile{TelegramID: id}, limited.Code); err != nil {
+			t.Fatalf("Register %d: %v", id, err)
+		}
+	}
+	if _, err := s.Register(ctx, Profile{TelegramID: 4}, limited.Code); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("third use of an invite for two: err = %v, want ErrInviteInvalid", err)
+	}
+
+	clock.Set(inviteTestStart.Add(59 * time.Minute))
+	if _, err := s.Register(ctx, Profile{TelegramID: 5}, expiring.Code); err != nil {
+		t.Fatalf("Register before the invite expires: %v", err)
+	}
+	clock.Set(inviteTestStart.Add(time.Hour))
+	if _, err := s.Register(ctx, Profile{TelegramID: 6}, expiring.Code); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("expired invite: err = %v, want ErrInviteInvalid", err)
+	}
+
+	if err := s.RevokeInvite(ctx, 2, revoked.Code); err == nil {
+		t.Error("a user who is not an admin revoked an invite")
+	}
+	if err := s.RevokeInvite(ctx, 1, "NOSUCHCODE"); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("revoking an unknown invite: err = %v, want ErrInviteInvalid", err)
+	}
+	if err := s.RevokeInvite(ctx, 1, revoked.Code); err != nil {
+		t.Fatalf("RevokeInvite: %v", err)
+	}
+	if _, err := s.Register(ctx, Profile{TelegramID: 7}, revoked.Code); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("revoked invite: err = %v, want ErrInviteInvalid", err)
+	}
+
+	var count int64
+	if err := s.db.Conn.Model(&database.User{}).Where("invite_id <> 0").Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("%d users joined with invites, want 3", count)
+	}
+
+	// The audit log names invites by ID, the codes let anyone join
+	var entries []database.AuditLog
+	if err := s.db.Conn.Where("action IN ?", []string{AuditCreateInvite, AuditRevokeInvite}).Order("id").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[3].Target != inviteTarget(revoked) {
+		t.Fatalf("audit entries = %+v, want three invites created and one revoked", entries)
+	}
+	for _, entry := range entries {
+		for _, invite := range []*database.Invite{limited, expiring, revoked} {
+			if strings.Contains(entry.Target+entry.After, invite.Code) {
+				t.Errorf("audit entry %q shows the code of invite #%d", entry.Target, invite.ID)
+			}
+		}
+	}
+}
+
+func TestWelcomeBonusIsPaidOnce(t *testing.T) {
+	s, _, usd := newTestInvites(t)
+	ctx := context.Background()
+
+	registration, err := s.Register(ctx, Profile{TelegramID: 2, Username: "bob"}, "")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if registration.Bonus != 5 || registration.Currency == nil || registration.Currency.Code != "USD" {
+		t.Errorf("registration = %+v, want a bonus of 5 USD", registration)
+	}
+	for range 2 {
+		registration, err = s.Register(ctx, Profile{TelegramID: 2, Username: "bob"}, "")
+		if err != nil {
+			t.Fatalf("Register again: %v", err)
+		}
+		if registration.Created || registration.Bonus != 0 {
+			t.Errorf("second registration = %+v, want no new account and no bonus", registration)
+		}
+	}
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 5)
+	assertAmount(t, "system", balanceOf(t, s, SystemTelegramID, "USD"), -5)
+	assertAmount(t, "total", totalMoney(t, s, usd), 0)
+
+	// Removed users cannot come back for another bonus
+	if err := s.RemoveUser(WithActor(ctx, 1, SourceBot), "bob", "alice"); err != nil {
+		t.Fatalf("RemoveUser: %v", err)
+	}
+	if _, err := s.Register(ctx, Profile{TelegramID: 2, Username: "bob"}, ""); err != nil {
+		t.Fatalf("Register after removal: %v", err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 5)
+	assertAmount(t, "bob after removal", balanceOf(t, s, 2, "USD"), 0)
+}
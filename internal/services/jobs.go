@@ -0,0 +1,26 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+)
+
+// RunPeriodically calls job right away and then every interval until ctx is
+// done. Failures are logged, the next run happens regardless.
+func RunPeriodically(ctx context.Context, name string, interval time.Duration, job func(context.Context) error) {
+	ctx = logger.With(ctx, "job", name)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := job(ctx); err != nil {
+			logger.ErrorContext(ctx, "Background job failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
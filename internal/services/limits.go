@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// Transfer limit kinds, as used in "/set @user limit.<kind>=<value> <currency>"
+const (
+	LimitSingle  = "single"
+	LimitDaily   = "daily"
+	LimitMonthly = "monthly"
+	LimitHourly  = "hourly"
+)
+
+// outgoingTransactionTypes are the transaction types counted against limits
+var outgoingTransactionTypes = []string{"transfer_out", "loan_out", "repay_out", "escrow_hold"}
+
+func (s *coreService) SetUserStatus(ctx context.Context, username, status string) error {
+	if status != database.UserStatusActive && status != database.UserStatusFrozen {
+		return fmt.Errorf("invalid status %q, use %s or %s", status, database.UserStatusActive, database.UserStatusFrozen)
+	}
+
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := findUserForUpdate(tx, username)
+		if err != nil {
+			return err
+		}
+		if user.Status == database.UserStatusClosed {
+			return errors.New("user is closed")
+		}
+
+		before := user.Status
+		if err := tx.Model(user).Update("status", status).Error; err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, tx, AuditSetStatus, "@"+user.Username, before, status)
+	})
+}
+
+// SetTransferLimit sets one limit of a user in a currency. A zero value removes the limit.
+func (s *coreService) SetTransferLimit(ctx context.Context, username, kind string, value float64, currencyCode string) error {
+	if value < 0 || !isFinite(value) {
+		return errors.New("limit must be a non-negative number")
+	}
+
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := findUserForUpdate(tx, username)
+		if err != nil {
+			return err
+		}
+		var currency database.Currency
+		if err := tx.Where("code = ?", currencyCode).First(&currency).Error; err != nil {
+			return errors.New("currency not found")
+		}
+
+		limit := database.TransferLimit{UserID: user.ID, CurrencyID: currency.ID}
+		if err := tx.Where(&limit).FirstOrCreate(&limit).Error; err != nil {
+			return err
+		}
+
+		var before any
+		switch kind {
+		case LimitSingle:
+			before, limit.MaxSingle = limit.MaxSingle, value
+		case LimitDaily:
+			before, limit.Daily = limit.Daily, value
+		case LimitMonthly:
+			before, limit.Monthly = limit.Monthly, value
+		case LimitHourly:
+			if value != float64(int(value)) {
+				return errors.New("hourly limit is a number of transfers")
+			}
+			before, limit.MaxPerHour = limit.MaxPerHour, int(value)
+		default:
+			return fmt.Errorf("unknown limit %q, available: %s, %s, %s, %s", kind, LimitSingle, LimitDaily, LimitMonthly, LimitHourly)
+		}
+		if err := tx.Save(&limit).Error; err != nil {
+			return err
+		}
+
+		target := fmt.Sprintf("@%s %s limit.%s", user.Username, currencyCode, kind)
+		return s.recordAudit(ctx, tx, AuditSetLimit, target, before, value)
+	})
+}
+
+func (s *coreService) GetTransferLimits(ctx context.Context, telegramID int64) ([]database.TransferLimit, error) {
+	user, err := s.userService.GetUser(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	var limits []database.TransferLimit
+	err = s.db.Conn.WithContext(ctx).
+		Preload("Currency").
+		Where("user_id = ?", user.ID).
+		Find(&limits).Error
+	if err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// checkTransferLimits returns an error wrapping ErrLimitExceeded when sending
+// amount from balance at now would break one of the user's limits. Days and
+// months start at midnight in the user's timezone.
+func checkTransferLimits(tx *gorm.DB, user *database.User, balance *database.Balance, amount float64, now time.Time) error {
+	var limit database.TransferLimit
+	err := tx.Where("user_id = ? AND currency_id = ?", user.ID, balance.CurrencyID).
+		Limit(1).
+		Find(&limit).Error
+	if err != nil {
+		return err
+	}
+	if limit.ID == 0 {
+		return nil
+	}
+
+	code := balance.Currency.Code
+	if limit.MaxSingle > 0 && amount > limit.MaxSingle {
+		return fmt.Errorf("%w: maximum single transfer is %.2f %s", ErrLimitExceeded, limit.MaxSingle, code)
+	}
+
+	local := now.In(user.Location())
+	if limit.Daily > 0 {
+		startOfDay := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+		sent, err := outgoingVolume(tx, balance.ID, startOfDay)
+		if err != nil {
+			return err
+		}
+		if sent+amount > limit.Daily {
+			return fmt.Errorf("%w: daily limit is %.2f %s, %.2f left today", ErrLimitExceeded, limit.Daily, code, max(limit.Daily-sent, 0))
+		}
+	}
+
+	if limit.Monthly > 0 {
+		startOfMonth := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, local.Location())
+		sent, err := outgoingVolume(tx, balance.ID, startOfMonth)
+		if err != nil {
+			return err
+		}
+		if sent+amount > limit.Monthly {
+			return fmt.Errorf("%w: monthly limit is %.2f %s, %.2f left this month", ErrLimitExceeded, limit.Monthly, code, max(limit.Monthly-sent, 0))
+		}
+	}
+
+	if limit.MaxPerHour > 0 {
+		var count int64
+		err := tx.Model(&database.Transaction{}).
+			Where("balance_id = ? AND type IN ? AND timestamp >= ?", balance.ID, outgoingTransactionTypes, now.Add(-time.Hour).UTC()).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count >= int64(limit.MaxPerHour) {
+			return fmt.Errorf("%w: at most %d transfers per hour in %s", ErrLimitExceeded, limit.MaxPerHour, code)
+		}
+	}
+
+	return nil
+}
+
+// outgoingVolume sums the money sent from a balance since the given time
+func outgoingVolume(tx *gorm.DB, balanceID uint, since time.Time) (float64, error) {
+	var sent float64
+	err := tx.Model(&database.Transaction{}).
+		Select("COALESCE(SUM(-amount), 0)").
+		Where("balance_id = ? AND type IN ? AND timestamp >= ?", balanceID, outgoingTransactionTypes, since.UTC()).
+		Scan(&sent).Error
+	return sent, err
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// limitTestStart is 23:00 in Berlin, an hour before the user's local midnight
+var limitTestStart = time.Date(2025, 5, 30, 21, 0, 0, 0, time.UTC)
+
+func TestTransferLimitSingleAndDaily(t *testing.T) {
+	s, clock := newTestService(t, limitTestStart)
+	usd := createCurrency(t, s, "USD")
+	alice := createUser(t, s, 1, "alice", usd, 1000, limitTestStart)
+	createUser(t, s, 2, "bob", usd, 0, limitTestStart)
+	s.db.Conn.Model(alice).Update("timezone", "Europe/Berlin")
+
+	ctx := context.Background()
+	if err := s.SetTransferLimit(ctx, "alice", LimitSingle, 100, "USD"); err != nil {
+		t.Fatalf("SetTransferLimit single: %v", err)
+	}
+	if err := s.SetTransferLimit(ctx, "alice", LimitDaily, 150, "USD"); err != nil {
+		t.Fatalf("SetTransferLimit daily: %v", err)
+	}
+
+	if err := s.TransferMoney(ctx, 1, "bob", 101, "USD"); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("transfer above the single limit: err = %v, want ErrLimitExceeded", err)
+	}
+	if err := s.TransferMoney(ctx, 1, "bob", 100, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	if err := s.TransferMoney(ctx, 1, "bob", 60, "USD"); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("transfer above the daily limit: err = %v, want ErrLimitExceeded", err)
+	}
+
+	// The day starts at midnight in Berlin, not in UTC
+	clock.Set(limitTestStart.Add(90 * time.Minute))
+	if err := s.TransferMoney(ctx, 1, "bob", 60, "USD"); err != nil {
+		t.Fatalf("transfer on the next local day: %v", err)
+	}
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 160)
+}
+
+func TestDailyLimitResetsAtLocalMidnight(t *testing.T) {
+	for _, timezone := range []string{"", "Europe/Berlin", "America/New_York", "Asia/Kolkata"} {
+		name := timezone
+		if name == "" {
+			name = "unset"
+		}
+		t.Run(name, func(t *testing.T) {
+			loc, err := time.LoadLocation(timezone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The evening of June 1 in the user's time zone
+			evening := time.Date(2025, 6, 1, 18, 0, 0, 0, loc)
+			s, clock := newTestService(t, evening)
+			usd := createCurrency(t, s, "USD")
+			alice := createUser(t, s, 1, "alice", usd, 1000, evening)
+			createUser(t, s, 2, "bob", usd, 0, evening)
+			s.db.Conn.Model(alice).Update("timezone", timezone)
+
+			ctx := context.Background()
+			if err := s.SetTransferLimit(ctx, "alice", LimitDaily, 100, "USD"); err != nil {
+				t.Fatalf("SetTransferLimit: %v", err)
+			}
+			if err := s.TransferMoney(ctx, 1, "bob", 100, "USD"); err != nil {
+				t.Fatalf("TransferMoney: %v", err)
+			}
+
+			midnight := time.Date(2025, 6, 2, 0, 0, 0, 0, loc)
+			for _, at := range []time.Time{
+				// UTC midnight passes first for users west of UTC
+				time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC),
+				midnight.Add(-time.Second),
+			} {
+				if !at.Before(midnight) || at.Before(evening) {
+					continue
+				}
+				clock.Set(at)
+				if err := s.TransferMoney(ctx, 1, "bob", 1, "USD"); !errors.Is(err, ErrLimitExceeded) {
+					t.Errorf("transfer at %v before local midnight: err = %v, want ErrLimitExceeded", at, err)
+				}
+			}
+
+			clock.Set(midnight)
+			if err := s.TransferMoney(ctx, 1, "bob", 100, "USD"); err != nil {
+				t.Fatalf("transfer at local midnight: %v", err)
+			}
+			if err := s.TransferMoney(ctx, 1, "bob", 1, "USD"); !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("transfer above the limit of the new day: err = %v, want ErrLimitExceeded", err)
+			}
+			assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 200)
+		})
+	}
+}
+
+func TestTransferLimitMonthlyAndHourly(t *testing.T) {
+	s, clock := newTestService(t, limitTestStart)
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 1000, limitTestStart)
+	createUser(t, s, 2, "bob", usd, 0, limitTestStart)
+
+	ctx := context.Background()
+	if err := s.SetTransferLimit(ctx, "alice", LimitHourly, 2, "USD"); err != nil {
+		t.Fatalf("SetTransferLimit hourly: %v", err)
+	}
+	if err := s.SetTransferLimit(ctx, "alice", LimitMonthly, 100, "USD"); err != nil {
+		t.Fatalf("SetTransferLimit monthly: %v", err)
+	}
+	if err := s.SetTransferLimit(ctx, "alice", LimitHourly, 1.5, "USD"); err == nil {
+		t.Error("SetTransferLimit accepted a fractional hourly limit")
+	}
+	if err := s.SetTransferLimit(ctx, "alice", "weekly", 1, "USD"); err == nil {
+		t.Error("SetTransferLimit accepted an unknown kind")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.TransferMoney(ctx, 1, "bob", 10, "USD"); err != nil {
+			t.Fatalf("transfer %d: %v", i+1, err)
+		}
+	}
+	if err := s.TransferMoney(ctx, 1, "bob", 10, "USD"); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("third transfer within an hour: err = %v, want ErrLimitExceeded", err)
+	}
+
+	clock.Set(limitTestStart.Add(61 * time.Minute))
+	if err := s.TransferMoney(ctx, 1, "bob", 80, "USD"); err != nil {
+		t.Fatalf("transfer after an hour: %v", err)
+	}
+	clock.Set(limitTestStart.Add(3 * time.Hour))
+	if err := s.TransferMoney(ctx, 1, "bob", 1, "USD"); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("transfer above the monthly limit: err = %v, want ErrLimitExceeded", err)
+	}
+
+	// Removing a limit with zero lifts it
+	if err := s.SetTransferLimit(ctx, "alice", LimitMonthly, 0, "USD"); err != nil {
+		t.Fatalf("SetTransferLimit: %v", err)
+	}
+	if err := s.TransferMoney(ctx, 1, "bob", 1, "USD"); err != nil {
+		t.Fatalf("transfer after removing the monthly limit: %v", err)
+	}
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 101)
+}
+
+func TestFrozenUserCannotTransfer(t *testing.T) {
+	s, _ := newTestService(t, limitTestStart)
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 100, limitTestStart)
+	createUser(t, s, 2, "bob", usd, 0, limitTestStart)
+
+	ctx := context.Background()
+	if err := s.SetUserStatus(ctx, "alice", "frozen"); err != nil {
+		t.Fatalf("SetUserStatus: %v", err)
+	}
+	if err := s.TransferMoney(ctx, 1, "bob", 10, "USD"); !errors.Is(err, ErrAccountFrozen) {
+		t.Fatalf("transfer from a frozen account: err = %v, want ErrAccountFrozen", err)
+	}
+	if err := s.SetUserStatus(ctx, "alice", "active"); err != nil {
+		t.Fatalf("SetUserStatus: %v", err)
+	}
+	if err := s.TransferMoney(ctx, 1, "bob", 10, "USD"); err != nil {
+		t.Fatalf("transfer after unfreezing: %v", err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 90)
+}
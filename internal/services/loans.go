@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"github.com/fitz123/mcduck-wallet/internal/metrics"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLoanRemindBefore = 3 * 24 * time.Hour
+	defaultLoanRemindEvery  = 7 * 24 * time.Hour
+)
+
+// Repayment is the result of paying back loans
+type Repayment struct {
+	CurrencyCode string
+	Loans        []database.Loan // loans the money went to
+	Outstanding  float64         // what is still owed to the lender in the currency
+}
+
+// withLoan links a transaction to a loan
+func withLoan(loanID uint) func(*database.Transaction) {
+	return func(t *database.Transaction) {
+		t.LoanID = loanID
+	}
+}
+
+// Lend transfers money to a borrower as a loan, due is the start of the day
+// it should be repaid by and may be nil. Loans go through the same checks,
+// fees and approvals as transfers.
+func (s *coreService) Lend(ctx context.Context, lenderTelegramID int64, borrowerUsername string, amount float64, currencyCode string, due *time.Time) (*database.Loan, error) {
+	now := s.now()
+	if due != nil && !due.Add(24*time.Hour).After(now) {
+		return nil, ErrInvalidDueDate
+	}
+
+	var loan *database.Loan
+	var pending *database.PendingOperation
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		lender, err := findUserByTelegramID(tx, lenderTelegramID)
+		if err != nil {
+			return err
+		}
+		borrower, err := findUserForUpdate(tx, borrowerUsername)
+		if err != nil {
+			return err
+		}
+		if _, _, err := prepareTransfer(tx, lender, borrower, amount, currencyCode, now); err != nil {
+			return err
+		}
+
+		pending, err = s.requestApproval(ctx, tx, database.OperationLoan, lender, borrower, amount, currencyCode, amount)
+		if err != nil {
+			return err
+		}
+		if pending != nil {
+			pending.DueDate = due
+			return tx.Model(pending).Update("due_date", due).Error
+		}
+		loan, err = lendTx(tx, lender, borrower, amount, currencyCode, due, now)
+		return err
+	})
+	if err != nil {
+		metrics.TransfersFailed.WithLabelValues(transferFailureReason(err)).Inc()
+		return nil, err
+	}
+	if pending != nil {
+		s.notifyApprovers(ctx, pending)
+		return nil, &PendingApprovalError{Operation: pending}
+	}
+	return loan, nil
+}
+
+// lendTx opens a loan and moves its amount to the borrower using tx
+func lendTx(tx *gorm.DB, lender, borrower *database.User, amount float64, currencyCode string, due *time.Time, now time.Time) (*database.Loan, error) {
+	fromBalance, toBalance, err := prepareTransfer(tx, lender, borrower, amount, currencyCode, now)
+	if err != nil {
+		return nil, err
+	}
+
+	loan := &database.Loan{
+		LenderID:           lender.ID,
+		LenderTelegramID:   lender.TelegramID,
+		LenderUsername:     lender.Username,
+		BorrowerID:         borrower.ID,
+		BorrowerTelegramID: borrower.TelegramID,
+		BorrowerUsername:   borrower.Username,
+		CurrencyID:         fromBalance.CurrencyID,
+		Currency:           fromBalance.Currency,
+		Amount:             amount,
+		DueDate:            due,
+		Status:             database.LoanOpen,
+	}
+	if err := tx.Create(loan).Error; err != nil {
+		return nil, err
+	}
+	if err := moveMoney(tx, lender, fromBalance, borrower, toBalance, amount, "loan_out", "loan_in", now, withLoan(loan.ID)); err != nil {
+		return nil, err
+	}
+	if err := chargeTransferFee(tx, lender, fromBalance, amount, now); err != nil {
+		return nil, err
+	}
+
+	metrics.Transfers.WithLabelValues(currencyCode).Inc()
+	metrics.TransferVolume.WithLabelValues(currencyCode).Add(amount)
+	return loan, nil
+}
+
+// Repay pays back money the borrower owes the lender, the oldest due loans
+// first. An empty currency code picks the currency of the debt when there is
+// only one. Repayments never need approval, the loans already had it.
+func (s *coreService) Repay(ctx context.Context, borrowerTelegramID int64, lenderUsername string, amount float64, currencyCode string) (*Repayment, error) {
+	repayment := &Repayment{}
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		borrower, err := findUserByTelegramID(tx, borrowerTelegramID)
+		if err != nil {
+			return err
+		}
+		lender, err := findUserForUpdate(tx, lenderUsername)
+		if err != nil {
+			return err
+		}
+
+		var loans []database.Loan
+		if err := tx.Preload("Currency").
+			Where("borrower_id = ? AND lender_id = ? AND status = ?", borrower.ID, lender.ID, database.LoanOpen).
+			Order("due_date IS NULL, due_date, id").
+			Find(&loans).Error; err != nil {
+			return err
+		}
+		loans, currencyCode, err = loansInCurrency(loans, currencyCode)
+		if err != nil {
+			return err
+		}
+
+		outstanding := 0.0
+		for _, loan := range loans {
+			outstanding += loan.Outstanding()
+		}
+		if amount > roundCents(outstanding) {
+			return fmt.Errorf("%w: you owe @%s %.2f %s", ErrRepaymentTooLarge, lender.Username, outstanding, currencyCode)
+		}
+
+		now := s.now()
+		fromBalance, toBalance, err := prepareTransfer(tx, borrower, lender, amount, currencyCode, now)
+		if err != nil {
+			return err
+		}
+		left := amount
+		for i := range loans {
+			loan := &loans[i]
+			part := min(left, loan.Outstanding())
+			if part <= 0 {
+				break
+			}
+			if err := moveMoney(tx, borrower, fromBalance, lender, toBalance, part, "repay_out", "repay_in", now, withLoan(loan.ID)); err != nil {
+				return err
+			}
+			loan.Repaid = roundCents(loan.Repaid + part)
+			if loan.Outstanding() < 0.005 {
+				loan.Status = database.LoanRepaid
+			}
+			if err := tx.Model(loan).Updates(map[string]any{"repaid": loan.Repaid, "status": loan.Status}).Error; err != nil {
+				return err
+			}
+			repayment.Loans = append(repayment.Loans, *loan)
+			left -= part
+		}
+		repayment.CurrencyCode = currencyCode
+		repayment.Outstanding = roundCents(outstanding - amount)
+		if err := chargeTransferFee(tx, borrower, fromBalance, amount, now); err != nil {
+			return err
+		}
+
+		metrics.Transfers.WithLabelValues(currencyCode).Inc()
+		metrics.TransferVolume.WithLabelValues(currencyCode).Add(amount)
+		return nil
+	})
+	if err != nil {
+		metrics.TransfersFailed.WithLabelValues(transferFailureReason(err)).Inc()
+		return nil, err
+	}
+	return repayment, nil
+}
+
+// loansInCurrency keeps the loans in currencyCode. Without a code, the loans
+// must all be in the same currency, which is returned.
+func loansInCurrency(loans []database.Loan, currencyCode string) ([]database.Loan, string, error) {
+	if len(loans) == 0 {
+		return nil, "", ErrLoanNotFound
+	}
+	if currencyCode == "" {
+		currencyCode = loans[0].Currency.Code
+		for _, loan := range loans {
+			if loan.Currency.Code != currencyCode {
+				return nil, "", fmt.Errorf("you owe this user in several currencies, please specify one")
+			}
+		}
+		return loans, currencyCode, nil
+	}
+
+	var matching []database.Loan
+	for _, loan := range loans {
+		if loan.Currency.Code == currencyCode {
+			matching = append(matching, loan)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, "", fmt.Errorf("%w in %s", ErrLoanNotFound, currencyCode)
+	}
+	return matching, currencyCode, nil
+}
+
+// ListLoans returns the open loans a user gave or took, the earliest due first
+func (s *coreService) ListLoans(ctx context.Context, telegramID int64) ([]database.Loan, error) {
+	user, err := s.userService.GetUser(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	var loans []database.Loan
+	err = s.db.Conn.WithContext(ctx).
+		Preload("Currency").
+		Where("(lender_id = ? OR borrower_id = ?) AND status = ?", user.ID, user.ID, database.LoanOpen).
+		Order("due_date IS NULL, due_date, id").
+		Find(&loans).Error
+	if err != nil {
+		return nil, err
+	}
+	return loans, nil
+}
+
+// RunLoanReminders reminds borrowers in all wallets of loans due soon once,
+// and of overdue loans repeatedly. It returns the number of reminders sent.
+func (s *coreService) RunLoanReminders(ctx context.Context) (int, error) {
+	type reminder struct {
+		loan    database.Loan
+		overdue bool
+	}
+
+	now := s.now()
+	var reminders []reminder
+	err := s.db.Conn.WithContext(database.AnyWallet(ctx)).Transaction(func(tx *gorm.DB) error {
+		var loans []database.Loan
+		if err := tx.Preload("Currency").
+			Where("status = ? AND due_date IS NOT NULL", database.LoanOpen).
+			Find(&loans).Error; err != nil {
+			return err
+		}
+		for _, loan := range loans {
+			settings := walletSettings(tx, loan.WalletID)
+			before := getDurationSetting(settings, SettingLoanRemindBefore, defaultLoanRemindBefore)
+			every := getDurationSetting(settings, SettingLoanRemindEvery, defaultLoanRemindEvery)
+
+			var column string
+			switch {
+			case loan.IsOverdue(now):
+				if loan.OverdueReminderAt != nil && now.Sub(*loan.OverdueReminderAt) < every {
+					continue
+				}
+				column = "overdue_reminder_at"
+			case loan.DueReminderAt == nil && loan.DueDate.Sub(now) <= before:
+				column = "due_reminder_at"
+			default:
+				continue
+			}
+			if err := tx.Model(&loan).Update(column, now).Error; err != nil {
+				return err
+			}
+			reminders = append(reminders, reminder{loan: loan, overdue: column == "overdue_reminder_at"})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if s.notifier == nil {
+		if len(reminders) > 0 {
+			logger.WarnContext(ctx, "No notifier for loan reminders", "reminders", len(reminders))
+		}
+		return 0, nil
+	}
+	for i := range reminders {
+		s.notifier.NotifyLoanReminder(ctx, &reminders[i].loan, reminders[i].overdue)
+	}
+	return len(reminders), nil
+}
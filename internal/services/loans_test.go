@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var loanTestStart = time.Date(2025, 8, 4, 12, 0, 0, 0, time.UTC)
+
+// newTestLoans returns a service where Alice (1) holds 300 USD, Bob (2)
+// 20 USD and Carol (3) nothing
+func newTestLoans(t *testing.T) (*coreService, *testClock, database.Currency) {
+	t.Helper()
+	s, clock := newTestService(t, loanTestStart)
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 300, loanTestStart)
+	createUser(t, s, 2, "bob", usd, 20, loanTestStart)
+	createUser(t, s, 3, "carol", usd, 0, loanTestStart)
+	return s, clock, usd
+}
+
+// loanReminder is a reminder recorded by reminderNotifier
+type loanReminder struct {
+	loanID  uint
+	overdue bool
+}
+
+// reminderNotifier records loan reminders, it must not be sent other
+// notifications
+type reminderNotifier struct {
+	Notifier
+	reminders []loanReminder
+}
+
+func (n *reminderNotifier) NotifyLoanReminder(ctx context.Context, loan *database.Loan, overdue bool) {
+	n.reminders = append(n.reminders, loanReminder{loan.ID, overdue})
+}
+
+func TestPartialRepaymentsPayTheEarliestDueLoanFirst(t *testing.T) {
+	s, _, usd := newTestLoans(t)
+	ctx := context.Background()
+
+	due := loanTestStart.Truncate(24*time.Hour).AddDate(0, 0, 10)
+	later, err := s.Lend(ctx, 1, "bob", 100, "USD", nil)
+	if err != nil {
+		t.Fatalf("Lend: %v", err)
+	}
+	first, err := s.Lend(ctx, 1, "bob", 50, "USD", &due)
+	if err != nil {
+		t.Fatalf("Lend: %v", err)
+	}
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 170)
+
+	repayment, err := s.Repay(ctx, 2, "alice", 80, "")
+	if err != nil {
+		t.Fatalf("Repay: %v", err)
+	}
+	if repayment.CurrencyCode != "USD" || len(repayment.Loans) != 2 || repayment.Loans[0].ID != first.ID {
+		t.Fatalf("repayment = %+v, want the loan due first repaid first", repayment)
+	}
+	if repayment.Loans[0].Status != database.LoanRepaid || repayment.Loans[1].Status != database.LoanOpen {
+		t.Errorf("statuses %q and %q, want the first loan repaid and the second open",
+			repayment.Loans[0].Status, repayment.Loans[1].Status)
+	}
+	assertAmount(t, "repaid on the second loan", repayment.Loans[1].Repaid, 30)
+	assertAmount(t, "outstanding", repayment.Outstanding, 70)
+
+	loans, err := s.ListLoans(ctx, 2)
+	if err != nil {
+		t.Fatalf("ListLoans: %v", err)
+	}
+	if len(loans) != 1 || loans[0].ID != later.ID {
+		t.Fatalf("open loans = %+v, want only the loan without a due date", loans)
+	}
+
+	if _, err := s.Repay(ctx, 2, "alice", 70, "USD"); err != nil {
+		t.Fatalf("Repay of the rest: %v", err)
+	}
+	if loans, _ := s.ListLoans(ctx, 1); len(loans) != 0 {
+		t.Errorf("open loans after repaying everything: %+v", loans)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 300)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 20)
+	assertAmount(t, "total", totalMoney(t, s, usd), 320)
+
+	var repaid []database.Transaction
+	if err := s.db.Conn.Where("type = ? AND loan_id = ?", "repay_in", later.ID).Find(&repaid).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(repaid) != 2 {
+		t.Errorf("got %d repayments linked to the second loan, want 2", len(repaid))
+	}
+}
+
+func TestRepaymentsCannotExceedTheDebt(t *testing.T) {
+	s, _, _ := newTestLoans(t)
+	ctx := context.Background()
+
+	if _, err := s.Repay(ctx, 2, "alice", 10, "USD"); !errors.Is(err, ErrLoanNotFound) {
+		t.Errorf("repayment without a loan: err = %v, want ErrLoanNotFound", err)
+	}
+	if _, err := s.Lend(ctx, 1, "bob", 40, "USD", nil); err != nil {
+		t.Fatalf("Lend: %v", err)
+	}
+	if _, err := s.Repay(ctx, 2, "alice", 40.01, "USD"); !errors.Is(err, ErrRepaymentTooLarge) {
+		t.Errorf("overpayment: err = %v, want ErrRepaymentTooLarge", err)
+	}
+	if _, err := s.Repay(ctx, 2, "carol", 10, "USD"); !errors.Is(err, ErrLoanNotFound) {
+		t.Errorf("repayment to another lender: err = %v, want ErrLoanNotFound", err)
+	}
+	if _, err := s.Repay(ctx, 2, "alice", 10, "EUR"); !errors.Is(err, ErrLoanNotFound) {
+		t.Errorf("repayment in another currency: err = %v, want ErrLoanNotFound", err)
+	}
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 60)
+
+	// Bob spent the money and cannot repay in full
+	if err := s.TransferMoney(ctx, 2, "carol", 50, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	if _, err := s.Repay(ctx, 2, "alice", 40, "USD"); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("repayment above the balance: err = %v, want ErrInsufficientBalance", err)
+	}
+	if loans, _ := s.ListLoans(ctx, 2); len(loans) != 1 || loans[0].Repaid != 0 {
+		t.Errorf("loans after failed repayments = %+v, want nothing repaid", loans)
+	}
+
+	yesterday := loanTestStart.Truncate(24*time.Hour).AddDate(0, 0, -1)
+	if _, err := s.Lend(ctx, 1, "bob", 10, "USD", &yesterday); !errors.Is(err, ErrInvalidDueDate) {
+		t.Errorf("loan due yesterday: err = %v, want ErrInvalidDueDate", err)
+	}
+}
+
+func TestFrozenAccountsCannotRepay(t *testing.T) {
+	s, _, _ := newTestLoans(t)
+	ctx := context.Background()
+	if _, err := s.Lend(ctx, 1, "bob", 40, "USD", nil); err != nil {
+		t.Fatalf("Lend: %v", err)
+	}
+
+	if err := s.SetUserStatus(ctx, "bob", database.UserStatusFrozen); err != nil {
+		t.Fatalf("SetUserStatus: %v", err)
+	}
+	if _, err := s.Repay(ctx, 2, "alice", 10, "USD"); !errors.Is(err, ErrAccountFrozen) {
+		t.Errorf("repayment from a frozen account: err = %v, want ErrAccountFrozen", err)
+	}
+	if err := s.SetUserStatus(ctx, "bob", database.UserStatusActive); err != nil {
+		t.Fatalf("SetUserStatus: %v", err)
+	}
+
+	// Money does not go to a frozen lender either
+	if err := s.SetUserStatus(ctx, "alice", database.UserStatusFrozen); err != nil {
+		t.Fatalf("SetUserStatus: %v", err)
+	}
+	if _, err := s.Repay(ctx, 2, "alice", 10, "USD"); !errors.Is(err, ErrRecipientNotActive) {
+		t.Errorf("repayment to a frozen lender: err = %v, want ErrRecipientNotActive", err)
+	}
+
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 60)
+	if loans, _ := s.ListLoans(ctx, 2); len(loans) != 1 || loans[0].Repaid != 0 {
+		t.Errorf("loans = %+v, want nothing repaid", loans)
+	}
+}
+
+func TestLoanRemindersBeforeAndAfterTheDueDate(t *testing.T) {
+	s, clock, _ := newTestLoans(t)
+	notifier := &reminderNotifier{}
+	s.SetNotifier(notifier)
+	ctx := context.Background()
+
+	due := loanTestStart.Truncate(24*time.Hour).AddDate(0, 0, 5)
+	loan, err := s.Lend(ctx, 1, "bob", 40, "USD", &due)
+	if err != nil {
+		t.Fatalf("Lend: %v", err)
+	}
+	if _, err := s.Lend(ctx, 1, "carol", 10, "USD", nil); err != nil {
+		t.Fatalf("Lend without a due date: %v", err)
+	}
+
+	run := func(at time.Time, want ...loanReminder) {
+		t.Helper()
+		clock.Set(at)
+		notifier.reminders = nil
+		sent, err := s.RunLoanReminders(ctx)
+		if err != nil {
+			t.Fatalf("RunLoanReminders: %v", err)
+		}
+		if sent != len(want) || len(notifier.reminders) != len(want) {
+			t.Fatalf("at %v sent %d reminders %+v, want %+v", at, sent, notifier.reminders, want)
+		}
+		for i := range want {
+			if notifier.reminders[i] != want[i] {
+				t.Errorf("at %v reminder %+v, want %+v", at, notifier.reminders[i], want[i])
+			}
+		}
+	}
+
+	run(loanTestStart)
+	run(due.Add(-defaultLoanRemindBefore), loanReminder{loan.ID, false})
+	run(due.Add(-time.Hour))
+	// The due day itself is not overdue yet
+	run(due.Add(23 * time.Hour))
+	run(due.Add(25*time.Hour), loanReminder{loan.ID, true})
+	run(due.Add(25*time.Hour + defaultLoanRemindEvery - time.Minute))
+	run(due.Add(25*time.Hour+defaultLoanRemindEvery), loanReminder{loan.ID, true})
+
+	if _, err := s.Repay(ctx, 2, "alice", 40, "USD"); err != nil {
+		t.Fatalf("Repay: %v", err)
+	}
+	run(due.Add(25*time.Hour + 3*defaultLoanRemindEvery))
+}
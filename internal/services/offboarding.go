@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// RemoveUser freezes a user account. A user holding money can only be removed
+// when sweepToUsername names an account that receives all remaining balances.
+func (s *coreService) RemoveUser(ctx context.Context, username, sweepToUsername string) error {
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := findUserForUpdate(tx, username)
+		if err != nil {
+			return err
+		}
+		if !user.IsActive() {
+			return fmt.Errorf("user is already %s", user.Status)
+		}
+
+		if hasHeldMoney(user) {
+			return errors.New("user has money on hold in escrow, settle it first")
+		}
+		if hasNonZeroBalance(user) {
+			if sweepToUsername == "" {
+				return errors.New("user has a non-zero balance, specify an account to sweep it to")
+			}
+			target, err := findUserForUpdate(tx, sweepToUsername)
+			if err != nil {
+				return fmt.Errorf("sweep account: %w", err)
+			}
+			if target.ID == user.ID {
+				return errors.New("cannot sweep balance to the removed user")
+			}
+			if !target.IsActive() {
+				return errors.New("sweep account is not active")
+			}
+			if err := sweepBalances(tx, user, target, s.now()); err != nil {
+				return err
+			}
+		}
+
+		before := userSnapshot(user)
+		if err := tx.Model(user).Update("status", database.UserStatusFrozen).Error; err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, tx, AuditRemoveUser, "@"+user.Username, before, userSnapshot(user))
+	})
+}
+
+// RestoreUser reactivates a removed user. Accounts removed by older versions
+// with a soft delete are restored as well.
+func (s *coreService) RestoreUser(ctx context.Context, username string) error {
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := findRemovedUser(tx, username)
+		if err != nil {
+			return err
+		}
+		if user.Status == database.UserStatusClosed {
+			return errors.New("user has been purged and cannot be restored")
+		}
+		if user.IsActive() && !user.DeletedAt.Valid {
+			return errors.New("user is already active")
+		}
+
+		before := userSnapshot(user)
+		if err := tx.Unscoped().Model(user).Updates(map[string]any{
+			"status":     database.UserStatusActive,
+			"deleted_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, tx, AuditRestoreUser, "@"+user.Username, before, userSnapshot(user))
+	})
+}
+
+// PurgeUser anonymises a removed user's personal data. Balances and
+// transactions are kept, so the ledger stays consistent.
+func (s *coreService) PurgeUser(ctx context.Context, username string) error {
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := findRemovedUser(tx, username)
+		if err != nil {
+			return err
+		}
+		if user.IsActive() && !user.DeletedAt.Valid {
+			return errors.New("remove the user before purging")
+		}
+		if hasNonZeroBalance(user) {
+			return errors.New("user has a non-zero balance, restore and remove with a sweep account first")
+		}
+
+		before := userSnapshot(user)
+		username := user.Username
+		anonymous := fmt.Sprintf("deleted-%d", user.ID)
+		if err := tx.Unscoped().Model(user).Updates(map[string]any{
+			"username":   anonymous,
+			"first_name": "",
+			"last_name":  "",
+			// Preferences say where the user lives and which language they speak
+			"language":     "",
+			"timezone":     "",
+			"app_language": "",
+			// Telegram IDs are positive, a negative ID frees the unique index
+			"telegram_id": -int64(user.ID),
+			"status":      database.UserStatusClosed,
+			"deleted_at":  nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&database.UsernameChange{}).Error; err != nil {
+			return err
+		}
+		// Scrub denormalised copies of the username, found by the user's ID
+		// where the row keeps it and by the old username where it does not
+		for _, scrub := range []struct {
+			model  any
+			column string
+			query  string
+			arg    any
+		}{
+			{&database.Transaction{}, "from_username", "from_user_id = ?", user.ID},
+			{&database.Transaction{}, "to_username", "to_user_id = ?", user.ID},
+			{&database.Loan{}, "lender_username", "lender_id = ?", user.ID},
+			{&database.Loan{}, "borrower_username", "borrower_id = ?", user.ID},
+			{&database.Escrow{}, "sender_username", "sender_id = ?", user.ID},
+			{&database.Escrow{}, "recipient_username", "recipient_id = ?", user.ID},
+			{&database.Escrow{}, "resolved_by", "resolved_by = ?", username},
+			{&database.Voucher{}, "created_by_username", "created_by_id = ?", user.ID},
+			{&database.Voucher{}, "redeemed_by_username", "redeemed_by_id = ?", user.ID},
+			{&database.PendingOperation{}, "initiator_username", "initiator_id = ?", user.ID},
+			{&database.PendingOperation{}, "target_username", "target_user_id = ?", user.ID},
+			{&database.Approval{}, "approver_username", "approver_id = ?", user.ID},
+			{&database.Invite{}, "created_by_username", "created_by_id = ?", user.ID},
+			{&database.Webhook{}, "created_by_username", "created_by_username = ?", username},
+		} {
+			if err := tx.Unscoped().Model(scrub.model).
+				Where(scrub.query, scrub.arg).
+				Update(scrub.column, anonymous).Error; err != nil {
+				return err
+			}
+		}
+
+		// The audit entry references the user by ID only
+		return s.recordAudit(ctx, tx, AuditPurgeUser, "@"+anonymous, before["status"], database.UserStatusClosed)
+	})
+}
+
+// findRemovedUser resolves a recipient like resolveRecipient, including
+// accounts removed by older versions with a soft delete
+func findRemovedUser(tx *gorm.DB, recipient string) (*database.User, error) {
+	return resolveRecipient(tx.Unscoped().Session(&gorm.Session{}), recipient)
+}
+
+func hasNonZeroBalance(user *database.User) bool {
+	for _, balance := range user.Accounts {
+		if balance.Amount != 0 || balance.Held != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasHeldMoney reports whether any balance of the user is on hold in escrow
+func hasHeldMoney(user *database.User) bool {
+	for _, balance := range user.Accounts {
+		if balance.Held != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sweepBalances moves every non-zero balance of from to the matching balance
+// of to, creating it when needed, and records the movements as transactions.
+func sweepBalances(tx *gorm.DB, from, to *database.User, now time.Time) error {
+	for i := range from.Accounts {
+		fromBalance := &from.Accounts[i]
+		amount := fromBalance.Amount
+		if amount == 0 {
+			continue
+		}
+
+		// Pots are swept into the main balance of the same currency
+		var toBalance *database.Balance
+		for j := range to.Accounts {
+			if to.Accounts[j].CurrencyID == fromBalance.CurrencyID && !to.Accounts[j].IsPot() {
+				toBalance = &to.Accounts[j]
+				break
+			}
+		}
+		if toBalance == nil {
+			balance := database.Balance{UserID: to.ID, CurrencyID: fromBalance.CurrencyID}
+			if err := tx.Create(&balance).Error; err != nil {
+				return err
+			}
+			to.Accounts = append(to.Accounts, balance)
+			toBalance = &to.Accounts[len(to.Accounts)-1]
+		}
+
+		fromBalance.Amount = 0
+		toBalance.Amount += amount
+		if err := tx.Save(fromBalance).Error; err != nil {
+			return err
+		}
+		if err := tx.Save(toBalance).Error; err != nil {
+			return err
+		}
+
+		sweepOut := database.Transaction{
+			UserID:       from.ID,
+			BalanceID:    fromBalance.ID,
+			Amount:       -amount,
+			Type:         "sweep_out",
+			FromUserID:   from.ID,
+			FromUsername: from.Username,
+			ToUserID:     to.ID,
+			ToUsername:   to.Username,
+			Timestamp:    now,
+			BalanceAfter: fromBalance.Amount,
+		}
+		sweepIn := database.Transaction{
+			UserID:       to.ID,
+			BalanceID:    toBalance.ID,
+			Amount:       amount,
+			Type:         "sweep_in",
+			FromUserID:   from.ID,
+			FromUsername: from.Username,
+			ToUserID:     to.ID,
+			ToUsername:   to.Username,
+			Timestamp:    now,
+			BalanceAfter: toBalance.Amount,
+		}
+		if err := tx.Create(&sweepOut).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&sweepIn).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var offboardingTestStart = time.Date(2025, 4, 2, 9, 30, 0, 0, time.UTC)
+
+func TestRemoveUserSweepsBalancesAndPots(t *testing.T) {
+	s, clock := newTestService(t, offboardingTestStart)
+	usd := createCurrency(t, s, "USD")
+	eur := createCurrency(t, s, "EUR")
+	alice := createUser(t, s, 1, "alice", usd, 100, offboardingTestStart)
+	createUser(t, s, 2, "bob", usd, 5, offboardingTestStart)
+	if err := s.db.Conn.Create(&database.Balance{UserID: alice.ID, CurrencyID: eur.ID, Amount: 7}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := s.CreatePot(ctx, 1, "trip", "USD", 0, nil); err != nil {
+		t.Fatalf("CreatePot: %v", err)
+	}
+	if err := s.MoveBetweenPots(ctx, 1, 30, "USD", MainPot, "trip"); err != nil {
+		t.Fatalf("MoveBetweenPots: %v", err)
+	}
+
+	if err := s.RemoveUser(ctx, "alice", ""); err == nil {
+		t.Fatal("RemoveUser without a sweep account succeeded for a user holding money")
+	}
+	if err := s.RemoveUser(ctx, "alice", "alice"); err == nil {
+		t.Fatal("RemoveUser swept the balances to the removed user")
+	}
+
+	sweptAt := offboardingTestStart.Add(time.Hour)
+	clock.Set(sweptAt)
+	if err := s.RemoveUser(ctx, "alice", "BOB"); err != nil {
+		t.Fatalf("RemoveUser: %v", err)
+	}
+
+	assertAmount(t, "bob USD", balanceOf(t, s, 2, "USD"), 105)
+	assertAmount(t, "bob EUR", balanceOf(t, s, 2, "EUR"), 7)
+	assertAmount(t, "total USD", totalMoney(t, s, usd), 105)
+	assertAmount(t, "total EUR", totalMoney(t, s, eur), 7)
+
+	var user database.User
+	if err := s.db.Conn.Preload("Accounts").First(&user, alice.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Status != database.UserStatusFrozen {
+		t.Errorf("status = %q, want %q", user.Status, database.UserStatusFrozen)
+	}
+	if hasNonZeroBalance(&user) {
+		t.Errorf("removed user still holds money: %+v", user.Accounts)
+	}
+
+	var sweeps []database.Transaction
+	if err := s.db.Conn.Where("type IN ?", []string{"sweep_out", "sweep_in"}).Find(&sweeps).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Main balance and pot in USD plus the EUR balance, each out and in
+	if len(sweeps) != 6 {
+		t.Fatalf("got %d sweep transactions, want 6", len(sweeps))
+	}
+	for _, sweep := range sweeps {
+		if !sweep.Timestamp.Equal(sweptAt) {
+			t.Errorf("sweep %d at %v, want %v", sweep.ID, sweep.Timestamp, sweptAt)
+		}
+	}
+}
+
+func TestRemoveUserRefusesMoneyOnHold(t *testing.T) {
+	s, _ := newTestService(t, offboardingTestStart)
+	usd := createCurrency(t, s, "USD")
+	alice := createUser(t, s, 1, "alice", usd, 10, offboardingTestStart)
+	createUser(t, s, 2, "bob", usd, 0, offboardingTestStart)
+	if err := s.db.Conn.Model(&database.Balance{}).Where("user_id = ?", alice.ID).Update("held", 5).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RemoveUser(context.Background(), "alice", "bob"); err == nil {
+		t.Fatal("RemoveUser succeeded for a user with money on hold")
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 10)
+}
+
+func TestRestoreAndPurgeMatchUsernamesLikeTelegram(t *testing.T) {
+	s, _ := newTestService(t, offboardingTestStart)
+	usd := createCurrency(t, s, "USD")
+	alice := createUser(t, s, 1, "Alice", usd, 0, offboardingTestStart)
+
+	ctx := context.Background()
+	if err := s.RemoveUser(ctx, "alice", ""); err != nil {
+		t.Fatalf("RemoveUser: %v", err)
+	}
+	if err := s.RestoreUser(ctx, "@ALICE"); err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	if err := s.RestoreUser(ctx, "alice"); err == nil {
+		t.Error("RestoreUser succeeded for an active user")
+	}
+	if err := s.PurgeUser(ctx, "alice"); err == nil {
+		t.Fatal("PurgeUser succeeded for an active user")
+	}
+
+	if err := s.RemoveUser(ctx, "1", ""); err != nil {
+		t.Fatalf("RemoveUser by Telegram ID: %v", err)
+	}
+	if err := s.PurgeUser(ctx, "aLiCe"); err != nil {
+		t.Fatalf("PurgeUser: %v", err)
+	}
+
+	var user database.User
+	if err := s.db.Conn.Unscoped().First(&user, alice.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Status != database.UserStatusClosed || user.Username == "Alice" || user.TelegramID > 0 {
+		t.Errorf("purged user = %+v, want closed and anonymous", user)
+	}
+	if err := s.RestoreUser(ctx, "alice"); err == nil {
+		t.Error("RestoreUser found a purged user by the old username")
+	}
+}
+
+func TestRestoreUserFindsSoftDeletedAccounts(t *testing.T) {
+	s, _ := newTestService(t, offboardingTestStart)
+	usd := createCurrency(t, s, "USD")
+	alice := createUser(t, s, 1, "alice", usd, 0, offboardingTestStart)
+	if err := s.db.Conn.Delete(alice).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RestoreUser(context.Background(), "Alice"); err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	user, err := findUserByTelegramID(s.db.Conn, 1)
+	if err != nil {
+		t.Fatalf("restored user not found: %v", err)
+	}
+	if !user.IsActive() {
+		t.Errorf("status = %q, want active", user.Status)
+	}
+}
+
+func TestPurgeUserScrubsEveryCopyOfTheUsername(t *testing.T) {
+	s, _ := newTestService(t, offboardingTestStart)
+	usd := createCurrency(t, s, "USD")
+	alice := createUser(t, s, 1, "alice", usd, 0, offboardingTestStart)
+	bob := createUser(t, s, 2, "bob", usd, 0, offboardingTestStart)
+	if err := s.db.Conn.Model(alice).Updates(map[string]any{
+		"language": "ru", "timezone": "Europe/Berlin", "app_language": "ru",
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	operation := &database.PendingOperation{InitiatorID: alice.ID, InitiatorUsername: "alice", TargetUserID: bob.ID, TargetUsername: "bob"}
+	rows := []any{
+		&database.Transaction{UserID: alice.ID, FromUserID: alice.ID, FromUsername: "alice", ToUserID: bob.ID, ToUsername: "bob"},
+		&database.Transaction{UserID: bob.ID, FromUserID: bob.ID, FromUsername: "bob", ToUserID: alice.ID, ToUsername: "alice"},
+		&database.Loan{LenderID: alice.ID, LenderUsername: "alice", BorrowerID: bob.ID, BorrowerUsername: "bob"},
+		&database.Loan{LenderID: bob.ID, LenderUsername: "bob", BorrowerID: alice.ID, BorrowerUsername: "alice"},
+		&database.Escrow{SenderID: alice.ID, SenderUsername: "alice", RecipientID: bob.ID, RecipientUsername: "bob"},
+		&database.Escrow{SenderID: bob.ID, SenderUsername: "bob", RecipientID: alice.ID, RecipientUsername: "alice", ResolvedBy: "alice"},
+		&database.Voucher{Code: "ALICE1", CreatedByID: alice.ID, CreatedByUsername: "alice"},
+		&database.Voucher{Code: "BOB1", CreatedByID: bob.ID, CreatedByUsername: "bob", RedeemedByID: alice.ID, RedeemedByUsername: "alice"},
+		operation,
+		&database.PendingOperation{InitiatorID: bob.ID, InitiatorUsername: "bob", TargetUserID: alice.ID, TargetUsername: "alice"},
+		&database.Invite{Code: "ALICEINV", CreatedByID: alice.ID, CreatedByUsername: "alice"},
+		&database.Webhook{URL: "https://example.com/hook", CreatedByUsername: "alice"},
+		&database.UsernameChange{UserID: alice.ID, OldUsername: "alice_old", NewUsername: "alice"},
+	}
+	for _, row := range rows {
+		if err := s.db.Conn.Create(row).Error; err != nil {
+			t.Fatalf("create %T: %v", row, err)
+		}
+	}
+	if err := s.db.Conn.Create(&database.Approval{PendingOperationID: operation.ID, ApproverID: alice.ID, ApproverUsername: "alice"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// A soft-deleted row is scrubbed as well
+	if err := s.db.Conn.Delete(&database.Loan{}, "lender_id = ?", alice.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := s.RemoveUser(ctx, "alice", ""); err != nil {
+		t.Fatalf("RemoveUser: %v", err)
+	}
+	if err := s.PurgeUser(ctx, "alice"); err != nil {
+		t.Fatalf("PurgeUser: %v", err)
+	}
+
+	var user database.User
+	if err := s.db.Conn.Unscoped().First(&user, alice.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Language != "" || user.Timezone != "" || user.AppLanguage != "" {
+		t.Errorf("purged user kept preferences %q, %q, %q", user.Language, user.Timezone, user.AppLanguage)
+	}
+
+	for _, scrub := range []struct {
+		table  string
+		column string
+		want   int64 // rows of Bob that must keep his username
+	}{
+		{"transactions", "from_username", 1},
+		{"transactions", "to_username", 1},
+		{"loans", "lender_username", 1},
+		{"loans", "borrower_username", 1},
+		{"escrows", "sender_username", 1},
+		{"escrows", "recipient_username", 1},
+		{"escrows", "resolved_by", 0},
+		{"vouchers", "created_by_username", 1},
+		{"vouchers", "redeemed_by_username", 0},
+		{"pending_operations", "initiator_username", 1},
+		{"pending_operations", "target_username", 1},
+		{"approvals", "approver_username", 0},
+		{"invites", "created_by_username", 0},
+		{"webhooks", "created_by_username", 0},
+	} {
+		var left, kept int64
+		query := "SELECT COUNT(*) FROM " + scrub.table + " WHERE " + scrub.column + " = ?"
+		if err := s.db.Conn.Raw(query, "alice").Scan(&left).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := s.db.Conn.Raw(query, "bob").Scan(&kept).Error; err != nil {
+			t.Fatal(err)
+		}
+		if left != 0 {
+			t.Errorf("%s.%s still names alice in %d rows", scrub.table, scrub.column, left)
+		}
+		if kept != scrub.want {
+			t.Errorf("%s.%s names bob in %d rows, want %d", scrub.table, scrub.column, kept, scrub.want)
+		}
+	}
+
+	var changes int64
+	if err := s.db.Conn.Model(&database.UsernameChange{}).Where("user_id = ?", alice.ID).Count(&changes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if changes != 0 {
+		t.Errorf("%d username changes of the purged user left", changes)
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// MainPot names the main balance in pot commands
+const MainPot = "main"
+
+var potNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,32}$`)
+
+// normalizePotName lowercases a pot name, mapping "main" to the main balance
+func normalizePotName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == MainPot {
+		return "", nil
+	}
+	if !potNamePattern.MatchString(name) {
+		return "", ErrInvalidPotName
+	}
+	return name, nil
+}
+
+// ListPots returns the savings pots of a user, without the main balances
+func (s *coreService) ListPots(ctx context.Context, telegramID int64) ([]database.Balance, error) {
+	user, err := s.userService.GetUser(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+	var pots []database.Balance
+	for _, balance := range user.Accounts {
+		if balance.IsPot() {
+			pots = append(pots, balance)
+		}
+	}
+	return pots, nil
+}
+
+// CreatePot opens an empty savings pot in one currency with an optional goal
+// and deadline
+func (s *coreService) CreatePot(ctx context.Context, telegramID int64, name, currencyCode string, goal float64, deadline *time.Time) (*database.Balance, error) {
+	pot, err := normalizePotName(name)
+	if err != nil {
+		return nil, err
+	}
+	if pot == "" {
+		return nil, fmt.Errorf("%w: %q is reserved for the main balance", ErrInvalidPotName, MainPot)
+	}
+	if goal < 0 || !isFinite(goal) {
+		return nil, fmt.Errorf("goal must not be negative")
+	}
+
+	var balance *database.Balance
+	err = s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := findUserByTelegramID(tx, telegramID)
+		if err != nil {
+			return err
+		}
+		if !user.IsActive() {
+			return ErrAccountFrozen
+		}
+		if findPot(user, pot) != nil {
+			return ErrPotExists
+		}
+		main := findBalance(user, currencyCode)
+		if main == nil {
+			return ErrCurrencyNotSupported
+		}
+
+		balance = &database.Balance{
+			UserID:     user.ID,
+			CurrencyID: main.CurrencyID,
+			Currency:   main.Currency,
+			Pot:        pot,
+			Goal:       goal,
+			Deadline:   deadline,
+		}
+		return tx.Create(balance).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+// MoveBetweenPots moves money between two balances of the same user, one of
+// them may be the main balance. Both sides are recorded as transactions.
+func (s *coreService) MoveBetweenPots(ctx context.Context, telegramID int64, amount float64, currencyCode, fromPot, toPot string) error {
+	if err := validateAmount(amount); err != nil {
+		return err
+	}
+	from, err := normalizePotName(fromPot)
+	if err != nil {
+		return err
+	}
+	to, err := normalizePotName(toPot)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return fmt.Errorf("cannot move money to the same pot")
+	}
+
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := findUserByTelegramID(tx, telegramID)
+		if err != nil {
+			return err
+		}
+		if !user.IsActive() {
+			return ErrAccountFrozen
+		}
+		fromBalance, err := findUserBalance(user, from, currencyCode)
+		if err != nil {
+			return err
+		}
+		toBalance, err := findUserBalance(user, to, currencyCode)
+		if err != nil {
+			return err
+		}
+		if fromBalance.Amount < amount {
+			return ErrInsufficientBalance
+		}
+
+		fromBalance.Amount -= amount
+		toBalance.Amount += amount
+		if err := tx.Save(fromBalance).Error; err != nil {
+			return err
+		}
+		if err := tx.Save(toBalance).Error; err != nil {
+			return err
+		}
+
+		now := s.now()
+		potOut := database.Transaction{
+			UserID:       user.ID,
+			BalanceID:    fromBalance.ID,
+			Amount:       -amount,
+			Type:         "pot_out",
+			FromUserID:   user.ID,
+			FromUsername: user.Username,
+			ToUserID:     user.ID,
+			ToUsername:   user.Username,
+			Timestamp:    now,
+			BalanceAfter: fromBalance.Amount,
+			Pot:          to,
+		}
+		potIn := database.Transaction{
+			UserID:       user.ID,
+			BalanceID:    toBalance.ID,
+			Amount:       amount,
+			Type:         "pot_in",
+			FromUserID:   user.ID,
+			FromUsername: user.Username,
+			ToUserID:     user.ID,
+			ToUsername:   user.Username,
+			Timestamp:    now,
+			BalanceAfter: toBalance.Amount,
+			Pot:          from,
+		}
+		if err := tx.Create(&potOut).Error; err != nil {
+			return err
+		}
+		return tx.Create(&potIn).Error
+	})
+}
+
+// findPot returns the user's pot with the given normalized name, or nil
+func findPot(user *database.User, pot string) *database.Balance {
+	for i := range user.Accounts {
+		if user.Accounts[i].Pot == pot && user.Accounts[i].IsPot() {
+			return &user.Accounts[i]
+		}
+	}
+	return nil
+}
+
+// findUserBalance returns the main balance or the named pot of a user in
+// the given currency
+func findUserBalance(user *database.User, pot, currencyCode string) (*database.Balance, error) {
+	if pot == "" {
+		if balance := findBalance(user, currencyCode); balance != nil {
+			return balance, nil
+		}
+		return nil, ErrCurrencyNotSupported
+	}
+	balance := findPot(user, pot)
+	if balance == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPotNotFound, pot)
+	}
+	if balance.Currency.Code != currencyCode {
+		return nil, fmt.Errorf("pot %s holds %s, not %s", pot, balance.Currency.Code, currencyCode)
+	}
+	return balance, nil
+}
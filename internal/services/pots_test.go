@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var potTestStart = time.Date(2025, 7, 14, 18, 0, 0, 0, time.UTC)
+
+// newTestPots returns a service where Alice (1) holds 100 USD and 50 EUR and
+// Bob (2) nothing
+func newTestPots(t *testing.T) (*coreService, database.Currency) {
+	t.Helper()
+	s, _ := newTestService(t, potTestStart)
+	usd := createCurrency(t, s, "USD")
+	eur := createCurrency(t, s, "EUR")
+	alice := createUser(t, s, 1, "alice", usd, 100, potTestStart)
+	createUser(t, s, 2, "bob", usd, 0, potTestStart)
+	if err := s.db.Conn.Create(&database.Balance{UserID: alice.ID, CurrencyID: eur.ID, Amount: 50}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return s, usd
+}
+
+// potOf returns a user's pot by name
+func potOf(t *testing.T, s *coreService, telegramID int64, name string) database.Balance {
+	t.Helper()
+	pots, err := s.ListPots(context.Background(), telegramID)
+	if err != nil {
+		t.Fatalf("ListPots: %v", err)
+	}
+	for _, pot := range pots {
+		if pot.Pot == name {
+			return pot
+		}
+	}
+	t.Fatalf("user %d has no pot %s", telegramID, name)
+	return database.Balance{}
+}
+
+func TestMoneyMovesBetweenPotsAndTheBalance(t *testing.T) {
+	s, usd := newTestPots(t)
+	ctx := context.Background()
+
+	if _, err := s.CreatePot(ctx, 1, "Trip", "USD", 0, nil); err != nil {
+		t.Fatalf("CreatePot: %v", err)
+	}
+	if _, err := s.CreatePot(ctx, 1, "house", "USD", 0, nil); err != nil {
+		t.Fatalf("CreatePot: %v", err)
+	}
+	if _, err := s.CreatePot(ctx, 1, "euros", "EUR", 0, nil); err != nil {
+		t.Fatalf("CreatePot: %v", err)
+	}
+
+	if err := s.MoveBetweenPots(ctx, 1, 60, "USD", MainPot, "TRIP"); err != nil {
+		t.Fatalf("MoveBetweenPots to a pot: %v", err)
+	}
+	if err := s.MoveBetweenPots(ctx, 1, 25, "USD", "trip", "house"); err != nil {
+		t.Fatalf("MoveBetweenPots between pots: %v", err)
+	}
+	if err := s.MoveBetweenPots(ctx, 1, 5, "USD", "house", MainPot); err != nil {
+		t.Fatalf("MoveBetweenPots to the balance: %v", err)
+	}
+
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 45)
+	assertAmount(t, "trip", potOf(t, s, 1, "trip").Amount, 35)
+	assertAmount(t, "house", potOf(t, s, 1, "house").Amount, 20)
+	assertAmount(t, "total", totalMoney(t, s, usd), 100)
+
+	var moves []database.Transaction
+	if err := s.db.Conn.Where("type IN ?", []string{"pot_out", "pot_in"}).Order("id").Find(&moves).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 6 {
+		t.Fatalf("got %d pot transactions, want both sides of 3 moves", len(moves))
+	}
+	if moves[0].Pot != "trip" || moves[1].Pot != "" || moves[1].BalanceAfter != 60 {
+		t.Errorf("first move = %+v and %+v, want the sides naming the other pot", moves[0], moves[1])
+	}
+
+	for _, tc := range []struct {
+		name     string
+		from, to string
+		currency string
+		want     error
+	}{
+		{"same pot", "trip", "Trip", "USD", nil},
+		{"unknown pot", MainPot, "car", "USD", ErrPotNotFound},
+		{"invalid name", MainPot, "a b", "USD", ErrInvalidPotName},
+		{"other currency", "euros", MainPot, "USD", nil},
+		{"unknown currency", MainPot, "trip", "GBP", ErrCurrencyNotSupported},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := s.MoveBetweenPots(ctx, 1, 1, tc.currency, tc.from, tc.to)
+			if err == nil || tc.want != nil && !errors.Is(err, tc.want) {
+				t.Errorf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+	assertAmount(t, "alice after failed moves", balanceOf(t, s, 1, "USD"), 45)
+}
+
+func TestPotsNeedTheMoney(t *testing.T) {
+	s, _ := newTestPots(t)
+	ctx := context.Background()
+	if _, err := s.CreatePot(ctx, 1, "trip", "USD", 0, nil); err != nil {
+		t.Fatalf("CreatePot: %v", err)
+	}
+
+	if err := s.MoveBetweenPots(ctx, 1, 100.01, "USD", MainPot, "trip"); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("move above the balance: err = %v, want ErrInsufficientBalance", err)
+	}
+	if err := s.MoveBetweenPots(ctx, 1, 100, "USD", MainPot, "trip"); err != nil {
+		t.Fatalf("MoveBetweenPots: %v", err)
+	}
+	if err := s.MoveBetweenPots(ctx, 1, 1, "USD", MainPot, "trip"); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("move from an empty balance: err = %v, want ErrInsufficientBalance", err)
+	}
+	if err := s.MoveBetweenPots(ctx, 1, 0, "USD", "trip", MainPot); err == nil {
+		t.Error("moved nothing")
+	}
+
+	// Money in a pot cannot be transferred until it is moved back
+	if err := s.TransferMoney(ctx, 1, "bob", 10, "USD"); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("transfer of pot money: err = %v, want ErrInsufficientBalance", err)
+	}
+	if err := s.MoveBetweenPots(ctx, 1, 10, "USD", "trip", MainPot); err != nil {
+		t.Fatalf("MoveBetweenPots: %v", err)
+	}
+	if err := s.TransferMoney(ctx, 1, "bob", 10, "USD"); err != nil {
+		t.Errorf("transfer after moving money back: %v", err)
+	}
+
+	// Frozen accounts keep their pots as they are
+	if err := s.db.Conn.Model(&database.User{}).Where("telegram_id = ?", 1).Update("status", database.UserStatusFrozen).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MoveBetweenPots(ctx, 1, 10, "USD", "trip", MainPot); !errors.Is(err, ErrAccountFrozen) {
+		t.Errorf("move of a frozen account: err = %v, want ErrAccountFrozen", err)
+	}
+	if _, err := s.CreatePot(ctx, 1, "car", "USD", 0, nil); !errors.Is(err, ErrAccountFrozen) {
+		t.Errorf("pot of a frozen account: err = %v, want ErrAccountFrozen", err)
+	}
+}
+
+func TestPotGoalsAndDeadlines(t *testing.T) {
+	s, _ := newTestPots(t)
+	ctx := context.Background()
+	deadline := time.Date(2025, 12, 24, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name     string
+		pot      string
+		currency string
+		goal     float64
+		want     error
+	}{
+		{"reserved name", MainPot, "USD", 0, ErrInvalidPotName},
+		{"name too long", "abcdefghijklmnopqrstuvwxyz0123456", "USD", 0, ErrInvalidPotName},
+		{"negative goal", "car", "USD", -1, nil},
+		{"unknown currency", "car", "GBP", 0, ErrCurrencyNotSupported},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.CreatePot(ctx, 1, tc.pot, tc.currency, tc.goal, nil)
+			if err == nil || tc.want != nil && !errors.Is(err, tc.want) {
+				t.Errorf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	if _, err := s.CreatePot(ctx, 1, "gifts", "USD", 80, &deadline); err != nil {
+		t.Fatalf("CreatePot: %v", err)
+	}
+	if _, err := s.CreatePot(ctx, 1, "Gifts", "EUR", 0, nil); !errors.Is(err, ErrPotExists) {
+		t.Errorf("second pot with the name: err = %v, want ErrPotExists", err)
+	}
+
+	pot := potOf(t, s, 1, "gifts")
+	if pot.Goal != 80 || pot.Deadline == nil || !pot.Deadline.Equal(deadline) {
+		t.Errorf("pot = %+v, want a goal of 80 by %v", pot, deadline)
+	}
+	assertAmount(t, "progress of an empty pot", pot.GoalProgress(), 0)
+
+	if err := s.MoveBetweenPots(ctx, 1, 20, "USD", MainPot, "gifts"); err != nil {
+		t.Fatalf("MoveBetweenPots: %v", err)
+	}
+	pot = potOf(t, s, 1, "gifts")
+	assertAmount(t, "progress", pot.GoalProgress(), 25)
+	if err := s.MoveBetweenPots(ctx, 1, 80, "USD", MainPot, "gifts"); err != nil {
+		t.Fatalf("MoveBetweenPots: %v", err)
+	}
+	pot = potOf(t, s, 1, "gifts")
+	assertAmount(t, "progress above the goal", pot.GoalProgress(), 100)
+
+	if _, err := s.CreatePot(ctx, 1, "rainy-day", "USD", 0, nil); err != nil {
+		t.Fatalf("CreatePot: %v", err)
+	}
+	pot = potOf(t, s, 1, "rainy-day")
+	assertAmount(t, "progress without a goal", pot.GoalProgress(), 0)
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// Profile is what Telegram tells about a user with every update
+type Profile struct {
+	TelegramID   int64
+	Username     string
+	FirstName    string
+	LastName     string
+	LanguageCode string
+}
+
+// SyncProfile stores the Telegram profile of a registered user in all their
+// wallets. Username changes are recorded so that the old username keeps
+// resolving, and a stale copy of the username on another user is dropped,
+// since Telegram usernames are unique.
+func (s *userService) SyncProfile(ctx context.Context, profile Profile) error {
+	return s.db.Conn.WithContext(database.AnyWallet(ctx)).Transaction(func(tx *gorm.DB) error {
+		// Most updates of unregistered users end here, Find keeps them out of the query log
+		var memberships []database.User
+		if err := tx.Where("telegram_id = ?", profile.TelegramID).Find(&memberships).Error; err != nil {
+			return err
+		}
+		if len(memberships) == 0 {
+			return ErrUserNotFound
+		}
+		for i := range memberships {
+			if err := syncMembership(tx, &memberships[i], profile); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// syncMembership stores profile on the user of one wallet using tx
+func syncMembership(tx *gorm.DB, user *database.User, profile Profile) error {
+	if user.Username == profile.Username && user.FirstName == profile.FirstName &&
+		user.LastName == profile.LastName && user.AppLanguage == profile.LanguageCode {
+		return nil
+	}
+
+	if user.Username != profile.Username {
+		if err := recordUsernameChange(tx, user.ID, user.Username, profile.Username); err != nil {
+			return err
+		}
+		if profile.Username != "" {
+			var stale []database.User
+			if err := tx.Where("telegram_id <> ? AND LOWER(username) = LOWER(?)", user.TelegramID, profile.Username).
+				Find(&stale).Error; err != nil {
+				return err
+			}
+			for _, other := range stale {
+				if err := recordUsernameChange(tx, other.ID, other.Username, ""); err != nil {
+					return err
+				}
+				if err := tx.Model(&other).Update("username", "").Error; err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return tx.Model(user).Updates(map[string]any{
+		"username":     profile.Username,
+		"first_name":   profile.FirstName,
+		"last_name":    profile.LastName,
+		"app_language": profile.LanguageCode,
+	}).Error
+}
+
+func recordUsernameChange(tx *gorm.DB, userID uint, oldUsername, newUsername string) error {
+	if oldUsername == "" {
+		return nil
+	}
+	return tx.Create(&database.UsernameChange{UserID: userID, OldUsername: oldUsername, NewUsername: newUsername}).Error
+}
+
+// findRenamedUser loads the user of the wallet tx is limited to who most
+// recently gave up username
+func findRenamedUser(tx *gorm.DB, username string) (*database.User, error) {
+	// Changes are recorded for the users of all wallets, the latest one of a
+	// user in this wallet counts
+	var changes []database.UsernameChange
+	if err := tx.Where("LOWER(old_username) = LOWER(?)", username).
+		Order("id desc").
+		Find(&changes).Error; err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		var user database.User
+		if err := tx.Preload("Accounts.Currency").Limit(1).Find(&user, change.UserID).Error; err != nil {
+			return nil, err
+		}
+		if user.ID != 0 {
+			return &user, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// IsRenamed reports whether recipient named user by a username the user no
+// longer has
+func IsRenamed(recipient string, user *database.User) bool {
+	recipient = strings.TrimPrefix(strings.TrimSpace(recipient), "@")
+	if _, err := strconv.ParseInt(recipient, 10, 64); err == nil {
+		return false
+	}
+	return !strings.EqualFold(recipient, user.Username)
+}
+
+// withCurrentNames replaces the usernames stored with transactions by the
+// current ones of the users involved, the stored ones are only kept for
+// users that no longer exist
+func withCurrentNames(tx *gorm.DB, transactions []database.Transaction) error {
+	ids := make(map[uint]bool)
+	for _, t := range transactions {
+		ids[t.FromUserID] = true
+		ids[t.ToUserID] = true
+	}
+	delete(ids, 0)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	userIDs := make([]uint, 0, len(ids))
+	for id := range ids {
+		userIDs = append(userIDs, id)
+	}
+	var users []database.User
+	if err := tx.Unscoped().Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return err
+	}
+	names := make(map[uint]string, len(users))
+	for _, user := range users {
+		switch {
+		case user.Username != "":
+			names[user.ID] = user.Username
+		case user.FirstName != "":
+			names[user.ID] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		}
+	}
+
+	for i := range transactions {
+		if name, ok := names[transactions[i].FromUserID]; ok {
+			transactions[i].FromUsername = name
+		}
+		if name, ok := names[transactions[i].ToUserID]; ok {
+			transactions[i].ToUsername = name
+		}
+	}
+	return nil
+}
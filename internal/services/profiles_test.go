@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var profileTestStart = time.Date(2025, 10, 13, 16, 0, 0, 0, time.UTC)
+
+// usernameChanges returns the recorded username changes, the oldest first
+func usernameChanges(t *testing.T, s *coreService) []database.UsernameChange {
+	t.Helper()
+	var changes []database.UsernameChange
+	if err := s.db.Conn.Order("id").Find(&changes).Error; err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func TestProfileSyncRecordsUsernameChanges(t *testing.T) {
+	s, _ := newTestService(t, profileTestStart)
+	usd := createCurrency(t, s, "USD")
+	alice := createUser(t, s, 1, "alice", usd, 100, profileTestStart)
+	createUser(t, s, 2, "bob", usd, 0, profileTestStart)
+	users := s.userService
+	ctx := context.Background()
+
+	if err := users.SyncProfile(ctx, Profile{TelegramID: 9, Username: "nobody"}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("profile of an unregistered user: err = %v, want ErrUserNotFound", err)
+	}
+	if err := users.SyncProfile(ctx, Profile{TelegramID: 1, Username: "alice", FirstName: "Alice", LanguageCode: "de"}); err != nil {
+		t.Fatalf("SyncProfile: %v", err)
+	}
+	if changes := usernameChanges(t, s); len(changes) != 0 {
+		t.Errorf("a profile with the same username recorded changes %+v", changes)
+	}
+
+	for _, username := range []string{"alice_smith", "Alice_Smith", ""} {
+		if err := users.SyncProfile(ctx, Profile{TelegramID: 1, Username: username}); err != nil {
+			t.Fatalf("SyncProfile: %v", err)
+		}
+	}
+	changes := usernameChanges(t, s)
+	want := [][2]string{{"alice", "alice_smith"}, {"alice_smith", "Alice_Smith"}, {"Alice_Smith", ""}}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %+v, want %v", changes, want)
+	}
+	for i, change := range changes {
+		if change.UserID != alice.ID || change.OldUsername != want[i][0] || change.NewUsername != want[i][1] {
+			t.Errorf("change %d = %s -> %s of user %d, want %s -> %s of user %d",
+				i, change.OldUsername, change.NewUsername, change.UserID, want[i][0], want[i][1], alice.ID)
+		}
+	}
+
+	user, err := users.GetUser(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.Username != "" || user.FirstName != "" || user.AppLanguage != "" {
+		t.Errorf("user = %+v, want the last profile stored", user)
+	}
+}
+
+func TestRecipientsAreFoundByOldUsernames(t *testing.T) {
+	s, _ := newTestService(t, profileTestStart)
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 100, profileTestStart)
+	createUser(t, s, 2, "bob", usd, 0, profileTestStart)
+	createUser(t, s, 3, "carol", usd, 0, profileTestStart)
+	users := s.userService
+	ctx := context.Background()
+
+	sync := func(telegramID int64, username string) {
+		t.Helper()
+		if err := users.SyncProfile(ctx, Profile{TelegramID: telegramID, Username: username}); err != nil {
+			t.Fatalf("SyncProfile: %v", err)
+		}
+	}
+	resolve := func(recipient string, want int64) {
+		t.Helper()
+		user, err := s.ResolveRecipient(ctx, recipient)
+		if err != nil {
+			t.Fatalf("ResolveRecipient(%q): %v", recipient, err)
+		}
+		if user.TelegramID != want {
+			t.Errorf("ResolveRecipient(%q) = user %d, want %d", recipient, user.TelegramID, want)
+		}
+		if IsRenamed(recipient, user) == strings.EqualFold(user.Username, strings.TrimPrefix(recipient, "@")) {
+			t.Errorf("IsRenamed(%q, @%s) = %v", recipient, user.Username, IsRenamed(recipient, user))
+		}
+	}
+
+	sync(2, "robert")
+	sync(2, "rob")
+	resolve("bob", 2)
+	resolve("BOB", 2)
+	resolve("robert", 2)
+	resolve("rob", 2)
+	if err := s.TransferMoney(ctx, 1, "@bob", 10, "USD"); err != nil {
+		t.Fatalf("transfer to an old username: %v", err)
+	}
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 10)
+
+	// Carol takes the old username, it is hers from now on
+	sync(3, "bob")
+	resolve("bob", 3)
+	resolve("carol", 3)
+
+	// Alice's stale copy of a username Carol took is dropped and recorded
+	if err := s.db.Conn.Model(&database.User{}).Where("telegram_id = ?", 1).Update("username", "dave").Error; err != nil {
+		t.Fatal(err)
+	}
+	sync(3, "Dave")
+	resolve("dave", 3)
+	resolve("bob", 3)
+	user, err := users.GetUser(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.Username != "" {
+		t.Errorf("alice kept the stale username %q", user.Username)
+	}
+	changes := usernameChanges(t, s)
+	if last := changes[len(changes)-1]; last.UserID != user.ID || last.OldUsername != "dave" || last.NewUsername != "" {
+		t.Errorf("change = %+v, want alice's stale username recorded", last)
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// ResolveRecipient finds the user a recipient refers to, see resolveRecipient
+func (s *coreService) ResolveRecipient(ctx context.Context, recipient string) (*database.User, error) {
+	return resolveRecipient(s.db.Conn.WithContext(ctx), recipient)
+}
+
+// resolveRecipient finds a non-deleted user with balances inside tx. The
+// recipient is a numeric Telegram ID or a username with or without the
+// leading @, matched regardless of case like Telegram does. A username no
+// user has anymore resolves to the user who had it last. Usernames are
+// ambiguous when stale copies differ only in case, those users can still be
+// named by Telegram ID.
+func resolveRecipient(tx *gorm.DB, recipient string) (*database.User, error) {
+	recipient = strings.TrimPrefix(strings.TrimSpace(recipient), "@")
+	if recipient == "" {
+		return nil, ErrUserNotFound
+	}
+
+	// Telegram usernames never start with a digit
+	if telegramID, err := strconv.ParseInt(recipient, 10, 64); err == nil {
+		if telegramID <= 0 {
+			return nil, ErrUserNotFound
+		}
+		return findUserByTelegramID(tx, telegramID)
+	}
+
+	var users []database.User
+	if err := tx.Preload("Accounts.Currency").
+		Where("LOWER(username) = LOWER(?)", recipient).
+		Limit(2).
+		Find(&users).Error; err != nil {
+		return nil, err
+	}
+	switch len(users) {
+	case 0:
+		return findRenamedUser(tx, recipient)
+	case 1:
+		return &users[0], nil
+	default:
+		return nil, ErrAmbiguousRecipient
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var recipientTestStart = time.Date(2025, 9, 22, 8, 0, 0, 0, time.UTC)
+
+func TestResolveRecipient(t *testing.T) {
+	s, _ := newTestService(t, recipientTestStart)
+	usd := createCurrency(t, s, "USD")
+	alice := createUser(t, s, 1001, "Alice", usd, 0, recipientTestStart)
+	createUser(t, s, 1002, "bob", usd, 0, recipientTestStart)
+	createUser(t, s, 1003, "BOB", usd, 0, recipientTestStart)
+	dave := createUser(t, s, 1004, "", usd, 0, recipientTestStart)
+	erin := createUser(t, s, 1005, "erin", usd, 0, recipientTestStart)
+	if err := s.db.Conn.Delete(erin).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// A user of another wallet with the same name does not collide
+	wallet := database.Wallet{Name: "Smiths"}
+	if err := s.db.Conn.Create(&wallet).Error; err != nil {
+		t.Fatal(err)
+	}
+	other, _ := inWallet(t, s, wallet.ID)
+	createUser(t, other, 2001, "alice", usd, 0, recipientTestStart)
+
+	for _, tc := range []struct {
+		name      string
+		recipient string
+		want      *database.User
+		err       error
+	}{
+		{"username", "Alice", alice, nil},
+		{"username in another case", "aLICE", alice, nil},
+		{"username with @ and spaces", "  @alice ", alice, nil},
+		{"Telegram ID", "1001", alice, nil},
+		{"Telegram ID of a user without a username", "1004", dave, nil},
+		{"usernames differing only in case", "Bob", nil, ErrAmbiguousRecipient},
+		{"colliding usernames by Telegram ID", "1003", &database.User{TelegramID: 1003}, nil},
+		{"unknown username", "carol", nil, ErrUserNotFound},
+		{"unknown Telegram ID", "9999", nil, ErrUserNotFound},
+		{"Telegram ID of another wallet", "2001", nil, ErrUserNotFound},
+		{"negative Telegram ID", "-1001", nil, ErrUserNotFound},
+		{"zero", "0", nil, ErrUserNotFound},
+		{"empty", " @ ", nil, ErrUserNotFound},
+		{"deleted user", "erin", nil, ErrUserNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			user, err := s.ResolveRecipient(context.Background(), tc.recipient)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Errorf("err = %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveRecipient: %v", err)
+			}
+			if user.TelegramID != tc.want.TelegramID {
+				t.Errorf("resolved user %d, want %d", user.TelegramID, tc.want.TelegramID)
+			}
+			if len(user.Accounts) != 1 || user.Accounts[0].Currency.Code != "USD" {
+				t.Errorf("accounts = %+v, want the USD balance with its currency", user.Accounts)
+			}
+		})
+	}
+}
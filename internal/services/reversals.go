@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// refundableTransfers limits how many incoming transfers ListRefundable returns
+const refundableTransfers = 10
+
+// Reversal is the result of refunding a transfer
+type Reversal struct {
+	Original  database.Transaction // incoming side of the refunded transfer
+	Amount    float64              // refunded by this reversal
+	Currency  database.Currency
+	Fee       float64       // part of the transfer fee given back to the sender
+	Overdraft float64       // how far an admin reversal took the recipient below zero
+	Sender    database.User // gets the money back
+	Recipient database.User // gives the money back
+	ByAdmin   bool          // an admin reversed the transfer, not the recipient
+}
+
+// ReverseTransaction refunds a transfer by moving money from the recipient
+// back to the sender, together with the matching part of the transfer fee.
+// Recipients may refund transfers they received, admins may reverse any
+// transfer. When the recipient cannot cover an admin reversal their balance
+// goes below zero, which is audited and reported as the overdraft. A zero
+// amount refunds whatever is left of the transfer. The original entries are
+// kept and the refund is recorded as new entries linked to them.
+func (s *coreService) ReverseTransaction(ctx context.Context, telegramID int64, transactionID uint, amount float64) (*Reversal, error) {
+	if amount < 0 || !isFinite(amount) {
+		return nil, ErrInvalidAmount
+	}
+	isAdmin := s.userService.IsAdmin(ctx, telegramID)
+
+	reversal := &Reversal{ByAdmin: isAdmin}
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		caller, err := findUserByTelegramID(tx, telegramID)
+		if err != nil {
+			return err
+		}
+		in, out, err := findTransfer(tx, transactionID)
+		if err != nil {
+			return err
+		}
+		if !isAdmin && in.UserID != caller.ID {
+			return ErrTransactionNotFound
+		}
+		// Transactions reach their wallet through their users, those of
+		// other wallets are not found
+		recipient, recipientBalance, err := transferSide(tx, in)
+		if err != nil {
+			return err
+		}
+		sender, senderBalance, err := transferSide(tx, out)
+		if err != nil {
+			return err
+		}
+
+		left := roundCents(in.Amount - in.Reversed)
+		if left <= 0 {
+			return ErrAlreadyReversed
+		}
+		if amount == 0 {
+			amount = left
+		}
+		if amount > left {
+			return fmt.Errorf("%w: %.2f can still be refunded", ErrReversalTooLarge, left)
+		}
+
+		// Claim the amount with a conditional update first, so that refunds
+		// running at the same time never give back more than was sent
+		result := tx.Model(&database.Transaction{}).
+			Where("id IN ? AND reversed + ? <= ?", []uint{in.ID, out.ID}, amount, in.Amount+0.005).
+			Update("reversed", gorm.Expr("reversed + ?", amount))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 2 {
+			return ErrAlreadyReversed
+		}
+		reversedBefore := in.Reversed
+		in.Reversed = roundCents(in.Reversed + amount)
+
+		if !isAdmin {
+			if !recipient.IsActive() {
+				return ErrAccountFrozen
+			}
+			if recipientBalance.Amount < amount {
+				return ErrInsufficientBalance
+			}
+		}
+		if short := amount - recipientBalance.Amount; short > 0 {
+			reversal.Overdraft = roundCents(min(short, amount))
+		}
+
+		now := s.now()
+		link := func(t *database.Transaction) {
+			t.ReversalOf = in.ID
+			if t.UserID == sender.ID {
+				t.ReversalOf = out.ID
+			}
+		}
+		if err := moveMoney(tx, recipient, recipientBalance, sender, senderBalance, amount, "reversal_out", "reversal_in", now, link); err != nil {
+			return err
+		}
+
+		// The fee goes back in proportion to the refunded part, computed from
+		// the totals so that partial refunds add up to the whole fee
+		reversal.Fee = roundCents(out.Fee*in.Reversed/in.Amount) - roundCents(out.Fee*reversedBefore/in.Amount)
+		if reversal.Fee > 0 {
+			system, err := systemAccount(tx)
+			if err != nil {
+				return err
+			}
+			systemBalance, err := systemBalance(tx, system, senderBalance.Currency)
+			if err != nil {
+				return err
+			}
+			linkFee := func(t *database.Transaction) { t.ReversalOf = out.ID }
+			if err := moveMoney(tx, system, systemBalance, sender, senderBalance, reversal.Fee, "fee_refund_out", "fee_refund_in", now, linkFee); err != nil {
+				return err
+			}
+		}
+
+		if isAdmin {
+			after := map[string]any{"amount": amount, "fee": reversal.Fee, "currency": in.Balance.Currency.Code, "from": recipient.Username, "to": sender.Username}
+			if reversal.Overdraft > 0 {
+				after["overdraft"] = reversal.Overdraft
+				after["recipient_balance"] = recipientBalance.Amount
+			}
+			if err := s.recordAudit(ctx, tx, AuditReverseTransaction, fmt.Sprintf("transaction:%d", in.ID), nil, after); err != nil {
+				return err
+			}
+		}
+
+		reversal.Original = *in
+		reversal.Amount = amount
+		reversal.Currency = in.Balance.Currency
+		reversal.Sender = *sender
+		reversal.Recipient = *recipient
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.notifier != nil {
+		s.notifier.NotifyReversal(ctx, reversal)
+	}
+	return reversal, nil
+}
+
+// findTransfer loads both sides of the transfer a transaction belongs to
+func findTransfer(tx *gorm.DB, transactionID uint) (in, out *database.Transaction, err error) {
+	var t database.Transaction
+	if err := tx.Preload("Balance.Currency").First(&t, transactionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrTransactionNotFound
+		}
+		return nil, nil, err
+	}
+
+	otherType := "transfer_out"
+	switch t.Type {
+	case "transfer_in":
+	case "transfer_out":
+		otherType = "transfer_in"
+	default:
+		return nil, nil, ErrNotReversible
+	}
+
+	// Both sides of a transfer are written together by moveMoney, which
+	// links them to each other
+	missing := fmt.Errorf("transaction #%d: other side of the transfer is missing", t.ID)
+	if t.CounterpartID == 0 {
+		return nil, nil, missing
+	}
+	var other database.Transaction
+	if err := tx.Preload("Balance.Currency").
+		Where("type = ? AND counterpart_id = ?", otherType, t.ID).
+		First(&other, t.CounterpartID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, missing
+		}
+		return nil, nil, err
+	}
+
+	if t.Type == "transfer_in" {
+		return &t, &other, nil
+	}
+	return &other, &t, nil
+}
+
+// transferSide loads the user and balance a transaction was booked on
+func transferSide(tx *gorm.DB, t *database.Transaction) (*database.User, *database.Balance, error) {
+	var user database.User
+	if err := tx.First(&user, t.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrTransactionNotFound
+		}
+		return nil, nil, err
+	}
+	var balance database.Balance
+	if err := tx.Preload("Currency").First(&balance, t.BalanceID).Error; err != nil {
+		return nil, nil, err
+	}
+	return &user, &balance, nil
+}
+
+// ListRefundable returns the latest incoming transfers of a user that are not
+// fully refunded yet, the newest first
+func (s *coreService) ListRefundable(ctx context.Context, telegramID int64) ([]database.Transaction, error) {
+	user, err := s.userService.GetUser(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions []database.Transaction
+	err = s.db.Conn.WithContext(ctx).
+		Preload("Balance.Currency").
+		Where("user_id = ? AND type = ? AND reversed < amount - 0.005", user.ID, "transfer_in").
+		Order("timestamp desc").
+		Limit(refundableTransfers).
+		Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := withCurrentNames(s.db.Conn.WithContext(ctx), transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+var reversalTestStart = time.Date(2025, 11, 3, 14, 0, 0, 0, time.UTC)
+
+// newTestReversals returns a service where Alice (1) holds 200 USD, Bob (2)
+// and Carol (3) nothing, and Dave (4) is an admin
+func newTestReversals(t *testing.T) (*coreService, database.Currency) {
+	t.Helper()
+	s, _ := newTestService(t, reversalTestStart)
+	usd := createCurrency(t, s, "USD")
+	createUser(t, s, 1, "alice", usd, 200, reversalTestStart)
+	createUser(t, s, 2, "bob", usd, 0, reversalTestStart)
+	createUser(t, s, 3, "carol", usd, 0, reversalTestStart)
+	admin := createUser(t, s, 4, "dave", usd, 0, reversalTestStart)
+	s.db.Conn.Model(admin).Update("is_admin", true)
+	return s, usd
+}
+
+// transferSides returns both sides of the transfers between two users, the
+// oldest first
+func transferSides(t *testing.T, s *coreService, from, to int64) (out, in []database.Transaction) {
+	t.Helper()
+	fromUser, err := findUserByTelegramID(s.db.Conn, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toUser, err := findUserByTelegramID(s.db.Conn, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := s.db.Conn.Where("from_user_id = ? AND to_user_id = ?", fromUser.ID, toUser.ID).Order("id")
+	if err := query.Session(&gorm.Session{}).Where("type = ?", "transfer_out").Find(&out).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := query.Session(&gorm.Session{}).Where("type = ?", "transfer_in").Find(&in).Error; err != nil {
+		t.Fatal(err)
+	}
+	return out, in
+}
+
+func TestRecipientRefundsTransferAndFee(t *testing.T) {
+	s, usd := newTestReversals(t)
+	setSetting(t, s, SettingFeePercent, "2")
+	ctx := context.Background()
+
+	if err := s.TransferMoney(ctx, 1, "bob", 100, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	out, in := transferSides(t, s, 1, 2)
+	if len(in) != 1 || in[0].CounterpartID != out[0].ID || out[0].CounterpartID != in[0].ID {
+		t.Fatalf("transfer sides %+v and %+v do not point at each other", out, in)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 98)
+
+	if _, err := s.ReverseTransaction(ctx, 3, in[0].ID, 0); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("refund by another user: err = %v, want ErrTransactionNotFound", err)
+	}
+	if _, err := s.ReverseTransaction(ctx, 2, in[0].ID, 100.01); !errors.Is(err, ErrReversalTooLarge) {
+		t.Errorf("refund above the transfer: err = %v, want ErrReversalTooLarge", err)
+	}
+
+	reversal, err := s.ReverseTransaction(ctx, 2, in[0].ID, 30)
+	if err != nil {
+		t.Fatalf("ReverseTransaction: %v", err)
+	}
+	assertAmount(t, "refunded fee", reversal.Fee, 0.6)
+	assertAmount(t, "alice after a partial refund", balanceOf(t, s, 1, "USD"), 128.6)
+	assertAmount(t, "bob after a partial refund", balanceOf(t, s, 2, "USD"), 70)
+
+	// The rest of the transfer takes the rest of the fee with it
+	if reversal, err = s.ReverseTransaction(ctx, 2, out[0].ID, 0); err != nil {
+		t.Fatalf("ReverseTransaction: %v", err)
+	}
+	assertAmount(t, "refunded amount", reversal.Amount, 70)
+	assertAmount(t, "refunded fee", reversal.Fee, 1.4)
+	if _, err := s.ReverseTransaction(ctx, 2, in[0].ID, 0); !errors.Is(err, ErrAlreadyReversed) {
+		t.Errorf("refund of a refunded transfer: err = %v, want ErrAlreadyReversed", err)
+	}
+
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 200)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 0)
+	assertAmount(t, "system", balanceOf(t, s, SystemTelegramID, "USD"), 0)
+	assertAmount(t, "total", totalMoney(t, s, usd), 200)
+
+	var feeRefunds []database.Transaction
+	if err := s.db.Conn.Where("type = ?", "fee_refund_in").Find(&feeRefunds).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(feeRefunds) != 2 || feeRefunds[0].ReversalOf != out[0].ID {
+		t.Errorf("fee refunds = %+v, want two linked to #%d", feeRefunds, out[0].ID)
+	}
+	if _, err := s.ReverseTransaction(ctx, 1, feeRefunds[0].ID, 0); !errors.Is(err, ErrNotReversible) {
+		t.Errorf("refund of a fee refund: err = %v, want ErrNotReversible", err)
+	}
+}
+
+func TestReversalFindsTheOtherSideByID(t *testing.T) {
+	s, _ := newTestReversals(t)
+	ctx := context.Background()
+
+	// Two transfers that share users, amount and time
+	for range 2 {
+		if err := s.TransferMoney(ctx, 1, "bob", 10, "USD"); err != nil {
+			t.Fatalf("TransferMoney: %v", err)
+		}
+	}
+	_, in := transferSides(t, s, 1, 2)
+	if _, err := s.ReverseTransaction(ctx, 2, in[1].ID, 0); err != nil {
+		t.Fatalf("ReverseTransaction: %v", err)
+	}
+
+	out, in := transferSides(t, s, 1, 2)
+	if out[0].Reversed != 0 || out[1].Reversed != 10 || in[1].Reversed != 10 {
+		t.Errorf("reversed out %v/%v in %v, want the second transfer reversed on both sides",
+			out[0].Reversed, out[1].Reversed, in[1].Reversed)
+	}
+	if _, err := s.ReverseTransaction(ctx, 2, in[0].ID, 0); err != nil {
+		t.Fatalf("refund of the first transfer: %v", err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 200)
+}
+
+func TestAdminReversalOverdraftIsAudited(t *testing.T) {
+	s, usd := newTestReversals(t)
+	ctx := WithActor(context.Background(), 4, SourceBot)
+
+	if err := s.TransferMoney(ctx, 1, "bob", 50, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	if err := s.TransferMoney(ctx, 2, "carol", 40, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	_, in := transferSides(t, s, 1, 2)
+
+	// Bob spent most of the money, only an admin can take it back
+	if _, err := s.ReverseTransaction(ctx, 2, in[0].ID, 0); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("refund without the money: err = %v, want ErrInsufficientBalance", err)
+	}
+	reversal, err := s.ReverseTransaction(ctx, 4, in[0].ID, 0)
+	if err != nil {
+		t.Fatalf("ReverseTransaction: %v", err)
+	}
+	if !reversal.ByAdmin {
+		t.Error("reversal is not marked as done by an admin")
+	}
+	assertAmount(t, "overdraft", reversal.Overdraft, 40)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), -40)
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 200)
+	assertAmount(t, "total", totalMoney(t, s, usd), 200)
+
+	var entry database.AuditLog
+	if err := s.db.Conn.Where("action = ?", AuditReverseTransaction).First(&entry).Error; err != nil {
+		t.Fatalf("audit entry: %v", err)
+	}
+	var after map[string]any
+	if err := json.Unmarshal([]byte(entry.After), &after); err != nil {
+		t.Fatal(err)
+	}
+	if after["overdraft"] != 40.0 || after["recipient_balance"] != -40.0 || entry.ActorTelegramID != 4 {
+		t.Errorf("audit entry = %+v, want the overdraft recorded for admin 4", entry)
+	}
+}
+
+func TestTransfersOfOlderVersionsCanBeRefunded(t *testing.T) {
+	statements := append(baselineSchema,
+		`INSERT INTO currencies (id, code, name, sign, is_default) VALUES (1, 'USD', 'USD', '$', true)`,
+		`INSERT INTO users (id, telegram_id, username) VALUES (1, 1, 'alice'), (2, 2, 'bob')`,
+		`INSERT INTO balances (id, user_id, amount, currency_id) VALUES (1, 1, 70, 1), (2, 2, 30, 1)`,
+		`INSERT INTO transactions (id, user_id, balance_id, amount, type, from_user_id, from_username, to_user_id, to_username, timestamp, balance_after) VALUES
+			(1, 1, 1, -30, 'transfer_out', 1, 'alice', 2, 'bob', '2025-01-02 10:00:00+00:00', 70),
+			(2, 2, 2, 30, 'transfer_in', 1, 'alice', 2, 'bob', '2025-01-02 10:00:00+00:00', 30)`,
+		// Columns added by a release that left them NULL on existing rows
+		`ALTER TABLE transactions ADD COLUMN reversed real`,
+		`ALTER TABLE transactions ADD COLUMN fee real`,
+	)
+	s, _ := newLegacyTestService(t, reversalTestStart, statements...)
+	ctx := context.Background()
+
+	refundable, err := s.ListRefundable(ctx, 2)
+	if err != nil {
+		t.Fatalf("ListRefundable: %v", err)
+	}
+	if len(refundable) != 1 || refundable[0].ID != 2 {
+		t.Fatalf("refundable transfers = %+v, want #2", refundable)
+	}
+	if _, err := s.ReverseTransaction(ctx, 2, 2, 10); err != nil {
+		t.Fatalf("ReverseTransaction: %v", err)
+	}
+	if _, err := s.ReverseTransaction(ctx, 2, 1, 0); err != nil {
+		t.Fatalf("refund of the rest from the outgoing side: %v", err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 100)
+	assertAmount(t, "bob", balanceOf(t, s, 2, "USD"), 0)
+}
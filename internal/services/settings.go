@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// Runtime settings changed with /config
+const (
+	// SettingApprovalThreshold is the amount above which operations need approval,
+	// "approval.threshold.<CODE>" overrides it for a single currency
+	SettingApprovalThreshold = "approval.threshold"
+	SettingApprovalRequired  = "approval.required"
+	SettingApprovalTTL       = "approval.ttl"
+
+	// SettingInterestRate is the yearly interest rate on positive balances
+	// in percent, "interest.rate.<CODE>" overrides it for a single currency
+	SettingInterestRate = "interest.rate"
+	// SettingFeeFlat and SettingFeePercent make up the fee on transfers,
+	// both may be overridden per currency like the interest rate
+	SettingFeeFlat    = "fee.flat"
+	SettingFeePercent = "fee.percent"
+	// SettingFeeWaive lists the roles that pay no fees, e.g. "admin,approver"
+	SettingFeeWaive = "fee.waive"
+
+	// SettingLoanRemindBefore is how long before the due date borrowers are
+	// reminded, SettingLoanRemindEvery how often overdue loans are reminded
+	SettingLoanRemindBefore = "loan.remind_before"
+	SettingLoanRemindEvery  = "loan.remind_every"
+
+	// SettingEscrowTTL is how long escrows are held before they are refunded
+	SettingEscrowTTL = "escrow.ttl"
+
+	// SettingRegistrationClosed limits new accounts to users with an invite
+	SettingRegistrationClosed = "registration.closed"
+	// SettingWelcomeBonus is paid by the system account to new users in the
+	// default currency, "welcome.bonus.<CODE>" overrides it for a currency
+	SettingWelcomeBonus = "welcome.bonus"
+)
+
+// settingValidators check the value of every known setting, keyed by name or
+// by prefix for per-currency settings ending with a dot
+var settingValidators = map[string]func(string) error{
+	SettingApprovalThreshold:       validateNonNegativeFloat,
+	SettingApprovalThreshold + ".": validateNonNegativeFloat,
+	SettingApprovalRequired:        validatePositiveInt,
+	SettingApprovalTTL:             validateDuration,
+	SettingInterestRate:            validatePercent,
+	SettingInterestRate + ".":      validatePercent,
+	SettingFeeFlat:                 validateNonNegativeFloat,
+	SettingFeeFlat + ".":           validateNonNegativeFloat,
+	SettingFeePercent:              validatePercent,
+	SettingFeePercent + ".":        validatePercent,
+	SettingFeeWaive:                validateRoles,
+	SettingLoanRemindBefore:        validateDuration,
+	SettingLoanRemindEvery:         validateDuration,
+	SettingEscrowTTL:               validateDuration,
+	SettingRegistrationClosed:      validateBool,
+	SettingWelcomeBonus:            validateNonNegativeFloat,
+	SettingWelcomeBonus + ".":      validateNonNegativeFloat,
+}
+
+func (s *coreService) ListSettings(ctx context.Context) ([]database.Setting, error) {
+	var settings []database.Setting
+	if err := s.db.Conn.WithContext(ctx).Order("key").Find(&settings).Error; err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// SetSetting validates and stores a setting of the wallet in ctx. An empty
+// value resets it to the default.
+func (s *coreService) SetSetting(ctx context.Context, key, value string) error {
+	validate, err := settingValidator(key)
+	if err != nil {
+		return err
+	}
+	if value != "" {
+		if err := validate(value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+	}
+
+	setting := database.Setting{WalletID: database.WalletFromContext(ctx), Key: key, Value: value}
+	if setting.WalletID == 0 {
+		return errors.New("settings belong to a wallet")
+	}
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		before := getSetting(tx, key)
+		if value == "" {
+			if err := tx.Delete(&setting).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Save(&setting).Error; err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, tx, AuditSetSetting, "setting:"+key, before, value)
+	})
+}
+
+func settingValidator(key string) (func(string) error, error) {
+	if validate, ok := settingValidators[key]; ok {
+		return validate, nil
+	}
+	if i := strings.LastIndex(key, "."); i > 0 {
+		if validate, ok := settingValidators[key[:i+1]]; ok {
+			return validate, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown setting %q", key)
+}
+
+// walletSettings returns tx reading the settings of walletID, for jobs that
+// work on the rows of all wallets
+func walletSettings(tx *gorm.DB, walletID uint) *gorm.DB {
+	return tx.WithContext(database.WithWallet(tx.Statement.Context, walletID))
+}
+
+// getSetting returns the raw value of a setting in the wallet of tx, or ""
+// when it is not set
+func getSetting(tx *gorm.DB, key string) string {
+	var setting database.Setting
+	if err := tx.Where("key = ?", key).Limit(1).Find(&setting).Error; err != nil {
+		return ""
+	}
+	return setting.Value
+}
+
+// getFloatSetting returns a numeric setting, or def when it is not set or
+// not a finite number
+func getFloatSetting(tx *gorm.DB, key string, def float64) float64 {
+	value, err := parseFiniteFloat(getSetting(tx, key))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// getCurrencyFloatSetting returns the "<key>.<CODE>" override of a setting,
+// falling back to key itself and then to def
+func getCurrencyFloatSetting(tx *gorm.DB, key, currencyCode string, def float64) float64 {
+	if value := getFloatSetting(tx, key+"."+currencyCode, -1); value >= 0 {
+		return value
+	}
+	return getFloatSetting(tx, key, def)
+}
+
+func getIntSetting(tx *gorm.DB, key string, def int) int {
+	value, err := strconv.Atoi(getSetting(tx, key))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+func getBoolSetting(tx *gorm.DB, key string, def bool) bool {
+	value, err := strconv.ParseBool(getSetting(tx, key))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+func getDurationSetting(tx *gorm.DB, key string, def time.Duration) time.Duration {
+	value, err := ParseDuration(getSetting(tx, key))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// ParseDuration extends time.ParseDuration with a "d" suffix for days
+func ParseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func validateNonNegativeFloat(value string) error {
+	f, err := parseFiniteFloat(value)
+	if err != nil || f < 0 {
+		return errors.New("expected a non-negative number")
+	}
+	return nil
+}
+
+func validatePercent(value string) error {
+	f, err := parseFiniteFloat(value)
+	if err != nil || f < 0 || f > 100 {
+		return errors.New("expected a percentage between 0 and 100")
+	}
+	return nil
+}
+
+func validateRoles(value string) error {
+	for _, role := range strings.Split(value, ",") {
+		switch strings.TrimSpace(role) {
+		case RoleAdmin, RoleApprover, RoleUser:
+		default:
+			return fmt.Errorf("unknown role %q, expected %s, %s or %s", role, RoleAdmin, RoleApprover, RoleUser)
+		}
+	}
+	return nil
+}
+
+func validatePositiveInt(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return errors.New("expected a positive integer")
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return errors.New("expected true or false")
+	}
+	return nil
+}
+
+func validateDuration(value string) error {
+	d, err := ParseDuration(value)
+	if err != nil || d <= 0 {
+		return errors.New("expected a duration such as 12h or 2d")
+	}
+	return nil
+}
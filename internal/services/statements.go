@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+// Statement is a user's account statement over a period
+type Statement struct {
+	User     database.User
+	From     time.Time // zero means since the first transaction
+	To       time.Time
+	Accounts []StatementAccount
+}
+
+// StatementAccount holds the movements of one balance within a statement
+type StatementAccount struct {
+	Balance        database.Balance
+	OpeningBalance float64
+	ClosingBalance float64
+	Rows           []StatementRow
+}
+
+// StatementRow is a transaction together with the balance after it
+type StatementRow struct {
+	Transaction    database.Transaction
+	RunningBalance float64
+}
+
+// GenerateStatement builds a statement of all the user's transactions in
+// [from, to) with opening and closing balances per currency
+func (s *coreService) GenerateStatement(ctx context.Context, telegramID int64, from, to time.Time) (*Statement, error) {
+	user, err := s.userService.GetUser(ctx, telegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Timestamps are stored in UTC and compared as text by SQLite
+	from, to = utc(from), utc(to)
+
+	db := s.db.Conn.WithContext(ctx)
+	query := db.Where("user_id = ? AND timestamp < ?", user.ID, to).
+		Preload("Balance.Currency").
+		Order("timestamp, id")
+	if !from.IsZero() {
+		query = query.Where("timestamp >= ?", from)
+	}
+	var transactions []database.Transaction
+	if err := query.Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+	if err := withCurrentNames(db, transactions); err != nil {
+		return nil, err
+	}
+
+	statement := &Statement{User: *user, From: from, To: to}
+	accounts := make(map[uint]*StatementAccount)
+	for _, balance := range user.Accounts {
+		accounts[balance.ID] = &StatementAccount{Balance: balance}
+	}
+	for _, t := range transactions {
+		account, ok := accounts[t.BalanceID]
+		if !ok {
+			account = &StatementAccount{Balance: t.Balance}
+			accounts[t.BalanceID] = account
+		}
+		account.Rows = append(account.Rows, StatementRow{Transaction: t, RunningBalance: t.BalanceAfter})
+	}
+
+	for _, account := range accounts {
+		opening, err := s.openingBalance(ctx, account, from, to)
+		if err != nil {
+			return nil, err
+		}
+		account.OpeningBalance = opening
+		account.ClosingBalance = opening
+		if n := len(account.Rows); n > 0 {
+			account.ClosingBalance = account.Rows[n-1].RunningBalance
+		}
+		statement.Accounts = append(statement.Accounts, *account)
+	}
+	sort.Slice(statement.Accounts, func(i, j int) bool {
+		a, b := statement.Accounts[i].Balance, statement.Accounts[j].Balance
+		if a.Currency.Code != b.Currency.Code {
+			return a.Currency.Code < b.Currency.Code
+		}
+		return a.Pot < b.Pot
+	})
+
+	return statement, nil
+}
+
+// openingBalance derives the balance at the start of the period from the
+// ledger, falling back to the current amount for balances without history
+func (s *coreService) openingBalance(ctx context.Context, account *StatementAccount, from, to time.Time) (float64, error) {
+	db := s.db.Conn.WithContext(ctx)
+
+	if !from.IsZero() {
+		var previous database.Transaction
+		err := db.Where("balance_id = ? AND timestamp < ?", account.Balance.ID, from).
+			Order("timestamp desc, id desc").
+			Limit(1).
+			Find(&previous).Error
+		if err != nil {
+			return 0, err
+		}
+		if previous.ID != 0 {
+			return previous.BalanceAfter, nil
+		}
+	}
+
+	if len(account.Rows) > 0 {
+		first := account.Rows[0].Transaction
+		return first.BalanceAfter - first.Amount, nil
+	}
+
+	var next database.Transaction
+	err := db.Where("balance_id = ? AND timestamp >= ?", account.Balance.ID, to).
+		Order("timestamp, id").
+		Limit(1).
+		Find(&next).Error
+	if err != nil {
+		return 0, err
+	}
+	if next.ID != 0 {
+		return next.BalanceAfter - next.Amount, nil
+	}
+	return account.Balance.Amount, nil
+}
+
+// utc converts t to UTC, keeping the zero time zero
+func utc(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.UTC()
+}
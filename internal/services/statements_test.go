@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStatementCoversLocalDays(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data: %v", err)
+	}
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, berlin)
+	s, clock := newTestService(t, start)
+	usd := createCurrency(t, s, "USD")
+	alice := createUser(t, s, 1, "alice", usd, 100, start)
+	createUser(t, s, 2, "bob", usd, 0, start)
+	createUser(t, s, 3, "carol", usd, 0, start)
+	s.db.Conn.Model(alice).Update("timezone", "Europe/Berlin")
+	ctx := context.Background()
+
+	for _, transfer := range []struct {
+		at     time.Time
+		amount float64
+	}{
+		{start, 30},
+		{time.Date(2025, 6, 2, 23, 30, 0, 0, berlin), 20}, // still June 2 in Berlin, not in UTC
+		{time.Date(2025, 6, 3, 0, 30, 0, 0, berlin), 10},  // June 3 in Berlin, June 2 in UTC
+	} {
+		clock.Set(transfer.at)
+		if err := s.TransferMoney(ctx, 1, "bob", transfer.amount, "USD"); err != nil {
+			t.Fatalf("TransferMoney: %v", err)
+		}
+	}
+
+	day := func(d int) time.Time { return time.Date(2025, 6, d, 0, 0, 0, 0, berlin) }
+	for _, tc := range []struct {
+		name             string
+		telegramID       int64
+		from, to         time.Time
+		rows             []float64
+		opening, closing float64
+	}{
+		{"one day", 1, day(2), day(3), []float64{-20}, 70, 50},
+		{"since the first transaction", 1, time.Time{}, day(4), []float64{-30, -20, -10}, 100, 40},
+		{"before the first transaction", 1, day(1).AddDate(0, 0, -7), day(1), nil, 100, 100},
+		{"after the last transaction", 2, day(4), day(5), nil, 60, 60},
+		{"never used", 3, time.Time{}, day(5), nil, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			statement, err := s.GenerateStatement(ctx, tc.telegramID, tc.from, tc.to)
+			if err != nil {
+				t.Fatalf("GenerateStatement: %v", err)
+			}
+			if len(statement.Accounts) != 1 {
+				t.Fatalf("statement has %d accounts, want the USD balance", len(statement.Accounts))
+			}
+			account := statement.Accounts[0]
+			if len(account.Rows) != len(tc.rows) {
+				t.Fatalf("statement has %d rows, want %d", len(account.Rows), len(tc.rows))
+			}
+			for i, row := range account.Rows {
+				assertAmount(t, "row amount", row.Transaction.Amount, tc.rows[i])
+			}
+			assertAmount(t, "opening balance", account.OpeningBalance, tc.opening)
+			assertAmount(t, "closing balance", account.ClosingBalance, tc.closing)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// The system account pays interest and collects fees. Its balances may go
+// negative, the sum over all balances stays what admins put into the wallet.
+const (
+	// Telegram IDs are positive and purged users get negative ones
+	SystemTelegramID int64 = 0
+	// SystemUsername is too short to be a Telegram username, so it can't
+	// clash with a real user
+	SystemUsername = "bank"
+)
+
+// systemAccount returns the system user of the wallet tx is limited to with
+// its balances, creating it on first use. Every wallet has its own.
+func systemAccount(tx *gorm.DB) (*database.User, error) {
+	if database.WalletFromContext(tx.Statement.Context) == 0 {
+		return nil, errors.New("the system account belongs to a wallet, none was given")
+	}
+	var user database.User
+	if err := tx.Preload("Accounts.Currency").
+		Where("telegram_id = ?", SystemTelegramID).
+		Limit(1).
+		Find(&user).Error; err != nil {
+		return nil, err
+	}
+	if user.ID != 0 {
+		return &user, nil
+	}
+
+	user = database.User{TelegramID: SystemTelegramID, Username: SystemUsername}
+	if err := tx.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// systemBalance returns the main balance of the system account in a
+// currency, creating it when needed
+func systemBalance(tx *gorm.DB, system *database.User, currency database.Currency) (*database.Balance, error) {
+	if balance := findBalance(system, currency.Code); balance != nil {
+		return balance, nil
+	}
+	balance := database.Balance{UserID: system.ID, CurrencyID: currency.ID, Currency: currency}
+	if err := tx.Create(&balance).Error; err != nil {
+		return nil, err
+	}
+	system.Accounts = append(system.Accounts, balance)
+	return &system.Accounts[len(system.Accounts)-1], nil
+}
+
+// moveMoney moves amount between two balances and records both sides as
+// transactions of the given types that point at each other, applying options
+// to both. It does not check balances or limits.
+func moveMoney(tx *gorm.DB, fromUser *database.User, fromBalance *database.Balance, toUser *database.User, toBalance *database.Balance, amount float64, outType, inType string, now time.Time, options ...func(*database.Transaction)) error {
+	fromBalance.Amount -= amount
+	toBalance.Amount += amount
+
+	out := database.Transaction{
+		UserID:       fromUser.ID,
+		BalanceID:    fromBalance.ID,
+		Amount:       -amount,
+		Type:         outType,
+		FromUserID:   fromUser.ID,
+		FromUsername: fromUser.Username,
+		ToUserID:     toUser.ID,
+		ToUsername:   toUser.Username,
+		Timestamp:    now,
+		BalanceAfter: fromBalance.Amount,
+	}
+	in := database.Transaction{
+		UserID:       toUser.ID,
+		BalanceID:    toBalance.ID,
+		Amount:       amount,
+		Type:         inType,
+		FromUserID:   fromUser.ID,
+		FromUsername: fromUser.Username,
+		ToUserID:     toUser.ID,
+		ToUsername:   toUser.Username,
+		Timestamp:    now,
+		BalanceAfter: toBalance.Amount,
+	}
+	for _, option := range options {
+		option(&out)
+		option(&in)
+	}
+
+	if err := tx.Save(fromBalance).Error; err != nil {
+		return err
+	}
+	if err := tx.Save(toBalance).Error; err != nil {
+		return err
+	}
+	if err := tx.Create(&out).Error; err != nil {
+		return err
+	}
+	in.CounterpartID = out.ID
+	if err := tx.Create(&in).Error; err != nil {
+		return err
+	}
+	return tx.Model(&out).Update("counterpart_id", in.ID).Error
+}
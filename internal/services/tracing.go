@@ -0,0 +1,448 @@
+package services
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/tracing"
+)
+
+// tracedCoreService wraps a CoreService with a span around every call
+type tracedCoreService struct {
+	next CoreService
+}
+
+// NewTracedCoreService returns a CoreService that traces every call of next
+func NewTracedCoreService(next CoreService) CoreService {
+	return &tracedCoreService{next: next}
+}
+
+func (s *tracedCoreService) GetBalances(ctx context.Context, telegramID int64) ([]database.Balance, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.GetBalances")
+	result, err := s.next.GetBalances(ctx, telegramID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ListPots(ctx context.Context, telegramID int64) ([]database.Balance, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ListPots")
+	result, err := s.next.ListPots(ctx, telegramID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) CreatePot(ctx context.Context, telegramID int64, name, currencyCode string, goal float64, deadline *time.Time) (*database.Balance, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.CreatePot")
+	result, err := s.next.CreatePot(ctx, telegramID, name, currencyCode, goal, deadline)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) MoveBetweenPots(ctx context.Context, telegramID int64, amount float64, currencyCode, fromPot, toPot string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.MoveBetweenPots")
+	err := s.next.MoveBetweenPots(ctx, telegramID, amount, currencyCode, fromPot, toPot)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) GetDefaultCurrency(ctx context.Context) (*database.Currency, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.GetDefaultCurrency")
+	result, err := s.next.GetDefaultCurrency(ctx)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ResolveRecipient(ctx context.Context, recipient string) (*database.User, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ResolveRecipient")
+	result, err := s.next.ResolveRecipient(ctx, recipient)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) TransferMoney(ctx context.Context, fromTelegramID int64, toUsername string, amount float64, currencyCode string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.TransferMoney")
+	err := s.next.TransferMoney(ctx, fromTelegramID, toUsername, amount, currencyCode)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) QuoteTransferFee(ctx context.Context, telegramID int64, amount float64, currencyCode string) (float64, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.QuoteTransferFee")
+	result, err := s.next.QuoteTransferFee(ctx, telegramID, amount, currencyCode)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) Lend(ctx context.Context, lenderTelegramID int64, borrowerUsername string, amount float64, currencyCode string, due *time.Time) (*database.Loan, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.Lend")
+	result, err := s.next.Lend(ctx, lenderTelegramID, borrowerUsername, amount, currencyCode, due)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) Repay(ctx context.Context, borrowerTelegramID int64, lenderUsername string, amount float64, currencyCode string) (*Repayment, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.Repay")
+	result, err := s.next.Repay(ctx, borrowerTelegramID, lenderUsername, amount, currencyCode)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ListLoans(ctx context.Context, telegramID int64) ([]database.Loan, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ListLoans")
+	result, err := s.next.ListLoans(ctx, telegramID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) CreateEscrow(ctx context.Context, senderTelegramID int64, recipientUsername string, amount float64, currencyCode string) (*database.Escrow, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.CreateEscrow")
+	result, err := s.next.CreateEscrow(ctx, senderTelegramID, recipientUsername, amount, currencyCode)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ReleaseEscrow(ctx context.Context, telegramID int64, escrowID uint) (*database.Escrow, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ReleaseEscrow")
+	result, err := s.next.ReleaseEscrow(ctx, telegramID, escrowID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) CancelEscrow(ctx context.Context, telegramID int64, escrowID uint) (*database.Escrow, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.CancelEscrow")
+	result, err := s.next.CancelEscrow(ctx, telegramID, escrowID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) DisputeEscrow(ctx context.Context, telegramID int64, escrowID uint) (*database.Escrow, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.DisputeEscrow")
+	result, err := s.next.DisputeEscrow(ctx, telegramID, escrowID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ResolveEscrow(ctx context.Context, adminTelegramID int64, escrowID uint, release bool) (*database.Escrow, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ResolveEscrow")
+	result, err := s.next.ResolveEscrow(ctx, adminTelegramID, escrowID, release)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ListEscrows(ctx context.Context, telegramID int64) ([]database.Escrow, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ListEscrows")
+	result, err := s.next.ListEscrows(ctx, telegramID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) CreateVouchers(ctx context.Context, adminTelegramID int64, amount float64, currencyCode string, count int, ttl time.Duration, fromSystem bool) ([]database.Voucher, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.CreateVouchers")
+	result, err := s.next.CreateVouchers(ctx, adminTelegramID, amount, currencyCode, count, ttl, fromSystem)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) RedeemVoucher(ctx context.Context, telegramID int64, code string) (*database.Voucher, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.RedeemVoucher")
+	result, err := s.next.RedeemVoucher(ctx, telegramID, code)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) VoucherReport(ctx context.Context) ([]VoucherBatch, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.VoucherReport")
+	result, err := s.next.VoucherReport(ctx)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) Register(ctx context.Context, profile Profile, inviteCode string) (*Registration, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.Register")
+	result, err := s.next.Register(ctx, profile, inviteCode)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) CreateInvite(ctx context.Context, adminTelegramID int64, maxUses int, ttl time.Duration) (*database.Invite, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.CreateInvite")
+	result, err := s.next.CreateInvite(ctx, adminTelegramID, maxUses, ttl)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) RevokeInvite(ctx context.Context, adminTelegramID int64, code string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.RevokeInvite")
+	err := s.next.RevokeInvite(ctx, adminTelegramID, code)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) ListInvites(ctx context.Context) ([]InviteReport, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ListInvites")
+	result, err := s.next.ListInvites(ctx)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) CreateWallet(ctx context.Context, adminTelegramID int64, name string) (*database.Wallet, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.CreateWallet")
+	result, err := s.next.CreateWallet(ctx, adminTelegramID, name)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) CreateWebhook(ctx context.Context, adminTelegramID int64, rawURL string, events []string) (*database.Webhook, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.CreateWebhook")
+	result, err := s.next.CreateWebhook(ctx, adminTelegramID, rawURL, events)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) DeleteWebhook(ctx context.Context, adminTelegramID int64, webhookID uint) error {
+	ctx, span := tracing.Start(ctx, "CoreService.DeleteWebhook")
+	err := s.next.DeleteWebhook(ctx, adminTelegramID, webhookID)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) ListWebhooks(ctx context.Context) ([]database.Webhook, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ListWebhooks")
+	result, err := s.next.ListWebhooks(ctx)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ListWebhookDeliveries(ctx context.Context, webhookID uint, limit int) ([]database.WebhookDelivery, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ListWebhookDeliveries")
+	result, err := s.next.ListWebhookDeliveries(ctx, webhookID, limit)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) GetTransactionHistory(ctx context.Context, telegramID int64) ([]database.Transaction, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.GetTransactionHistory")
+	result, err := s.next.GetTransactionHistory(ctx, telegramID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ReverseTransaction(ctx context.Context, telegramID int64, transactionID uint, amount float64) (*Reversal, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ReverseTransaction")
+	result, err := s.next.ReverseTransaction(ctx, telegramID, transactionID, amount)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ListRefundable(ctx context.Context, telegramID int64) ([]database.Transaction, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ListRefundable")
+	result, err := s.next.ListRefundable(ctx, telegramID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) SetAdminStatus(ctx context.Context, targetUsername string, isAdmin bool) error {
+	ctx, span := tracing.Start(ctx, "CoreService.SetAdminStatus")
+	err := s.next.SetAdminStatus(ctx, targetUsername, isAdmin)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) SetApproverStatus(ctx context.Context, targetUsername string, isApprover bool) error {
+	ctx, span := tracing.Start(ctx, "CoreService.SetApproverStatus")
+	err := s.next.SetApproverStatus(ctx, targetUsername, isApprover)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) SetUserStatus(ctx context.Context, username, status string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.SetUserStatus")
+	err := s.next.SetUserStatus(ctx, username, status)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) SetTransferLimit(ctx context.Context, username, kind string, value float64, currencyCode string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.SetTransferLimit")
+	err := s.next.SetTransferLimit(ctx, username, kind, value, currencyCode)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) GetTransferLimits(ctx context.Context, telegramID int64) ([]database.TransferLimit, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.GetTransferLimits")
+	result, err := s.next.GetTransferLimits(ctx, telegramID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) AdminSetBalance(ctx context.Context, adminTelegramID int64, targetUsername string, amount float64, currencyCode string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.AdminSetBalance")
+	err := s.next.AdminSetBalance(ctx, adminTelegramID, targetUsername, amount, currencyCode)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) GetCurrencyByCode(ctx context.Context, code string) (*database.Currency, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.GetCurrencyByCode")
+	result, err := s.next.GetCurrencyByCode(ctx, code)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ListUsersWithBalances(ctx context.Context) ([]UserWithBalance, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ListUsersWithBalances")
+	result, err := s.next.ListUsersWithBalances(ctx)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) RemoveUser(ctx context.Context, username, sweepToUsername string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.RemoveUser")
+	err := s.next.RemoveUser(ctx, username, sweepToUsername)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) RestoreUser(ctx context.Context, username string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.RestoreUser")
+	err := s.next.RestoreUser(ctx, username)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) PurgeUser(ctx context.Context, username string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.PurgeUser")
+	err := s.next.PurgeUser(ctx, username)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) AddUser(ctx context.Context, telegramID int64, username string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.AddUser")
+	err := s.next.AddUser(ctx, telegramID, username)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) AddCurrency(ctx context.Context, code, name, sign string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.AddCurrency")
+	err := s.next.AddCurrency(ctx, code, name, sign)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) SetDefaultCurrency(ctx context.Context, code string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.SetDefaultCurrency")
+	err := s.next.SetDefaultCurrency(ctx, code)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) Import(ctx context.Context, format string, r io.Reader, dryRun bool) (*ImportReport, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.Import")
+	result, err := s.next.Import(ctx, format, r, dryRun)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) GenerateStatement(ctx context.Context, telegramID int64, from, to time.Time) (*Statement, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.GenerateStatement")
+	result, err := s.next.GenerateStatement(ctx, telegramID, from, to)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ListAuditLogs(ctx context.Context, filter AuditFilter) ([]database.AuditLog, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ListAuditLogs")
+	result, err := s.next.ListAuditLogs(ctx, filter)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) VerifyAuditLog(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "CoreService.VerifyAuditLog")
+	err := s.next.VerifyAuditLog(ctx)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) ListSettings(ctx context.Context) ([]database.Setting, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ListSettings")
+	result, err := s.next.ListSettings(ctx)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) SetSetting(ctx context.Context, key, value string) error {
+	ctx, span := tracing.Start(ctx, "CoreService.SetSetting")
+	err := s.next.SetSetting(ctx, key, value)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedCoreService) SetNotifier(notifier Notifier) {
+	s.next.SetNotifier(notifier)
+}
+
+func (s *tracedCoreService) Now() time.Time {
+	return s.next.Now()
+}
+
+func (s *tracedCoreService) ListPendingOperations(ctx context.Context, telegramID int64) ([]database.PendingOperation, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ListPendingOperations")
+	result, err := s.next.ListPendingOperations(ctx, telegramID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) ApproveOperation(ctx context.Context, approverTelegramID int64, operationID uint) (*database.PendingOperation, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.ApproveOperation")
+	result, err := s.next.ApproveOperation(ctx, approverTelegramID, operationID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) RejectOperation(ctx context.Context, approverTelegramID int64, operationID uint) (*database.PendingOperation, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.RejectOperation")
+	result, err := s.next.RejectOperation(ctx, approverTelegramID, operationID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) RunInterest(ctx context.Context) (*InterestReport, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.RunInterest")
+	result, err := s.next.RunInterest(ctx)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) RunLoanReminders(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.RunLoanReminders")
+	result, err := s.next.RunLoanReminders(ctx)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) RunEscrowTimeouts(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.RunEscrowTimeouts")
+	result, err := s.next.RunEscrowTimeouts(ctx)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) RunVoucherExpiry(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.RunVoucherExpiry")
+	result, err := s.next.RunVoucherExpiry(ctx)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedCoreService) RunWebhookDeliveries(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "CoreService.RunWebhookDeliveries")
+	result, err := s.next.RunWebhookDeliveries(ctx)
+	tracing.End(span, err)
+	return result, err
+}
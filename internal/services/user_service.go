@@ -3,18 +3,23 @@ package services
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"time"
 
 	"github.com/fitz123/mcduck-wallet/internal/database"
-	"gorm.io/gorm"
 )
 
 type UserService interface {
 	GetUser(ctx context.Context, telegramID int64) (*database.User, error)
-	GetUserByUsername(username string) (*database.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*database.User, error)
 	CreateUser(ctx context.Context, user *database.User) error
-	UpdateUsername(ctx context.Context, telegramID int64, username string) error
+	SyncProfile(ctx context.Context, profile Profile) error
+	SetLanguage(ctx context.Context, telegramID int64, language string) error
+	SetTimezone(ctx context.Context, telegramID int64, timezone string) error
 	IsAdmin(ctx context.Context, telegramID int64) bool
+	ActiveWallet(ctx context.Context, telegramID int64) (uint, error)
+	ListWallets(ctx context.Context, telegramID int64) ([]database.Wallet, error)
+	SwitchWallet(ctx context.Context, telegramID int64, walletID uint) error
 }
 
 type userService struct {
@@ -36,32 +41,47 @@ func (s *userService) GetUser(ctx context.Context, telegramID int64) (*database.
 	return &user, nil
 }
 
-func (s *userService) GetUserByUsername(username string) (*database.User, error) {
-	var user database.User
-	result := s.db.Conn.Preload("Accounts.Currency").Where("username = ?", username).First(&user)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
-		}
-		return nil, result.Error
-	}
-	return &user, nil
+func (s *userService) GetUserByUsername(ctx context.Context, username string) (*database.User, error) {
+	return resolveRecipient(s.db.Conn.WithContext(ctx), username)
 }
 
 func (s *userService) CreateUser(ctx context.Context, user *database.User) error {
 	return s.db.Conn.WithContext(ctx).Create(user).Error
 }
 
-func (s *userService) UpdateUsername(ctx context.Context, telegramID int64, username string) error {
-	result := s.db.Conn.WithContext(ctx).
+// SetLanguage stores the language chosen by the user in all their wallets,
+// an empty language falls back to the one reported by Telegram
+func (s *userService) SetLanguage(ctx context.Context, telegramID int64, language string) error {
+	result := s.db.Conn.WithContext(database.AnyWallet(ctx)).
+		Model(&database.User{}).
+		Where("telegram_id = ?", telegramID).
+		Update("language", language)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetTimezone stores the IANA time zone of the user in all their wallets, an
+// empty zone means UTC
+func (s *userService) SetTimezone(ctx context.Context, telegramID int64, timezone string) error {
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+			return fmt.Errorf("%w: %q", ErrInvalidTimezone, timezone)
+		}
+	}
+	result := s.db.Conn.WithContext(database.AnyWallet(ctx)).
 		Model(&database.User{}).
 		Where("telegram_id = ?", telegramID).
-		Update("username", username)
+		Update("timezone", timezone)
 	if result.Error != nil {
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
 	}
 	return nil
 }
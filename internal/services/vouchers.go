@@ -0,0 +1,388 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxVoucherBatch limits the number of vouchers created at once
+	MaxVoucherBatch   = 100
+	voucherCodeLength = 12
+	// voucherAlphabet leaves out characters that are easily confused, like 0 and O
+	voucherAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// VoucherBatch summarizes the vouchers created together
+type VoucherBatch struct {
+	Batch             string
+	CreatedAt         time.Time
+	CreatedByUsername string
+	CurrencyCode      string
+	Amount            float64 // value of a single voucher
+	Issued            int
+	Redeemed          int
+	Expired           int
+}
+
+// NormalizeVoucherCode uppercases a code and drops separators users may type
+func NormalizeVoucherCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// newVoucherCode returns a random voucher code
+func newVoucherCode() (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(voucherAlphabet)))
+	for range voucherCodeLength {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(voucherAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// CreateVouchers issues count vouchers worth amount each. They are paid by
+// the system account when fromSystem is set, otherwise the admin's money is
+// put on hold until the vouchers are redeemed or expire. A zero ttl creates
+// vouchers that never expire.
+func (s *coreService) CreateVouchers(ctx context.Context, adminTelegramID int64, amount float64, currencyCode string, count int, ttl time.Duration, fromSystem bool) ([]database.Voucher, error) {
+	if !s.userService.IsAdmin(ctx, adminTelegramID) {
+		return nil, errors.New("unauthorized")
+	}
+	if err := validateAmount(amount); err != nil {
+		return nil, err
+	}
+	if count < 1 || count > MaxVoucherBatch {
+		return nil, fmt.Errorf("between 1 and %d vouchers can be created at once", MaxVoucherBatch)
+	}
+
+	var vouchers []database.Voucher
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		admin, err := findUserByTelegramID(tx, adminTelegramID)
+		if err != nil {
+			return err
+		}
+		var currency database.Currency
+		if err := tx.Where("code = ?", currencyCode).First(&currency).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCurrencyNotSupported
+			}
+			return err
+		}
+
+		now := s.now()
+		total := amount * float64(count)
+		var balanceID uint
+		if !fromSystem {
+			balance := findBalance(admin, currencyCode)
+			if balance == nil {
+				return ErrCurrencyNotSupported
+			}
+			if balance.Amount < total {
+				return ErrInsufficientBalance
+			}
+			balance.Amount -= total
+			balance.Held += total
+			if err := tx.Save(balance).Error; err != nil {
+				return err
+			}
+			hold := database.Transaction{
+				UserID:       admin.ID,
+				BalanceID:    balance.ID,
+				Amount:       -total,
+				Type:         "voucher_hold",
+				FromUserID:   admin.ID,
+				FromUsername: admin.Username,
+				Timestamp:    now,
+				BalanceAfter: balance.Amount,
+			}
+			if err := tx.Create(&hold).Error; err != nil {
+				return err
+			}
+			balanceID = balance.ID
+		}
+
+		var expiresAt *time.Time
+		if ttl > 0 {
+			t := now.Add(ttl)
+			expiresAt = &t
+		}
+		batch, err := newVoucherCode()
+		if err != nil {
+			return err
+		}
+		for range count {
+			code, err := newVoucherCode()
+			if err != nil {
+				return err
+			}
+			vouchers = append(vouchers, database.Voucher{
+				Code:              code,
+				Batch:             batch[:8],
+				CurrencyID:        currency.ID,
+				Currency:          currency,
+				Amount:            amount,
+				CreatedByID:       admin.ID,
+				CreatedByUsername: admin.Username,
+				BalanceID:         balanceID,
+				ExpiresAt:         expiresAt,
+			})
+		}
+		if err := tx.Create(&vouchers).Error; err != nil {
+			return err
+		}
+
+		after := map[string]any{"count": count, "amount": amount, "currency": currencyCode, "system": fromSystem}
+		if expiresAt != nil {
+			after["expires_at"] = expiresAt.Format(time.RFC3339)
+		}
+		return s.recordAudit(ctx, tx, AuditCreateVouchers, "vouchers:"+batch[:8], nil, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vouchers, nil
+}
+
+// RedeemVoucher pays the value of a voucher to the user. Every voucher is
+// redeemed at most once, even when several users try at the same time, and
+// a user redeems at most one voucher of a batch.
+func (s *coreService) RedeemVoucher(ctx context.Context, telegramID int64, code string) (*database.Voucher, error) {
+	code = NormalizeVoucherCode(code)
+	var voucher database.Voucher
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := findUserByTelegramID(tx, telegramID)
+		if err != nil {
+			return err
+		}
+		if !user.IsActive() {
+			return ErrAccountFrozen
+		}
+		if err := tx.Preload("Currency").Where("code = ?", code).First(&voucher).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrVoucherNotFound
+			}
+			return err
+		}
+
+		now := s.now()
+		switch {
+		case voucher.RedeemedAt != nil:
+			return ErrVoucherRedeemed
+		case voucher.IsExpired(now):
+			return ErrVoucherExpired
+		}
+
+		// Claim the voucher with a single conditional update, so that only
+		// one of several concurrent redemptions can succeed
+		result := tx.Model(&database.Voucher{}).
+			Where("id = ? AND redeemed_at IS NULL AND refunded_at IS NULL", voucher.ID).
+			Where("NOT EXISTS (SELECT 1 FROM vouchers v WHERE v.batch = ? AND v.redeemed_by_id = ?)", voucher.Batch, user.ID).
+			Updates(map[string]any{
+				"redeemed_by_id":       user.ID,
+				"redeemed_by_username": user.Username,
+				"redeemed_at":          now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			var used int64
+			if err := tx.Model(&database.Voucher{}).Where("batch = ? AND redeemed_by_id = ?", voucher.Batch, user.ID).Count(&used).Error; err != nil {
+				return err
+			}
+			if used > 0 {
+				return ErrVoucherBatchUsed
+			}
+			return ErrVoucherRedeemed
+		}
+		voucher.RedeemedByID = user.ID
+		voucher.RedeemedByUsername = user.Username
+		voucher.RedeemedAt = &now
+
+		balance := findBalance(user, voucher.Currency.Code)
+		if balance == nil {
+			balance = &database.Balance{UserID: user.ID, CurrencyID: voucher.CurrencyID, Currency: voucher.Currency}
+			if err := tx.Create(balance).Error; err != nil {
+				return err
+			}
+		}
+		return payVoucher(tx, &voucher, user, balance, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &voucher, nil
+}
+
+// payVoucher moves the value of a redeemed voucher to the user's balance,
+// from the money the creator put on hold or from the system account
+func payVoucher(tx *gorm.DB, voucher *database.Voucher, user *database.User, balance *database.Balance, now time.Time) error {
+	if voucher.BalanceID == 0 {
+		system, err := systemAccount(tx)
+		if err != nil {
+			return err
+		}
+		systemBalance, err := systemBalance(tx, system, voucher.Currency)
+		if err != nil {
+			return err
+		}
+		return moveMoney(tx, system, systemBalance, user, balance, voucher.Amount, "voucher_out", "voucher_redeem", now)
+	}
+
+	var held database.Balance
+	if err := tx.First(&held, voucher.BalanceID).Error; err != nil {
+		return err
+	}
+	held.Held = roundCents(held.Held - voucher.Amount)
+	if held.Held < 0 {
+		return fmt.Errorf("voucher %s: the money on hold is missing", voucher.Code)
+	}
+	balance.Amount += voucher.Amount
+	if err := tx.Save(&held).Error; err != nil {
+		return err
+	}
+	if err := tx.Save(balance).Error; err != nil {
+		return err
+	}
+	entry := database.Transaction{
+		UserID:       user.ID,
+		BalanceID:    balance.ID,
+		Amount:       voucher.Amount,
+		Type:         "voucher_redeem",
+		FromUserID:   voucher.CreatedByID,
+		FromUsername: voucher.CreatedByUsername,
+		ToUserID:     user.ID,
+		ToUsername:   user.Username,
+		Timestamp:    now,
+		BalanceAfter: balance.Amount,
+	}
+	return tx.Create(&entry).Error
+}
+
+// VoucherReport summarizes all voucher batches, the newest first
+func (s *coreService) VoucherReport(ctx context.Context) ([]VoucherBatch, error) {
+	var vouchers []database.Voucher
+	if err := s.db.Conn.WithContext(ctx).
+		Preload("Currency").
+		Order("id desc").
+		Find(&vouchers).Error; err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	var batches []VoucherBatch
+	index := make(map[string]int)
+	for _, v := range vouchers {
+		i, ok := index[v.Batch]
+		if !ok {
+			i = len(batches)
+			index[v.Batch] = i
+			batches = append(batches, VoucherBatch{
+				Batch:             v.Batch,
+				CreatedAt:         v.CreatedAt,
+				CreatedByUsername: v.CreatedByUsername,
+				CurrencyCode:      v.Currency.Code,
+				Amount:            v.Amount,
+			})
+		}
+		batches[i].Issued++
+		switch {
+		case v.RedeemedAt != nil:
+			batches[i].Redeemed++
+		case v.IsExpired(now):
+			batches[i].Expired++
+		}
+	}
+	return batches, nil
+}
+
+// RunVoucherExpiry gives the money of expired vouchers in all wallets back to
+// the admins who put it on hold. Every voucher is refunded in its own
+// transaction, one that fails is logged and retried on the next run. It
+// returns the number of refunded vouchers.
+func (s *coreService) RunVoucherExpiry(ctx context.Context) (int, error) {
+	now := s.now()
+	db := s.db.Conn.WithContext(database.AnyWallet(ctx))
+	var expired []database.Voucher
+	if err := db.Select("id").
+		Where("balance_id <> 0 AND redeemed_at IS NULL AND refunded_at IS NULL AND expires_at <= ?", now).
+		Order("id").
+		Find(&expired).Error; err != nil {
+		return 0, err
+	}
+
+	refunded := 0
+	for _, candidate := range expired {
+		done := false
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var voucher database.Voucher
+			if err := tx.First(&voucher, candidate.ID).Error; err != nil {
+				return err
+			}
+			// The voucher may have been redeemed since it was listed
+			result := tx.Model(&database.Voucher{}).
+				Where("id = ? AND redeemed_at IS NULL AND refunded_at IS NULL", voucher.ID).
+				Update("refunded_at", now)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return nil
+			}
+			if err := refundVoucher(tx, &voucher, now); err != nil {
+				return err
+			}
+			done = true
+			return nil
+		})
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to refund expired voucher", "voucher", candidate.ID, "error", err)
+			continue
+		}
+		if done {
+			refunded++
+		}
+	}
+	return refunded, nil
+}
+
+// refundVoucher moves the money an expired voucher held back to its creator
+func refundVoucher(tx *gorm.DB, voucher *database.Voucher, now time.Time) error {
+	var balance database.Balance
+	if err := tx.First(&balance, voucher.BalanceID).Error; err != nil {
+		return err
+	}
+	balance.Held = roundCents(balance.Held - voucher.Amount)
+	if balance.Held < 0 {
+		return fmt.Errorf("voucher %s: the money on hold is missing", voucher.Code)
+	}
+	balance.Amount += voucher.Amount
+	if err := tx.Save(&balance).Error; err != nil {
+		return err
+	}
+	entry := database.Transaction{
+		UserID:       voucher.CreatedByID,
+		BalanceID:    balance.ID,
+		Amount:       voucher.Amount,
+		Type:         "voucher_refund",
+		FromUserID:   voucher.CreatedByID,
+		FromUsername: voucher.CreatedByUsername,
+		Timestamp:    now,
+		BalanceAfter: balance.Amount,
+	}
+	return tx.Create(&entry).Error
+}
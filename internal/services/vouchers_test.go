@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var voucherTestStart = time.Date(2025, 10, 6, 9, 0, 0, 0, time.UTC)
+
+// newTestVouchers adds an admin, Alice (1) with 100 USD, and users 2 to
+// users+1 without money to s
+func newTestVouchers(t *testing.T, s *coreService, users int) database.Currency {
+	t.Helper()
+	usd := createCurrency(t, s, "USD")
+	admin := createUser(t, s, 1, "alice", usd, 100, voucherTestStart)
+	s.db.Conn.Model(admin).Update("is_admin", true)
+	for i := range users {
+		createUser(t, s, int64(i+2), "", usd, 0, voucherTestStart)
+	}
+	return usd
+}
+
+func TestVoucherIsRedeemedOnce(t *testing.T) {
+	s, _ := newTestService(t, voucherTestStart)
+	usd := newTestVouchers(t, s, 2)
+	ctx := context.Background()
+
+	if _, err := s.CreateVouchers(ctx, 2, 10, "USD", 1, 0, false); err == nil {
+		t.Error("a user who is not an admin created vouchers")
+	}
+	if _, err := s.CreateVouchers(ctx, 1, 60, "USD", 2, 0, false); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("vouchers above the balance: err = %v, want ErrInsufficientBalance", err)
+	}
+	vouchers, err := s.CreateVouchers(ctx, 1, 10, "USD", 2, 0, false)
+	if err != nil {
+		t.Fatalf("CreateVouchers: %v", err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 80)
+	assertAmount(t, "alice held", heldOf(t, s, 1, "USD"), 20)
+
+	code := vouchers[0].Code
+	if _, err := s.RedeemVoucher(ctx, 2, " "+code[:4]+"-"+code[4:]+" "); err != nil {
+		t.Fatalf("RedeemVoucher: %v", err)
+	}
+	if _, err := s.RedeemVoucher(ctx, 3, code); !errors.Is(err, ErrVoucherRedeemed) {
+		t.Errorf("second redemption: err = %v, want ErrVoucherRedeemed", err)
+	}
+	if _, err := s.RedeemVoucher(ctx, 2, vouchers[1].Code); !errors.Is(err, ErrVoucherBatchUsed) {
+		t.Errorf("second voucher of a batch: err = %v, want ErrVoucherBatchUsed", err)
+	}
+	if _, err := s.RedeemVoucher(ctx, 2, "NOSUCHCODE"); !errors.Is(err, ErrVoucherNotFound) {
+		t.Errorf("unknown code: err = %v, want ErrVoucherNotFound", err)
+	}
+
+	assertAmount(t, "user 2", balanceOf(t, s, 2, "USD"), 10)
+	assertAmount(t, "user 3", balanceOf(t, s, 3, "USD"), 0)
+	assertAmount(t, "alice held", heldOf(t, s, 1, "USD"), 10)
+	assertAmount(t, "total with held money", totalMoney(t, s, usd)+heldOf(t, s, 1, "USD"), 100)
+}
+
+func TestConcurrentRedemptionsPayOnce(t *testing.T) {
+	// Concurrent transactions need a database on disk that waits for locks
+	db, err := database.New("file:" + filepath.Join(t.TempDir(), "wallet.db") + "?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := NewCoreService(db, NewUserService(db)).(*coreService)
+	s.clock = (&testClock{now: voucherTestStart}).Now
+
+	const users = 8
+	usd := newTestVouchers(t, s, users)
+	ctx := context.Background()
+	vouchers, err := s.CreateVouchers(ctx, 1, 25, "USD", 1, 0, false)
+	if err != nil {
+		t.Fatalf("CreateVouchers: %v", err)
+	}
+
+	errs := make([]error, users)
+	var wg sync.WaitGroup
+	for i := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = s.RedeemVoucher(ctx, int64(i+2), vouchers[0].Code)
+		}()
+	}
+	wg.Wait()
+
+	redeemed := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			redeemed++
+		case !errors.Is(err, ErrVoucherRedeemed):
+			t.Errorf("user %d: err = %v, want ErrVoucherRedeemed", i+2, err)
+		}
+	}
+	if redeemed != 1 {
+		t.Fatalf("voucher redeemed %d times", redeemed)
+	}
+	assertAmount(t, "alice held", heldOf(t, s, 1, "USD"), 0)
+	assertAmount(t, "total", totalMoney(t, s, usd), 100)
+}
+
+func TestExpiredVouchersGoBackToTheCreator(t *testing.T) {
+	s, clock := newTestService(t, voucherTestStart)
+	usd := newTestVouchers(t, s, 1)
+	ctx := context.Background()
+
+	vouchers, err := s.CreateVouchers(ctx, 1, 15, "USD", 3, 24*time.Hour, false)
+	if err != nil {
+		t.Fatalf("CreateVouchers: %v", err)
+	}
+	if _, err := s.CreateVouchers(ctx, 1, 5, "USD", 1, time.Hour, true); err != nil {
+		t.Fatalf("CreateVouchers from the system account: %v", err)
+	}
+	if _, err := s.RedeemVoucher(ctx, 2, vouchers[0].Code); err != nil {
+		t.Fatalf("RedeemVoucher: %v", err)
+	}
+
+	clock.Set(voucherTestStart.Add(24 * time.Hour))
+	if _, err := s.RedeemVoucher(ctx, 1, vouchers[1].Code); !errors.Is(err, ErrVoucherExpired) {
+		t.Errorf("expired voucher: err = %v, want ErrVoucherExpired", err)
+	}
+	refunded, err := s.RunVoucherExpiry(ctx)
+	if err != nil {
+		t.Fatalf("RunVoucherExpiry: %v", err)
+	}
+	if refunded != 2 {
+		t.Errorf("refunded %d vouchers, want the two expired ones Alice paid for", refunded)
+	}
+	if refunded, err = s.RunVoucherExpiry(ctx); err != nil || refunded != 0 {
+		t.Errorf("second RunVoucherExpiry = %d, %v, want nothing refunded", refunded, err)
+	}
+
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 85)
+	assertAmount(t, "alice held", heldOf(t, s, 1, "USD"), 0)
+	assertAmount(t, "user 2", balanceOf(t, s, 2, "USD"), 15)
+	assertAmount(t, "total", totalMoney(t, s, usd), 100)
+
+	report, err := s.VoucherReport(ctx)
+	if err != nil {
+		t.Fatalf("VoucherReport: %v", err)
+	}
+	if len(report) != 2 || report[1].Issued != 3 || report[1].Redeemed != 1 || report[1].Expired != 2 {
+		t.Errorf("report = %+v, want the first batch with 1 redeemed and 2 expired", report)
+	}
+}
+
+func TestVoucherExpiryRefundsEachVoucherOnItsOwn(t *testing.T) {
+	s, clock := newTestService(t, voucherTestStart)
+	usd := newTestVouchers(t, s, 2)
+	ctx := context.Background()
+
+	// User 3 is a second admin with money of their own
+	s.db.Conn.Model(&database.User{}).Where("telegram_id = ?", 3).Update("is_admin", true)
+	if err := s.AdminSetBalance(ctx, 1, "3", 50, "USD"); err != nil {
+		t.Fatalf("AdminSetBalance: %v", err)
+	}
+
+	broken, err := s.CreateVouchers(ctx, 1, 20, "USD", 1, time.Hour, false)
+	if err != nil {
+		t.Fatalf("CreateVouchers: %v", err)
+	}
+	if _, err := s.CreateVouchers(ctx, 3, 10, "USD", 2, time.Hour, false); err != nil {
+		t.Fatalf("CreateVouchers: %v", err)
+	}
+
+	// Alice's hold went missing, refunding her voucher fails
+	if err := s.db.Conn.Model(&database.Balance{}).Where("id = ?", broken[0].BalanceID).Update("held", 0).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Set(voucherTestStart.Add(time.Hour))
+	refunded, err := s.RunVoucherExpiry(ctx)
+	if err != nil {
+		t.Fatalf("RunVoucherExpiry: %v", err)
+	}
+	if refunded != 2 {
+		t.Fatalf("refunded %d vouchers, want the two of user 3", refunded)
+	}
+	assertAmount(t, "user 3", balanceOf(t, s, 3, "USD"), 50)
+	assertAmount(t, "user 3 held", heldOf(t, s, 3, "USD"), 0)
+
+	// The failed voucher is retried once its hold is back
+	if err := s.db.Conn.Model(&database.Balance{}).Where("id = ?", broken[0].BalanceID).Update("held", 20).Error; err != nil {
+		t.Fatal(err)
+	}
+	if refunded, err = s.RunVoucherExpiry(ctx); err != nil || refunded != 1 {
+		t.Fatalf("RunVoucherExpiry = %d, %v, want Alice's voucher refunded", refunded, err)
+	}
+	assertAmount(t, "alice", balanceOf(t, s, 1, "USD"), 100)
+	assertAmount(t, "total", totalMoney(t, s, usd), 150)
+}
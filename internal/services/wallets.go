@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"gorm.io/gorm"
+)
+
+// ActiveWallet returns the wallet a Telegram user works with: the one they
+// switched to as long as they are a member of it, otherwise the wallet they
+// joined first. Users who belong to no wallet get the default wallet, which
+// is where they register.
+func (s *userService) ActiveWallet(ctx context.Context, telegramID int64) (uint, error) {
+	db := s.db.Conn.WithContext(database.AnyWallet(ctx))
+	var memberships []database.User
+	if err := db.Where("telegram_id = ?", telegramID).Order("id").Find(&memberships).Error; err != nil {
+		return 0, err
+	}
+	if len(memberships) == 0 {
+		return database.DefaultWalletID, nil
+	}
+
+	var active database.ActiveWallet
+	if err := db.Where("telegram_id = ?", telegramID).Limit(1).Find(&active).Error; err != nil {
+		return 0, err
+	}
+	for _, user := range memberships {
+		if user.WalletID == active.WalletID {
+			return user.WalletID, nil
+		}
+	}
+	return memberships[0].WalletID, nil
+}
+
+// ListWallets returns the wallets a Telegram user is a member of
+func (s *userService) ListWallets(ctx context.Context, telegramID int64) ([]database.Wallet, error) {
+	db := s.db.Conn.WithContext(database.AnyWallet(ctx))
+	var walletIDs []uint
+	if err := db.Model(&database.User{}).
+		Where("telegram_id = ?", telegramID).
+		Pluck("wallet_id", &walletIDs).Error; err != nil {
+		return nil, err
+	}
+	if len(walletIDs) == 0 {
+		return nil, nil
+	}
+
+	var wallets []database.Wallet
+	if err := db.Where("id IN ?", walletIDs).Order("id").Find(&wallets).Error; err != nil {
+		return nil, err
+	}
+	return wallets, nil
+}
+
+// SwitchWallet makes walletID the active wallet of a Telegram user who is a
+// member of it
+func (s *userService) SwitchWallet(ctx context.Context, telegramID int64, walletID uint) error {
+	db := s.db.Conn.WithContext(database.AnyWallet(ctx))
+	var count int64
+	if err := db.Model(&database.User{}).
+		Where("telegram_id = ? AND wallet_id = ?", telegramID, walletID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotWalletMember
+	}
+	return db.Save(&database.ActiveWallet{TelegramID: telegramID, WalletID: walletID}).Error
+}
+
+// CreateWallet creates a wallet with the admin as its first member and
+// admin. Only admins of the default wallet run the deployment and may create
+// wallets, the admin then invites the members of the new wallet.
+func (s *coreService) CreateWallet(ctx context.Context, adminTelegramID int64, name string) (*database.Wallet, error) {
+	if !s.userService.IsAdmin(database.WithWallet(ctx, database.DefaultWalletID), adminTelegramID) {
+		return nil, errors.New("only admins of the default wallet can create wallets")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("a wallet needs a name")
+	}
+
+	wallet := &database.Wallet{Name: name}
+	err := s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		admin, err := findUserByTelegramID(tx, adminTelegramID)
+		if err != nil {
+			return err
+		}
+		var existing int64
+		if err := tx.Model(&database.Wallet{}).Where("LOWER(name) = LOWER(?)", name).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return errors.New("a wallet with this name already exists")
+		}
+		if err := tx.Create(wallet).Error; err != nil {
+			return err
+		}
+
+		member := &database.User{
+			WalletID:    wallet.ID,
+			TelegramID:  admin.TelegramID,
+			Username:    admin.Username,
+			FirstName:   admin.FirstName,
+			LastName:    admin.LastName,
+			AppLanguage: admin.AppLanguage,
+			Language:    admin.Language,
+			Timezone:    admin.Timezone,
+			IsAdmin:     true,
+		}
+		if err := tx.Create(member).Error; err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, tx, AuditCreateWallet, "wallet:"+name, nil, map[string]any{"id": wallet.ID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wallet, nil
+}
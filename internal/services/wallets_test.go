@@ -0,0 +1,340 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var walletTestStart = time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestWallets returns a service with a second wallet next to the default
+// one. Alice (1) is admin of the default wallet and created the second one,
+// Bob (2) is a member of the default wallet and Carol (3) of the second one.
+// Both wallets have their own default USD.
+func newTestWallets(t *testing.T) (a *coreService, ctxA context.Context, b *coreService, ctxB context.Context) {
+	t.Helper()
+	s, _ := newTestService(t, walletTestStart)
+	a, ctxA = inWallet(t, s, database.DefaultWalletID)
+
+	usdA := createCurrency(t, a, "USD")
+	if err := a.SetDefaultCurrency(ctxA, "USD"); err != nil {
+		t.Fatalf("SetDefaultCurrency: %v", err)
+	}
+	createUser(t, a, 1, "alice", usdA, 100, walletTestStart)
+	createUser(t, a, 2, "bob", usdA, 0, walletTestStart)
+	if err := a.SetAdminStatus(ctxA, "alice", true); err != nil {
+		t.Fatalf("SetAdminStatus: %v", err)
+	}
+
+	wallet, err := a.CreateWallet(ctxA, 1, "Smiths")
+	if err != nil {
+		t.Fatalf("CreateWallet: %v", err)
+	}
+	b, ctxB = inWallet(t, s, wallet.ID)
+	usdB := createCurrency(t, b, "USD")
+	if err := b.SetDefaultCurrency(ctxB, "USD"); err != nil {
+		t.Fatalf("SetDefaultCurrency: %v", err)
+	}
+	createUser(t, b, 3, "carol", usdB, 50, walletTestStart)
+	return a, ctxA, b, ctxB
+}
+
+func TestWalletsKeepMembersAndMoneyApart(t *testing.T) {
+	a, ctxA, b, ctxB := newTestWallets(t)
+	usdA, err := a.GetCurrencyByCode(ctxA, "USD")
+	if err != nil {
+		t.Fatalf("GetCurrencyByCode: %v", err)
+	}
+	usdB, err := b.GetCurrencyByCode(ctxB, "USD")
+	if err != nil {
+		t.Fatalf("GetCurrencyByCode: %v", err)
+	}
+	if usdA.ID == usdB.ID {
+		t.Fatal("both wallets share one USD")
+	}
+	if !usdA.IsDefault || !usdB.IsDefault {
+		t.Errorf("default USD: %v in the default wallet, %v in the second, want both", usdA.IsDefault, usdB.IsDefault)
+	}
+
+	// Alice has a separate membership with its own balance in each wallet
+	if err := b.AdminSetBalance(ctxB, 1, "alice", 5, "USD"); err != nil {
+		t.Fatalf("AdminSetBalance: %v", err)
+	}
+	assertAmount(t, "alice in the default wallet", balanceOf(t, a, 1, "USD"), 100)
+	assertAmount(t, "alice in the second wallet", balanceOf(t, b, 1, "USD"), 5)
+
+	// Members of other wallets cannot be found, by name or by ID
+	for _, recipient := range []string{"carol", "3"} {
+		if _, err := a.ResolveRecipient(ctxA, recipient); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("ResolveRecipient(%q) = %v, want ErrUserNotFound", recipient, err)
+		}
+	}
+	if err := a.TransferMoney(ctxA, 1, "carol", 10, "USD"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("transfer to another wallet = %v, want ErrUserNotFound", err)
+	}
+	if err := b.TransferMoney(ctxB, 3, "bob", 10, "USD"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("transfer to another wallet = %v, want ErrUserNotFound", err)
+	}
+
+	if err := b.TransferMoney(ctxB, 1, "carol", 5, "USD"); err != nil {
+		t.Fatalf("transfer within the wallet: %v", err)
+	}
+	assertAmount(t, "carol", balanceOf(t, b, 3, "USD"), 55)
+	assertAmount(t, "alice in the default wallet", balanceOf(t, a, 1, "USD"), 100)
+	assertAmount(t, "total of the default wallet", totalMoney(t, a, *usdA), 100)
+	assertAmount(t, "total of the second wallet", totalMoney(t, b, *usdB), 55)
+
+	users, err := b.ListUsersWithBalances(ctxB)
+	if err != nil {
+		t.Fatalf("ListUsersWithBalances: %v", err)
+	}
+	var names []string
+	for _, u := range users {
+		names = append(names, u.Username)
+	}
+	if len(names) != 2 || names[0] != "alice" || names[1] != "carol" {
+		t.Errorf("members of the second wallet = %v, want [alice carol]", names)
+	}
+}
+
+func TestWalletsKeepAdminRightsApart(t *testing.T) {
+	a, ctxA, b, ctxB := newTestWallets(t)
+
+	if !a.userService.IsAdmin(ctxB, 1) {
+		t.Error("the creator of a wallet is not its admin")
+	}
+	if b.userService.IsAdmin(ctxB, 3) {
+		t.Error("carol is admin without being made one")
+	}
+	if err := b.SetAdminStatus(ctxB, "carol", true); err != nil {
+		t.Fatalf("SetAdminStatus: %v", err)
+	}
+	if a.userService.IsAdmin(ctxA, 3) {
+		t.Error("admin rights leaked into the default wallet")
+	}
+	if err := b.SetAdminStatus(ctxB, "bob", true); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("making a member of another wallet admin = %v, want ErrUserNotFound", err)
+	}
+	if err := b.AdminSetBalance(ctxB, 3, "bob", 1000, "USD"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("setting the balance of another wallet's member = %v, want ErrUserNotFound", err)
+	}
+	if _, err := b.CreateWallet(ctxB, 3, "Joneses"); err == nil {
+		t.Error("an admin of a second wallet created a wallet")
+	}
+
+	logs, err := b.ListAuditLogs(ctxB, AuditFilter{})
+	if err != nil {
+		t.Fatalf("ListAuditLogs: %v", err)
+	}
+	if len(logs) == 0 {
+		t.Error("audit log of the second wallet is empty")
+	}
+	for _, entry := range logs {
+		if entry.WalletID != database.WalletFromContext(ctxB) || entry.Action == AuditCreateWallet {
+			t.Errorf("audit log of the second wallet shows %s %s", entry.Action, entry.Target)
+		}
+	}
+	if err := a.VerifyAuditLog(ctxA); err != nil {
+		t.Errorf("VerifyAuditLog: %v", err)
+	}
+}
+
+func TestWalletsKeepOperationsApart(t *testing.T) {
+	a, ctxA, b, ctxB := newTestWallets(t)
+	if err := b.SetAdminStatus(ctxB, "carol", true); err != nil {
+		t.Fatalf("SetAdminStatus: %v", err)
+	}
+
+	escrow, err := a.CreateEscrow(ctxA, 1, "bob", 10, "USD")
+	if err != nil {
+		t.Fatalf("CreateEscrow: %v", err)
+	}
+	if _, err := b.ResolveEscrow(ctxB, 3, escrow.ID, true); err == nil {
+		t.Error("an admin of another wallet resolved an escrow")
+	}
+
+	vouchers, err := a.CreateVouchers(ctxA, 1, 5, "USD", 1, 0, true)
+	if err != nil {
+		t.Fatalf("CreateVouchers: %v", err)
+	}
+	if _, err := b.RedeemVoucher(ctxB, 3, vouchers[0].Code); err == nil {
+		t.Error("a voucher of another wallet was redeemed")
+	}
+
+	if err := a.TransferMoney(ctxA, 1, "bob", 10, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	var transfer database.Transaction
+	a.db.Conn.Where("type = ?", "transfer_in").Last(&transfer)
+	if _, err := b.ReverseTransaction(ctxB, 3, transfer.ID, 0); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("reversing a transfer of another wallet = %v, want ErrTransactionNotFound", err)
+	}
+
+	setSetting(t, a, SettingApprovalThreshold, "20")
+	var pending *PendingApprovalError
+	if err := a.TransferMoney(ctxA, 1, "bob", 30, "USD"); !errors.As(err, &pending) {
+		t.Fatalf("large transfer = %v, want a pending operation", err)
+	}
+	if _, err := b.ApproveOperation(ctxB, 3, pending.Operation.ID); err == nil {
+		t.Error("an admin of another wallet approved an operation")
+	}
+	if ops, err := b.ListPendingOperations(ctxB, 3); err != nil || len(ops) != 0 {
+		t.Errorf("pending operations of the second wallet = %d, %v, want none", len(ops), err)
+	}
+
+	for name, count := range map[string]func() (int, error){
+		"escrows":  func() (int, error) { l, err := b.ListEscrows(ctxB, 1); return len(l), err },
+		"invites":  func() (int, error) { l, err := b.ListInvites(ctxB); return len(l), err },
+		"vouchers": func() (int, error) { l, err := b.VoucherReport(ctxB); return len(l), err },
+		"history":  func() (int, error) { l, err := b.GetTransactionHistory(ctxB, 1); return len(l), err },
+	} {
+		if n, err := count(); err != nil || n != 0 {
+			t.Errorf("%s of the second wallet = %d, %v, want none", name, n, err)
+		}
+	}
+	assertAmount(t, "bob", balanceOf(t, a, 2, "USD"), 10)
+}
+
+func TestWalletsKeepSettingsApart(t *testing.T) {
+	a, ctxA, b, ctxB := newTestWallets(t)
+
+	if err := a.SetSetting(ctxA, SettingFeeFlat, "2"); err != nil {
+		t.Fatalf("SetSetting in the default wallet: %v", err)
+	}
+	if err := b.SetSetting(ctxB, SettingFeeFlat, "1"); err != nil {
+		t.Fatalf("SetSetting in the second wallet: %v", err)
+	}
+	for _, w := range []struct {
+		name  string
+		s     *coreService
+		ctx   context.Context
+		value string
+	}{{"default", a, ctxA, "2"}, {"second", b, ctxB, "1"}} {
+		settings, err := w.s.ListSettings(w.ctx)
+		if err != nil {
+			t.Fatalf("ListSettings: %v", err)
+		}
+		if len(settings) != 1 || settings[0].Value != w.value {
+			t.Errorf("settings of the %s wallet = %+v, want %s = %s", w.name, settings, SettingFeeFlat, w.value)
+		}
+	}
+
+	if err := a.TransferMoney(ctxA, 1, "bob", 10, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	usdB, err := b.GetCurrencyByCode(ctxB, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	createUser(t, b, 4, "dave", *usdB, 0, walletTestStart)
+	if err := b.TransferMoney(ctxB, 3, "dave", 10, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	assertAmount(t, "alice in the default wallet", balanceOf(t, a, 1, "USD"), 88)
+	assertAmount(t, "carol", balanceOf(t, b, 3, "USD"), 39)
+
+	// Resetting a setting leaves the other wallet alone
+	if err := b.SetSetting(ctxB, SettingFeeFlat, ""); err != nil {
+		t.Fatalf("SetSetting: %v", err)
+	}
+	if fee, err := a.QuoteTransferFee(ctxA, 1, 10, "USD"); err != nil || fee != 2 {
+		t.Errorf("fee in the default wallet = %v, %v, want 2", fee, err)
+	}
+	if fee, err := b.QuoteTransferFee(ctxB, 3, 10, "USD"); err != nil || fee != 0 {
+		t.Errorf("fee in the second wallet = %v, %v, want 0", fee, err)
+	}
+}
+
+func TestWalletMembership(t *testing.T) {
+	a, ctxA, b, ctxB := newTestWallets(t)
+	users := a.userService
+
+	// Alice works with the wallet she joined first until she switches
+	if id, err := users.ActiveWallet(ctxA, 1); err != nil || id != database.DefaultWalletID {
+		t.Errorf("ActiveWallet = %d, %v, want the default wallet", id, err)
+	}
+	walletB := database.WalletFromContext(ctxB)
+	if err := users.SwitchWallet(ctxA, 1, walletB); err != nil {
+		t.Fatalf("SwitchWallet: %v", err)
+	}
+	if id, _ := users.ActiveWallet(ctxA, 1); id != walletB {
+		t.Errorf("ActiveWallet after switching = %d, want %d", id, walletB)
+	}
+	if err := users.SwitchWallet(ctxA, 2, walletB); !errors.Is(err, ErrNotWalletMember) {
+		t.Errorf("switching to a foreign wallet = %v, want ErrNotWalletMember", err)
+	}
+
+	// An invite adds Bob to the second wallet, whichever wallet he starts in
+	invite, err := b.CreateInvite(ctxB, 1, 1, 0)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	registration, err := a.Register(ctxA, Profile{TelegramID: 2, Username: "bob"}, invite.Code)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if !registration.Created || registration.User.WalletID != walletB {
+		t.Errorf("registration = %+v, want a new membership in wallet %d", registration.User, walletB)
+	}
+	if id, _ := users.ActiveWallet(ctxA, 2); id != walletB {
+		t.Errorf("ActiveWallet after the invite = %d, want %d", id, walletB)
+	}
+	wallets, err := users.ListWallets(ctxA, 2)
+	if err != nil || len(wallets) != 2 {
+		t.Fatalf("ListWallets = %v, %v, want both wallets", wallets, err)
+	}
+	assertAmount(t, "bob in the default wallet", balanceOf(t, a, 2, "USD"), 0)
+
+	// Preferences and profiles follow the user into every wallet
+	if err := users.SetLanguage(ctxB, 2, "ru"); err != nil {
+		t.Fatalf("SetLanguage: %v", err)
+	}
+	if err := users.SyncProfile(ctxA, Profile{TelegramID: 2, Username: "robert"}); err != nil {
+		t.Fatalf("SyncProfile: %v", err)
+	}
+	for name, ctx := range map[string]context.Context{"default": ctxA, "second": ctxB} {
+		user, err := users.GetUser(ctx, 2)
+		if err != nil {
+			t.Fatalf("GetUser in the %s wallet: %v", name, err)
+		}
+		if user.Language != "ru" || user.Username != "robert" {
+			t.Errorf("bob in the %s wallet = %s/%s, want robert/ru", name, user.Username, user.Language)
+		}
+	}
+}
+
+func TestInterestIsPaidByEachWallet(t *testing.T) {
+	s, clock := newTestService(t, day(time.January, 1, 1))
+	a, _ := inWallet(t, s, database.DefaultWalletID)
+	if err := s.db.Conn.Create(&database.Wallet{Name: "Smiths"}).Error; err != nil {
+		t.Fatalf("create wallet: %v", err)
+	}
+	b, _ := inWallet(t, s, 2)
+
+	usdA := createCurrency(t, a, "USD")
+	usdB := createCurrency(t, b, "USD")
+	createUser(t, a, 1, "alice", usdA, 1000, day(time.January, 1, 0))
+	createUser(t, b, 1, "alice", usdB, 2000, day(time.January, 1, 0))
+	// The second wallet pays twice the rate
+	setSetting(t, a, SettingInterestRate+".USD", testInterestRate)
+	setSetting(t, b, SettingInterestRate+".USD", "73")
+
+	for d := 2; d <= 31; d++ {
+		clock.Set(day(time.January, d, 1))
+		runInterest(t, s)
+	}
+	clock.Set(day(time.February, 1, 1))
+	runInterest(t, s)
+
+	// 31 days at 0.1% and 0.2%
+	assertAmount(t, "alice in the default wallet", balanceOf(t, a, 1, "USD"), 1031)
+	assertAmount(t, "alice in the second wallet", balanceOf(t, b, 1, "USD"), 2124)
+	assertAmount(t, "system of the default wallet", balanceOf(t, a, SystemTelegramID, "USD"), -31)
+	assertAmount(t, "system of the second wallet", balanceOf(t, b, SystemTelegramID, "USD"), -124)
+	assertAmount(t, "total of the default wallet", totalMoney(t, a, usdA), 1000)
+	assertAmount(t, "total of the second wallet", totalMoney(t, b, usdB), 2000)
+}
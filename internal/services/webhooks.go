@@ -0,0 +1,446 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"gorm.io/gorm"
+)
+
+// Headers of webhook requests
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	// webhookTimeout limits a single delivery attempt
+	webhookTimeout = 10 * time.Second
+	// webhookRunTimeout limits a whole run of the job, deliveries that were
+	// not started by then wait for the next run
+	webhookRunTimeout = 30 * time.Second
+	// webhookConcurrency is how many webhooks are sent to at the same time
+	webhookConcurrency = 8
+	// webhookMaxAttempts is how often a delivery is tried before it fails
+	webhookMaxAttempts = 8
+	// webhookFirstRetry is the delay after the first failed attempt, it
+	// doubles with every further one up to webhookMaxRetry
+	webhookFirstRetry = 30 * time.Second
+	webhookMaxRetry   = 6 * time.Hour
+	// webhookBatchSize limits the deliveries sent by one run of the job
+	webhookBatchSize = 100
+)
+
+// SignWebhook returns the signature of a webhook body, which receivers
+// compare with the X-Webhook-Signature header: "sha256=" followed by the hex
+// HMAC-SHA256 of the body keyed with the webhook's secret
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay returns how long to wait after the given number of
+// failed attempts
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookFirstRetry
+	for i := 1; i < attempts && delay < webhookMaxRetry; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxRetry)
+}
+
+// parseWebhookEvents checks a list of event types, none means all
+func parseWebhookEvents(events []string) (string, error) {
+	var accepted []string
+	for _, event := range events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if event == "" || slices.Contains(accepted, event) {
+			continue
+		}
+		if !slices.Contains(EventTypes, event) {
+			return "", fmt.Errorf("unknown event %q, use one of %s", event, strings.Join(EventTypes, ", "))
+		}
+		accepted = append(accepted, event)
+	}
+	return strings.Join(accepted, ","), nil
+}
+
+// newWebhookSecret returns a random secret for signing deliveries
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// publicAddress reports whether webhooks may be sent to ip. Loopback,
+// private, link-local and multicast addresses are refused so that webhooks
+// cannot reach the host or its internal network.
+func publicAddress(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// newWebhookClient returns the client deliveries are sent with. It checks
+// every address it connects to with allowed, which also covers redirects
+// and host names that resolve differently than when the webhook was created.
+func newWebhookClient(allowed func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowed(ip) {
+				return ErrWebhookAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{Transport: &http.Transport{
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: webhookTimeout,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConnsPerHost: webhookConcurrency,
+	}}
+}
+
+// checkWebhookAddress resolves the host of a webhook URL and refuses it when
+// any of its addresses is not allowed
+func (s *coreService) checkWebhookAddress(ctx context.Context, target *url.URL) error {
+	host := target.Hostname()
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return fmt.Errorf("cannot resolve webhook host %q: %w", host, err)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !s.webhookAddressAllowed(ip) {
+			return ErrWebhookAddress
+		}
+	}
+	return nil
+}
+
+// CreateWebhook registers a URL that receives the given events of the
+// wallet, all events when none are given. The returned webhook carries the
+// secret deliveries are signed with.
+func (s *coreService) CreateWebhook(ctx context.Context, adminTelegramID int64, rawURL string, events []string) (*database.Webhook, error) {
+	if !s.userService.IsAdmin(ctx, adminTelegramID) {
+		return nil, errors.New("unauthorized")
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q, use an http or https URL", rawURL)
+	}
+	if err := s.checkWebhookAddress(ctx, target); err != nil {
+		return nil, err
+	}
+	accepted, err := parseWebhookEvents(events)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	var webhook *database.Webhook
+	err = s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		admin, err := findUserByTelegramID(tx, adminTelegramID)
+		if err != nil {
+			return err
+		}
+		webhook = &database.Webhook{
+			URL:               target.String(),
+			Secret:            secret,
+			Events:            accepted,
+			CreatedByUsername: admin.Username,
+		}
+		if err := tx.Create(webhook).Error; err != nil {
+			return err
+		}
+		after := map[string]string{"url": webhook.URL, "events": webhook.Events}
+		return s.recordAudit(ctx, tx, AuditCreateWebhook, webhookTarget(webhook), nil, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// DeleteWebhook removes a webhook, its pending deliveries fail
+func (s *coreService) DeleteWebhook(ctx context.Context, adminTelegramID int64, webhookID uint) error {
+	if !s.userService.IsAdmin(ctx, adminTelegramID) {
+		return errors.New("unauthorized")
+	}
+	return s.db.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var webhook database.Webhook
+		if err := tx.First(&webhook, webhookID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrWebhookNotFound
+			}
+			return err
+		}
+		if err := tx.Delete(&webhook).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&database.WebhookDelivery{}).
+			Where("webhook_id = ? AND status = ?", webhook.ID, database.DeliveryPending).
+			Updates(map[string]any{"status": database.DeliveryFailed, "error": "webhook deleted"}).Error; err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, tx, AuditDeleteWebhook, webhookTarget(&webhook), map[string]string{"url": webhook.URL}, nil)
+	})
+}
+
+// ListWebhooks returns the webhooks of the wallet
+func (s *coreService) ListWebhooks(ctx context.Context) ([]database.Webhook, error) {
+	var webhooks []database.Webhook
+	if err := s.db.Conn.WithContext(ctx).Order("id").Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// ListWebhookDeliveries returns the latest deliveries to a webhook, the
+// newest first
+func (s *coreService) ListWebhookDeliveries(ctx context.Context, webhookID uint, limit int) ([]database.WebhookDelivery, error) {
+	db := s.db.Conn.WithContext(ctx)
+	var webhook database.Webhook
+	if err := db.Unscoped().Select("id").First(&webhook, webhookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+
+	query := db.Where("webhook_id = ?", webhookID).Order("id desc")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var deliveries []database.WebhookDelivery
+	if err := query.Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// enqueueWebhooks adds a delivery of event to the outbox for every webhook
+// of its wallet that subscribed to it, in the transaction of the change
+func (s *coreService) enqueueWebhooks(ctx context.Context, tx *gorm.DB, event *Event) error {
+	var webhooks []database.Webhook
+	if err := tx.Find(&webhooks).Error; err != nil {
+		return err
+	}
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.Accepts(event.Type) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(event); err != nil {
+				return err
+			}
+		}
+		delivery := database.WebhookDelivery{
+			WalletID:      event.WalletID,
+			WebhookID:     webhook.ID,
+			EventType:     event.Type,
+			Payload:       string(payload),
+			Status:        database.DeliveryPending,
+			NextAttemptAt: event.Time,
+		}
+		if err := tx.Create(&delivery).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookAttempt is the outcome of sending a delivery
+type webhookAttempt struct {
+	made       bool
+	statusCode int
+	err        error
+}
+
+// RunWebhookDeliveries sends the due deliveries of all wallets and returns
+// how many of them were delivered. Failed attempts are retried with growing
+// delays until webhookMaxAttempts is reached. Up to webhookConcurrency
+// webhooks are sent to at a time and the run ends after webhookRunTimeout,
+// so that a slow receiver holds up neither the others nor the next run.
+func (s *coreService) RunWebhookDeliveries(ctx context.Context) (int, error) {
+	db := s.db.Conn.WithContext(database.AnyWallet(ctx))
+	var deliveries []database.WebhookDelivery
+	if err := db.Where("status = ? AND next_attempt_at <= ?", database.DeliveryPending, s.now()).
+		Order("id").
+		Limit(webhookBatchSize).
+		Find(&deliveries).Error; err != nil {
+		return 0, err
+	}
+	webhooks := make([]database.Webhook, len(deliveries))
+	for i := range deliveries {
+		if err := db.Limit(1).Find(&webhooks[i], deliveries[i].WebhookID).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	// Each webhook gets its deliveries in order, while different webhooks are
+	// sent to in parallel
+	var queues [][]int
+	queueOf := map[uint]int{}
+	for i := range deliveries {
+		if webhooks[i].ID == 0 {
+			continue
+		}
+		q, ok := queueOf[webhooks[i].ID]
+		if !ok {
+			q = len(queues)
+			queueOf[webhooks[i].ID] = q
+			queues = append(queues, nil)
+		}
+		queues[q] = append(queues[q], i)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, webhookRunTimeout)
+	defer cancel()
+	attempts := make([]webhookAttempt, len(deliveries))
+	for i := range deliveries {
+		if webhooks[i].ID == 0 {
+			attempts[i] = webhookAttempt{made: true, err: errors.New("webhook deleted")}
+		}
+	}
+	slots := make(chan struct{}, webhookConcurrency)
+	var wg sync.WaitGroup
+	for _, queue := range queues {
+		select {
+		case slots <- struct{}{}:
+		case <-runCtx.Done():
+		}
+		if runCtx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			for _, i := range queue {
+				if runCtx.Err() != nil {
+					return
+				}
+				statusCode, err := s.sendWebhook(runCtx, &webhooks[i], &deliveries[i])
+				attempts[i] = webhookAttempt{made: true, statusCode: statusCode, err: err}
+			}
+		}()
+	}
+	wg.Wait()
+	if runCtx.Err() != nil {
+		logger.WarnContext(ctx, "Webhook run ended before all deliveries were sent", "error", runCtx.Err())
+	}
+
+	// The attempts that were made are recorded even when ctx ended meanwhile
+	db = db.WithContext(database.AnyWallet(context.WithoutCancel(ctx)))
+	delivered := 0
+	for i, attempt := range attempts {
+		if !attempt.made {
+			continue
+		}
+		delivery := &deliveries[i]
+		now := s.now()
+		delivery.Attempts++
+		delivery.LastAttemptAt = &now
+		delivery.StatusCode = attempt.statusCode
+		delivery.Error = ""
+		switch {
+		case attempt.err == nil:
+			delivery.Status = database.DeliveryDelivered
+			delivered++
+		case webhooks[i].ID == 0 || delivery.Attempts >= webhookMaxAttempts:
+			delivery.Status = database.DeliveryFailed
+			delivery.Error = attempt.err.Error()
+		default:
+			delivery.NextAttemptAt = now.Add(webhookRetryDelay(delivery.Attempts))
+			delivery.Error = attempt.err.Error()
+		}
+		if attempt.err != nil {
+			logger.WarnContext(ctx, "Webhook delivery failed", "delivery", delivery.ID, "webhook", delivery.WebhookID,
+				"attempt", delivery.Attempts, "status", delivery.Status, "error", attempt.err)
+		}
+		if err := db.Save(delivery).Error; err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// sendWebhook posts a delivery to its webhook and returns the HTTP status of
+// the response. Any status other than 2xx fails the attempt.
+func (s *coreService) sendWebhook(ctx context.Context, webhook *database.Webhook, delivery *database.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "McDuck-Wallet-Webhooks")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, body))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so that the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookTarget names a webhook in the audit log
+func webhookTarget(webhook *database.Webhook) string {
+	return fmt.Sprintf("webhook:#%d", webhook.ID)
+}
@@ -0,0 +1,490 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fitz123/mcduck-wallet/internal/database"
+)
+
+var webhookTestStart = time.Date(2025, time.April, 1, 12, 0, 0, 0, time.UTC)
+
+// receivedWebhook is a request that reached a webhookReceiver
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver records webhook requests and answers them with the given
+// statuses in turn, with 200 once they are used up
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []receivedWebhook
+	statuses []int
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	t.Helper()
+	r := &webhookReceiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, receivedWebhook{header: req.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// received returns the requests received so far
+func (r *webhookReceiver) received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook(nil), r.requests...)
+}
+
+// allowLocalWebhooks lets s send webhooks to receivers on this host
+func allowLocalWebhooks(s *coreService) {
+	s.webhookAddressAllowed = func(net.IP) bool { return true }
+	s.httpClient = &http.Client{}
+}
+
+// newWebhookTest returns a service where alice (1) is an admin with 100 USD
+// and bob (2) a user without money
+func newWebhookTest(t *testing.T) (*coreService, *testClock, context.Context) {
+	t.Helper()
+	s, clock := newTestService(t, webhookTestStart)
+	ctx := context.Background()
+	usd := createCurrency(t, s, "USD")
+	alice := createUser(t, s, 1, "alice", usd, 100, webhookTestStart)
+	createUser(t, s, 2, "bob", usd, 0, webhookTestStart)
+	if err := s.db.Conn.Model(alice).Update("is_admin", true).Error; err != nil {
+		t.Fatalf("make alice admin: %v", err)
+	}
+	allowLocalWebhooks(s)
+	return s, clock, ctx
+}
+
+func runWebhookDeliveries(t *testing.T, s *coreService) int {
+	t.Helper()
+	delivered, err := s.RunWebhookDeliveries(context.Background())
+	if err != nil {
+		t.Fatalf("RunWebhookDeliveries: %v", err)
+	}
+	return delivered
+}
+
+// deliveriesOf returns the deliveries to a webhook, the oldest first
+func deliveriesOf(t *testing.T, s *coreService, webhook *database.Webhook) []database.WebhookDelivery {
+	t.Helper()
+	var deliveries []database.WebhookDelivery
+	if err := s.db.Conn.Where("webhook_id = ?", webhook.ID).Order("id").Find(&deliveries).Error; err != nil {
+		t.Fatalf("load deliveries: %v", err)
+	}
+	return deliveries
+}
+
+func TestWebhookDeliversSignedEvents(t *testing.T) {
+	s, _, ctx := newWebhookTest(t)
+	receiver := newWebhookReceiver(t)
+	webhook, err := s.CreateWebhook(ctx, 1, receiver.URL+"/hook", nil)
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if webhook.Secret == "" {
+		t.Fatal("webhook has no secret")
+	}
+
+	if err := s.TransferMoney(ctx, 1, "bob", 10, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	if err := s.AddCurrency(ctx, "EUR", "Euro", "€"); err != nil {
+		t.Fatalf("AddCurrency: %v", err)
+	}
+	if err := s.AdminSetBalance(WithActor(ctx, 1, SourceBot), 1, "bob", 50, "USD"); err != nil {
+		t.Fatalf("AdminSetBalance: %v", err)
+	}
+	if err := s.AddUser(ctx, 3, "carol"); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	// Failed changes publish nothing
+	if err := s.TransferMoney(ctx, 2, "alice", 1000, "USD"); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("overdrawing transfer = %v, want ErrInsufficientBalance", err)
+	}
+
+	// Nothing is sent before the delivery job runs
+	if n := len(receiver.received()); n != 0 {
+		t.Fatalf("receiver got %d requests before the job ran", n)
+	}
+	if delivered := runWebhookDeliveries(t, s); delivered != 4 {
+		t.Fatalf("delivered = %d, want 4", delivered)
+	}
+
+	requests := receiver.received()
+	want := []string{EventTransferCompleted, EventCurrencyAdded, EventBalanceAdjusted, EventUserCreated}
+	if len(requests) != len(want) {
+		t.Fatalf("receiver got %d requests, want %d", len(requests), len(want))
+	}
+	for i, req := range requests {
+		if got := req.header.Get(WebhookSignatureHeader); got != SignWebhook(webhook.Secret, req.body) {
+			t.Errorf("request %d: signature %q does not match the body", i, got)
+		}
+		if got := req.header.Get(WebhookEventHeader); got != want[i] {
+			t.Errorf("request %d: event header %q, want %q", i, got, want[i])
+		}
+		var event struct {
+			Type     string          `json:"type"`
+			WalletID uint            `json:"wallet_id"`
+			Time     time.Time       `json:"time"`
+			Data     json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(req.body, &event); err != nil {
+			t.Fatalf("request %d: decode body: %v", i, err)
+		}
+		if event.Type != want[i] || event.WalletID != database.DefaultWalletID || !event.Time.Equal(webhookTestStart) {
+			t.Errorf("request %d: event %s in wallet %d at %s", i, event.Type, event.WalletID, event.Time)
+		}
+	}
+
+	var transfer TransferCompleted
+	if err := json.Unmarshal(requests[0].body, &struct{ Data *TransferCompleted }{&transfer}); err != nil {
+		t.Fatalf("decode transfer: %v", err)
+	}
+	if transfer.FromUsername != "alice" || transfer.ToTelegramID != 2 || transfer.Amount != 10 || transfer.Currency != "USD" {
+		t.Errorf("transfer event = %+v", transfer)
+	}
+	var adjusted BalanceAdjusted
+	if err := json.Unmarshal(requests[2].body, &struct{ Data *BalanceAdjusted }{&adjusted}); err != nil {
+		t.Fatalf("decode adjustment: %v", err)
+	}
+	if adjusted.Username != "bob" || adjusted.Before != 10 || adjusted.After != 50 || adjusted.AdminUsername != "alice" {
+		t.Errorf("adjustment event = %+v", adjusted)
+	}
+
+	for _, d := range deliveriesOf(t, s, webhook) {
+		if d.Status != database.DeliveryDelivered || d.Attempts != 1 || d.StatusCode != http.StatusOK {
+			t.Errorf("delivery #%d: %s after %d attempts with %d", d.ID, d.Status, d.Attempts, d.StatusCode)
+		}
+	}
+	if delivered := runWebhookDeliveries(t, s); delivered != 0 || len(receiver.received()) != 4 {
+		t.Errorf("second run delivered %d, want nothing", delivered)
+	}
+}
+
+func TestWebhookFiltersEvents(t *testing.T) {
+	s, _, ctx := newWebhookTest(t)
+	receiver := newWebhookReceiver(t)
+
+	if _, err := s.CreateWebhook(ctx, 2, receiver.URL, nil); err == nil {
+		t.Error("a user who is not admin created a webhook")
+	}
+	if _, err := s.CreateWebhook(ctx, 1, "ftp://example.com", nil); err == nil {
+		t.Error("created a webhook with an ftp URL")
+	}
+	if _, err := s.CreateWebhook(ctx, 1, receiver.URL, []string{"transfer.started"}); err == nil {
+		t.Error("created a webhook for an unknown event")
+	}
+
+	currencies, err := s.CreateWebhook(ctx, 1, receiver.URL, []string{"currency.added"})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	everything, err := s.CreateWebhook(ctx, 1, receiver.URL, nil)
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+
+	if err := s.TransferMoney(ctx, 1, "bob", 10, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	if err := s.AddCurrency(ctx, "EUR", "Euro", "€"); err != nil {
+		t.Fatalf("AddCurrency: %v", err)
+	}
+	runWebhookDeliveries(t, s)
+
+	if got := deliveriesOf(t, s, currencies); len(got) != 1 || got[0].EventType != EventCurrencyAdded {
+		t.Errorf("currency webhook got %d deliveries, want only currency.added", len(got))
+	}
+	if got := deliveriesOf(t, s, everything); len(got) != 2 {
+		t.Errorf("webhook for all events got %d deliveries, want 2", len(got))
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	s, clock, ctx := newWebhookTest(t)
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	webhook, err := s.CreateWebhook(ctx, 1, receiver.URL, nil)
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if err := s.TransferMoney(ctx, 1, "bob", 10, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+
+	// The first attempt fails and is retried 30 seconds later
+	if delivered := runWebhookDeliveries(t, s); delivered != 0 {
+		t.Fatalf("delivered = %d despite the error", delivered)
+	}
+	d := deliveriesOf(t, s, webhook)[0]
+	if d.Status != database.DeliveryPending || d.Attempts != 1 || d.StatusCode != http.StatusInternalServerError || d.Error == "" {
+		t.Fatalf("after the first attempt: %s, %d attempts, HTTP %d, %q", d.Status, d.Attempts, d.StatusCode, d.Error)
+	}
+	if want := webhookTestStart.Add(30 * time.Second); !d.NextAttemptAt.Equal(want) {
+		t.Errorf("next attempt at %s, want %s", d.NextAttemptAt, want)
+	}
+
+	// Nothing is sent before the retry is due
+	runWebhookDeliveries(t, s)
+	if n := len(receiver.received()); n != 1 {
+		t.Fatalf("receiver got %d requests before the retry was due", n)
+	}
+
+	// The second failure doubles the delay, the third attempt succeeds
+	clock.Set(webhookTestStart.Add(30 * time.Second))
+	runWebhookDeliveries(t, s)
+	d = deliveriesOf(t, s, webhook)[0]
+	if want := webhookTestStart.Add(90 * time.Second); !d.NextAttemptAt.Equal(want) || d.StatusCode != http.StatusBadGateway {
+		t.Errorf("after the second attempt: next at %s with HTTP %d, want %s", d.NextAttemptAt, d.StatusCode, want)
+	}
+	clock.Set(webhookTestStart.Add(90 * time.Second))
+	if delivered := runWebhookDeliveries(t, s); delivered != 1 {
+		t.Fatalf("third attempt delivered %d, want 1", delivered)
+	}
+	d = deliveriesOf(t, s, webhook)[0]
+	if d.Status != database.DeliveryDelivered || d.Attempts != 3 || d.Error != "" {
+		t.Errorf("after the third attempt: %s, %d attempts, %q", d.Status, d.Attempts, d.Error)
+	}
+
+	// All attempts are signed the same way
+	requests := receiver.received()
+	for i, req := range requests {
+		if string(req.body) != string(requests[0].body) || req.header.Get(WebhookSignatureHeader) != SignWebhook(webhook.Secret, req.body) {
+			t.Errorf("attempt %d differs from the first one", i+1)
+		}
+	}
+}
+
+func TestWebhookGivesUpAfterMaxAttempts(t *testing.T) {
+	s, clock, ctx := newWebhookTest(t)
+	statuses := make([]int, webhookMaxAttempts+1)
+	for i := range statuses {
+		statuses[i] = http.StatusServiceUnavailable
+	}
+	receiver := newWebhookReceiver(t, statuses...)
+	webhook, err := s.CreateWebhook(ctx, 1, receiver.URL, nil)
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if err := s.AddCurrency(ctx, "EUR", "Euro", "€"); err != nil {
+		t.Fatalf("AddCurrency: %v", err)
+	}
+
+	for range webhookMaxAttempts {
+		runWebhookDeliveries(t, s)
+		clock.Set(deliveriesOf(t, s, webhook)[0].NextAttemptAt)
+	}
+	d := deliveriesOf(t, s, webhook)[0]
+	if d.Status != database.DeliveryFailed || d.Attempts != webhookMaxAttempts {
+		t.Fatalf("delivery: %s after %d attempts, want failed after %d", d.Status, d.Attempts, webhookMaxAttempts)
+	}
+	clock.Set(webhookTestStart.Add(30 * 24 * time.Hour))
+	runWebhookDeliveries(t, s)
+	if n := len(receiver.received()); n != webhookMaxAttempts {
+		t.Errorf("receiver got %d requests, want %d", n, webhookMaxAttempts)
+	}
+
+	log, err := s.ListWebhookDeliveries(ctx, webhook.ID, 10)
+	if err != nil || len(log) != 1 || log[0].Status != database.DeliveryFailed {
+		t.Errorf("delivery log = %v, %v", log, err)
+	}
+}
+
+func TestDeletedWebhookFailsPendingDeliveries(t *testing.T) {
+	s, _, ctx := newWebhookTest(t)
+	receiver := newWebhookReceiver(t)
+	webhook, err := s.CreateWebhook(ctx, 1, receiver.URL, nil)
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if err := s.AddCurrency(ctx, "EUR", "Euro", "€"); err != nil {
+		t.Fatalf("AddCurrency: %v", err)
+	}
+	if err := s.DeleteWebhook(ctx, 1, webhook.ID); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+	if err := s.AddCurrency(ctx, "GBP", "Pound", "£"); err != nil {
+		t.Fatalf("AddCurrency: %v", err)
+	}
+	runWebhookDeliveries(t, s)
+
+	if n := len(receiver.received()); n != 0 {
+		t.Errorf("receiver got %d requests from a deleted webhook", n)
+	}
+	log, err := s.ListWebhookDeliveries(ctx, webhook.ID, 0)
+	if err != nil || len(log) != 1 || log[0].Status != database.DeliveryFailed {
+		t.Errorf("delivery log of the deleted webhook = %v, %v", log, err)
+	}
+	if err := s.DeleteWebhook(ctx, 1, webhook.ID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("deleting again = %v, want ErrWebhookNotFound", err)
+	}
+}
+
+func TestWebhooksRefuseLocalAddresses(t *testing.T) {
+	s, _, ctx := newWebhookTest(t)
+	s.webhookAddressAllowed = publicAddress
+	s.httpClient = newWebhookClient(publicAddress)
+
+	for _, rawURL := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+		"http://10.0.0.1/hook",
+		"http://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fe80::1]/hook",
+	} {
+		if _, err := s.CreateWebhook(ctx, 1, rawURL, nil); !errors.Is(err, ErrWebhookAddress) {
+			t.Errorf("CreateWebhook(%s) = %v, want ErrWebhookAddress", rawURL, err)
+		}
+	}
+	public, err := s.CreateWebhook(ctx, 1, "https://203.0.113.10/hook", nil)
+	if err != nil {
+		t.Fatalf("CreateWebhook with a public address: %v", err)
+	}
+	if err := s.DeleteWebhook(ctx, 1, public.ID); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+
+	// A name that resolved to a public address when the webhook was created
+	// may point at the host by the time deliveries are sent
+	receiver := newWebhookReceiver(t)
+	rebound := database.Webhook{URL: receiver.URL, Secret: "secret"}
+	if err := s.db.Conn.Create(&rebound).Error; err != nil {
+		t.Fatalf("create webhook: %v", err)
+	}
+	if err := s.AddCurrency(ctx, "EUR", "Euro", "€"); err != nil {
+		t.Fatalf("AddCurrency: %v", err)
+	}
+	if delivered := runWebhookDeliveries(t, s); delivered != 0 || len(receiver.received()) != 0 {
+		t.Fatalf("delivered %d to a local address", delivered)
+	}
+	got := deliveriesOf(t, s, &rebound)
+	if len(got) != 1 || !strings.Contains(got[0].Error, ErrWebhookAddress.Error()) {
+		t.Errorf("deliveries = %+v, want one refused by the dialer", got)
+	}
+}
+
+func TestWebhookRunIsCappedAndSendsToWebhooksInParallel(t *testing.T) {
+	s, _, ctx := newWebhookTest(t)
+	fast := newWebhookReceiver(t)
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	slowHook, err := s.CreateWebhook(ctx, 1, slow.URL, nil)
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	fastHook, err := s.CreateWebhook(ctx, 1, fast.URL, nil)
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	for _, code := range []string{"EUR", "GBP", "JPY"} {
+		if err := s.AddCurrency(ctx, code, code, code); err != nil {
+			t.Fatalf("AddCurrency: %v", err)
+		}
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	delivered, err := s.RunWebhookDeliveries(runCtx)
+	if err != nil {
+		t.Fatalf("RunWebhookDeliveries: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("run took %v, want it to end with its context", elapsed)
+	}
+	if delivered != 3 || len(fast.received()) != 3 {
+		t.Errorf("delivered %d, fast receiver got %d, want 3 behind the slow receiver", delivered, len(fast.received()))
+	}
+	// The slow receiver's first delivery timed out, the others were kept
+	// back for the next run to keep them in order
+	for i, delivery := range deliveriesOf(t, s, slowHook) {
+		wantAttempts := 0
+		if i == 0 {
+			wantAttempts = 1
+		}
+		if delivery.Status != database.DeliveryPending || delivery.Attempts != wantAttempts || (delivery.Error == "") != (i > 0) {
+			t.Errorf("slow delivery %d = %s after %d attempts (%q), want pending after %d", i, delivery.Status, delivery.Attempts, delivery.Error, wantAttempts)
+		}
+	}
+	for _, delivery := range deliveriesOf(t, s, fastHook) {
+		if delivery.Status != database.DeliveryDelivered {
+			t.Errorf("fast delivery = %s, want delivered", delivery.Status)
+		}
+	}
+}
+
+func TestWebhooksStayInTheirWallet(t *testing.T) {
+	a, ctxA, b, ctxB := newTestWallets(t)
+	allowLocalWebhooks(a)
+	allowLocalWebhooks(b)
+	receiver := newWebhookReceiver(t)
+	webhook, err := b.CreateWebhook(ctxB, 1, receiver.URL, nil)
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+
+	if err := a.TransferMoney(ctxA, 1, "bob", 10, "USD"); err != nil {
+		t.Fatalf("TransferMoney: %v", err)
+	}
+	if err := b.AddCurrency(ctxB, "EUR", "Euro", "€"); err != nil {
+		t.Fatalf("AddCurrency: %v", err)
+	}
+	if delivered := runWebhookDeliveries(t, a); delivered != 1 {
+		t.Fatalf("delivered = %d, want only the event of the second wallet", delivered)
+	}
+	var event struct {
+		Type     string `json:"type"`
+		WalletID uint   `json:"wallet_id"`
+	}
+	if err := json.Unmarshal(receiver.received()[0].body, &event); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if event.Type != EventCurrencyAdded || event.WalletID != webhook.WalletID {
+		t.Errorf("event = %s in wallet %d, want currency.added in %d", event.Type, event.WalletID, webhook.WalletID)
+	}
+
+	if webhooks, err := a.ListWebhooks(ctxA); err != nil || len(webhooks) != 0 {
+		t.Errorf("webhooks of the default wallet = %v, %v, want none", webhooks, err)
+	}
+	if _, err := a.ListWebhookDeliveries(ctxA, webhook.ID, 0); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("delivery log from another wallet = %v, want ErrWebhookNotFound", err)
+	}
+	if err := a.DeleteWebhook(ctxA, 1, webhook.ID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("deleting from another wallet = %v, want ErrWebhookNotFound", err)
+	}
+}
@@ -13,7 +13,9 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
 	"github.com/fitz123/mcduck-wallet/internal/logger"
+	"github.com/fitz123/mcduck-wallet/internal/services"
 )
 
 type AuthService struct {
@@ -26,38 +28,48 @@ func NewAuthService(botToken string) *AuthService {
 
 func (as *AuthService) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger.Debug("AuthMiddleware: Starting authentication process")
+		logger.DebugContext(r.Context(), "AuthMiddleware: Starting authentication process")
 
+		// initData is a bearer credential, only its auth_date and the user
+		// ID are logged
 		initData := r.Header.Get("X-Telegram-Init-Data")
-		logger.Debug("AuthMiddleware: Received initData", "initData", initData)
 
 		if initData == "" {
-			logger.Warn("AuthMiddleware: No initData received")
+			logger.WarnContext(r.Context(), "AuthMiddleware: No initData received")
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
 		if !as.validateInitData(initData) {
-			logger.Warn("AuthMiddleware: Invalid initData")
+			logger.WarnContext(r.Context(), "AuthMiddleware: Invalid initData")
 			http.Error(w, "Invalid init data", http.StatusUnauthorized)
 			return
 		}
 
-		userID, err := as.getUserIDFromInitData(initData)
+		userID, languageCode, err := as.getUserFromInitData(initData)
 		if err != nil {
-			logger.Error("AuthMiddleware: Error getting user ID", "error", err)
+			logger.ErrorContext(r.Context(), "AuthMiddleware: Error getting user ID", "error", err)
 			http.Error(w, "Invalid user data", http.StatusUnauthorized)
 			return
 		}
 
-		logger.Info("AuthMiddleware: Authenticated user", "userID", userID)
-
 		// Set user ID in context
 		ctx := context.WithValue(r.Context(), "userID", userID)
+		ctx = logger.With(ctx, "user_id", userID)
+		logger.DebugContext(ctx, "AuthMiddleware: Authenticated user", "auth_date", authDate(initData))
+		ctx = services.WithActor(ctx, userID, services.SourceWeb)
+		// The stored /language override is applied later by WebService.localize
+		ctx = i18n.WithLocale(ctx, i18n.Resolve("", languageCode))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// authDate returns the auth_date field of validated initData
+func authDate(initData string) string {
+	values, _ := url.ParseQuery(initData)
+	return values.Get("auth_date")
+}
+
 func (as *AuthService) validateInitData(initData string) bool {
 	values, err := url.ParseQuery(initData)
 	if err != nil {
@@ -95,19 +107,21 @@ func (as *AuthService) getHash(data string, secret []byte) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-func (as *AuthService) getUserIDFromInitData(initData string) (int64, error) {
+// getUserFromInitData returns the ID and the client language of the user
+func (as *AuthService) getUserFromInitData(initData string) (int64, string, error) {
 	values, _ := url.ParseQuery(initData)
 	userDataStr := values.Get("user")
 	var userData map[string]interface{}
 	err := json.Unmarshal([]byte(userDataStr), &userData)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 	userID, ok := userData["id"].(float64)
 	if !ok {
-		return 0, fmt.Errorf("user ID not found")
+		return 0, "", fmt.Errorf("user ID not found")
 	}
-	return int64(userID), nil
+	languageCode, _ := userData["language_code"].(string)
+	return int64(userID), languageCode, nil
 }
 
 func GetUserIDFromContext(ctx context.Context) int64 {
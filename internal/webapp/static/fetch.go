@@ -0,0 +1,91 @@
+//go:build ignore
+
+// fetch downloads the pinned upstream assets into the assets directory and
+// checks their integrity. Run it with go generate after changing Assets.
+//
+// Every asset needs an Integrity hash. For a new or updated asset, run
+// "go run fetch.go -pin", review the downloaded file and copy the printed
+// hash into Assets.
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fitz123/mcduck-wallet/internal/webapp/static"
+)
+
+func main() {
+	pin := flag.Bool("pin", false, "print the integrity of assets without one instead of fetching")
+	flag.Parse()
+
+	for _, asset := range static.Assets {
+		if *pin != (asset.Integrity == "") {
+			if !*pin {
+				log.Fatalf("%s: no integrity, pin it with go run fetch.go -pin", asset.Name)
+			}
+			continue
+		}
+		data, err := download(asset.Source)
+		if err != nil {
+			log.Fatalf("%s: %v", asset.Name, err)
+		}
+		if *pin {
+			sum := sha512.Sum384(data)
+			fmt.Printf("%s: sha384-%s\n", asset.Name, base64.StdEncoding.EncodeToString(sum[:]))
+			continue
+		}
+		if err := verify(data, asset.Integrity); err != nil {
+			log.Fatalf("%s: %v", asset.Name, err)
+		}
+		if err := os.WriteFile(filepath.Join("assets", asset.Name), data, 0o644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("fetched %s (%d bytes)", asset.Name, len(data))
+	}
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func verify(data []byte, integrity string) error {
+	algorithm, want, ok := strings.Cut(integrity, "-")
+	if !ok {
+		return fmt.Errorf("invalid integrity %q", integrity)
+	}
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha384":
+		h = sha512.New384()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported integrity algorithm %q", algorithm)
+	}
+	h.Write(data)
+	if got := base64.StdEncoding.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("integrity mismatch: got %s-%s, want %s", algorithm, got, integrity)
+	}
+	return nil
+}
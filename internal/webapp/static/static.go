@@ -0,0 +1,100 @@
+// Package static serves the vendored CSS and JavaScript of the web app from
+// the binary. Asset URLs contain a content hash, so they can be cached forever.
+package static
+
+//go:generate go run fetch.go
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Prefix is the URL path the assets are served under
+const Prefix = "/static/"
+
+// Asset is a vendored file and the pinned upstream it was fetched from
+type Asset struct {
+	Name      string
+	Source    string
+	Integrity string // subresource integrity of Source, checked by fetch.go
+}
+
+// Assets lists the files fetch.go downloads into the assets directory
+var Assets = []Asset{
+	{Name: "pico.classless.pink.min.css", Source: "https://cdn.jsdelivr.net/npm/@picocss/pico@2.0.6/css/pico.classless.pink.min.css"},
+	{Name: "htmx.min.js", Source: "https://unpkg.com/htmx.org@2.0.2/dist/htmx.min.js", Integrity: "sha384-Y7hw+L/jvKeWIRRkqWYfPcvVxHzVzn5REgzbawhxAuQGwX1XWe70vji+VSeHOThJ"},
+	{Name: "telegram-web-app.js", Source: "https://telegram.org/js/telegram-web-app.js"},
+}
+
+//go:embed assets
+var embedded embed.FS
+
+var (
+	files  fs.FS
+	hashed = make(map[string]string) // asset name to hashed name
+	names  = make(map[string]string) // hashed name to asset name
+)
+
+func init() {
+	var err error
+	files, err = fs.Sub(embedded, "assets")
+	if err != nil {
+		panic(err)
+	}
+	for _, asset := range Assets {
+		data, err := fs.ReadFile(files, asset.Name)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		ext := path.Ext(asset.Name)
+		name := strings.TrimSuffix(asset.Name, ext) + "." + hex.EncodeToString(sum[:])[:12] + ext
+		hashed[asset.Name] = name
+		names[name] = asset.Name
+	}
+}
+
+// Path returns the URL of an asset
+func Path(name string) string {
+	if h, ok := hashed[name]; ok {
+		return Prefix + h
+	}
+	return Prefix + name
+}
+
+// Check returns an error naming the assets missing from the binary. The web
+// app is served from its own origin only, so it breaks without them.
+func Check() error {
+	var missing []string
+	for _, asset := range Assets {
+		if _, ok := hashed[asset.Name]; !ok {
+			missing = append(missing, asset.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("assets %s are not vendored, run go generate ./internal/webapp/static and rebuild", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Handler serves the embedded assets. Hashed names are immutable, plain
+// names are served too but must be revalidated.
+func Handler() http.Handler {
+	fileServer := http.FileServer(http.FS(files))
+	return http.StripPrefix(Prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name, ok := names[r.URL.Path]; ok {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			r.URL.Path = name
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		fileServer.ServeHTTP(w, r)
+	}))
+}
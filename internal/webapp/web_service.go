@@ -2,30 +2,46 @@
 package webapp
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/a-h/templ"
 	"github.com/fitz123/mcduck-wallet/internal/database"
+	"github.com/fitz123/mcduck-wallet/internal/export"
+	"github.com/fitz123/mcduck-wallet/internal/i18n"
 	"github.com/fitz123/mcduck-wallet/internal/logger"
 	"github.com/fitz123/mcduck-wallet/internal/messages"
+	"github.com/fitz123/mcduck-wallet/internal/ratelimit"
 	"github.com/fitz123/mcduck-wallet/internal/services"
 	"github.com/fitz123/mcduck-wallet/internal/webapp/views"
 )
 
+// maxImportSize limits the size of uploaded import files
+const maxImportSize = 5 << 20
+
+// timezoneHeader carries the IANA time zone detected by the WebApp
+const timezoneHeader = "X-Timezone"
+
 type WebService struct {
 	userService services.UserService
 	coreService services.CoreService
 	authService *AuthService
+	limiter     *ratelimit.Limiter
 }
 
-func NewWebService(userService services.UserService, coreService services.CoreService, botToken string) *WebService {
+func NewWebService(userService services.UserService, coreService services.CoreService, botToken string, limiter *ratelimit.Limiter) *WebService {
 	return &WebService{
 		userService: userService,
 		coreService: coreService,
 		authService: NewAuthService(botToken),
+		limiter:     limiter,
 	}
 }
 
@@ -38,14 +54,14 @@ func (ws *WebService) GetDashboard(w http.ResponseWriter, r *http.Request) {
 	userID := GetUserIDFromContext(r.Context())
 	user, err := ws.userService.GetUser(r.Context(), userID)
 	if err != nil {
-		logger.Error(messages.ErrUserNotFound, "error", err)
-		http.Error(w, messages.ErrUserNotFound, http.StatusInternalServerError)
+		logger.ErrorContext(r.Context(), "User not found", "error", err)
+		http.Error(w, i18n.T(r.Context(), messages.ErrUserNotFound), http.StatusInternalServerError)
 		return
 	}
 
-	component := views.MainContent(user, "", true)
+	component := views.MainContent(user, ws.listWallets(r), "", true)
 	if err := component.Render(r.Context(), w); err != nil {
-		logger.Error("Error rendering dashboard", "error", err)
+		logger.ErrorContext(r.Context(), "Error rendering dashboard", "error", err)
 		http.Error(w, "Error rendering page", http.StatusInternalServerError)
 	}
 }
@@ -55,14 +71,47 @@ func (ws *WebService) GetTransferForm(w http.ResponseWriter, r *http.Request) {
 
 	balances, err := ws.coreService.GetBalances(r.Context(), userID)
 	if err != nil {
-		logger.Error("Failed to get balances", "error", err)
-		http.Error(w, "Failed to fetch balances", http.StatusInternalServerError)
+		logger.ErrorContext(r.Context(), "Failed to get balances", "error", err)
+		http.Error(w, i18n.T(r.Context(), "web.balances_failed"), http.StatusInternalServerError)
 		return
 	}
 
 	component := views.TransferForm(balances)
 	if err := component.Render(r.Context(), w); err != nil {
-		logger.Error("Error rendering transfer form", "error", err)
+		logger.ErrorContext(r.Context(), "Error rendering transfer form", "error", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
+// ConfirmTransfer shows the transfer from the form with its fee, the user
+// then confirms it with a POST to /transfer
+func (ws *WebService) ConfirmTransfer(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIDFromContext(r.Context())
+	r.ParseForm()
+
+	toUsername, amount, currencyCode, err := ws.parseTransferFormValues(r)
+	if err != nil {
+		ws.handleResponse(w, r, userID, Response{
+			Message:    err.Error(),
+			Error:      err,
+			StatusCode: http.StatusBadRequest,
+		})
+		return
+	}
+
+	fee, err := ws.coreService.QuoteTransferFee(r.Context(), userID, amount, currencyCode)
+	if err != nil {
+		ws.handleResponse(w, r, userID, Response{
+			Message:    "Failed to quote transfer fee",
+			Error:      err,
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	component := views.TransferConfirm(toUsername, amount, currencyCode, fee)
+	if err := component.Render(r.Context(), w); err != nil {
+		logger.ErrorContext(r.Context(), "Error rendering transfer confirmation", "error", err)
 		http.Error(w, "Error rendering page", http.StatusInternalServerError)
 	}
 }
@@ -82,17 +131,28 @@ func (ws *WebService) TransferMoney(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = ws.coreService.TransferMoney(r.Context(), userID, toUsername, amount, currencyCode)
+	var pending *services.PendingApprovalError
+	if errors.As(err, &pending) {
+		ws.handleResponse(w, r, userID, Response{
+			Message: i18n.T(r.Context(), messages.InfoPendingApproval, pending.Operation.ID, pending.Operation.RequiredApprovals),
+		})
+		return
+	}
 	if err != nil {
 		ws.handleResponse(w, r, userID, Response{
-			Message:    "Transfer failed",
+			Message:    i18n.T(r.Context(), "web.transfer_failed"),
 			Error:      err,
 			StatusCode: http.StatusInternalServerError,
 		})
 		return
 	}
 
+	recipient, notice := "@"+toUsername, ""
+	if to, err := ws.coreService.ResolveRecipient(r.Context(), toUsername); err == nil {
+		recipient, notice = messages.DisplayName(to), messages.RenameNotice(r.Context(), toUsername, to)
+	}
 	ws.handleResponse(w, r, userID, Response{
-		Message: fmt.Sprintf(messages.InfoTransferSuccessful, amount, currencyCode, toUsername),
+		Message: notice + i18n.T(r.Context(), messages.InfoTransferSuccessful, amount, currencyCode, recipient),
 	})
 }
 
@@ -101,7 +161,7 @@ func (ws *WebService) GetTransactionHistory(w http.ResponseWriter, r *http.Reque
 
 	transactions, err := ws.coreService.GetTransactionHistory(r.Context(), userID)
 	if err != nil {
-		logger.Error("Failed to get transaction history", "error", err)
+		logger.ErrorContext(r.Context(), "Failed to get transaction history", "error", err)
 		http.Error(w, "Failed to fetch transaction history", http.StatusInternalServerError)
 		return
 	}
@@ -110,15 +170,217 @@ func (ws *WebService) GetTransactionHistory(w http.ResponseWriter, r *http.Reque
 	templ.Handler(component).ServeHTTP(w, r)
 }
 
+// GetLoans shows the open loans the user gave and owes
+func (ws *WebService) GetLoans(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIDFromContext(r.Context())
+	user, err := ws.userService.GetUser(r.Context(), userID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "User not found", "error", err)
+		http.Error(w, i18n.T(r.Context(), messages.ErrUserNotFound), http.StatusInternalServerError)
+		return
+	}
+
+	loans, err := ws.coreService.ListLoans(r.Context(), userID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to get loans", "error", err)
+		http.Error(w, "Failed to fetch loans", http.StatusInternalServerError)
+		return
+	}
+
+	component := views.Loans(user, loans, ws.coreService.Now())
+	templ.Handler(component).ServeHTTP(w, r)
+}
+
+func (ws *WebService) ExportStatement(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIDFromContext(r.Context())
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	if !export.IsFormat(format) {
+		http.Error(w, "Unsupported format", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := export.ParsePeriod(r.URL.Query().Get("from"), r.URL.Query().Get("to"), time.Now().In(i18n.Location(r.Context())))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	statement, err := ws.coreService.GenerateStatement(r.Context(), userID, from, to)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to generate statement", "error", err)
+		http.Error(w, "Failed to generate statement", http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := export.Write(&buf, format, statement); err != nil {
+		logger.ErrorContext(r.Context(), "Failed to render statement", "error", err)
+		http.Error(w, "Failed to generate statement", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.MIMEType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.FileName(format, statement)))
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (ws *WebService) GetAdminPage(w http.ResponseWriter, r *http.Request) {
+	throttled := ws.limiter.Throttled()
+	names := make(map[string]string)
+	for _, stat := range throttled {
+		if id, ok := ratelimit.ParseUserSubject(stat.Subject); ok {
+			if user, err := ws.userService.GetUser(r.Context(), id); err == nil {
+				names[stat.Subject] = "@" + user.Username
+			}
+		}
+	}
+
+	component := views.AdminPage(throttled, names)
+	if err := component.Render(r.Context(), w); err != nil {
+		logger.ErrorContext(r.Context(), "Error rendering admin page", "error", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
+func (ws *WebService) ImportData(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		http.Error(w, "Invalid upload", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "File is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	format := strings.TrimPrefix(strings.ToLower(path.Ext(header.Filename)), ".")
+	dryRun := r.FormValue("dry_run") != ""
+
+	report, err := ws.coreService.Import(r.Context(), format, io.LimitReader(file, maxImportSize), dryRun)
+	if err != nil {
+		report = &services.ImportReport{Errors: []services.ImportError{{Message: err.Error()}}}
+	}
+
+	component := views.ImportReport(report)
+	if err := component.Render(r.Context(), w); err != nil {
+		logger.ErrorContext(r.Context(), "Error rendering import report", "error", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
 func (ws *WebService) AuthMiddleware(next http.Handler) http.Handler {
-	return ws.authService.AuthMiddleware(next)
+	return ws.authService.AuthMiddleware(ws.selectWallet(ws.localize(next)))
+}
+
+// selectWallet limits the request's context to the user's active wallet
+func (ws *WebService) selectWallet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		walletID, err := ws.userService.ActiveWallet(ctx, GetUserIDFromContext(ctx))
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to get active wallet", "error", err)
+			http.Error(w, "Failed to fetch user data", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(database.WithWallet(ctx, walletID)))
+	})
+}
+
+// SwitchWallet makes the posted wallet the active one and shows its dashboard
+func (ws *WebService) SwitchWallet(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserIDFromContext(r.Context())
+	r.ParseForm()
+
+	walletID, err := strconv.ParseUint(r.FormValue("wallet_id"), 10, 64)
+	if err == nil {
+		err = ws.userService.SwitchWallet(r.Context(), userID, uint(walletID))
+	}
+	if err != nil {
+		ws.handleResponse(w, r, userID, Response{
+			Message:    i18n.T(r.Context(), "web.switch_wallet_failed"),
+			Error:      err,
+			StatusCode: http.StatusBadRequest,
+		})
+		return
+	}
+
+	r = r.WithContext(database.WithWallet(r.Context(), uint(walletID)))
+	var name string
+	for _, wallet := range ws.listWallets(r) {
+		if wallet.ID == uint(walletID) {
+			name = wallet.Name
+		}
+	}
+	ws.handleResponse(w, r, userID, Response{
+		Message: i18n.T(r.Context(), messages.InfoWalletSwitched, name),
+	})
+}
+
+// listWallets returns the wallets of the authenticated user for the wallet
+// switcher, which is hidden when they cannot be loaded
+func (ws *WebService) listWallets(r *http.Request) []database.Wallet {
+	wallets, err := ws.userService.ListWallets(r.Context(), GetUserIDFromContext(r.Context()))
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to list wallets", "error", err)
+	}
+	return wallets
+}
+
+// localize applies the language the user chose with /language over the one
+// of their Telegram client, and their time zone. Users without a time zone
+// get the one detected by the WebApp, which is stored for the bot as well.
+func (ws *WebService) localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, err := ws.userService.GetUser(ctx, GetUserIDFromContext(ctx))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if user.Language != "" {
+			ctx = i18n.WithLocale(ctx, i18n.Resolve(user.Language, ""))
+		}
+		if detected := r.Header.Get(timezoneHeader); user.Timezone == "" && detected != "" {
+			if err := ws.userService.SetTimezone(ctx, user.TelegramID, detected); err != nil {
+				logger.WarnContext(ctx, "Ignoring detected time zone", "timezone", detected, "error", err)
+			} else {
+				user.Timezone = detected
+			}
+		}
+		ctx = i18n.WithLocation(ctx, user.Location())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RateLimitMiddleware throttles the authenticated user, it must run after AuthMiddleware
+func (ws *WebService) RateLimitMiddleware(class ratelimit.Class) func(http.Handler) http.Handler {
+	return ws.limiter.HTTPMiddleware(class, func(r *http.Request) string {
+		return ratelimit.UserSubject(GetUserIDFromContext(r.Context()))
+	})
+}
+
+// AdminMiddleware allows only admins, it must run after AuthMiddleware
+func (ws *WebService) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := GetUserIDFromContext(r.Context())
+		if !ws.userService.IsAdmin(r.Context(), userID) {
+			http.Error(w, i18n.T(r.Context(), messages.ErrUnauthorized), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // Helper functions
 
 func (ws *WebService) parseTransferFormValues(r *http.Request) (string, float64, string, error) {
-	toUsername := strings.TrimPrefix(r.FormValue("to_username"), "@")
-	toUsername = strings.ToLower(toUsername)
+	toUsername := strings.TrimSpace(strings.TrimPrefix(r.FormValue("to_username"), "@"))
 	if toUsername == "" {
 		return "", 0, "", fmt.Errorf("Recipient username is required")
 	}
@@ -128,7 +390,7 @@ func (ws *WebService) parseTransferFormValues(r *http.Request) (string, float64,
 		return "", 0, "", fmt.Errorf("Amount is required")
 	}
 
-	amount, err := strconv.ParseFloat(amountStr, 64)
+	amount, err := services.ParseAmount(amountStr)
 	if err != nil {
 		return "", 0, "", fmt.Errorf("Invalid amount")
 	}
@@ -156,21 +418,21 @@ func (ws *WebService) handleResponse(w http.ResponseWriter, r *http.Request, use
 	message := response.Message
 
 	if !success {
-		logger.Error(response.Message, "error", response.Error)
+		logger.ErrorContext(r.Context(), response.Message, "error", response.Error)
 		message = response.Error.Error()
 	}
 
 	user, err := ws.userService.GetUser(r.Context(), userID)
 	if err != nil {
-		logger.Error("Failed to get user", "error", err)
+		logger.ErrorContext(r.Context(), "Failed to get user", "error", err)
 		user = &database.User{}
 		message = "Failed to fetch user data"
 		success = false
 	}
 
-	component := views.MainContent(user, message, success)
+	component := views.MainContent(user, ws.listWallets(r), message, success)
 	if err := component.Render(r.Context(), w); err != nil {
-		logger.Error("Error rendering response", "error", err)
+		logger.ErrorContext(r.Context(), "Error rendering response", "error", err)
 		http.Error(w, "Error rendering page", http.StatusInternalServerError)
 	}
 }